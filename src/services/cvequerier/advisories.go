@@ -0,0 +1,48 @@
+package main
+
+import (
+	"melaka/cvequerier/cvss"
+)
+
+// the OSV severity types we can score, in the order we'd rather use them
+var osvSeverityTypes = []string{"CVSS_V4", "CVSS_V3"}
+
+// mergeAdvisories folds the OSV and GHSA advisories for a CVE into its NVD record. Withdrawn advisories are
+// dropped, and while NVD has yet to score the CVE (it can take a while) the advisories' own CVSS vector stands in
+func mergeAdvisories(cve *CveMsg, advisories []Advisory) {
+
+	for _, advisory := range advisories {
+		if advisory.Osv.Withdrawn == "" {
+			cve.Advisories = append(cve.Advisories, advisory)
+		}
+	}
+
+	if cve.PreferredScore != nil {
+		return
+	}
+
+	for _, severityType := range osvSeverityTypes {
+		for _, advisory := range cve.Advisories {
+			for _, severity := range advisory.Osv.Severity {
+				if severity.Type != severityType {
+					continue
+				}
+				v, err := cvss.Parse(severity.Score)
+				if err != nil {
+					continue
+				}
+				scores := v.Scores()
+				cve.PreferredScore = &PreferredScore{
+					Source:       advisory.Osv.ID,
+					Type:         "Secondary",
+					Version:      scores.Version,
+					VectorString: scores.VectorString,
+					BaseScore:    scores.BaseScore,
+					BaseSeverity: scores.BaseSeverity,
+				}
+				return
+			}
+		}
+	}
+
+}
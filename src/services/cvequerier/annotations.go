@@ -0,0 +1,506 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const openVexContext = "https://openvex.dev/ns/v0.2.0"
+
+// the statuses a CVE can be triaged as, as OpenVEX names them
+var vexStatuses = map[string]bool{
+	"not_affected":        true,
+	"affected":            true,
+	"fixed":               true,
+	"under_investigation": true,
+}
+
+// why a product isn't affected by a CVE, from OpenVEX's vocabulary of justifications
+var vexJustifications = map[string]bool{
+	"component_not_present":                             true,
+	"vulnerable_code_not_present":                       true,
+	"vulnerable_code_not_in_execute_path":               true,
+	"vulnerable_code_cannot_be_controlled_by_adversary": true,
+	"inline_mitigations_already_exist":                  true,
+}
+
+var annotationCveID = regexp.MustCompile(`^CVE-\d{4}-\d{4,}$`)
+
+// an engineer's triage of a CVE, for either one of our assets or a product (a CPE name or package URL) wherever it's
+// used. A CVE can only be triaged once for each asset or product
+type Annotation struct {
+	ID              string `bson:"id" json:"id"`
+	Asset           string `bson:"asset,omitempty" json:"asset,omitempty"`     // the ID of one of our assets
+	Product         string `bson:"product,omitempty" json:"product,omitempty"` // a CPE name or package URL
+	CveID           string `bson:"cveId" json:"cveId"`
+	Status          string `bson:"status" json:"status"`
+	Justification   string `bson:"justification,omitempty" json:"justification,omitempty"`
+	ImpactStatement string `bson:"impactStatement,omitempty" json:"impactStatement,omitempty"` // why it's not affected, in words
+	ActionStatement string `bson:"actionStatement,omitempty" json:"actionStatement,omitempty"` // what to do about it if it is
+	Notes           string `bson:"notes,omitempty" json:"notes,omitempty"`
+	Author          string `bson:"author" json:"author"`
+	CreatedAt       string `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       string `bson:"updatedAt" json:"updatedAt"`
+}
+
+// resolved reports whether the annotation takes the CVE off the list of things to deal with
+func (a *Annotation) resolved() bool {
+	return a != nil && (a.Status == "not_affected" || a.Status == "fixed")
+}
+
+type AnnotationRequest struct {
+	Asset           string `json:"asset"`
+	Product         string `json:"product"`
+	CveID           string `json:"cveId"`
+	Status          string `json:"status"`
+	Justification   string `json:"justification"`
+	ImpactStatement string `json:"impactStatement"`
+	ActionStatement string `json:"actionStatement"`
+	Notes           string `json:"notes"`
+	Author          string `json:"author"` // only used with authentication off, otherwise whoever's authenticated is
+}
+
+// validate checks an annotation request says what OpenVEX needs it to for its status
+func (req *AnnotationRequest) validate() error {
+
+	req.CveID = strings.ToUpper(strings.TrimSpace(req.CveID))
+	req.Author = strings.TrimSpace(req.Author)
+
+	if (req.Asset == "") == (req.Product == "") {
+		return fmt.Errorf("annotation must be for one of an asset or a product")
+	}
+	if req.Product != "" && !strings.HasPrefix(req.Product, "cpe:2.3:") && !strings.HasPrefix(req.Product, "pkg:") {
+		return fmt.Errorf("product must be a CPE 2.3 name or a package URL")
+	}
+	if !annotationCveID.MatchString(req.CveID) {
+		return fmt.Errorf("cveId must be a CVE ID, e.g. CVE-2021-44228")
+	}
+	if req.Author == "" {
+		return fmt.Errorf("author must be given")
+	}
+
+	if !vexStatuses[req.Status] {
+		return fmt.Errorf("status must be one of not_affected, affected, fixed or under_investigation")
+	}
+	if req.Justification != "" {
+		if req.Status != "not_affected" {
+			return fmt.Errorf("justification can only be given for not_affected")
+		}
+		if !vexJustifications[req.Justification] {
+			return fmt.Errorf("unknown justification: %s", req.Justification)
+		}
+	}
+	if req.Status == "not_affected" && req.Justification == "" && req.ImpactStatement == "" {
+		return fmt.Errorf("not_affected needs a justification or an impactStatement")
+	}
+	if req.Status == "affected" && req.ActionStatement == "" {
+		return fmt.Errorf("affected needs an actionStatement")
+	}
+
+	return nil
+}
+
+// annotationFor picks the annotation that applies to a CVE found in an asset. One made for the asset itself beats
+// one made for any of its products
+func annotationFor(annotations []Annotation, assetID, cveID string, products []string) *Annotation {
+	var found *Annotation
+	for i := range annotations {
+		a := &annotations[i]
+		if a.CveID != cveID {
+			continue
+		}
+		if a.Asset == assetID {
+			return a
+		}
+		for _, product := range products {
+			if found == nil && a.Product != "" && productCovers(a.Product, product) {
+				found = a
+			}
+		}
+	}
+	return found
+}
+
+// productCovers reports whether an annotation made for a product applies to a component. A package URL without a
+// version covers every version of the package
+func productCovers(product, component string) bool {
+	if product == component {
+		return true
+	}
+	if !strings.HasPrefix(product, "pkg:") || strings.Contains(product, "@") {
+		return false
+	}
+	if i := strings.IndexAny(component, "@?#"); i >= 0 {
+		component = component[:i]
+	}
+	return product == component
+}
+
+// annotationProducts lists every product an annotation could be made for that covers the given components: the
+// components themselves, and their packages regardless of version
+func annotationProducts(components []AssetComponent) []string {
+	products := []string{}
+	for _, component := range components {
+		products = append(products, component.String())
+		if component.Purl != "" {
+			if i := strings.IndexAny(component.Purl, "@?#"); i >= 0 {
+				products = append(products, component.Purl[:i])
+			}
+		}
+	}
+	return products
+}
+
+// applyAnnotations attaches the triage annotations that apply to each of an asset's vulnerabilities
+func (s *Server) applyAnnotations(asset *Asset, vulns []AssetVulnerability) error {
+
+	if len(vulns) == 0 {
+		return nil
+	}
+
+	annotations, err := s.db.GetAnnotations(AnnotationFilter{Asset: asset.ID, Products: annotationProducts(asset.Components)})
+	if err != nil {
+		return err
+	}
+
+	for i := range vulns {
+		vulns[i].Annotation = annotationFor(annotations, asset.ID, vulns[i].CveID, vulns[i].Components)
+	}
+
+	return nil
+}
+
+// annotationAsset checks the asset an annotation is for exists, responding with an error if it doesn't
+func (s *Server) annotationAsset(c *gin.Context, req AnnotationRequest) bool {
+
+	if req.Asset == "" {
+		return true
+	}
+
+	_, err := s.db.GetAsset(req.Asset)
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "asset not found"})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to fetch asset %s: %s", req.Asset, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save annotation"})
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) createAnnotation(c *gin.Context) {
+
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	// triage is credited to whoever's authenticated, whatever the request says, so it can't be passed off as
+	// someone else's. Only with authentication off is the author taken from the request
+	if p := principal(c); p != nil {
+		req.Author = p.Subject
+	}
+	if err := req.validate(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.annotationAsset(c, req) {
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	annotation := Annotation{
+		ID:              randomUUID(),
+		Asset:           req.Asset,
+		Product:         req.Product,
+		CveID:           req.CveID,
+		Status:          req.Status,
+		Justification:   req.Justification,
+		ImpactStatement: req.ImpactStatement,
+		ActionStatement: req.ActionStatement,
+		Notes:           req.Notes,
+		Author:          req.Author,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	err := s.db.CreateAnnotation(annotation)
+	if errors.Is(err, ErrConflict) {
+		c.IndentedJSON(http.StatusConflict, gin.H{"error": "the CVE has already been triaged for this asset or product, update that annotation instead"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create annotation: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save annotation"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, annotation)
+
+}
+
+// parseAnnotationFilter reads which annotations are wanted from the query string
+func parseAnnotationFilter(c *gin.Context) (AnnotationFilter, error) {
+
+	filter := AnnotationFilter{Asset: c.Query("asset"), CveID: strings.ToUpper(c.Query("cve")), Status: c.Query("status")}
+	if v := c.Query("product"); v != "" {
+		filter.Products = []string{v}
+	}
+	if filter.Status != "" && !vexStatuses[filter.Status] {
+		return filter, fmt.Errorf("status must be one of not_affected, affected, fixed or under_investigation")
+	}
+
+	return filter, nil
+}
+
+// getAnnotations lists annotations, narrowed down by ?asset=, ?product=, ?cve= and ?status=
+func (s *Server) getAnnotations(c *gin.Context) {
+
+	filter, err := parseAnnotationFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	annotations, err := s.db.GetAnnotations(filter)
+	if err != nil {
+		log.Printf("Failed to fetch annotations: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch annotations"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, annotations)
+
+}
+
+// fetchAnnotation looks up the annotation a request is for, responding with an error if it can't be found
+func (s *Server) fetchAnnotation(c *gin.Context) (*Annotation, bool) {
+
+	annotation, err := s.db.GetAnnotation(c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "annotation not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to fetch annotation %s: %s", c.Param("id"), err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch annotation"})
+		return nil, false
+	}
+
+	return annotation, true
+}
+
+func (s *Server) getAnnotation(c *gin.Context) {
+	if annotation, ok := s.fetchAnnotation(c); ok {
+		c.IndentedJSON(http.StatusOK, annotation)
+	}
+}
+
+// updateAnnotation re-triages a CVE. What it's for can't change, so a request naming a different asset, product or
+// CVE is rejected
+func (s *Server) updateAnnotation(c *gin.Context) {
+
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	annotation, ok := s.fetchAnnotation(c)
+	if !ok {
+		return
+	}
+
+	// anything identifying the annotation left out of the request stays as it was
+	if req.Asset == "" && req.Product == "" {
+		req.Asset, req.Product = annotation.Asset, annotation.Product
+	}
+	if req.CveID == "" {
+		req.CveID = annotation.CveID
+	}
+	if p := principal(c); p != nil {
+		req.Author = p.Subject
+	}
+	if err := req.validate(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Asset != annotation.Asset || req.Product != annotation.Product || req.CveID != annotation.CveID {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "an annotation's asset, product and cveId can't be changed"})
+		return
+	}
+
+	annotation.Status = req.Status
+	annotation.Justification = req.Justification
+	annotation.ImpactStatement = req.ImpactStatement
+	annotation.ActionStatement = req.ActionStatement
+	annotation.Notes = req.Notes
+	annotation.Author = req.Author
+	annotation.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	err := s.db.UpdateAnnotation(*annotation)
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "annotation not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update annotation %s: %s", annotation.ID, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save annotation"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, annotation)
+
+}
+
+func (s *Server) deleteAnnotation(c *gin.Context) {
+
+	err := s.db.DeleteAnnotation(c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "annotation not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete annotation %s: %s", c.Param("id"), err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to delete annotation"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+
+}
+
+// an OpenVEX document (https://github.com/openvex/spec)
+type OpenVexDocument struct {
+	Context    string             `json:"@context"`
+	ID         string             `json:"@id"`
+	Author     string             `json:"author"`
+	Timestamp  string             `json:"timestamp"`
+	Version    int                `json:"version"`
+	Tooling    string             `json:"tooling,omitempty"`
+	Statements []OpenVexStatement `json:"statements"`
+}
+
+type OpenVexStatement struct {
+	Vulnerability   OpenVexVulnerability `json:"vulnerability"`
+	Timestamp       string               `json:"timestamp"`
+	Products        []OpenVexProduct     `json:"products"`
+	Status          string               `json:"status"`
+	StatusNotes     string               `json:"status_notes,omitempty"`
+	Justification   string               `json:"justification,omitempty"`
+	ImpactStatement string               `json:"impact_statement,omitempty"`
+	ActionStatement string               `json:"action_statement,omitempty"`
+}
+
+type OpenVexVulnerability struct {
+	ID   string `json:"@id"`
+	Name string `json:"name"`
+}
+
+type OpenVexProduct struct {
+	ID            string             `json:"@id"`
+	Identifiers   map[string]string  `json:"identifiers,omitempty"`
+	Subcomponents []OpenVexComponent `json:"subcomponents,omitempty"`
+}
+
+type OpenVexComponent struct {
+	ID          string            `json:"@id"`
+	Identifiers map[string]string `json:"identifiers,omitempty"`
+}
+
+// vexIdentifiers says what kind of identifier a product is, as OpenVEX products can carry a purl or a CPE
+func vexIdentifiers(product string) map[string]string {
+	if strings.HasPrefix(product, "pkg:") {
+		return map[string]string{"purl": product}
+	}
+	return map[string]string{"cpe23": product}
+}
+
+// newOpenVexStatement states an annotation in OpenVEX. Annotations for one of our assets are statements about the
+// asset, with its components as subcomponents
+func newOpenVexStatement(a Annotation, asset *Asset) OpenVexStatement {
+
+	statement := OpenVexStatement{
+		Vulnerability:   OpenVexVulnerability{ID: "https://nvd.nist.gov/vuln/detail/" + a.CveID, Name: a.CveID},
+		Timestamp:       a.UpdatedAt,
+		Status:          a.Status,
+		StatusNotes:     a.Notes,
+		Justification:   a.Justification,
+		ImpactStatement: a.ImpactStatement,
+		ActionStatement: a.ActionStatement,
+	}
+
+	if a.Product != "" {
+		statement.Products = []OpenVexProduct{{ID: a.Product, Identifiers: vexIdentifiers(a.Product)}}
+		return statement
+	}
+
+	product := OpenVexProduct{ID: "urn:melaka:asset:" + a.Asset}
+	if asset != nil {
+		for _, component := range asset.Components {
+			product.Subcomponents = append(product.Subcomponents, OpenVexComponent{
+				ID:          component.String(),
+				Identifiers: vexIdentifiers(component.String()),
+			})
+		}
+	}
+	statement.Products = []OpenVexProduct{product}
+
+	return statement
+}
+
+// exportOpenVex exports annotations as an OpenVEX document, taking the same filters as /annotations
+func (s *Server) exportOpenVex(c *gin.Context) {
+
+	filter, err := parseAnnotationFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	annotations, err := s.db.GetAnnotations(filter)
+	if err != nil {
+		log.Printf("Failed to fetch annotations: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to export annotations"})
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	doc := OpenVexDocument{
+		Context:    openVexContext,
+		ID:         "urn:uuid:" + randomUUID(),
+		Author:     s.csafPublisher.Name,
+		Timestamp:  now,
+		Version:    1,
+		Tooling:    "melaka cvequerier",
+		Statements: []OpenVexStatement{},
+	}
+
+	assets := map[string]*Asset{}
+	for _, a := range annotations {
+		if _, ok := assets[a.Asset]; a.Asset != "" && !ok {
+			asset, err := s.db.GetAsset(a.Asset)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				log.Printf("Failed to fetch asset %s: %s", a.Asset, err)
+				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to export annotations"})
+				return
+			}
+			assets[a.Asset] = asset
+		}
+		doc.Statements = append(doc.Statements, newOpenVexStatement(a, assets[a.Asset]))
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="melaka.openvex.json"`)
+	c.IndentedJSON(http.StatusOK, doc)
+
+}
@@ -4,6 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
 )
 
 func main() {
@@ -11,25 +16,78 @@ func main() {
 	// Create a DB instance and connect to our database
 	// Note that we're not doing this inside an init function because the init function is executed on test runs
 
+	// how often the in-process text index, if we're using one, is rebuilt to pick up new and updated CVEs
+	textIndexRefresh, err := time.ParseDuration(readFromENV("SEARCH_INDEX_REFRESH", "15m"))
+	if err != nil {
+		log.Fatalf("Invalid SEARCH_INDEX_REFRESH: %s", err)
+	}
+
 	db := &MongoDB{
 		Configuration: DBConnConfig{
-			Url:            readFromENV("MONGO_URL", "mongodb://localhost:27017"),
-			Username:       readFromENV("MONGO_ROOT_USERNAME", "dev"),
-			Password:       readFromENV("MONGO_ROOT_PASSWORD", "dev"),
-			Database:       readFromENV("MONGO_DATABASE", "melakaDB"),
-			CveCollection:  readFromENV("MONGO_CVE_COLLECTION", "cves"),
-			MetaCollection: readFromENV("MONGO_META_COLLECTION", "meta"),
+			Url:                    readFromENV("MONGO_URL", "mongodb://localhost:27017"),
+			Username:               readFromENV("MONGO_ROOT_USERNAME", "dev"),
+			Password:               readFromENV("MONGO_ROOT_PASSWORD", "dev"),
+			Database:               readFromENV("MONGO_DATABASE", "melakaDB"),
+			CveCollection:          readFromENV("MONGO_CVE_COLLECTION", "cves"),
+			MetaCollection:         readFromENV("MONGO_META_COLLECTION", "meta"),
+			EpssCollection:         readFromENV("MONGO_EPSS_COLLECTION", "epsshistory"),
+			CweCollection:          readFromENV("MONGO_CWE_COLLECTION", "cwes"),
+			CpeCollection:          readFromENV("MONGO_CPE_COLLECTION", "cpes"),
+			CpeMatchCollection:     readFromENV("MONGO_CPEMATCH_COLLECTION", "cpematches"),
+			ChangeCollection:       readFromENV("MONGO_CHANGE_COLLECTION", "cvechanges"),
+			DistroCollection:       readFromENV("MONGO_DISTRO_COLLECTION", "distrostatus"),
+			AdvisoryCollection:     readFromENV("MONGO_ADVISORY_COLLECTION", "advisories"),
+			SubscriptionCollection: readFromENV("MONGO_SUBSCRIPTION_COLLECTION", "subscriptions"),
+			DeliveryCollection:     readFromENV("MONGO_DELIVERY_COLLECTION", "deliveries"),
+			AssetCollection:        readFromENV("MONGO_ASSET_COLLECTION", "assets"),
+			AnnotationCollection:   readFromENV("MONGO_ANNOTATION_COLLECTION", "annotations"),
+			ApiKeyCollection:       readFromENV("MONGO_APIKEY_COLLECTION", "apikeys"),
+			TextIndex:              readFromENV("SEARCH_TEXT_INDEX", "mongo"),
+			TextLanguage:           readFromENV("SEARCH_LANGUAGE", "en"),
+			TextIndexRefresh:       textIndexRefresh,
 		},
 	}
 	defer db.Connection.Disconnect(context.Background())
 
-	err := db.Connect()
+	err = db.Connect()
 	if err != nil {
 		log.Fatalf("Failed to connect to DB instance with error: %s", err)
 	}
 
+	// CVE lookups go through an in-process cache, unless it's turned off
+	connector, err := readCveCache(db)
+	if err != nil {
+		log.Fatalf("Invalid CVE cache configuration: %s", err)
+	}
+
 	// Set up our server with it's routes & middleware
-	server := buildServer(db)
+	server := buildServer(connector)
+	if server.stats.ttl, err = time.ParseDuration(readFromENV("STATS_CACHE_TTL", defaultStatsTTL.String())); err != nil {
+		log.Fatalf("Invalid STATS_CACHE_TTL: %s", err)
+	}
+	server.csafPublisher.Name = readFromENV("CSAF_PUBLISHER_NAME", defaultCsafPublisher.Name)
+	server.csafPublisher.Namespace = readFromENV("CSAF_PUBLISHER_NAMESPACE", defaultCsafPublisher.Namespace)
+	if server.auth, err = readAuthConfig(); err != nil {
+		log.Fatalf("Invalid auth configuration: %s", err)
+	}
+	if server.auth == nil {
+		log.Printf("Authentication is disabled, anyone can read and change anything")
+	}
+	if server.limiter, server.rateLimits, err = readRateLimits(db); err != nil {
+		log.Fatalf("Invalid rate limit configuration: %s", err)
+	}
+	if server.graphqlLimits.MaxDepth, err = strconv.Atoi(readFromENV("GRAPHQL_MAX_DEPTH", strconv.Itoa(defaultGraphqlMaxDepth))); err != nil {
+		log.Fatalf("Invalid GRAPHQL_MAX_DEPTH: %s", err)
+	}
+	if server.graphqlLimits.MaxComplexity, err = strconv.Atoi(readFromENV("GRAPHQL_MAX_COMPLEXITY", strconv.Itoa(defaultGraphqlMaxComplexity))); err != nil {
+		log.Fatalf("Invalid GRAPHQL_MAX_COMPLEXITY: %s", err)
+	}
+	// behind a load balancer, clients without a key are only told apart by the address it forwards
+	if proxies := readFromENV("TRUSTED_PROXIES", ""); proxies != "" {
+		if err := server.router.SetTrustedProxies(strings.Split(proxies, ",")); err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES: %s", err)
+		}
+	}
 
 	// Run our server!
 	port := readFromENV("LISTEN_PORT", "8080")
@@ -37,3 +95,108 @@ func main() {
 	fmt.Printf("Listening on port %s\n", port)
 
 }
+
+// readAuthConfig reads how callers authenticate from the environment. Authentication is on unless AUTH_ENABLED is
+// false, in which case there's no config. With it on, there has to be a bootstrap key or a JWKS, or nobody could ever
+// authenticate to mint the first API key
+func readAuthConfig() (*AuthConfig, error) {
+
+	if enabled, err := strconv.ParseBool(readFromENV("AUTH_ENABLED", "true")); err != nil {
+		return nil, fmt.Errorf("invalid AUTH_ENABLED: %w", err)
+	} else if !enabled {
+		return nil, nil
+	}
+
+	config := &AuthConfig{BootstrapKey: readFromENV("AUTH_BOOTSTRAP_KEY", "")}
+	if config.BootstrapKey != "" && len(config.BootstrapKey) < 32 {
+		return nil, fmt.Errorf("AUTH_BOOTSTRAP_KEY must be at least 32 characters")
+	}
+
+	if jwks := readFromENV("AUTH_JWKS", ""); jwks != "" {
+		verifier, err := NewJWTVerifier(
+			jwks,
+			readFromENV("AUTH_JWT_ISSUER", ""),
+			readFromENV("AUTH_JWT_AUDIENCE", ""),
+			readFromENV("AUTH_JWT_SCOPE_CLAIM", "scope"),
+		)
+		if err != nil {
+			return nil, err
+		}
+		config.JWT = verifier
+	}
+
+	if config.BootstrapKey == "" && config.JWT == nil {
+		return nil, fmt.Errorf("authentication is enabled, but neither AUTH_BOOTSTRAP_KEY nor AUTH_JWKS is set")
+	}
+
+	return config, nil
+}
+
+// readRateLimits reads the quota for each group of routes from the environment, and where usage is counted: in
+// process, or in mongo so that every replica counts against the same quota
+func readRateLimits(db *MongoDB) (RateLimiter, map[string]RateLimit, error) {
+
+	limits := map[string]RateLimit{}
+	for group, env := range map[string][2]string{
+		ScopeRead:             {"RATE_LIMIT_READ", ""},
+		ScopeAnnotate:         {"RATE_LIMIT_ANNOTATE", ""},
+		ScopeAdmin:            {"RATE_LIMIT_ADMIN", ""},
+		rateLimitAuthFailures: {"RATE_LIMIT_AUTH_FAILURES", "20/1m"},
+	} {
+		limit, err := parseRateLimit(readFromENV(env[0], env[1]))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", env[0], err)
+		}
+		limits[group] = limit
+	}
+
+	switch backend := readFromENV("RATE_LIMIT_BACKEND", "memory"); backend {
+	case "memory":
+		return newMemoryRateLimiter(), limits, nil
+	case "mongo":
+		return newMongoRateLimiter(db.Database.Collection(readFromENV("MONGO_RATELIMIT_COLLECTION", "ratelimits"))), limits, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown RATE_LIMIT_BACKEND %s, must be memory or mongo", backend)
+	}
+}
+
+// readCveCache puts a cache in front of the database's CVE lookups if CVE_CACHE_SIZE isn't 0, and has it drop CVEs
+// as cvewriter publishes updates to them
+func readCveCache(db *MongoDB) (DBConnector, error) {
+
+	entries, err := strconv.Atoi(readFromENV("CVE_CACHE_SIZE", strconv.Itoa(defaultCveCacheEntries)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CVE_CACHE_SIZE: %w", err)
+	}
+	if entries <= 0 {
+		return db, nil
+	}
+	maxBytes, err := strconv.Atoi(readFromENV("CVE_CACHE_MAX_BYTES", strconv.Itoa(defaultCveCacheMaxBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CVE_CACHE_MAX_BYTES: %w", err)
+	}
+	ttl, err := time.ParseDuration(readFromENV("CVE_CACHE_TTL", defaultCveCacheTTL.String()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CVE_CACHE_TTL: %w", err)
+	}
+
+	cache := newCveCache(entries, maxBytes, ttl)
+
+	kafkaServer := readFromENV("KAFKA_BROKER", "localhost:9092")
+	kafkaUpdateTopic := readFromENV("KAFKA_UPDATE_TOPIC", "cve-updates")
+	go func() {
+		// updates to a CVE could be on any of the topic's partitions, so we read each of them
+		for _, partition := range topicPartitions(kafkaServer, kafkaUpdateTopic) {
+			reader := kafka.NewReader(kafka.ReaderConfig{
+				Brokers:   []string{kafkaServer}, // TODO handling for multiple brokers
+				Topic:     kafkaUpdateTopic,
+				Partition: partition.ID,
+				MaxBytes:  10e6,
+			})
+			go watchCveUpdates(reader, cache)
+		}
+	}()
+	fmt.Printf("Caching up to %d CVEs for %s, invalidated by %s on %s\n", entries, ttl, kafkaUpdateTopic, kafkaServer)
+
+	return &cachingDB{DBConnector: db, cache: cache}, nil
+}
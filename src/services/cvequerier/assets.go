@@ -0,0 +1,576 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"melaka/shared/purl"
+)
+
+const maxAssetComponents = 1000
+
+// a component an asset is built from, given as either a concrete CPE name or a package URL with its version
+type AssetComponent struct {
+	Cpe  string `bson:"cpe,omitempty" json:"cpe,omitempty"`   // e.g. cpe:2.3:a:apache:log4j:2.14.1:*:*:*:*:*:*:*
+	Purl string `bson:"purl,omitempty" json:"purl,omitempty"` // e.g. pkg:maven/org.apache.logging.log4j/log4j-core@2.14.1
+}
+
+// String returns the CPE name or package URL the component was given as
+func (c AssetComponent) String() string {
+	if c.Cpe != "" {
+		return c.Cpe
+	}
+	return c.Purl
+}
+
+// an asset a team owns, registered so we can tell them which CVEs it's exposed to
+type Asset struct {
+	ID         string           `bson:"id" json:"id"`
+	Name       string           `bson:"name" json:"name"`
+	Team       string           `bson:"team" json:"team"`
+	Components []AssetComponent `bson:"components" json:"components"`
+	CreatedAt  string           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  string           `bson:"updatedAt" json:"updatedAt"`
+}
+
+type AssetRequest struct {
+	Name       string           `json:"name"`
+	Team       string           `json:"team"`
+	Components []AssetComponent `json:"components"`
+}
+
+// validate checks an asset request has everything an asset needs, tidying up its team's name
+func (req *AssetRequest) validate() error {
+
+	req.Name, req.Team = strings.TrimSpace(req.Name), strings.TrimSpace(req.Team)
+	if req.Name == "" {
+		return fmt.Errorf("name must be given")
+	}
+	if req.Team == "" || strings.Contains(req.Team, "/") {
+		return fmt.Errorf("team must be given, and can't contain /")
+	}
+
+	if len(req.Components) == 0 || len(req.Components) > maxAssetComponents {
+		return fmt.Errorf("components must list between 1 and %d CPEs or package URLs", maxAssetComponents)
+	}
+	for _, component := range req.Components {
+		switch {
+		case (component.Cpe == "") == (component.Purl == ""):
+			return fmt.Errorf("each component must give one of cpe or purl")
+		case component.Cpe != "" && !strings.HasPrefix(component.Cpe, "cpe:2.3:"):
+			return fmt.Errorf("cpe must be a CPE 2.3 name: %s", component.Cpe)
+		case component.Purl != "":
+			if _, err := purl.Parse(component.Purl); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// a CVE an asset is exposed to, and which of its components it affects
+type AssetVulnerability struct {
+	CveID        string          `json:"cveId"`
+	Published    string          `json:"published"`
+	LastModified string          `json:"lastModified"`
+	Description  string          `json:"description"`
+	Score        *PreferredScore `json:"score,omitempty"`
+	Kev          bool            `json:"kev"`
+	Epss         *EpssData       `json:"epss,omitempty"`
+	Components   []string        `json:"components"`
+
+	// how the CVE has been triaged for the asset, if it has
+	Annotation *Annotation `json:"annotation,omitempty"`
+}
+
+// severity returns the CVE's qualitative rating, or NONE if it hasn't been scored
+func (v AssetVulnerability) severity() string {
+	if v.Score == nil || v.Score.BaseSeverity == "" {
+		return "NONE"
+	}
+	return v.Score.BaseSeverity
+}
+
+func (v AssetVulnerability) baseScore() float64 {
+	if v.Score == nil {
+		return 0
+	}
+	return v.Score.BaseScore
+}
+
+// an asset's exposure, as computed from the CVE data we hold at the time it's asked for
+type AssetExposure struct {
+	Asset           Asset                `json:"asset"`
+	ComputedAt      string               `json:"computedAt"`
+	Summary         ExposureSummary      `json:"summary"`
+	Vulnerabilities []AssetVulnerability `json:"vulnerabilities"`
+}
+
+// counts of the CVEs still to be dealt with. Those triaged as not_affected or fixed are only counted as resolved
+type ExposureSummary struct {
+	Total      int            `json:"total"`
+	Kev        int            `json:"kev"`
+	BySeverity map[string]int `json:"bySeverity"`
+	Resolved   int            `json:"resolved"`
+}
+
+func (s *ExposureSummary) add(score *PreferredScore, kev bool, resolved bool) {
+	if s.BySeverity == nil {
+		s.BySeverity = map[string]int{}
+	}
+	if resolved {
+		s.Resolved++
+		return
+	}
+	s.Total++
+	if kev {
+		s.Kev++
+	}
+	s.BySeverity[AssetVulnerability{Score: score}.severity()]++
+}
+
+// a team's exposure across all of its assets. Each CVE is listed once, with the assets it affects. A CVE only
+// counts as resolved for the team once it's been resolved for every asset
+type TeamExposure struct {
+	Team            string              `json:"team"`
+	ComputedAt      string              `json:"computedAt"`
+	Summary         ExposureSummary     `json:"summary"`
+	Assets          []TeamAssetExposure `json:"assets"`
+	Vulnerabilities []TeamVulnerability `json:"vulnerabilities"`
+}
+
+type TeamAssetExposure struct {
+	ID      string          `json:"id"`
+	Name    string          `json:"name"`
+	Summary ExposureSummary `json:"summary"`
+}
+
+type TeamVulnerability struct {
+	CveID  string          `json:"cveId"`
+	Score  *PreferredScore `json:"score,omitempty"`
+	Kev    bool            `json:"kev"`
+	Assets []string        `json:"assets"`
+
+	// the assets the CVE has been triaged as not_affected or fixed for
+	ResolvedAssets []string `json:"resolvedAssets,omitempty"`
+}
+
+// sortVulnerabilities puts the most severe CVEs first, with those being exploited ahead of the rest at each score
+func sortVulnerabilities(vulns []AssetVulnerability) {
+	sort.Slice(vulns, func(i, j int) bool {
+		if vulns[i].baseScore() != vulns[j].baseScore() {
+			return vulns[i].baseScore() > vulns[j].baseScore()
+		}
+		if vulns[i].Kev != vulns[j].Kev {
+			return vulns[i].Kev
+		}
+		return vulns[i].CveID < vulns[j].CveID
+	})
+}
+
+// assetVulnerabilities finds the CVEs that affect any of an asset's components. CPEs are matched the same way as
+// /cves?cpe=, through the match criteria they fall under. Package URLs are matched against the packages and
+// version ranges in the advisories packageAdvisories found for them, and the CVEs those advisories describe
+func (s *Server) assetVulnerabilities(asset *Asset, advisories map[string][]Advisory) ([]AssetVulnerability, error) {
+
+	byID := map[string]*AssetVulnerability{}
+	order := []string{}
+
+	add := func(cve *CveMsg, component string) {
+		vuln, ok := byID[cve.CveData.ID]
+		if !ok {
+			vuln = &AssetVulnerability{
+				CveID:        cve.CveData.ID,
+				Published:    cve.CveData.Published,
+				LastModified: cve.CveData.LastModified,
+				Description:  firstDescription(cve.CveData.Descriptions),
+				Score:        cve.PreferredScore,
+				Kev:          cve.Kev != nil,
+				Epss:         cve.Epss,
+				Components:   []string{},
+			}
+			byID[cve.CveData.ID] = vuln
+			order = append(order, cve.CveData.ID)
+		}
+		for _, c := range vuln.Components {
+			if c == component {
+				return
+			}
+		}
+		vuln.Components = append(vuln.Components, component)
+	}
+
+	for _, component := range asset.Components {
+
+		var cves []CveMsg
+		var err error
+		if component.Cpe != "" {
+			cves, err = s.cpeCves(component.Cpe)
+		} else {
+			cves, err = s.purlCves(component.Purl, advisories)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range cves {
+			add(&cves[i], component.String())
+		}
+	}
+
+	vulns := make([]AssetVulnerability, len(order))
+	for i, id := range order {
+		vulns[i] = *byID[id]
+	}
+	sortVulnerabilities(vulns)
+
+	if err := s.applyAnnotations(asset, vulns); err != nil {
+		return nil, err
+	}
+
+	return vulns, nil
+}
+
+// cpeCves returns every CVE that affects a concrete CPE name
+func (s *Server) cpeCves(cpe string) ([]CveMsg, error) {
+
+	filter := CveFilter{Cpe: cpe, Sort: "published", Descending: true}
+	if err := resolveFilter(s.db, &filter); err != nil {
+		return nil, err
+	}
+	if len(filter.MatchCriteriaIDs) == 0 {
+		return nil, nil
+	}
+
+	return s.db.SearchCves(filter)
+}
+
+// packageAdvisories looks up the advisories for every package the assets' components give purls for at once,
+// keyed by the package
+func (s *Server) packageAdvisories(assets ...*Asset) (map[string][]Advisory, error) {
+
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, asset := range assets {
+		for _, component := range asset.Components {
+			if component.Purl == "" {
+				continue
+			}
+			p, err := purl.Parse(component.Purl)
+			if err != nil || seen[p.Key()] {
+				continue
+			}
+			seen[p.Key()] = true
+			keys = append(keys, p.Key())
+		}
+	}
+
+	byPackage := map[string][]Advisory{}
+	if len(keys) == 0 {
+		return byPackage, nil
+	}
+
+	advisories, err := s.db.GetPackageAdvisories(keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, advisory := range advisories {
+		listed := map[string]bool{}
+		for _, affected := range advisory.Osv.Affected {
+			key := purl.PackageKey(affected.Package.Ecosystem, affected.Package.Name)
+			if seen[key] && !listed[key] {
+				listed[key] = true
+				byPackage[key] = append(byPackage[key], advisory)
+			}
+		}
+	}
+
+	return byPackage, nil
+}
+
+// purlCves returns every CVE an advisory says affects a package at the purl's version
+func (s *Server) purlCves(v string, advisories map[string][]Advisory) ([]CveMsg, error) {
+
+	p, err := purl.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, advisory := range advisories[p.Key()] {
+		if advisory.Osv.Withdrawn != "" {
+			continue
+		}
+
+		affected := false
+		for _, a := range advisory.Osv.Affected {
+			if purlAffected(p, a) {
+				affected = true
+				break
+			}
+		}
+		if !affected {
+			continue
+		}
+
+		for _, id := range append([]string{advisory.Osv.ID}, advisory.Osv.Aliases...) {
+			if strings.HasPrefix(id, "CVE-") && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	return s.db.GetCvesFromIDs(ids)
+}
+
+func (s *Server) createAsset(c *gin.Context) {
+
+	var req AssetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	asset := Asset{
+		ID:         randomUUID(),
+		Name:       req.Name,
+		Team:       req.Team,
+		Components: req.Components,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := s.db.CreateAsset(asset); err != nil {
+		log.Printf("Failed to create asset: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to create asset"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, asset)
+
+}
+
+// getAssets lists the assets we know of, or only a team's with ?team=
+func (s *Server) getAssets(c *gin.Context) {
+
+	assets, err := s.db.GetAssets(strings.TrimSpace(c.Query("team")))
+	if err != nil {
+		log.Printf("Failed to fetch assets: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch assets"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, assets)
+
+}
+
+// fetchAsset looks up the asset a request is for, responding with an error if it can't be found
+func (s *Server) fetchAsset(c *gin.Context) (*Asset, bool) {
+
+	asset, err := s.db.GetAsset(c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "asset not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to fetch asset %s: %s", c.Param("id"), err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch asset"})
+		return nil, false
+	}
+
+	return asset, true
+}
+
+func (s *Server) getAsset(c *gin.Context) {
+	if asset, ok := s.fetchAsset(c); ok {
+		c.IndentedJSON(http.StatusOK, asset)
+	}
+}
+
+// updateAsset replaces an asset's name, team and components
+func (s *Server) updateAsset(c *gin.Context) {
+
+	var req AssetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	asset, ok := s.fetchAsset(c)
+	if !ok {
+		return
+	}
+
+	asset.Name, asset.Team, asset.Components = req.Name, req.Team, req.Components
+	asset.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	err := s.db.UpdateAsset(*asset)
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "asset not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update asset %s: %s", asset.ID, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to update asset"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, asset)
+
+}
+
+func (s *Server) deleteAsset(c *gin.Context) {
+
+	err := s.db.DeleteAsset(c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "asset not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete asset %s: %s", c.Param("id"), err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to delete asset"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+
+}
+
+// getAssetVulnerabilities lists the CVEs an asset is exposed to, most severe first, with how each has been triaged.
+// It's worked out from the CVE data we hold when it's asked for, so it takes in new CVEs and updates to old ones as
+// soon as cvewriter has them
+func (s *Server) getAssetVulnerabilities(c *gin.Context) {
+
+	asset, ok := s.fetchAsset(c)
+	if !ok {
+		return
+	}
+
+	advisories, err := s.packageAdvisories(asset)
+	if err != nil {
+		log.Printf("Failed to fetch advisories for asset %s: %s", asset.ID, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to compute vulnerabilities"})
+		return
+	}
+
+	vulns, err := s.assetVulnerabilities(asset, advisories)
+	if err != nil {
+		log.Printf("Failed to compute vulnerabilities for asset %s: %s", asset.ID, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to compute vulnerabilities"})
+		return
+	}
+
+	exposure := AssetExposure{
+		Asset:           *asset,
+		ComputedAt:      time.Now().UTC().Format(time.RFC3339),
+		Summary:         ExposureSummary{BySeverity: map[string]int{}},
+		Vulnerabilities: vulns,
+	}
+	for _, vuln := range vulns {
+		exposure.Summary.add(vuln.Score, vuln.Kev, vuln.Annotation.resolved())
+	}
+
+	c.IndentedJSON(http.StatusOK, exposure)
+
+}
+
+// getTeamExposure sums up the CVEs each of a team's assets is exposed to, and lists every CVE affecting any of them
+// with the assets it affects
+func (s *Server) getTeamExposure(c *gin.Context) {
+
+	team := strings.TrimSpace(c.Param("team"))
+
+	assets, err := s.db.GetAssets(team)
+	if err != nil {
+		log.Printf("Failed to fetch assets for team %s: %s", team, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to compute exposure"})
+		return
+	}
+	if len(assets) == 0 {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "team has no assets"})
+		return
+	}
+
+	exposure := TeamExposure{
+		Team:            team,
+		ComputedAt:      time.Now().UTC().Format(time.RFC3339),
+		Summary:         ExposureSummary{BySeverity: map[string]int{}},
+		Assets:          []TeamAssetExposure{},
+		Vulnerabilities: []TeamVulnerability{},
+	}
+
+	// the advisories for every asset's packages are looked up together, rather than once per component
+	teamAssets := make([]*Asset, len(assets))
+	for i := range assets {
+		teamAssets[i] = &assets[i]
+	}
+	advisories, err := s.packageAdvisories(teamAssets...)
+	if err != nil {
+		log.Printf("Failed to fetch advisories for team %s: %s", team, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to compute exposure"})
+		return
+	}
+
+	all := []AssetVulnerability{}
+	affectedAssets, resolvedAssets := map[string][]string{}, map[string][]string{}
+	for i := range assets {
+		vulns, err := s.assetVulnerabilities(&assets[i], advisories)
+		if err != nil {
+			log.Printf("Failed to compute vulnerabilities for asset %s: %s", assets[i].ID, err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to compute exposure"})
+			return
+		}
+
+		assetExposure := TeamAssetExposure{ID: assets[i].ID, Name: assets[i].Name, Summary: ExposureSummary{BySeverity: map[string]int{}}}
+		for _, vuln := range vulns {
+			assetExposure.Summary.add(vuln.Score, vuln.Kev, vuln.Annotation.resolved())
+			if _, ok := affectedAssets[vuln.CveID]; !ok {
+				all = append(all, vuln)
+				affectedAssets[vuln.CveID] = []string{}
+			}
+			if vuln.Annotation.resolved() {
+				resolvedAssets[vuln.CveID] = append(resolvedAssets[vuln.CveID], assets[i].ID)
+			} else {
+				affectedAssets[vuln.CveID] = append(affectedAssets[vuln.CveID], assets[i].ID)
+			}
+		}
+		exposure.Assets = append(exposure.Assets, assetExposure)
+	}
+
+	sortVulnerabilities(all)
+	for _, vuln := range all {
+		exposure.Summary.add(vuln.Score, vuln.Kev, len(affectedAssets[vuln.CveID]) == 0)
+		exposure.Vulnerabilities = append(exposure.Vulnerabilities, TeamVulnerability{
+			CveID:          vuln.CveID,
+			Score:          vuln.Score,
+			Kev:            vuln.Kev,
+			Assets:         affectedAssets[vuln.CveID],
+			ResolvedAssets: resolvedAssets[vuln.CveID],
+		})
+	}
+
+	c.IndentedJSON(http.StatusOK, exposure)
+
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the scopes a caller can be granted
+const (
+	ScopeRead     = "read"     // read CVEs, assets, annotations and the rest
+	ScopeAnnotate = "annotate" // triage CVEs
+	ScopeAdmin    = "admin"    // manage assets, subscriptions and API keys
+)
+
+// what each scope grants. Each grants everything the scopes below it do
+var scopeGrants = map[string][]string{
+	ScopeRead:     {ScopeRead},
+	ScopeAnnotate: {ScopeRead, ScopeAnnotate},
+	ScopeAdmin:    {ScopeRead, ScopeAnnotate, ScopeAdmin},
+}
+
+// API keys look like mk_<id>_<secret>, so we can find a key by its ID without ever storing the secret
+const apiKeyPrefix = "mk_"
+
+// the gin context key the caller's Principal is kept under
+const principalKey = "principal"
+
+// an API key, as stored. Only a hash of the key itself is kept, so it's only ever seen when it's minted
+type ApiKey struct {
+	ID        string   `bson:"id" json:"id"`
+	Name      string   `bson:"name" json:"name"`
+	Hash      string   `bson:"hash" json:"-"` // the hex SHA-256 of the whole key
+	Scopes    []string `bson:"scopes" json:"scopes"`
+	CreatedBy string   `bson:"createdBy" json:"createdBy"`
+	CreatedAt string   `bson:"createdAt" json:"createdAt"`
+	RevokedAt string   `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+type ApiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// a newly minted API key, the one time it's given out in full
+type MintedApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+// who's calling, and what they're allowed to do
+type Principal struct {
+	Subject string   `json:"subject"` // an API key's name, or a token's subject
+	Method  string   `json:"method"`  // apiKey or jwt
+	Scopes  []string `json:"scopes"`
+
+	key string // identifies the key or token the principal was authenticated with, which is what's rate limited
+}
+
+// has reports whether the principal has been granted a scope, directly or through a broader one
+func (p *Principal) has(scope string) bool {
+	for _, granted := range p.Scopes {
+		for _, s := range scopeGrants[granted] {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AuthConfig says how callers authenticate. A server without one lets everyone do everything, as it did before
+// there were keys
+type AuthConfig struct {
+	// a key with the admin scope that isn't stored anywhere, to mint the first stored keys with
+	BootstrapKey string
+	// verifies OIDC bearer tokens, if they're accepted
+	JWT *JWTVerifier
+}
+
+var errUnauthenticated = errors.New("no API key or bearer token given")
+
+// authenticate works out who a request is from, from an API key in the X-API-Key header or either an API key or a
+// JWT as a bearer token
+func (s *Server) authenticate(r *http.Request) (*Principal, error) {
+
+	credential := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); credential == "" && auth != "" {
+		scheme, token, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, fmt.Errorf("unsupported authorization scheme %s", scheme)
+		}
+		credential = strings.TrimSpace(token)
+	}
+	if credential == "" {
+		return nil, errUnauthenticated
+	}
+
+	if s.auth.BootstrapKey != "" && subtle.ConstantTimeCompare([]byte(credential), []byte(s.auth.BootstrapKey)) == 1 {
+		return &Principal{Subject: "bootstrap", Method: "apiKey", Scopes: []string{ScopeAdmin}, key: "apiKey:bootstrap"}, nil
+	}
+
+	if strings.HasPrefix(credential, apiKeyPrefix) {
+		return s.authenticateApiKey(credential)
+	}
+
+	if s.auth.JWT != nil {
+		return s.auth.JWT.Verify(credential)
+	}
+
+	return nil, errors.New("invalid API key")
+}
+
+func (s *Server) authenticateApiKey(key string) (*Principal, error) {
+
+	id, _, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+
+	stored, err := s.db.GetApiKey(id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errors.New("invalid API key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key %s: %w", id, err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashApiKey(key)), []byte(stored.Hash)) != 1 {
+		return nil, errors.New("invalid API key")
+	}
+	if stored.RevokedAt != "" {
+		return nil, errors.New("API key has been revoked")
+	}
+
+	return &Principal{Subject: stored.Name, Method: "apiKey", Scopes: stored.Scopes, key: "apiKey:" + stored.ID}, nil
+}
+
+// requireScope only lets through requests from callers granted the scope. Callers we can't identify get a 401, and
+// those without the scope a 403. Why a credential was refused is only logged, so a 401 doesn't tell the caller which
+// keys exist or give away anything about the database behind them
+func (s *Server) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		if s.auth == nil {
+			c.Next()
+			return
+		}
+
+		principal, err := s.authenticate(c.Request)
+		if err != nil {
+			message := "authentication required"
+			if !errors.Is(err, errUnauthenticated) {
+				log.Printf("Failed to authenticate request to %s: %s", c.Request.URL.Path, err)
+				message = "invalid credentials"
+			}
+			c.Header("WWW-Authenticate", `Bearer realm="cvequerier"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+			return
+		}
+
+		if !principal.has(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("the %s scope is required", scope)})
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// principal returns who made a request, or nil if the server doesn't authenticate requests
+func principal(c *gin.Context) *Principal {
+	if p, ok := c.Get(principalKey); ok {
+		return p.(*Principal)
+	}
+	return nil
+}
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (req *ApiKeyRequest) validate() error {
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return fmt.Errorf("name must be given")
+	}
+
+	if len(req.Scopes) == 0 {
+		return fmt.Errorf("at least one scope must be given")
+	}
+	for _, scope := range req.Scopes {
+		if scopeGrants[scope] == nil {
+			return fmt.Errorf("unknown scope %s, must be one of read, annotate or admin", scope)
+		}
+	}
+
+	return nil
+}
+
+// createApiKey mints an API key. The key is only in the response, so has to be kept by whoever asked for it
+func (s *Server) createApiKey(c *gin.Context) {
+
+	var req ApiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := randomHex(8)
+	key := apiKeyPrefix + id + "_" + randomHex(32)
+
+	minted := MintedApiKey{
+		ApiKey: ApiKey{
+			ID:        id,
+			Name:      req.Name,
+			Hash:      hashApiKey(key),
+			Scopes:    req.Scopes,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		Key: key,
+	}
+	if p := principal(c); p != nil {
+		minted.CreatedBy = p.Subject
+	}
+
+	if err := s.db.CreateApiKey(minted.ApiKey); err != nil {
+		log.Printf("Failed to create API key: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to save API key"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, minted)
+
+}
+
+func (s *Server) getApiKeys(c *gin.Context) {
+
+	keys, err := s.db.GetApiKeys()
+	if err != nil {
+		log.Printf("Failed to fetch API keys: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch API keys"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, keys)
+
+}
+
+// revokeApiKey stops a key from being accepted. The key is kept, so it's clear who it was issued to and when it
+// stopped working
+func (s *Server) revokeApiKey(c *gin.Context) {
+
+	err := s.db.RevokeApiKey(c.Param("id"), time.Now().UTC().Format(time.RFC3339))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to revoke API key %s: %s", c.Param("id"), err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke API key"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+
+}
+
+// whoami says who the caller has authenticated as, which helps when working out why they're being refused
+func (s *Server) whoami(c *gin.Context) {
+
+	p := principal(c)
+	if p == nil {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "authentication is disabled"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, p)
+
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testBootstrapKey = "test-bootstrap-key-0123456789abcdef"
+
+func TestApiKeyAuth(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+	server.auth = &AuthConfig{BootstrapKey: testBootstrapKey}
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	mint := func(name, scopes string) MintedApiKey {
+		resp := do("POST", "/auth/keys", testBootstrapKey, `{"name": "`+name+`", "scopes": [`+scopes+`]}`)
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		var minted MintedApiKey
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &minted))
+		return minted
+	}
+
+	reader := mint("dashboard", `"read"`)
+	triager := mint("alice", `"annotate"`)
+	assert.True(t, strings.HasPrefix(reader.Key, apiKeyPrefix+reader.ID+"_"))
+	assert.Equal(t, "bootstrap", reader.CreatedBy)
+
+	// only a hash of the key is kept, and it's never given out again
+	assert.Equal(t, hashApiKey(reader.Key), db.apiKeys[reader.ID].Hash)
+	resp := do("GET", "/auth/keys", testBootstrapKey, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), reader.Key)
+	assert.NotContains(t, resp.Body.String(), "hash")
+
+	// no key, or one we don't know
+	resp = do("GET", "/cve/CVE-2021-44228", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("WWW-Authenticate"))
+	resp = do("GET", "/cve/CVE-2021-44228", apiKeyPrefix+reader.ID+"_wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// the key can also be given in X-API-Key
+	req, _ := http.NewRequest("GET", "/cve/CVE-2021-44228", nil)
+	req.Header.Set("X-API-Key", reader.Key)
+	rec := httptest.NewRecorder()
+	server.router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// read can't triage, annotate can, and annotate can read too
+	annotation := `{"product": "pkg:npm/left-pad", "cveId": "CVE-2021-44228", "status": "under_investigation", "author": "mallory"}`
+	resp = do("POST", "/annotations", reader.Key, annotation)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = do("POST", "/annotations", triager.Key, annotation)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	var created Annotation
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	assert.Equal(t, "alice", created.Author)
+	resp = do("PUT", "/annotations/"+created.ID, triager.Key, `{"status": "fixed", "author": "mallory"}`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	assert.Equal(t, "alice", created.Author)
+	resp = do("GET", "/annotations", triager.Key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// only admins manage keys, assets and subscriptions
+	resp = do("POST", "/auth/keys", triager.Key, `{"name": "mallory", "scopes": ["admin"]}`)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = do("POST", "/assets", triager.Key, `{"name": "payments-api", "team": "payments", "components": [{"cpe": "`+mockCpeName+`"}]}`)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = do("GET", "/subscriptions", reader.Key, "")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = do("GET", "/auth/whoami", reader.Key, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var p Principal
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &p))
+	assert.Equal(t, Principal{Subject: "dashboard", Method: "apiKey", Scopes: []string{"read"}}, p)
+
+	// metrics stay open for prometheus
+	resp = do("GET", "/metrics", "", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// once revoked, a key's refused
+	resp = do("DELETE", "/auth/keys/"+reader.ID, testBootstrapKey, "")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	resp = do("GET", "/cve/CVE-2021-44228", reader.Key, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = do("DELETE", "/auth/keys/missing", testBootstrapKey, "")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+}
+
+func TestApiKeyAuth_Reject_Invalid_Keys(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+	server.auth = &AuthConfig{BootstrapKey: testBootstrapKey}
+
+	bodies := []string{
+		`{"scopes": ["read"]}`,
+		`{"name": "dashboard"}`,
+		`{"name": "dashboard", "scopes": []}`,
+		`{"name": "dashboard", "scopes": ["write"]}`,
+	}
+
+	for _, body := range bodies {
+		req, _ := http.NewRequest("POST", "/auth/keys", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testBootstrapKey)
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, body)
+	}
+
+}
+
+// unreachableKeysDatabase fails every API key lookup, as if the database were down
+type unreachableKeysDatabase struct {
+	MockDatabase
+}
+
+func (m *unreachableKeysDatabase) GetApiKey(id string) (*ApiKey, error) {
+	return nil, errors.New("server selection error: connection refused to mongodb://10.0.0.5:27017")
+}
+
+func TestApiKeyAuth_Does_Not_Leak_Errors(t *testing.T) {
+
+	server := buildServer(&unreachableKeysDatabase{})
+	server.auth = &AuthConfig{BootstrapKey: testBootstrapKey}
+
+	get := func(auth string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/cve/CVE-2021-44228", nil)
+		req.Header.Set("Authorization", auth)
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// whatever went wrong, the caller's only told their credentials were refused
+	for _, auth := range []string{"Bearer " + apiKeyPrefix + "abc_secret", "Bearer " + apiKeyPrefix + "nounderscore", "Basic dXNlcjpwYXNz"} {
+		resp := get(auth)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code, auth)
+		assert.JSONEq(t, `{"error": "invalid credentials"}`, resp.Body.String(), auth)
+	}
+
+	resp := get("")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.JSONEq(t, `{"error": "authentication required"}`, resp.Body.String())
+
+}
+
+// signToken makes a JWT with the given claims, signed with an RSA or EC P-256 key
+func signToken(t *testing.T, key crypto.Signer, kid string, claims map[string]interface{}) string {
+
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestReadAuthConfig(t *testing.T) {
+
+	t.Setenv("AUTH_ENABLED", "false")
+	config, err := readAuthConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	// with nothing to authenticate with, nobody could ever mint the first key
+	t.Setenv("AUTH_ENABLED", "true")
+	t.Setenv("AUTH_BOOTSTRAP_KEY", "")
+	t.Setenv("AUTH_JWKS", "")
+	_, err = readAuthConfig()
+	assert.EqualError(t, err, "authentication is enabled, but neither AUTH_BOOTSTRAP_KEY nor AUTH_JWKS is set")
+
+	t.Setenv("AUTH_BOOTSTRAP_KEY", "too-short")
+	_, err = readAuthConfig()
+	assert.Error(t, err)
+
+	t.Setenv("AUTH_BOOTSTRAP_KEY", testBootstrapKey)
+	config, err = readAuthConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, testBootstrapKey, config.BootstrapKey)
+
+}
+
+func TestJWTVerifier(t *testing.T) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "oct", "kid": "hmac-1", "k": "c2VjcmV0"},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewJWTVerifier(path, "https://idp.example.com", "cvequerier", "scope")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://idp.example.com",
+			"aud":   []string{"cvequerier", "other"},
+			"sub":   "alice@example.com",
+			"exp":   now.Add(time.Hour).Unix(),
+			"nbf":   now.Add(-time.Minute).Unix(),
+			"scope": "openid annotate",
+		}
+		for k, v := range changes {
+			c[k] = v
+		}
+		return c
+	}
+
+	p, err := verifier.Verify(signToken(t, rsaKey, "rsa-1", claims(nil)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Principal{Subject: "alice@example.com", Method: "jwt", Scopes: []string{"annotate"}, key: "jwt:alice@example.com"}, p)
+	}
+	_, err = verifier.Verify(signToken(t, ecKey, "ec-1", claims(map[string]interface{}{"aud": "cvequerier"})))
+	assert.NoError(t, err)
+
+	bad := map[string]string{
+		"expired":        signToken(t, rsaKey, "rsa-1", claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})),
+		"not yet valid":  signToken(t, rsaKey, "rsa-1", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})),
+		"wrong issuer":   signToken(t, rsaKey, "rsa-1", claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+		"wrong audience": signToken(t, rsaKey, "rsa-1", claims(map[string]interface{}{"aud": "other"})),
+		"no subject":     signToken(t, rsaKey, "rsa-1", claims(map[string]interface{}{"sub": ""})),
+		"unknown key":    signToken(t, rsaKey, "rsa-2", claims(nil)),
+		"wrong key":      signToken(t, rsaKey, "ec-1", claims(nil)),
+		"malformed":      "not.a.token",
+	}
+	for name, token := range bad {
+		_, err := verifier.Verify(token)
+		assert.Error(t, err, name)
+	}
+
+	// a tampered payload doesn't match the signature
+	parts := strings.Split(signToken(t, rsaKey, "rsa-1", claims(nil)), ".")
+	payload, _ := json.Marshal(claims(map[string]interface{}{"scope": "admin"}))
+	_, err = verifier.Verify(parts[0] + "." + b64(payload) + "." + parts[2])
+	assert.Error(t, err)
+
+	// alg none is never accepted
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "rsa-1"})
+	_, err = verifier.Verify(b64(header) + "." + parts[1] + ".")
+	assert.Error(t, err)
+
+	// bearer tokens that aren't API keys go to the verifier
+	server := buildServer(&MockDatabase{})
+	server.auth = &AuthConfig{JWT: verifier}
+	req, _ := http.NewRequest("POST", "/annotations", strings.NewReader(`{"product": "pkg:npm/left-pad", "cveId": "CVE-2021-44228", "status": "under_investigation"}`))
+	req.Header.Set("Authorization", "Bearer "+signToken(t, ecKey, "ec-1", claims(nil)))
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	req, _ = http.NewRequest("GET", "/auth/keys", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken(t, ecKey, "ec-1", claims(nil)))
+	resp = httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+}
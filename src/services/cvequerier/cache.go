@@ -0,0 +1,226 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultCveCacheEntries  = 10000
+	defaultCveCacheMaxBytes = 256 << 20
+	defaultCveCacheTTL      = time.Hour
+)
+
+var (
+	cveCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cvequerier_cve_cache_hits_total",
+		Help: "CVE lookups served from the in-process cache",
+	})
+	cveCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cvequerier_cve_cache_misses_total",
+		Help: "CVE lookups that had to go to the database",
+	})
+	cveCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cvequerier_cve_cache_evictions_total",
+		Help: "CVEs dropped from the cache to make room for others",
+	})
+	cveCacheInvalidations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cvequerier_cve_cache_invalidations_total",
+		Help: "CVEs dropped from the cache because cvewriter updated them",
+	})
+	cveCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cvequerier_cve_cache_bytes",
+		Help: "The size of the CVE records held in the cache",
+	})
+)
+
+// cveCache is an LRU cache of CVE records, as the BSON they're stored as. Handlers fill in and change the records
+// they're given, so each lookup decodes a fresh copy rather than sharing one. Records are dropped once they've been
+// cached for the TTL, or when cvewriter tells us they've changed
+type cveCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+	now        func() time.Time
+
+	entries map[string]*list.Element
+	order   *list.List // most recently used at the front
+	bytes   int
+
+	// bumped whenever a record is invalidated, so a lookup that raced an update doesn't cache what it read before it
+	generation uint64
+}
+
+type cveCacheEntry struct {
+	id      string
+	doc     []byte
+	expires time.Time
+}
+
+func newCveCache(maxEntries, maxBytes int, ttl time.Duration) *cveCache {
+	return &cveCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		now:        time.Now,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+func (c *cveCache) get(id string) ([]byte, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cveCacheEntry)
+	if c.now().After(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+	return entry.doc, true
+}
+
+// put caches a record read at the given generation, unless a record has been invalidated since
+func (c *cveCache) put(id string, doc []byte, generation uint64) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || len(doc) > c.maxBytes {
+		return
+	}
+
+	if e, ok := c.entries[id]; ok {
+		c.remove(e)
+	}
+	c.entries[id] = c.order.PushFront(&cveCacheEntry{id: id, doc: doc, expires: c.now().Add(c.ttl)})
+	c.bytes += len(doc)
+
+	for len(c.entries) > c.maxEntries || c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+		cveCacheEvictions.Inc()
+	}
+	cveCacheBytes.Set(float64(c.bytes))
+}
+
+func (c *cveCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *cveCache) invalidate(id string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if e, ok := c.entries[id]; ok {
+		c.remove(e)
+		cveCacheInvalidations.Inc()
+		cveCacheBytes.Set(float64(c.bytes))
+	}
+}
+
+func (c *cveCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*cveCacheEntry)
+	delete(c.entries, entry.id)
+	c.bytes -= len(entry.doc)
+}
+
+// cachingDB puts a cveCache in front of a database's CVE lookups
+type cachingDB struct {
+	DBConnector
+	cache *cveCache
+}
+
+func (db *cachingDB) GetCveFromID(id string) (*CveMsg, error) {
+
+	if doc, ok := db.cache.get(id); ok {
+		var cve CveMsg
+		if err := bson.UnmarshalWithRegistry(jsonFallbackRegistry, doc, &cve); err == nil {
+			cveCacheHits.Inc()
+			return &cve, nil
+		}
+	}
+	cveCacheMisses.Inc()
+
+	generation := db.cache.currentGeneration()
+	cve, err := db.DBConnector.GetCveFromID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc, err := bson.MarshalWithRegistry(jsonFallbackRegistry, cve); err == nil {
+		db.cache.put(id, doc, generation)
+	} else {
+		log.Printf("Failed to cache CVE %s: %s", id, err)
+	}
+
+	return cve, nil
+}
+
+// topicPartitions looks up a topic's partitions, waiting for kafka to be up and the topic to exist if need be
+func topicPartitions(broker, topic string) []kafka.Partition {
+
+	for {
+		conn, err := kafka.Dial("tcp", broker)
+		if err == nil {
+			var partitions []kafka.Partition
+			partitions, err = conn.ReadPartitions(topic)
+			conn.Close()
+			if err == nil && len(partitions) > 0 {
+				return partitions
+			} else if err == nil {
+				err = fmt.Errorf("topic has no partitions")
+			}
+		}
+		log.Printf("Failed to look up the partitions of %s, retrying: %s", topic, err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// watchCveUpdates drops CVEs from the cache as cvewriter tells us they've been updated. Every replica has its own
+// cache, so each reads every update rather than sharing them out in a consumer group, starting from the newest. A
+// reader only reads one of the topic's partitions, so there's one of these for each
+func watchCveUpdates(reader *kafka.Reader, cache *cveCache) {
+
+	if err := reader.SetOffset(kafka.LastOffset); err != nil {
+		log.Printf("Failed to skip to the newest CVE updates: %s", err)
+	}
+
+	for {
+		m, err := reader.ReadMessage(context.Background())
+		if err != nil {
+			log.Printf("Failed to read CVE update, cached CVEs may be stale until they expire: %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var update CveUpdateMsg
+		if err := json.Unmarshal(m.Value, &update); err != nil || update.CveID == "" {
+			log.Printf("Ignoring malformed CVE update at offset %d", m.Offset)
+			continue
+		}
+
+		cache.invalidate(update.CveID)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCveCache(t *testing.T) {
+
+	now := time.Date(2023, 7, 13, 12, 0, 0, 0, time.UTC)
+	cache := newCveCache(2, 10, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.put("CVE-1", []byte("aaa"), 0)
+	cache.put("CVE-2", []byte("bbb"), 0)
+	_, ok := cache.get("CVE-1")
+	assert.True(t, ok)
+
+	// the least recently used goes first, whether we're out of entries or bytes
+	cache.put("CVE-3", []byte("ccc"), 0)
+	_, ok = cache.get("CVE-2")
+	assert.False(t, ok)
+	cache.put("CVE-4", []byte("dddddddd"), 0)
+	_, ok = cache.get("CVE-1")
+	assert.False(t, ok)
+	_, ok = cache.get("CVE-3")
+	assert.False(t, ok)
+	assert.Equal(t, 8, cache.bytes)
+
+	// records too big to ever fit aren't cached at all
+	cache.put("CVE-5", []byte("eeeeeeeeeee"), 0)
+	_, ok = cache.get("CVE-5")
+	assert.False(t, ok)
+
+	// and records expire
+	now = now.Add(2 * time.Minute)
+	_, ok = cache.get("CVE-4")
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.bytes)
+
+	// a record read before an update isn't cached after it
+	generation := cache.currentGeneration()
+	cache.invalidate("CVE-6")
+	cache.put("CVE-6", []byte("fff"), generation)
+	_, ok = cache.get("CVE-6")
+	assert.False(t, ok)
+
+}
+
+// countingDatabase counts the CVE lookups that reach the database
+type countingDatabase struct {
+	MockDatabase
+	lookups int
+}
+
+func (m *countingDatabase) GetCveFromID(id string) (*CveMsg, error) {
+	m.lookups++
+	return m.MockDatabase.GetCveFromID(id)
+}
+
+func TestCachingDB(t *testing.T) {
+
+	db := &countingDatabase{}
+	cached := &cachingDB{DBConnector: db, cache: newCveCache(10, 1<<20, time.Minute)}
+
+	first, err := cached.GetCveFromID("CVE-2021-44228")
+	assert.NoError(t, err)
+
+	// what's handed out can be changed without changing what's cached
+	first.CveData.VulnStatus = "Changed"
+	first.WeaknessNames = map[string]string{"CWE-79": "Cross-site Scripting"}
+
+	second, err := cached.GetCveFromID("CVE-2021-44228")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, db.lookups)
+	assert.Equal(t, "CVE-2021-44228", second.CveData.ID)
+	assert.Empty(t, second.CveData.VulnStatus)
+	assert.Nil(t, second.WeaknessNames)
+
+	// missing CVEs aren't cached
+	_, err = cached.GetCveFromID(missingCveID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = cached.GetCveFromID(missingCveID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 3, db.lookups)
+
+	cached.cache.invalidate("CVE-2021-44228")
+	_, err = cached.GetCveFromID("CVE-2021-44228")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, db.lookups)
+
+}
+
+func TestGetCve_Conditional(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/cve/CVE-2021-44228", nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	etag := resp.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, "Accept", resp.Header().Get("Vary"))
+
+	resp = get("/cve/CVE-2021-44228", map[string]string{"If-None-Match": `"other", ` + etag})
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+	assert.Empty(t, resp.Body.String())
+	assert.Equal(t, etag, resp.Header().Get("ETag"))
+
+	// the same CVE in another format or asked for with other parameters is a different response
+	resp = get("/cve/CVE-2021-44228", map[string]string{"If-None-Match": etag, "Accept": mimeStix})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEqual(t, etag, resp.Header().Get("ETag"))
+	resp = get("/cve/CVE-2021-44228?distro=debian", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = get("/cve/CVE-2021-44228", map[string]string{"If-None-Match": `"stale"`})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+}
+
+func TestGetCve_Conditional_Merged_Sources(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// a distro changing its status changes the response, though the CVE's record hasn't changed
+	resp := get("/cve/CVE-2021-44228?distro=debian", "")
+	etag := resp.Header().Get("ETag")
+	assert.Empty(t, resp.Header().Get("Last-Modified"))
+	assert.Equal(t, http.StatusNotModified, get("/cve/CVE-2021-44228?distro=debian", etag).Code)
+
+	statuses := mockDistroStatuses
+	defer func() { mockDistroStatuses = statuses }()
+	mockDistroStatuses = append([]DistroStatus{}, statuses...)
+	mockDistroStatuses[0].Status = "affected"
+	assert.Equal(t, http.StatusOK, get("/cve/CVE-2021-44228?distro=debian", etag).Code)
+
+	// as does an advisory being updated, which for a CVE no distro has assessed moves Last-Modified on too
+	mockDistroStatuses = nil
+	db.advisories = []Advisory{{Source: "GHSA", Osv: OsvAdvisory{ID: "GHSA-jfh8-c2jp-5v3q", Modified: "2030-01-02T03:04:05Z"}}}
+	resp = get("/cve/CVE-2021-44228", "")
+	etag = resp.Header().Get("ETag")
+	assert.Equal(t, "Wed, 02 Jan 2030 03:04:05 GMT", resp.Header().Get("Last-Modified"))
+	assert.Equal(t, http.StatusNotModified, get("/cve/CVE-2021-44228", etag).Code)
+
+	db.advisories[0].Osv.Withdrawn = "2030-02-01T00:00:00Z"
+	assert.Equal(t, http.StatusOK, get("/cve/CVE-2021-44228", etag).Code)
+
+}
+
+func TestNotModified_Since(t *testing.T) {
+
+	lastModified := time.Date(2023, 4, 3, 20, 15, 9, 143000000, time.UTC)
+
+	check := func(headers map[string]string) int {
+		server := buildServer(&MockDatabase{})
+		server.router.GET("/test", func(c *gin.Context) {
+			if !notModified(c, `W/"abc"`, lastModified) {
+				c.Status(http.StatusOK)
+			}
+		})
+		req, _ := http.NewRequest("GET", "/test", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, "Mon, 03 Apr 2023 20:15:09 GMT", resp.Header().Get("Last-Modified"))
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusNotModified, check(map[string]string{"If-Modified-Since": "Mon, 03 Apr 2023 20:15:09 GMT"}))
+	assert.Equal(t, http.StatusOK, check(map[string]string{"If-Modified-Since": "Mon, 03 Apr 2023 20:15:08 GMT"}))
+	assert.Equal(t, http.StatusNotModified, check(map[string]string{"If-None-Match": `"abc"`}))
+
+	// If-None-Match wins over If-Modified-Since
+	assert.Equal(t, http.StatusOK, check(map[string]string{"If-None-Match": `"xyz"`, "If-Modified-Since": "Mon, 03 Apr 2023 20:15:09 GMT"}))
+
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// cveValidators works out the ETag and Last-Modified of a response about a CVE, before anything is merged into it.
+// The ETag covers the whole stored record, so it changes whenever any source updates the CVE, the advisories and
+// distro statuses that are merged into it, and the query and format the response was asked for in. Last-Modified is
+// when NVD or an advisory last changed the CVE. Distro trackers don't say when they changed a status, so responses
+// with any distro statuses have no Last-Modified, and can only be revalidated with the ETag
+func cveValidators(c *gin.Context, cve *CveMsg, advisories []Advisory, statuses []DistroStatus) (string, time.Time, error) {
+
+	doc, err := bson.MarshalWithRegistry(jsonFallbackRegistry, struct {
+		Cve          *CveMsg
+		Advisories   []Advisory
+		DistroStatus []DistroStatus
+	}{cve, advisories, statuses})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	h := sha256.New()
+	h.Write(doc)
+	h.Write([]byte{0})
+	h.Write([]byte(c.Request.URL.RawQuery))
+	h.Write([]byte{0})
+	h.Write([]byte(c.NegotiateFormat(gin.MIMEJSON, mimeStix, mimeCsaf)))
+
+	// weak, as the CWE names merged in when we respond could change without the record doing so
+	etag := `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+	if len(statuses) > 0 {
+		return etag, time.Time{}, nil
+	}
+	lastModified := parseNvdTime(cve.CveData.LastModified)
+	for _, advisory := range advisories {
+		if t, err := time.Parse(time.RFC3339, advisory.Osv.Modified); err == nil && t.After(lastModified) {
+			lastModified = t
+		}
+	}
+
+	return etag, lastModified, nil
+}
+
+// notModified sets a response's validators and reports whether the client already has it, per the request's
+// If-None-Match or, failing that, If-Modified-Since. If so, it responds with a 304
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("Vary", "Accept")
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	fresh := false
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		fresh = etagMatches(match, etag)
+	} else if since := c.GetHeader("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(since); err == nil {
+			fresh = !lastModified.Truncate(time.Second).After(t)
+		}
+	}
+
+	if fresh {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+	}
+
+	return fresh
+}
+
+// etagMatches compares an If-None-Match list to an ETag, weakly as RFC 9110 has If-None-Match do
+func etagMatches(match, etag string) bool {
+	for _, candidate := range strings.Split(match, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
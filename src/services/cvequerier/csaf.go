@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const csafVersion = "2.0"
+
+// CsafPublisher is who CSAF documents we produce say they're from
+type CsafPublisher struct {
+	Category  string `json:"category"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+var defaultCsafPublisher = CsafPublisher{
+	Category:  "other",
+	Name:      "Melaka",
+	Namespace: "urn:melaka",
+}
+
+// a CSAF 2.0 document in the base profile. The product tree comes last, as it's only complete once every
+// vulnerability has been described
+type CsafDocument struct {
+	Document        CsafDocumentMeta    `json:"document"`
+	Vulnerabilities []CsafVulnerability `json:"vulnerabilities"`
+	ProductTree     *CsafProductTree    `json:"product_tree,omitempty"`
+}
+
+type CsafDocumentMeta struct {
+	Category    string        `json:"category"`
+	CsafVersion string        `json:"csaf_version"`
+	Publisher   CsafPublisher `json:"publisher"`
+	Title       string        `json:"title"`
+	Tracking    CsafTracking  `json:"tracking"`
+}
+
+type CsafTracking struct {
+	ID                 string         `json:"id"`
+	Status             string         `json:"status"`
+	Version            string         `json:"version"`
+	InitialReleaseDate string         `json:"initial_release_date"`
+	CurrentReleaseDate string         `json:"current_release_date"`
+	RevisionHistory    []CsafRevision `json:"revision_history"`
+	Generator          CsafGenerator  `json:"generator"`
+}
+
+type CsafRevision struct {
+	Date    string `json:"date"`
+	Number  string `json:"number"`
+	Summary string `json:"summary"`
+}
+
+type CsafGenerator struct {
+	Engine struct {
+		Name string `json:"name"`
+	} `json:"engine"`
+}
+
+type CsafProductTree struct {
+	FullProductNames []CsafFullProductName `json:"full_product_names"`
+}
+
+type CsafFullProductName struct {
+	ProductID                   string `json:"product_id"`
+	Name                        string `json:"name"`
+	ProductIdentificationHelper struct {
+		Cpe string `json:"cpe"`
+	} `json:"product_identification_helper"`
+}
+
+type CsafVulnerability struct {
+	Cve           string             `json:"cve"`
+	Cwe           *CsafCwe           `json:"cwe,omitempty"`
+	Ids           []CsafID           `json:"ids,omitempty"`
+	Notes         []CsafNote         `json:"notes,omitempty"`
+	References    []CsafReference    `json:"references,omitempty"`
+	ReleaseDate   string             `json:"release_date,omitempty"`
+	ProductStatus *CsafProductStatus `json:"product_status,omitempty"`
+	Scores        []CsafScore        `json:"scores,omitempty"`
+	Threats       []CsafThreat       `json:"threats,omitempty"`
+}
+
+type CsafCwe struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// another ID the vulnerability is tracked under, e.g. a GHSA ID
+type CsafID struct {
+	SystemName string `json:"system_name"`
+	Text       string `json:"text"`
+}
+
+type CsafNote struct {
+	Category string `json:"category"`
+	Text     string `json:"text"`
+	Title    string `json:"title,omitempty"`
+}
+
+type CsafReference struct {
+	Category string `json:"category"`
+	Summary  string `json:"summary"`
+	URL      string `json:"url"`
+}
+
+type CsafProductStatus struct {
+	KnownAffected []string `json:"known_affected"`
+}
+
+// a score and the products it applies to. CSAF takes scores in FIRST's CVSS JSON format, of which we only fill in
+// the required properties, as NVD leaves the optional ones empty rather than leaving them out
+type CsafScore struct {
+	Products []string    `json:"products"`
+	CvssV3   *CsafCvssV3 `json:"cvss_v3,omitempty"`
+	CvssV2   *CsafCvssV2 `json:"cvss_v2,omitempty"`
+}
+
+type CsafCvssV3 struct {
+	Version      string  `json:"version"`
+	VectorString string  `json:"vectorString"`
+	BaseScore    float64 `json:"baseScore"`
+	BaseSeverity string  `json:"baseSeverity"`
+}
+
+type CsafCvssV2 struct {
+	Version      string  `json:"version"`
+	VectorString string  `json:"vectorString"`
+	BaseScore    float64 `json:"baseScore"`
+}
+
+type CsafThreat struct {
+	Category string `json:"category"`
+	Details  string `json:"details"`
+	Date     string `json:"date,omitempty"`
+}
+
+// csafBuilder describes CVEs as CSAF vulnerabilities, collecting the products they affect into one product tree
+type csafBuilder struct {
+	productIDs map[string]string
+	products   []CsafFullProductName
+}
+
+func newCsafBuilder() *csafBuilder {
+	return &csafBuilder{productIDs: map[string]string{}}
+}
+
+// productTree returns the tree of every product the vulnerabilities described so far affect, if there are any
+func (b *csafBuilder) productTree() *CsafProductTree {
+	if len(b.products) == 0 {
+		return nil
+	}
+	return &CsafProductTree{FullProductNames: b.products}
+}
+
+// product returns the ID of the product a CPE match stands for, adding it to the tree if it's new. Each range of
+// versions is a product of its own, as it's the range that's affected
+func (b *csafBuilder) product(match CpeMatch) string {
+
+	name := cpeProductName(match.Criteria)
+	for _, bound := range []struct{ op, version string }{
+		{">", match.VersionStartExcluding},
+		{">=", match.VersionStartIncluding},
+		{"<", match.VersionEndExcluding},
+		{"<=", match.VersionEndIncluding},
+	} {
+		if bound.version != "" {
+			name += " " + bound.op + bound.version
+		}
+	}
+
+	key := match.Criteria + " " + name
+	if id, ok := b.productIDs[key]; ok {
+		return id
+	}
+
+	id := fmt.Sprintf("CSAFPID-%04d", len(b.products)+1)
+	b.productIDs[key] = id
+	product := CsafFullProductName{ProductID: id, Name: name}
+	product.ProductIdentificationHelper.Cpe = match.Criteria
+	b.products = append(b.products, product)
+
+	return id
+}
+
+// cpeProductName turns a CPE name into something readable, e.g. "apache log4j 2.14.1" from
+// cpe:2.3:a:apache:log4j:2.14.1:*:*:*:*:*:*:*
+func cpeProductName(cpe string) string {
+	parts := strings.Split(cpe, ":")
+	if len(parts) < 6 {
+		return cpe
+	}
+	name := strings.ReplaceAll(parts[3]+" "+parts[4], "_", " ")
+	if version := parts[5]; version != "*" && version != "-" {
+		name += " " + version
+	}
+	return name
+}
+
+// vulnerability describes a CVE as a CSAF vulnerability, with the vulnerable products from its configurations
+func (b *csafBuilder) vulnerability(cve *CveMsg) CsafVulnerability {
+
+	vuln := CsafVulnerability{
+		Cve:         cve.CveData.ID,
+		ReleaseDate: utcTimestamp(cve.CveData.Published),
+	}
+
+	// CSAF only has room for one CWE
+	for _, id := range cve.WeaknessIDs() {
+		if strings.HasPrefix(id, "CWE-") && cve.WeaknessNames[id] != "" {
+			vuln.Cwe = &CsafCwe{ID: id, Name: cve.WeaknessNames[id]}
+			break
+		}
+	}
+
+	for _, advisory := range cve.Advisories {
+		vuln.Ids = append(vuln.Ids, CsafID{SystemName: advisory.Source, Text: advisory.Osv.ID})
+	}
+
+	if desc := firstDescription(cve.CveData.Descriptions); desc != "" {
+		vuln.Notes = append(vuln.Notes, CsafNote{Category: "description", Text: desc, Title: "Vulnerability description"})
+	}
+
+	vuln.References = append(vuln.References, CsafReference{
+		Category: "external",
+		Summary:  "NVD entry for " + cve.CveData.ID,
+		URL:      "https://nvd.nist.gov/vuln/detail/" + cve.CveData.ID,
+	})
+	for _, ref := range cve.CveData.References {
+		summary := ref.URL
+		if len(ref.Tags) > 0 {
+			summary = strings.Join(ref.Tags, ", ")
+		}
+		vuln.References = append(vuln.References, CsafReference{Category: "external", Summary: summary, URL: ref.URL})
+	}
+
+	affected := []string{}
+	seen := map[string]bool{}
+	for _, config := range cve.CveData.Configurations {
+		for _, node := range config.Nodes {
+			for _, match := range node.CpeMatch {
+				if !match.Vulnerable {
+					continue
+				}
+				if id := b.product(match); !seen[id] {
+					seen[id] = true
+					affected = append(affected, id)
+				}
+			}
+		}
+	}
+
+	// scores have to say which products they're for, so there's nowhere to put them without any
+	if len(affected) > 0 {
+		vuln.ProductStatus = &CsafProductStatus{KnownAffected: affected}
+
+		for _, metric := range append(cve.CveData.Metrics.CvssMetricV31, cve.CveData.Metrics.CvssMetricV30...) {
+			vuln.Scores = append(vuln.Scores, CsafScore{Products: affected, CvssV3: &CsafCvssV3{
+				Version:      metric.CvssData.Version,
+				VectorString: metric.CvssData.VectorString,
+				BaseScore:    metric.CvssData.BaseScore,
+				BaseSeverity: metric.CvssData.BaseSeverity,
+			}})
+		}
+		for _, metric := range cve.CveData.Metrics.CvssMetricV2 {
+			vuln.Scores = append(vuln.Scores, CsafScore{Products: affected, CvssV2: &CsafCvssV2{
+				Version:      metric.CvssData.Version,
+				VectorString: metric.CvssData.VectorString,
+				BaseScore:    metric.CvssData.BaseScore,
+			}})
+		}
+	}
+
+	if cve.Kev != nil {
+		vuln.Threats = append(vuln.Threats, CsafThreat{
+			Category: "exploit_status",
+			Details:  "Listed in CISA's Known Exploited Vulnerabilities catalog. Required action: " + cve.Kev.RequiredAction,
+			Date:     cve.Kev.DateAdded + "T00:00:00Z",
+		})
+	}
+
+	return vuln
+}
+
+// newCsafMeta fills in the document level properties of a CSAF document, released at the given dates
+func newCsafMeta(publisher CsafPublisher, id, title, initial, current string) CsafDocumentMeta {
+
+	meta := CsafDocumentMeta{
+		Category:    "csaf_base",
+		CsafVersion: csafVersion,
+		Publisher:   publisher,
+		Title:       title,
+		Tracking: CsafTracking{
+			ID:                 id,
+			Status:             "final",
+			Version:            "1",
+			InitialReleaseDate: initial,
+			CurrentReleaseDate: current,
+			RevisionHistory:    []CsafRevision{{Date: current, Number: "1", Summary: "Generated from NVD data"}},
+		},
+	}
+	meta.Tracking.Generator.Engine.Name = "melaka cvequerier"
+
+	return meta
+}
+
+// newCveCsafDocument describes a single CVE in a CSAF document of its own, tracked under the CVE's ID
+func newCveCsafDocument(publisher CsafPublisher, cve *CveMsg) CsafDocument {
+
+	initial, current := utcTimestamp(cve.CveData.Published), utcTimestamp(cve.CveData.LastModified)
+	if current < initial {
+		current = initial
+	}
+
+	b := newCsafBuilder()
+	vuln := b.vulnerability(cve)
+
+	return CsafDocument{
+		Document:        newCsafMeta(publisher, cve.CveData.ID, cve.CveData.ID, initial, current),
+		Vulnerabilities: []CsafVulnerability{vuln},
+		ProductTree:     b.productTree(),
+	}
+}
+
+// newSearchCsafMeta fills in the document level properties of a CSAF document describing a set of CVEs, which is
+// released as it's generated
+func newSearchCsafMeta(publisher CsafPublisher, now time.Time) CsafDocumentMeta {
+	date := now.UTC().Format(time.RFC3339)
+	id := "cves-" + now.UTC().Format("20060102T150405Z")
+	return newCsafMeta(publisher, id, "CVEs matching a search", date, date)
+}
+
+// newSearchCsafDocument describes a set of CVEs in one CSAF document
+func newSearchCsafDocument(publisher CsafPublisher, cves []*CveMsg) CsafDocument {
+
+	b := newCsafBuilder()
+	vulns := []CsafVulnerability{}
+	for _, cve := range cves {
+		vulns = append(vulns, b.vulnerability(cve))
+	}
+
+	return CsafDocument{
+		Document:        newSearchCsafMeta(publisher, time.Now()),
+		Vulnerabilities: vulns,
+		ProductTree:     b.productTree(),
+	}
+}
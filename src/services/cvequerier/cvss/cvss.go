@@ -0,0 +1,209 @@
+// Package cvss parses and validates CVSS vector strings, and computes their scores.
+// Versions 2.0, 3.0, 3.1 and 4.0 are supported.
+package cvss
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Vector is a parsed CVSS vector. Metrics that weren't set in the vector string hold their "Not Defined" value
+type Vector struct {
+	spec    *spec
+	metrics map[string]string
+}
+
+// Scores holds everything we compute from a vector. For v4.0, which has a single score whose meaning depends on
+// the metrics set, Base only considers the base metrics, Temporal adds the threat metrics and Environmental adds the rest
+type Scores struct {
+	Version               string  `json:"version"`
+	VectorString          string  `json:"vectorString"`
+	BaseScore             float64 `json:"baseScore"`
+	BaseSeverity          string  `json:"baseSeverity"`
+	TemporalScore         float64 `json:"temporalScore"`
+	TemporalSeverity      string  `json:"temporalSeverity"`
+	EnvironmentalScore    float64 `json:"environmentalScore"`
+	EnvironmentalSeverity string  `json:"environmentalSeverity"`
+}
+
+// spec describes a CVSS version: its vector prefix, its metrics in their canonical order and how it's scored
+type spec struct {
+	version    string
+	prefix     string
+	notDefined string
+	metrics    []metric
+	score      func(v *Vector) (base, temporal, environmental float64)
+	severity   func(score float64) string
+}
+
+type metric struct {
+	key       string
+	values    []string
+	mandatory bool
+}
+
+func (s *spec) metric(key string) (metric, bool) {
+	for _, m := range s.metrics {
+		if m.key == key {
+			return m, true
+		}
+	}
+	return metric{}, false
+}
+
+// Parse validates a vector string and returns the parsed vector. The version is taken from the
+// vector's CVSS: prefix, and vectors without one are treated as v2.0, as NVD publishes them
+func Parse(vector string) (*Vector, error) {
+
+	vector = strings.TrimSpace(vector)
+
+	s := v2
+	for _, candidate := range []*spec{v30, v31, v40} {
+		if strings.HasPrefix(vector, candidate.prefix+"/") {
+			s = candidate
+			vector = strings.TrimPrefix(vector, candidate.prefix+"/")
+			break
+		}
+	}
+	if s == v2 {
+		if strings.HasPrefix(vector, "CVSS:") {
+			return nil, fmt.Errorf("unsupported CVSS version in %s", vector)
+		}
+		// v2 vectors are sometimes wrapped in parentheses
+		vector = strings.TrimSuffix(strings.TrimPrefix(vector, "("), ")")
+	}
+
+	v := &Vector{spec: s, metrics: map[string]string{}}
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(vector, "/") {
+		key, value, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed metric %q", part)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("metric %s is defined more than once", key)
+		}
+		seen[key] = true
+
+		if err := v.set(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range s.metrics {
+		if m.mandatory && !seen[m.key] {
+			return nil, fmt.Errorf("mandatory metric %s is missing", m.key)
+		}
+	}
+
+	return v, nil
+}
+
+// Apply overrides the vector's metrics with those in a partial vector string, e.g. CR:H/MAV:L, so
+// that teams can apply their own environmental (or temporal) modifiers to a published vector
+func (v *Vector) Apply(modifiers string) error {
+
+	modifiers = strings.Trim(strings.TrimSpace(modifiers), "/")
+	if modifiers == "" {
+		return nil
+	}
+
+	for _, part := range strings.Split(modifiers, "/") {
+		key, value, ok := strings.Cut(part, ":")
+		if !ok {
+			return fmt.Errorf("malformed metric %q", part)
+		}
+		if err := v.set(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *Vector) set(key, value string) error {
+
+	m, ok := v.spec.metric(key)
+	if !ok {
+		return fmt.Errorf("unknown metric %s for CVSS v%s", key, v.spec.version)
+	}
+
+	for _, allowed := range m.values {
+		if value == allowed {
+			v.metrics[key] = value
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid value %s for metric %s", value, key)
+}
+
+// Version returns the CVSS version of the vector, e.g. 3.1
+func (v *Vector) Version() string {
+	return v.spec.version
+}
+
+// get returns a metric's value, falling back to "Not Defined" for optional metrics that haven't been set
+func (v *Vector) get(key string) string {
+	if value, ok := v.metrics[key]; ok {
+		return value
+	}
+	return v.spec.notDefined
+}
+
+// String renders the vector in its canonical form, omitting any metrics that aren't defined
+func (v *Vector) String() string {
+
+	parts := []string{}
+	if v.spec.prefix != "" {
+		parts = append(parts, v.spec.prefix)
+	}
+
+	for _, m := range v.spec.metrics {
+		value := v.get(m.key)
+		if m.mandatory || value != v.spec.notDefined {
+			parts = append(parts, m.key+":"+value)
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// Scores computes the vector's base, temporal and environmental scores and their severities
+func (v *Vector) Scores() Scores {
+
+	base, temporal, environmental := v.spec.score(v)
+
+	return Scores{
+		Version:               v.spec.version,
+		VectorString:          v.String(),
+		BaseScore:             base,
+		BaseSeverity:          v.spec.severity(base),
+		TemporalScore:         temporal,
+		TemporalSeverity:      v.spec.severity(temporal),
+		EnvironmentalScore:    environmental,
+		EnvironmentalSeverity: v.spec.severity(environmental),
+	}
+}
+
+// severity gives the qualitative rating shared by v3.x and v4.0
+func severity(score float64) string {
+	switch {
+	case score >= 9.0:
+		return "CRITICAL"
+	case score >= 7.0:
+		return "HIGH"
+	case score >= 4.0:
+		return "MEDIUM"
+	case score >= 0.1:
+		return "LOW"
+	default:
+		return "NONE"
+	}
+}
+
+func roundToOneDecimal(x float64) float64 {
+	return math.Round(x*10) / 10
+}
@@ -0,0 +1,86 @@
+package cvss
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScores_Base(t *testing.T) {
+
+	for vector, expected := range map[string]float64{
+		"AV:N/AC:L/Au:N/C:P/I:P/A:P":                                      7.5,
+		"(AV:N/AC:M/Au:N/C:C/I:C/A:C)":                                    9.3,
+		"AV:L/AC:H/Au:M/C:N/I:N/A:N":                                      0,
+		"CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H":                    8.8,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H":                    9.8,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H":                    10.0,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N":                    6.1,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N":                    0,
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N": 9.3,
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:H/SI:H/SA:H": 10,
+		"CVSS:4.0/AV:L/AC:L/AT:N/PR:L/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N": 8.5,
+		"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:N/VI:N/VA:N/SC:N/SI:N/SA:N": 0,
+	} {
+		v, err := Parse(vector)
+		if assert.NoError(t, err, vector) {
+			assert.Equal(t, expected, v.Scores().BaseScore, vector)
+		}
+	}
+
+}
+
+func TestScores_Temporal_And_Environmental(t *testing.T) {
+
+	v, err := Parse("CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:P/RL:O/RC:C")
+	assert.NoError(t, err)
+
+	scores := v.Scores()
+	assert.Equal(t, 9.8, scores.BaseScore)
+	assert.Equal(t, 8.8, scores.TemporalScore)
+	assert.Equal(t, "HIGH", scores.TemporalSeverity)
+
+	assert.NoError(t, v.Apply("MAV:L/CR:L/IR:L/AR:L"))
+	scores = v.Scores()
+	assert.Equal(t, 9.8, scores.BaseScore)
+	assert.Equal(t, 5.9, scores.EnvironmentalScore)
+	assert.Equal(t, "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/E:P/RL:O/RC:C/CR:L/IR:L/AR:L/MAV:L", scores.VectorString)
+
+	v4, err := Parse("CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N/E:U")
+	assert.NoError(t, err)
+	scores = v4.Scores()
+	assert.Equal(t, 9.3, scores.BaseScore)
+	assert.Equal(t, 8.1, scores.TemporalScore)
+	assert.Equal(t, 8.1, scores.EnvironmentalScore)
+
+}
+
+func TestParse_Rejects_Invalid_Vectors(t *testing.T) {
+
+	for _, vector := range []string{
+		"",
+		"CVSS:2.0/AV:N/AC:L/Au:N/C:P/I:P/A:P",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H",
+		"CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/Au:N",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H/",
+		"CVSS:4.0/AV:N/AC:L/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N",
+		"AV:N/AC:L/Au:N/C:P/I:P",
+	} {
+		_, err := Parse(vector)
+		assert.Error(t, err, vector)
+	}
+
+}
+
+func TestApply_Rejects_Invalid_Modifiers(t *testing.T) {
+
+	v, err := Parse("AV:N/AC:L/Au:N/C:P/I:P/A:P")
+	assert.NoError(t, err)
+
+	assert.Error(t, v.Apply("MAV:L"))
+	assert.Error(t, v.Apply("CR"))
+	assert.NoError(t, v.Apply("CDP:H/TD:H/CR:H"))
+
+}
@@ -0,0 +1,84 @@
+package cvss
+
+import "math"
+
+// CVSS v2.0, per https://www.first.org/cvss/v2/guide
+var v2 = &spec{
+	version:    "2.0",
+	notDefined: "ND",
+	metrics: []metric{
+		{key: "AV", values: []string{"L", "A", "N"}, mandatory: true},
+		{key: "AC", values: []string{"H", "M", "L"}, mandatory: true},
+		{key: "Au", values: []string{"M", "S", "N"}, mandatory: true},
+		{key: "C", values: []string{"N", "P", "C"}, mandatory: true},
+		{key: "I", values: []string{"N", "P", "C"}, mandatory: true},
+		{key: "A", values: []string{"N", "P", "C"}, mandatory: true},
+		{key: "E", values: []string{"U", "POC", "F", "H", "ND"}},
+		{key: "RL", values: []string{"OF", "TF", "W", "U", "ND"}},
+		{key: "RC", values: []string{"UC", "UR", "C", "ND"}},
+		{key: "CDP", values: []string{"N", "L", "LM", "MH", "H", "ND"}},
+		{key: "TD", values: []string{"N", "L", "M", "H", "ND"}},
+		{key: "CR", values: []string{"L", "M", "H", "ND"}},
+		{key: "IR", values: []string{"L", "M", "H", "ND"}},
+		{key: "AR", values: []string{"L", "M", "H", "ND"}},
+	},
+	score:    scoreV2,
+	severity: severityV2,
+}
+
+var v2Weights = map[string]map[string]float64{
+	"AV":  {"L": 0.395, "A": 0.646, "N": 1.0},
+	"AC":  {"H": 0.35, "M": 0.61, "L": 0.71},
+	"Au":  {"M": 0.45, "S": 0.56, "N": 0.704},
+	"C":   {"N": 0.0, "P": 0.275, "C": 0.660},
+	"I":   {"N": 0.0, "P": 0.275, "C": 0.660},
+	"A":   {"N": 0.0, "P": 0.275, "C": 0.660},
+	"E":   {"U": 0.85, "POC": 0.9, "F": 0.95, "H": 1.0, "ND": 1.0},
+	"RL":  {"OF": 0.87, "TF": 0.90, "W": 0.95, "U": 1.0, "ND": 1.0},
+	"RC":  {"UC": 0.90, "UR": 0.95, "C": 1.0, "ND": 1.0},
+	"CDP": {"N": 0.0, "L": 0.1, "LM": 0.3, "MH": 0.4, "H": 0.5, "ND": 0.0},
+	"TD":  {"N": 0.0, "L": 0.25, "M": 0.75, "H": 1.0, "ND": 1.0},
+	"CR":  {"L": 0.5, "M": 1.0, "H": 1.51, "ND": 1.0},
+	"IR":  {"L": 0.5, "M": 1.0, "H": 1.51, "ND": 1.0},
+	"AR":  {"L": 0.5, "M": 1.0, "H": 1.51, "ND": 1.0},
+}
+
+func scoreV2(v *Vector) (float64, float64, float64) {
+
+	w := func(key string) float64 {
+		return v2Weights[key][v.get(key)]
+	}
+
+	exploitability := 20 * w("AV") * w("AC") * w("Au")
+	baseScore := func(impact float64) float64 {
+		f := 1.176
+		if impact == 0 {
+			f = 0
+		}
+		return roundToOneDecimal(((0.6 * impact) + (0.4 * exploitability) - 1.5) * f)
+	}
+
+	impact := 10.41 * (1 - (1-w("C"))*(1-w("I"))*(1-w("A")))
+	base := baseScore(impact)
+
+	temporalFactor := w("E") * w("RL") * w("RC")
+	temporal := roundToOneDecimal(base * temporalFactor)
+
+	adjustedImpact := math.Min(10, 10.41*(1-(1-w("C")*w("CR"))*(1-w("I")*w("IR"))*(1-w("A")*w("AR"))))
+	adjustedTemporal := roundToOneDecimal(baseScore(adjustedImpact) * temporalFactor)
+	environmental := roundToOneDecimal((adjustedTemporal + (10-adjustedTemporal)*w("CDP")) * w("TD"))
+
+	return base, temporal, environmental
+}
+
+// v2 predates the None and Critical ratings, so we use the three NVD applied to it
+func severityV2(score float64) string {
+	switch {
+	case score >= 7.0:
+		return "HIGH"
+	case score >= 4.0:
+		return "MEDIUM"
+	default:
+		return "LOW"
+	}
+}
@@ -0,0 +1,155 @@
+package cvss
+
+import "math"
+
+// CVSS v3.0 and v3.1 share their metrics, differing only in rounding and the modified impact formula.
+// See https://www.first.org/cvss/v3.1/specification-document
+var v3Metrics = []metric{
+	{key: "AV", values: []string{"N", "A", "L", "P"}, mandatory: true},
+	{key: "AC", values: []string{"L", "H"}, mandatory: true},
+	{key: "PR", values: []string{"N", "L", "H"}, mandatory: true},
+	{key: "UI", values: []string{"N", "R"}, mandatory: true},
+	{key: "S", values: []string{"U", "C"}, mandatory: true},
+	{key: "C", values: []string{"H", "L", "N"}, mandatory: true},
+	{key: "I", values: []string{"H", "L", "N"}, mandatory: true},
+	{key: "A", values: []string{"H", "L", "N"}, mandatory: true},
+	{key: "E", values: []string{"X", "H", "F", "P", "U"}},
+	{key: "RL", values: []string{"X", "U", "W", "T", "O"}},
+	{key: "RC", values: []string{"X", "C", "R", "U"}},
+	{key: "CR", values: []string{"X", "H", "M", "L"}},
+	{key: "IR", values: []string{"X", "H", "M", "L"}},
+	{key: "AR", values: []string{"X", "H", "M", "L"}},
+	{key: "MAV", values: []string{"X", "N", "A", "L", "P"}},
+	{key: "MAC", values: []string{"X", "L", "H"}},
+	{key: "MPR", values: []string{"X", "N", "L", "H"}},
+	{key: "MUI", values: []string{"X", "N", "R"}},
+	{key: "MS", values: []string{"X", "U", "C"}},
+	{key: "MC", values: []string{"X", "H", "L", "N"}},
+	{key: "MI", values: []string{"X", "H", "L", "N"}},
+	{key: "MA", values: []string{"X", "H", "L", "N"}},
+}
+
+var v30 = &spec{
+	version:    "3.0",
+	prefix:     "CVSS:3.0",
+	notDefined: "X",
+	metrics:    v3Metrics,
+	score: func(v *Vector) (float64, float64, float64) {
+		return scoreV3(v, roundUpV30, modifiedImpactV30)
+	},
+	severity: severity,
+}
+
+var v31 = &spec{
+	version:    "3.1",
+	prefix:     "CVSS:3.1",
+	notDefined: "X",
+	metrics:    v3Metrics,
+	score: func(v *Vector) (float64, float64, float64) {
+		return scoreV3(v, roundUpV31, modifiedImpactV31)
+	},
+	severity: severity,
+}
+
+var v3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	"E":  {"X": 1, "H": 1, "F": 0.97, "P": 0.94, "U": 0.91},
+	"RL": {"X": 1, "U": 1, "W": 0.97, "T": 0.96, "O": 0.95},
+	"RC": {"X": 1, "C": 1, "R": 0.96, "U": 0.92},
+	"CR": {"X": 1, "H": 1.5, "M": 1, "L": 0.5},
+	"IR": {"X": 1, "H": 1.5, "M": 1, "L": 0.5},
+	"AR": {"X": 1, "H": 1.5, "M": 1, "L": 0.5},
+}
+
+// privileges required is weighted higher when the scope changes
+var v3PrivilegesRequired = map[string]map[string]float64{
+	"U": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"C": {"N": 0.85, "L": 0.68, "H": 0.5},
+}
+
+func scoreV3(v *Vector, roundUp func(float64) float64, modifiedImpact func(miss float64) float64) (float64, float64, float64) {
+
+	w := func(key string) float64 {
+		return v3Weights[key][v.get(key)]
+	}
+
+	// modified metrics fall back to their base equivalent when not defined
+	modified := func(key string) string {
+		if value := v.get("M" + key); value != "X" {
+			return value
+		}
+		return v.get(key)
+	}
+	mw := func(key string) float64 {
+		return v3Weights[key][modified(key)]
+	}
+
+	// base
+	scope := v.get("S")
+	iss := 1 - (1-w("C"))*(1-w("I"))*(1-w("A"))
+	impact := 6.42 * iss
+	if scope == "C" {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	exploitability := 8.22 * w("AV") * w("AC") * v3PrivilegesRequired[scope][v.get("PR")] * w("UI")
+
+	base := 0.0
+	if impact > 0 {
+		if scope == "U" {
+			base = roundUp(math.Min(impact+exploitability, 10))
+		} else {
+			base = roundUp(math.Min(1.08*(impact+exploitability), 10))
+		}
+	}
+
+	// temporal
+	temporalFactor := w("E") * w("RL") * w("RC")
+	temporal := roundUp(base * temporalFactor)
+
+	// environmental
+	modifiedScope := modified("S")
+	miss := math.Min(1-(1-w("CR")*mw("C"))*(1-w("IR")*mw("I"))*(1-w("AR")*mw("A")), 0.915)
+	mImpact := 6.42 * miss
+	if modifiedScope == "C" {
+		mImpact = modifiedImpact(miss)
+	}
+	mExploitability := 8.22 * mw("AV") * mw("AC") * v3PrivilegesRequired[modifiedScope][modified("PR")] * mw("UI")
+
+	environmental := 0.0
+	if mImpact > 0 {
+		if modifiedScope == "U" {
+			environmental = roundUp(roundUp(math.Min(mImpact+mExploitability, 10)) * temporalFactor)
+		} else {
+			environmental = roundUp(roundUp(math.Min(1.08*(mImpact+mExploitability), 10)) * temporalFactor)
+		}
+	}
+
+	return base, temporal, environmental
+}
+
+func modifiedImpactV30(miss float64) float64 {
+	return 7.52*(miss-0.029) - 3.25*math.Pow(miss-0.02, 15)
+}
+
+func modifiedImpactV31(miss float64) float64 {
+	return 7.52*(miss-0.029) - 3.25*math.Pow(miss*0.9731-0.02, 13)
+}
+
+// v3.0 rounds up to one decimal place
+func roundUpV30(x float64) float64 {
+	return math.Ceil(x*10) / 10
+}
+
+// v3.1 does the same, but working in integers to avoid floating point artifacts (e.g. 4.000000001 becoming 4.1)
+func roundUpV31(x float64) float64 {
+	i := math.Round(x * 100000)
+	if math.Mod(i, 10000) == 0 {
+		return i / 100000
+	}
+	return (math.Floor(i/10000) + 1) / 10
+}
@@ -0,0 +1,337 @@
+package cvss
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CVSS v4.0, per https://www.first.org/cvss/v4.0/specification-document. Scores aren't computed from a formula
+// but interpolated from the expert-assigned score of the vector's MacroVector, following the reference calculator
+var v40 = &spec{
+	version:    "4.0",
+	prefix:     "CVSS:4.0",
+	notDefined: "X",
+	metrics: []metric{
+		// base
+		{key: "AV", values: []string{"N", "A", "L", "P"}, mandatory: true},
+		{key: "AC", values: []string{"L", "H"}, mandatory: true},
+		{key: "AT", values: []string{"N", "P"}, mandatory: true},
+		{key: "PR", values: []string{"N", "L", "H"}, mandatory: true},
+		{key: "UI", values: []string{"N", "P", "A"}, mandatory: true},
+		{key: "VC", values: []string{"H", "L", "N"}, mandatory: true},
+		{key: "VI", values: []string{"H", "L", "N"}, mandatory: true},
+		{key: "VA", values: []string{"H", "L", "N"}, mandatory: true},
+		{key: "SC", values: []string{"H", "L", "N"}, mandatory: true},
+		{key: "SI", values: []string{"H", "L", "N"}, mandatory: true},
+		{key: "SA", values: []string{"H", "L", "N"}, mandatory: true},
+		// threat
+		{key: "E", values: []string{"X", "A", "P", "U"}},
+		// environmental
+		{key: "CR", values: []string{"X", "H", "M", "L"}},
+		{key: "IR", values: []string{"X", "H", "M", "L"}},
+		{key: "AR", values: []string{"X", "H", "M", "L"}},
+		{key: "MAV", values: []string{"X", "N", "A", "L", "P"}},
+		{key: "MAC", values: []string{"X", "L", "H"}},
+		{key: "MAT", values: []string{"X", "N", "P"}},
+		{key: "MPR", values: []string{"X", "N", "L", "H"}},
+		{key: "MUI", values: []string{"X", "N", "P", "A"}},
+		{key: "MVC", values: []string{"X", "H", "L", "N"}},
+		{key: "MVI", values: []string{"X", "H", "L", "N"}},
+		{key: "MVA", values: []string{"X", "H", "L", "N"}},
+		{key: "MSC", values: []string{"X", "H", "L", "N"}},
+		{key: "MSI", values: []string{"X", "S", "H", "L", "N"}},
+		{key: "MSA", values: []string{"X", "S", "H", "L", "N"}},
+		// supplemental, which don't affect the score
+		{key: "S", values: []string{"X", "N", "P"}},
+		{key: "AU", values: []string{"X", "N", "Y"}},
+		{key: "R", values: []string{"X", "A", "U", "I"}},
+		{key: "V", values: []string{"X", "D", "C"}},
+		{key: "RE", values: []string{"X", "L", "M", "H"}},
+		{key: "U", values: []string{"X", "Clear", "Green", "Amber", "Red"}},
+	},
+	score:    scoreV4,
+	severity: severity,
+}
+
+// the metrics in each group, which we clear to score the vector with only base, or base and threat, metrics
+var (
+	v4ThreatMetrics        = []string{"E"}
+	v4EnvironmentalMetrics = []string{"CR", "IR", "AR", "MAV", "MAC", "MAT", "MPR", "MUI", "MVC", "MVI", "MVA", "MSC", "MSI", "MSA"}
+)
+
+func scoreV4(v *Vector) (float64, float64, float64) {
+
+	without := func(keys ...[]string) *Vector {
+		clone := &Vector{spec: v.spec, metrics: map[string]string{}}
+		for key, value := range v.metrics {
+			clone.metrics[key] = value
+		}
+		for _, group := range keys {
+			for _, key := range group {
+				delete(clone.metrics, key)
+			}
+		}
+		return clone
+	}
+
+	base := scoreV4Vector(without(v4ThreatMetrics, v4EnvironmentalMetrics))
+	threat := scoreV4Vector(without(v4EnvironmentalMetrics))
+	environmental := scoreV4Vector(v)
+
+	return base, threat, environmental
+}
+
+// the severity level of each metric value, in tenths: how far it is from the most severe value of that metric
+var v4Levels = map[string]map[string]float64{
+	"AV": {"N": 0.0, "A": 0.1, "L": 0.2, "P": 0.3},
+	"PR": {"N": 0.0, "L": 0.1, "H": 0.2},
+	"UI": {"N": 0.0, "P": 0.1, "A": 0.2},
+	"AC": {"L": 0.0, "H": 0.1},
+	"AT": {"N": 0.0, "P": 0.1},
+	"VC": {"H": 0.0, "L": 0.1, "N": 0.2},
+	"VI": {"H": 0.0, "L": 0.1, "N": 0.2},
+	"VA": {"H": 0.0, "L": 0.1, "N": 0.2},
+	"SC": {"H": 0.1, "L": 0.2, "N": 0.3},
+	"SI": {"S": 0.0, "H": 0.1, "L": 0.2, "N": 0.3},
+	"SA": {"S": 0.0, "H": 0.1, "L": 0.2, "N": 0.3},
+	"CR": {"H": 0.0, "M": 0.1, "L": 0.2},
+	"IR": {"H": 0.0, "M": 0.1, "L": 0.2},
+	"AR": {"H": 0.0, "M": 0.1, "L": 0.2},
+}
+
+// the most severe vectors within each level of each EQ, from the specification's tables 24 to 30
+var (
+	v4MaxEQ1    = [][]string{{"AV:N/PR:N/UI:N/"}, {"AV:A/PR:N/UI:N/", "AV:N/PR:L/UI:N/", "AV:N/PR:N/UI:P/"}, {"AV:P/PR:N/UI:N/", "AV:A/PR:L/UI:P/"}}
+	v4MaxEQ2    = [][]string{{"AC:L/AT:N/"}, {"AC:H/AT:N/", "AC:L/AT:P/"}}
+	v4MaxEQ3EQ6 = [][][]string{
+		{{"VC:H/VI:H/VA:H/CR:H/IR:H/AR:H/"}, {"VC:H/VI:H/VA:L/CR:M/IR:M/AR:H/", "VC:H/VI:H/VA:H/CR:M/IR:M/AR:M/"}},
+		{{"VC:L/VI:H/VA:H/CR:H/IR:H/AR:H/", "VC:H/VI:L/VA:H/CR:H/IR:H/AR:H/"}, {"VC:L/VI:H/VA:L/CR:H/IR:M/AR:H/", "VC:L/VI:H/VA:H/CR:H/IR:M/AR:M/", "VC:H/VI:L/VA:H/CR:M/IR:H/AR:M/", "VC:H/VI:L/VA:L/CR:M/IR:H/AR:H/", "VC:L/VI:L/VA:H/CR:H/IR:H/AR:M/"}},
+		{nil, {"VC:L/VI:L/VA:L/CR:H/IR:H/AR:H/"}},
+	}
+	v4MaxEQ4 = [][]string{{"SC:H/SI:S/SA:S/"}, {"SC:H/SI:H/SA:H/"}, {"SC:L/SI:L/SA:L/"}}
+	v4MaxEQ5 = [][]string{{"E:A/"}, {"E:P/"}, {"E:U/"}}
+)
+
+// the severity depth of each EQ level, i.e. how many steps separate its most and least severe vectors, plus one
+var (
+	v4DepthEQ1    = []float64{1, 4, 5}
+	v4DepthEQ2    = []float64{1, 2}
+	v4DepthEQ3EQ6 = [][]float64{{7, 6}, {8, 8}, {0, 10}}
+	v4DepthEQ4    = []float64{6, 5, 4}
+)
+
+// effective returns the value a v4.0 metric takes for scoring, applying any modified metric and the
+// spec's worst case defaults for threat and environmental metrics that aren't defined
+func (v *Vector) effective(key string) string {
+
+	switch key {
+	case "E":
+		if v.get("E") == "X" {
+			return "A"
+		}
+		return v.get("E")
+	case "CR", "IR", "AR":
+		if v.get(key) == "X" {
+			return "H"
+		}
+		return v.get(key)
+	}
+
+	if modified := v.get("M" + key); modified != "X" {
+		return modified
+	}
+	return v.get(key)
+}
+
+func (v *Vector) macroVector() [6]int {
+
+	m := v.effective
+	var eq [6]int
+
+	// EQ1: AV/PR/UI
+	switch {
+	case m("AV") == "N" && m("PR") == "N" && m("UI") == "N":
+		eq[0] = 0
+	case (m("AV") == "N" || m("PR") == "N" || m("UI") == "N") && m("AV") != "P":
+		eq[0] = 1
+	default:
+		eq[0] = 2
+	}
+
+	// EQ2: AC/AT
+	if m("AC") != "L" || m("AT") != "N" {
+		eq[1] = 1
+	}
+
+	// EQ3: VC/VI/VA
+	switch {
+	case m("VC") == "H" && m("VI") == "H":
+		eq[2] = 0
+	case m("VC") == "H" || m("VI") == "H" || m("VA") == "H":
+		eq[2] = 1
+	default:
+		eq[2] = 2
+	}
+
+	// EQ4: SC/SI/SA, where the modified subsequent system metrics can raise the impact to Safety
+	switch {
+	case v.get("MSI") == "S" || v.get("MSA") == "S":
+		eq[3] = 0
+	case m("SC") == "H" || m("SI") == "H" || m("SA") == "H":
+		eq[3] = 1
+	default:
+		eq[3] = 2
+	}
+
+	// EQ5: E
+	switch m("E") {
+	case "P":
+		eq[4] = 1
+	case "U":
+		eq[4] = 2
+	}
+
+	// EQ6: CR/IR/AR against VC/VI/VA
+	if !((m("CR") == "H" && m("VC") == "H") || (m("IR") == "H" && m("VI") == "H") || (m("AR") == "H" && m("VA") == "H")) {
+		eq[5] = 1
+	}
+
+	return eq
+}
+
+func lookupV4(eq [6]int) (float64, bool) {
+	score, ok := v4Lookup[fmt.Sprintf("%d%d%d%d%d%d", eq[0], eq[1], eq[2], eq[3], eq[4], eq[5])]
+	return score, ok
+}
+
+func scoreV4Vector(v *Vector) float64 {
+
+	m := v.effective
+
+	// with no impact on either the vulnerable or subsequent systems there's nothing to score
+	noImpact := true
+	for _, key := range []string{"VC", "VI", "VA", "SC", "SI", "SA"} {
+		if m(key) != "N" {
+			noImpact = false
+		}
+	}
+	if noImpact {
+		return 0
+	}
+
+	eq := v.macroVector()
+	value, _ := lookupV4(eq)
+
+	// the score of the next lower MacroVector along each EQ, where one exists
+	nextLower := func(changes map[int]int) (float64, bool) {
+		lower := eq
+		for i, delta := range changes {
+			lower[i] += delta
+		}
+		return lookupV4(lower)
+	}
+
+	eq1Lower, eq1Ok := nextLower(map[int]int{0: 1})
+	eq2Lower, eq2Ok := nextLower(map[int]int{1: 1})
+	eq4Lower, eq4Ok := nextLower(map[int]int{3: 1})
+	eq5Lower, eq5Ok := nextLower(map[int]int{4: 1})
+
+	// EQ3 and EQ6 are scored together, as not every combination of their levels exists
+	var eq3eq6Lower float64
+	var eq3eq6Ok bool
+	switch {
+	case eq[2] == 0 && eq[5] == 0:
+		// two paths lead down from here, and we take whichever is scored higher
+		left, leftOk := nextLower(map[int]int{5: 1})
+		right, rightOk := nextLower(map[int]int{2: 1})
+		if leftOk && (!rightOk || left > right) {
+			eq3eq6Lower, eq3eq6Ok = left, true
+		} else {
+			eq3eq6Lower, eq3eq6Ok = right, rightOk
+		}
+	case eq[2] == 1 && eq[5] == 1, eq[2] == 0 && eq[5] == 1:
+		eq3eq6Lower, eq3eq6Ok = nextLower(map[int]int{2: 1})
+	case eq[2] == 1 && eq[5] == 0:
+		eq3eq6Lower, eq3eq6Ok = nextLower(map[int]int{5: 1})
+	}
+
+	// find the first of the MacroVector's most severe vectors that our vector is no more severe than, and measure
+	// how far along each EQ we are from it
+	var distEQ1, distEQ2, distEQ3EQ6, distEQ4 float64
+	level := func(key string) float64 {
+		return v4Levels[key][m(key)]
+	}
+	maxLevel := func(maxVector map[string]string, key string) float64 {
+		return v4Levels[key][maxVector[key]]
+	}
+
+	var maxVectors []string
+	for _, eq1Max := range v4MaxEQ1[eq[0]] {
+		for _, eq2Max := range v4MaxEQ2[eq[1]] {
+			for _, eq3eq6Max := range v4MaxEQ3EQ6[eq[2]][eq[5]] {
+				for _, eq4Max := range v4MaxEQ4[eq[3]] {
+					for _, eq5Max := range v4MaxEQ5[eq[4]] {
+						maxVectors = append(maxVectors, eq1Max+eq2Max+eq3eq6Max+eq4Max+eq5Max)
+					}
+				}
+			}
+		}
+	}
+
+search:
+	for _, maxVector := range maxVectors {
+		maxMetrics := parseV4Partial(maxVector)
+
+		distances := map[string]float64{}
+		for key := range v4Levels {
+			distances[key] = level(key) - maxLevel(maxMetrics, key)
+			if distances[key] < 0 {
+				continue search
+			}
+		}
+
+		distEQ1 = distances["AV"] + distances["PR"] + distances["UI"]
+		distEQ2 = distances["AC"] + distances["AT"]
+		distEQ3EQ6 = distances["VC"] + distances["VI"] + distances["VA"] + distances["CR"] + distances["IR"] + distances["AR"]
+		distEQ4 = distances["SC"] + distances["SI"] + distances["SA"]
+		break
+	}
+
+	// each EQ with a lower MacroVector contributes the proportion of the score gap to it that our distance covers
+	step := 0.1
+	lowerCount := 0
+	total := 0.0
+	contribute := func(lower float64, ok bool, distance float64, depth float64) {
+		if !ok {
+			return
+		}
+		lowerCount++
+		total += (value - lower) * (distance / (depth * step))
+	}
+
+	contribute(eq1Lower, eq1Ok, distEQ1, v4DepthEQ1[eq[0]])
+	contribute(eq2Lower, eq2Ok, distEQ2, v4DepthEQ2[eq[1]])
+	contribute(eq3eq6Lower, eq3eq6Ok, distEQ3EQ6, v4DepthEQ3EQ6[eq[2]][eq[5]])
+	contribute(eq4Lower, eq4Ok, distEQ4, v4DepthEQ4[eq[3]])
+	// EQ5 only has the one metric, so a vector is always at its level's most severe point
+	contribute(eq5Lower, eq5Ok, 0, 1)
+
+	if lowerCount > 0 {
+		value -= total / float64(lowerCount)
+	}
+
+	// the distances accumulate floating point error, so nudge the value before rounding as the reference calculator does
+	return roundToOneDecimal(math.Max(0, math.Min(10, value)) + 1e-6)
+}
+
+// parseV4Partial reads one of the spec's max vectors. These aren't valid vectors in their own right - they only
+// hold the metrics relevant to each EQ, and use the Safety value for SI and SA - so we skip validation
+func parseV4Partial(vector string) map[string]string {
+	metrics := map[string]string{}
+	for _, part := range strings.Split(strings.Trim(vector, "/"), "/") {
+		key, value, _ := strings.Cut(part, ":")
+		metrics[key] = value
+	}
+	return metrics
+}
@@ -2,28 +2,113 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+// ErrNotFound is returned by DBConnector lookups when the requested record doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned by DBConnector writes that would duplicate a record that has to be unique
+var ErrConflict = errors.New("conflict")
+
 type DBConnector interface {
 	Connect() error
-	GetCveFromID(id string) (interface{}, error)
+	GetCveFromID(id string) (*CveMsg, error)
+	SearchCves(filter CveFilter) ([]CveMsg, error)
+	ExportCves(ctx context.Context, filter CveFilter, each func(cve *CveMsg) error) error
+	GetEpssHistory(id string) ([]EpssData, error)
+	GetCveChanges(id string) ([]CveChange, error)
+	GetEpssHistoryForIDs(ids []string) ([]EpssData, error)
+	GetCveChangesForIDs(ids []string) ([]CveChange, error)
+	GetDistroStatuses(id string, distros []string) ([]DistroStatus, error)
+	GetDistroCveIDs(distros []string, statuses []string) ([]string, error)
+	GetAdvisories(cveID string) ([]Advisory, error)
+	GetPackageAdvisories(packages []string) ([]Advisory, error)
+	GetCvesFromIDs(ids []string) ([]CveMsg, error)
+	SearchText(q string, filter CveFilter) ([]SearchResult, error)
+	CountPublishedByMonth(r DateRange) ([]StatsCount, error)
+	CountBySeverity(r DateRange) ([]StatsCount, error)
+	CountByStatus(r DateRange) ([]StatsCount, error)
+	TopCwes(r DateRange, limit int) ([]StatsCount, error)
+	TopVendors(r DateRange, limit int) ([]StatsCount, error)
+	TopProducts(r DateRange, limit int) ([]StatsCount, error)
+	GetAnalysisTime(r DateRange) (*AnalysisTime, error)
+	GetCwe(id string) (*Cwe, error)
+	GetCwes(ids []string) ([]Cwe, error)
+	GetCweChildren(id string) ([]Cwe, error)
+	SearchCpes(q string, limit int) ([]Cpe, error)
+	GetMatchCriteriaIDs(cpeName string) ([]string, error)
+	CreateSubscription(sub Subscription) error
+	GetSubscriptions() ([]Subscription, error)
+	GetSubscription(id string) (*Subscription, error)
+	SetSubscriptionEnabled(id string, enabled bool) (*Subscription, error)
+	DeleteSubscription(id string) error
+	GetDeliveries(subscriptionID string, limit int) ([]Delivery, error)
+	CreateAsset(asset Asset) error
+	GetAssets(team string) ([]Asset, error)
+	GetAsset(id string) (*Asset, error)
+	UpdateAsset(asset Asset) error
+	DeleteAsset(id string) error
+	CreateAnnotation(annotation Annotation) error
+	GetAnnotations(filter AnnotationFilter) ([]Annotation, error)
+	GetAnnotation(id string) (*Annotation, error)
+	UpdateAnnotation(annotation Annotation) error
+	DeleteAnnotation(id string) error
+	CreateApiKey(key ApiKey) error
+	GetApiKeys() ([]ApiKey, error)
+	GetApiKey(id string) (*ApiKey, error)
+	RevokeApiKey(id string, revokedAt string) error
 	GetMetaDoc(createIfMissing bool) (interface{}, error)
 }
 
+// cvewriter stores NVD records as they arrive, keyed by their JSON names (e.g. cvssMetricV31), so fields
+// without a bson tag need to fall back to their json tag rather than the lowercased field name
+var jsonFallbackRegistry = func() *bsoncodec.Registry {
+	structCodec, err := bsoncodec.NewStructCodec(bsoncodec.JSONFallbackStructTagParser)
+	if err != nil {
+		panic(err)
+	}
+	registry := bson.NewRegistry()
+	registry.RegisterKindEncoder(reflect.Struct, structCodec)
+	registry.RegisterKindDecoder(reflect.Struct, structCodec)
+	return registry
+}()
+
 // Define our MongoDB type and implement the DBConnector interface on it
 
 type MongoDB struct {
-	Configuration  DBConnConfig
-	Connection     *mongo.Client
-	Database       *mongo.Database
-	CveCollection  *mongo.Collection
-	MetaCollection *mongo.Collection
+	Configuration          DBConnConfig
+	Connection             *mongo.Client
+	Database               *mongo.Database
+	CveCollection          *mongo.Collection
+	MetaCollection         *mongo.Collection
+	EpssCollection         *mongo.Collection
+	CweCollection          *mongo.Collection
+	CpeCollection          *mongo.Collection
+	CpeMatchCollection     *mongo.Collection
+	ChangeCollection       *mongo.Collection
+	DistroCollection       *mongo.Collection
+	AdvisoryCollection     *mongo.Collection
+	SubscriptionCollection *mongo.Collection
+	DeliveryCollection     *mongo.Collection
+	AssetCollection        *mongo.Collection
+	AnnotationCollection   *mongo.Collection
+	ApiKeyCollection       *mongo.Collection
+	TextIndex              TextIndex
 }
 
 func (m *MongoDB) Connect() error {
@@ -36,7 +121,7 @@ func (m *MongoDB) Connect() error {
 	}
 
 	var err error
-	m.Connection, err = mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoServer).SetAuth(credentials))
+	m.Connection, err = mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoServer).SetAuth(credentials).SetRegistry(jsonFallbackRegistry))
 	if err != nil {
 		log.Fatalf("Instantiation of db connection failed: %s", err)
 	}
@@ -50,25 +135,1157 @@ func (m *MongoDB) Connect() error {
 	m.Database = m.Connection.Database(m.Configuration.Database)
 	m.CveCollection = m.Database.Collection(m.Configuration.CveCollection)
 	m.MetaCollection = m.Database.Collection(m.Configuration.MetaCollection)
+	m.EpssCollection = m.Database.Collection(m.Configuration.EpssCollection)
+	m.CweCollection = m.Database.Collection(m.Configuration.CweCollection)
+	m.CpeCollection = m.Database.Collection(m.Configuration.CpeCollection)
+	m.CpeMatchCollection = m.Database.Collection(m.Configuration.CpeMatchCollection)
+	m.ChangeCollection = m.Database.Collection(m.Configuration.ChangeCollection)
+	m.DistroCollection = m.Database.Collection(m.Configuration.DistroCollection)
+	m.AdvisoryCollection = m.Database.Collection(m.Configuration.AdvisoryCollection)
+	m.SubscriptionCollection = m.Database.Collection(m.Configuration.SubscriptionCollection)
+	m.DeliveryCollection = m.Database.Collection(m.Configuration.DeliveryCollection)
+	m.AssetCollection = m.Database.Collection(m.Configuration.AssetCollection)
+	m.AnnotationCollection = m.Database.Collection(m.Configuration.AnnotationCollection)
+	m.ApiKeyCollection = m.Database.Collection(m.Configuration.ApiKeyCollection)
+
+	// a CVE can only be triaged once for each asset or product
+	annotationKey := mongo.IndexModel{
+		Keys:    bson.D{{Key: "asset", Value: 1}, {Key: "product", Value: 1}, {Key: "cveId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := m.AnnotationCollection.Indexes().CreateOne(context.TODO(), annotationKey); err != nil {
+		log.Printf("Failed to create unique index on annotations, a CVE may be triaged twice for the same asset: %s", err)
+	}
+
+	// assets' packages are looked up by the normalised keys cvewriter stores with each advisory
+	packageKey := mongo.IndexModel{Keys: bson.D{{Key: "packages", Value: 1}}}
+	if _, err := m.AdvisoryCollection.Indexes().CreateOne(context.TODO(), packageKey); err != nil {
+		log.Printf("Failed to create index on advisories' packages, looking up assets' packages will be slow: %s", err)
+	}
+
+	// a CVE's changes are looked up by its ID, both for /cve/:id/changes and the analysis time stats
+	changeKey := mongo.IndexModel{Keys: bson.D{{Key: "changedata.cveId", Value: 1}}}
+	if _, err := m.ChangeCollection.Indexes().CreateOne(context.TODO(), changeKey); err != nil {
+		log.Printf("Failed to create index on CVE changes' CVE IDs, looking up a CVE's changes will be slow: %s", err)
+	}
+
+	// a CVE's advisories are those with its ID, or listing it as an alias. Each side of the $or needs its own index
+	advisoryKeys := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "osvdata.id", Value: 1}}},
+		{Keys: bson.D{{Key: "osvdata.aliases", Value: 1}}},
+	}
+	if _, err := m.AdvisoryCollection.Indexes().CreateMany(context.TODO(), advisoryKeys); err != nil {
+		log.Printf("Failed to create indexes on advisories' IDs and aliases, looking up a CVE's advisories will be slow: %s", err)
+	}
+
+	// searches filtering by distro or status find the CVEs they cover from the statuses alone
+	distroKey := mongo.IndexModel{Keys: bson.D{{Key: "distro", Value: 1}, {Key: "status", Value: 1}, {Key: "cve", Value: 1}}}
+	if _, err := m.DistroCollection.Indexes().CreateOne(context.TODO(), distroKey); err != nil {
+		log.Printf("Failed to create index on distro statuses, filtering searches by distro will be slow: %s", err)
+	}
 
 	// If we don't have a metadoc yet (a doc with details & settings) create it
 	m.GetMetaDoc(true)
 
+	if err := m.setUpTextIndex(); err != nil {
+		return err
+	}
+
 	return nil
 
 }
 
-func (db *MongoDB) GetCveFromID(id string) (interface{}, error) {
+func (db *MongoDB) GetCveFromID(id string) (*CveMsg, error) {
 
 	filter := bson.D{{Key: "cvedata.id", Value: id}}
 
 	var result CveMsg
 	err := db.CveCollection.FindOne(context.TODO(), filter).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+
+}
+
+func (db *MongoDB) SearchCves(filter CveFilter) ([]CveMsg, error) {
+
+	opts := options.Find().
+		SetSort(buildCveSort(filter)).
+		SetSkip(int64(filter.Offset)).
+		SetLimit(int64(filter.Limit))
+
+	cursor, err := db.CveCollection.Find(context.TODO(), buildCveQuery(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []CveMsg{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+
+}
+
+// ExportCves calls each with every CVE matching the filter in turn, reading them off a cursor rather than loading
+// them all at once. A zero limit exports every match. If each returns an error, or ctx is done (e.g. the client has
+// gone away), the export stops there
+func (db *MongoDB) ExportCves(ctx context.Context, filter CveFilter, each func(cve *CveMsg) error) error {
+
+	opts := options.Find().
+		SetSort(buildCveSort(filter)).
+		SetSkip(int64(filter.Offset)).
+		SetLimit(int64(filter.Limit)).
+		SetProjection(bson.D{{Key: "search", Value: 0}})
+
+	cursor, err := db.CveCollection.Find(ctx, buildCveQuery(filter), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		var cve CveMsg
+		if err := cursor.Decode(&cve); err != nil {
+			return err
+		}
+		if err := each(&cve); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+
+}
+
+// buildCveQuery translates a search filter into the equivalent mongo query
+func buildCveQuery(filter CveFilter) bson.D {
+
+	query := bson.D{}
+
+	if filter.Kev != nil {
+		query = append(query, bson.E{Key: "kev", Value: bson.D{{Key: "$exists", Value: *filter.Kev}}})
+	}
+
+	if len(filter.Cwes) > 0 {
+		query = append(query, bson.E{Key: "cvedata.weaknesses.description.value", Value: bson.D{{Key: "$in", Value: filter.Cwes}}})
+	}
+
+	if filter.EpssMin != nil {
+		query = append(query, bson.E{Key: "epss.score", Value: bson.D{{Key: "$gte", Value: *filter.EpssMin}}})
+	}
+
+	if filter.PercentileMin != nil {
+		query = append(query, bson.E{Key: "epss.percentile", Value: bson.D{{Key: "$gte", Value: *filter.PercentileMin}}})
+	}
+
+	// a CVE affects a product when one of its vulnerable configurations uses criteria that match it. The
+	// configurations also list the platforms a product must be running on, which aren't themselves affected
+	cpeMatches := bson.A{}
+
+	if filter.Cpe != "" {
+		cpeMatch := bson.D{
+			{Key: "matchCriteriaId", Value: bson.D{{Key: "$in", Value: filter.MatchCriteriaIDs}}},
+			{Key: "vulnerable", Value: true},
+		}
+		cpeMatches = append(cpeMatches, bson.D{{Key: "$elemMatch", Value: cpeMatch}})
+	}
+
+	if filter.Vendor != "" {
+		cpeMatch := bson.D{
+			{Key: "criteria", Value: primitive.Regex{Pattern: "^cpe:2\\.3:[aho]:" + regexp.QuoteMeta(filter.Vendor) + ":"}},
+			{Key: "vulnerable", Value: true},
+		}
+		cpeMatches = append(cpeMatches, bson.D{{Key: "$elemMatch", Value: cpeMatch}})
+	}
+
+	// the two may be met by different criteria, so each gets an $elemMatch of its own
+	switch len(cpeMatches) {
+	case 1:
+		query = append(query, bson.E{Key: "cvedata.configurations.nodes.cpeMatch", Value: cpeMatches[0]})
+	case 2:
+		query = append(query, bson.E{Key: "cvedata.configurations.nodes.cpeMatch", Value: bson.D{{Key: "$all", Value: cpeMatches}}})
+	}
+
+	if filter.Keyword != "" {
+		keyword := primitive.Regex{Pattern: regexp.QuoteMeta(filter.Keyword), Options: "i"}
+		query = append(query, bson.E{Key: "cvedata.descriptions.value", Value: keyword})
+	}
+
+	if filter.ScoreMin != nil {
+		query = append(query, bson.E{Key: "preferredScore.baseScore", Value: bson.D{{Key: "$gte", Value: *filter.ScoreMin}}})
+	}
+
+	if len(filter.Severities) > 0 {
+		query = append(query, bson.E{Key: "preferredScore.baseSeverity", Value: bson.D{{Key: "$in", Value: filter.Severities}}})
+	}
+
+	if filter.hasDistroFilter() {
+		query = append(query, bson.E{Key: "cvedata.id", Value: bson.D{{Key: "$in", Value: filter.DistroCveIDs}}})
+	}
+
+	return query
+
+}
+
+// the document fields behind each of the search's sort keys
+var sortPaths = map[string]string{
+	"published":    "cvedata.published",
+	"lastModified": "cvedata.lastModified",
+	"epss":         "epss.score",
+	"score":        "preferredScore.baseScore",
+}
+
+func buildCveSort(filter CveFilter) bson.D {
+
+	order := 1
+	if filter.Descending {
+		order = -1
+	}
+
+	// tie-break on the CVE ID so that paging through results is stable
+	return bson.D{{Key: sortPaths[filter.Sort], Value: order}, {Key: "cvedata.id", Value: 1}}
+
+}
+
+func (db *MongoDB) GetEpssHistory(id string) ([]EpssData, error) {
+	return db.findEpssHistory(bson.D{{Key: "cve", Value: id}})
+}
+
+// GetEpssHistoryForIDs returns the EPSS score histories of several CVEs at once, oldest first
+func (db *MongoDB) GetEpssHistoryForIDs(ids []string) ([]EpssData, error) {
+	return db.findEpssHistory(bson.D{{Key: "cve", Value: bson.D{{Key: "$in", Value: ids}}}})
+}
+
+func (db *MongoDB) findEpssHistory(filter bson.D) ([]EpssData, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}})
+
+	cursor, err := db.EpssCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	history := []EpssData{}
+	if err := cursor.All(context.TODO(), &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+
+}
+
+// the shape of the documents cvewriter stores in the CVE change collection
+type cveChangeDoc struct {
+	Change CveChange `bson:"changedata"`
+}
+
+func (db *MongoDB) GetCveChanges(id string) ([]CveChange, error) {
+	return db.findCveChanges(bson.D{{Key: "changedata.cveId", Value: id}})
+}
+
+// GetCveChangesForIDs returns the change histories of several CVEs at once, oldest first
+func (db *MongoDB) GetCveChangesForIDs(ids []string) ([]CveChange, error) {
+	return db.findCveChanges(bson.D{{Key: "changedata.cveId", Value: bson.D{{Key: "$in", Value: ids}}}})
+}
+
+func (db *MongoDB) findCveChanges(filter bson.D) ([]CveChange, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "changedata.created", Value: 1}})
+
+	cursor, err := db.ChangeCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []cveChangeDoc{}
+	if err := cursor.All(context.TODO(), &docs); err != nil {
+		return nil, err
+	}
+
+	changes := make([]CveChange, len(docs))
+	for i, doc := range docs {
+		changes[i] = doc.Change
+	}
+
+	return changes, nil
+
+}
+
+// GetDistroStatuses returns the given distros' assessments of a CVE, or every distro's if none are given
+func (db *MongoDB) GetDistroStatuses(id string, distros []string) ([]DistroStatus, error) {
+
+	filter := bson.D{{Key: "cve", Value: id}}
+	if len(distros) > 0 {
+		filter = append(filter, bson.E{Key: "distro", Value: bson.D{{Key: "$in", Value: distros}}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "distro", Value: 1}, {Key: "release", Value: 1}, {Key: "package", Value: 1}})
+
+	cursor, err := db.DistroCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []DistroStatus{}
+	if err := cursor.All(context.TODO(), &statuses); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+
+}
+
+// GetDistroCveIDs returns the CVEs that any of the given distros, or any distro if none are given, have assessed one
+// of their packages as any of the given statuses, or as anything if none are given
+func (db *MongoDB) GetDistroCveIDs(distros []string, statuses []string) ([]string, error) {
+
+	filter := bson.D{}
+	if len(distros) > 0 {
+		filter = append(filter, bson.E{Key: "distro", Value: bson.D{{Key: "$in", Value: distros}}})
+	}
+	if len(statuses) > 0 {
+		filter = append(filter, bson.E{Key: "status", Value: bson.D{{Key: "$in", Value: statuses}}})
+	}
+
+	values, err := db.DistroCollection.Distinct(context.TODO(), "cve", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+
+}
+
+// GetAdvisories returns the OSV-format advisories (from OSV or GHSA) for a CVE - those with it as their ID or
+// one of their aliases
+func (db *MongoDB) GetAdvisories(cveID string) ([]Advisory, error) {
+
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "osvdata.id", Value: cveID}},
+		bson.D{{Key: "osvdata.aliases", Value: cveID}},
+	}}}
+	opts := options.Find().SetSort(bson.D{{Key: "osvdata.id", Value: 1}})
+
+	cursor, err := db.AdvisoryCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	advisories := []Advisory{}
+	if err := cursor.All(context.TODO(), &advisories); err != nil {
+		return nil, err
+	}
+
+	return advisories, nil
+
+}
+
+// GetPackageAdvisories returns the OSV-format advisories that list any of the packages as affected. Packages are
+// given by their keys (see purl.PackageKey), which cvewriter stores alongside each advisory for the packages it lists
+func (db *MongoDB) GetPackageAdvisories(packages []string) ([]Advisory, error) {
+
+	filter := bson.D{{Key: "packages", Value: bson.D{{Key: "$in", Value: packages}}}}
+	opts := options.Find().SetSort(bson.D{{Key: "osvdata.id", Value: 1}})
+
+	cursor, err := db.AdvisoryCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	advisories := []Advisory{}
+	if err := cursor.All(context.TODO(), &advisories); err != nil {
+		return nil, err
+	}
+
+	return advisories, nil
+
+}
+
+// GetCvesFromIDs returns the CVEs with the given IDs that we have records for
+func (db *MongoDB) GetCvesFromIDs(ids []string) ([]CveMsg, error) {
+
+	filter := bson.D{{Key: "cvedata.id", Value: bson.D{{Key: "$in", Value: ids}}}}
+	opts := options.Find().SetSort(bson.D{{Key: "cvedata.id", Value: 1}}).SetProjection(bson.D{{Key: "search", Value: 0}})
+
+	cursor, err := db.CveCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []CveMsg{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+
+}
+
+// SearchText finds the CVEs whose descriptions, reference URLs or products match a free text query, using
+// whichever text index we've been configured with
+func (db *MongoDB) SearchText(q string, filter CveFilter) ([]SearchResult, error) {
+	return db.TextIndex.Search(q, filter)
+}
+
+// setUpTextIndex readies the configured text index: either a Mongo text index over the search block cvewriter keeps
+// on each CVE, or our own in-process index for deployments that can't have one
+func (m *MongoDB) setUpTextIndex() error {
+
+	switch m.Configuration.TextIndex {
+	case "mongo":
+		weights := bson.D{}
+		keys := bson.D{}
+		for _, field := range textFields {
+			keys = append(keys, bson.E{Key: "search." + field.name, Value: "text"})
+			weights = append(weights, bson.E{Key: "search." + field.name, Value: field.weight})
+		}
+		index := mongo.IndexModel{
+			Keys:    keys,
+			Options: options.Index().SetName("cve_text").SetWeights(weights).SetDefaultLanguage(m.Configuration.TextLanguage),
+		}
+		_, err := m.CveCollection.Indexes().CreateOne(context.TODO(), index)
+		if isIndexConflict(err) {
+			// the index was made with other weights or another language, so it has to go before we can make ours
+			log.Printf("Rebuilding text index cve_text, as its options have changed")
+			if _, err = m.CveCollection.Indexes().DropOne(context.TODO(), "cve_text"); err == nil {
+				_, err = m.CveCollection.Indexes().CreateOne(context.TODO(), index)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create text index: %w", err)
+		}
+		m.TextIndex = &mongoTextIndex{collection: m.CveCollection}
+
+	case "memory":
+		index := &memoryTextIndex{load: m.loadSearchText, filter: m.filterRanked}
+		m.TextIndex = index
+		go func() {
+			for {
+				start := time.Now()
+				if err := index.rebuild(); err != nil {
+					log.Printf("Failed to build the search index: %s", err)
+				} else {
+					log.Printf("Built the search index in %s", time.Since(start))
+				}
+				time.Sleep(m.Configuration.TextIndexRefresh)
+			}
+		}()
+
+	default:
+		return fmt.Errorf("unknown text index: %s", m.Configuration.TextIndex)
+	}
+
+	return nil
+}
+
+// isIndexConflict reports whether an index couldn't be created because one by the same name or on the same keys
+// already exists with other options
+func isIndexConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Name == "IndexOptionsConflict" || cmdErr.Name == "IndexKeySpecsConflict")
+}
+
+// mongoTextIndex searches with a Mongo text index, which does the ranking and filtering in one go
+type mongoTextIndex struct {
+	collection *mongo.Collection
+}
+
+func (m *mongoTextIndex) Search(q string, filter CveFilter) ([]SearchResult, error) {
+
+	query := append(buildCveQuery(filter), bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: q}}})
+	score := bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}
+	opts := options.Find().
+		SetProjection(score).
+		SetSort(score).
+		SetSkip(int64(filter.Offset)).
+		SetLimit(int64(filter.Limit))
+
+	cursor, err := m.collection.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []SearchResult{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// loadSearchText reads the search block of every CVE, for building the in-process index
+func (db *MongoDB) loadSearchText() ([]indexedDoc, error) {
+
+	opts := options.Find().SetProjection(bson.D{{Key: "cvedata.id", Value: 1}, {Key: "search", Value: 1}})
+	cursor, err := db.CveCollection.Find(context.TODO(), bson.D{{Key: "search", Value: bson.D{{Key: "$exists", Value: true}}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	docs := []indexedDoc{}
+	for cursor.Next(context.TODO()) {
+		var cve CveMsg
+		if err := cursor.Decode(&cve); err != nil {
+			return nil, err
+		}
+		if cve.Search != nil {
+			docs = append(docs, indexedDoc{ID: cve.CveData.ID, Search: *cve.Search})
+		}
+	}
+
+	return docs, cursor.Err()
+}
+
+// filterRanked fetches those of the in-process index's ranked matches that pass the search filter, in rank order
+func (db *MongoDB) filterRanked(ranked []rankedID, filter CveFilter) ([]SearchResult, error) {
+
+	if len(ranked) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	scores := map[string]float64{}
+	ids := make([]string, len(ranked))
+	for i, r := range ranked {
+		scores[r.ID] = r.Score
+		ids[i] = r.ID
+	}
+
+	// the filter may narrow the CVE IDs down too, so the two are kept apart rather than one replacing the other
+	query := bson.D{{Key: "$and", Value: bson.A{
+		buildCveQuery(filter),
+		bson.D{{Key: "cvedata.id", Value: bson.D{{Key: "$in", Value: ids}}}},
+	}}}
+	cursor, err := db.CveCollection.Find(context.TODO(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []SearchResult{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Score = scores[results[i].CveData.ID]
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+
+	return results, nil
+}
+
+// matchPublished is the pipeline stage narrowing CVEs down to those published in the range. It always excludes the
+// records we're holding enrichment data on (e.g. EPSS scores) for CVEs NVD hasn't sent us yet, as they have no publish date
+func matchPublished(path string, r DateRange) bson.D {
+	published := bson.D{{Key: "$gte", Value: r.From}}
+	if r.Before != "" {
+		published = append(published, bson.E{Key: "$lt", Value: r.Before})
+	}
+	return bson.D{{Key: "$match", Value: bson.D{{Key: path, Value: published}}}}
+}
+
+// countBy counts the CVEs published in the range sharing each value of an expression, ordered as given
+func (db *MongoDB) countBy(r DateRange, key interface{}, sort bson.D) ([]StatsCount, error) {
+	return db.aggregateCounts(db.CveCollection, mongo.Pipeline{
+		matchPublished("cvedata.published", r),
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: key}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		{{Key: "$sort", Value: sort}},
+	})
+}
+
+func (db *MongoDB) aggregateCounts(collection *mongo.Collection, pipeline mongo.Pipeline) ([]StatsCount, error) {
+
+	cursor, err := collection.Aggregate(context.TODO(), pipeline)
 	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	counts := []StatsCount{}
+	if err := cursor.All(context.TODO(), &counts); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// the order we list counts in for a top N, biggest first
+var byCountDesc = bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}
+
+func (db *MongoDB) CountPublishedByMonth(r DateRange) ([]StatsCount, error) {
+	month := bson.D{{Key: "$substrBytes", Value: bson.A{"$cvedata.published", 0, 7}}}
+	return db.countBy(r, month, bson.D{{Key: "_id", Value: 1}})
+}
+
+func (db *MongoDB) CountBySeverity(r DateRange) ([]StatsCount, error) {
+	severity := bson.D{{Key: "$ifNull", Value: bson.A{"$preferredScore.baseSeverity", "UNSCORED"}}}
+	return db.countBy(r, severity, byCountDesc)
+}
+
+func (db *MongoDB) CountByStatus(r DateRange) ([]StatsCount, error) {
+	return db.countBy(r, "$cvedata.vulnStatus", byCountDesc)
+}
+
+func (db *MongoDB) TopCwes(r DateRange, limit int) ([]StatsCount, error) {
+	return db.aggregateCounts(db.CveCollection, mongo.Pipeline{
+		matchPublished("cvedata.published", r),
+		{{Key: "$unwind", Value: "$cvedata.weaknesses"}},
+		{{Key: "$unwind", Value: "$cvedata.weaknesses.description"}},
+		// leaving out NVD's placeholders, NVD-CWE-Other and NVD-CWE-noinfo, which would otherwise top the list
+		{{Key: "$match", Value: bson.D{{Key: "cvedata.weaknesses.description.value", Value: bson.D{{Key: "$regex", Value: "^CWE-"}}}}}},
+		// NVD and the CNA often assign the same CWE, so count each CVE once
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "cve", Value: "$_id"}, {Key: "cwe", Value: "$cvedata.weaknesses.description.value"}}}}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$_id.cwe"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		{{Key: "$sort", Value: byCountDesc}},
+		{{Key: "$limit", Value: limit}},
+	})
+}
+
+// TopVendors counts CVEs by the vendors of the CPEs their configurations list as vulnerable, e.g. apache
+func (db *MongoDB) TopVendors(r DateRange, limit int) ([]StatsCount, error) {
+	return db.topCpeParts(r, limit, bson.D{{Key: "$arrayElemAt", Value: bson.A{"$cpe", 3}}})
+}
+
+// TopProducts counts CVEs by the products of the CPEs their configurations list as vulnerable, e.g. apache:log4j
+func (db *MongoDB) TopProducts(r DateRange, limit int) ([]StatsCount, error) {
+	return db.topCpeParts(r, limit, bson.D{{Key: "$concat", Value: bson.A{
+		bson.D{{Key: "$arrayElemAt", Value: bson.A{"$cpe", 3}}}, ":", bson.D{{Key: "$arrayElemAt", Value: bson.A{"$cpe", 4}}},
+	}}})
+}
+
+// topCpeParts counts CVEs by a key taken from the parts of their vulnerable CPEs ($cpe, split on its colons)
+func (db *MongoDB) topCpeParts(r DateRange, limit int, key bson.D) ([]StatsCount, error) {
+	return db.aggregateCounts(db.CveCollection, mongo.Pipeline{
+		matchPublished("cvedata.published", r),
+		{{Key: "$unwind", Value: "$cvedata.configurations"}},
+		{{Key: "$unwind", Value: "$cvedata.configurations.nodes"}},
+		{{Key: "$unwind", Value: "$cvedata.configurations.nodes.cpeMatch"}},
+		{{Key: "$match", Value: bson.D{{Key: "cvedata.configurations.nodes.cpeMatch.vulnerable", Value: true}}}},
+		{{Key: "$project", Value: bson.D{{Key: "cpe", Value: bson.D{{Key: "$split", Value: bson.A{"$cvedata.configurations.nodes.cpeMatch.criteria", ":"}}}}}}},
+		// a CVE can list many versions of the same product, so count each CVE once
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "cve", Value: "$_id"}, {Key: "key", Value: key}}}}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$_id.key"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		{{Key: "$sort", Value: byCountDesc}},
+		{{Key: "$limit", Value: limit}},
+	})
+}
+
+// GetAnalysisTime works out how long NVD took to analyse the CVEs published in the range, from when their Initial
+// Analysis events appear in the change history
+func (db *MongoDB) GetAnalysisTime(r DateRange) (*AnalysisTime, error) {
+
+	toDate := func(path string) bson.D {
+		return bson.D{{Key: "$dateFromString", Value: bson.D{{Key: "dateString", Value: path}}}}
+	}
+	msPerDay := 24 * 60 * 60 * 1000
+
+	// starting from the CVEs published in the range, so we only look up the changes of those rather than joining
+	// every analysis there's ever been to its CVE
+	cursor, err := db.CveCollection.Aggregate(context.TODO(), mongo.Pipeline{
+		matchPublished("cvedata.published", r),
+		{{Key: "$project", Value: bson.D{{Key: "cvedata.id", Value: 1}, {Key: "cvedata.published", Value: 1}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: db.ChangeCollection.Name()},
+			{Key: "localField", Value: "cvedata.id"},
+			{Key: "foreignField", Value: "changedata.cveId"},
+			{Key: "as", Value: "change"},
+		}}},
+		{{Key: "$unwind", Value: "$change"}},
+		{{Key: "$match", Value: bson.D{{Key: "change.changedata.eventName", Value: "Initial Analysis"}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$cvedata.id"},
+			{Key: "published", Value: bson.D{{Key: "$first", Value: "$cvedata.published"}}},
+			{Key: "analysed", Value: bson.D{{Key: "$min", Value: "$change.changedata.created"}}},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "averageDays", Value: bson.D{{Key: "$avg", Value: bson.D{{Key: "$divide", Value: bson.A{
+				bson.D{{Key: "$subtract", Value: bson.A{toDate("$analysed"), toDate("$published")}}},
+				msPerDay,
+			}}}}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []AnalysisTime{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return &AnalysisTime{}, nil
+	}
+
+	return &results[0], nil
+}
+
+func (db *MongoDB) GetCwe(id string) (*Cwe, error) {
+
+	filter := bson.D{{Key: "id", Value: id}}
+
+	var result Cwe
+	err := db.CweCollection.FindOne(context.TODO(), filter).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+
+}
+
+func (db *MongoDB) GetCwes(ids []string) ([]Cwe, error) {
+	return db.findCwes(bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}}})
+}
+
+func (db *MongoDB) GetCweChildren(id string) ([]Cwe, error) {
+	return db.findCwes(bson.D{{Key: "parents", Value: id}})
+}
+
+func (db *MongoDB) findCwes(filter bson.D) ([]Cwe, error) {
+
+	cursor, err := db.CweCollection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Cwe{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+
+}
+
+// the shape of the documents cvewriter stores in the CPE collections
+type cpeDoc struct {
+	Cpe Cpe `bson:"cpedata"`
+}
+
+type cpeMatchDoc struct {
+	Match struct {
+		MatchCriteriaID string `bson:"matchCriteriaId"`
+	} `bson:"matchdata"`
+}
+
+func (db *MongoDB) SearchCpes(q string, limit int) ([]Cpe, error) {
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "cpedata.cpeName", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := db.CpeCollection.Find(context.TODO(), buildCpeQuery(q), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []cpeDoc{}
+	if err := cursor.All(context.TODO(), &docs); err != nil {
+		return nil, err
+	}
+
+	cpes := make([]Cpe, len(docs))
+	for i, doc := range docs {
+		cpes[i] = doc.Cpe
+	}
+
+	return cpes, nil
+
+}
+
+// buildCpeQuery matches products for autocomplete. A query that's already a CPE name is matched as a prefix of
+// the product's name, otherwise every word in it has to appear in either the product's name or one of its titles
+func buildCpeQuery(q string) bson.D {
+
+	query := bson.D{{Key: "cpedata.deprecated", Value: false}}
+
+	if strings.HasPrefix(q, "cpe:") {
+		return append(query, bson.E{Key: "cpedata.cpeName", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q)}})
+	}
+
+	terms := bson.A{}
+	for _, term := range strings.Fields(q) {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(term), Options: "i"}
+		terms = append(terms, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "cpedata.cpeName", Value: pattern}},
+			bson.D{{Key: "cpedata.titles.title", Value: pattern}},
+		}}})
+	}
+	if len(terms) > 0 {
+		query = append(query, bson.E{Key: "$and", Value: terms})
+	}
+
+	return query
+
+}
+
+// GetMatchCriteriaIDs finds the IDs of all the match criteria that expand to the given CPE name
+func (db *MongoDB) GetMatchCriteriaIDs(cpeName string) ([]string, error) {
+
+	filter := bson.D{{Key: "matchdata.matches.cpeName", Value: cpeName}}
+	opts := options.Find().SetProjection(bson.D{{Key: "matchdata.matchCriteriaId", Value: 1}})
+
+	cursor, err := db.CpeMatchCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []cpeMatchDoc{}
+	if err := cursor.All(context.TODO(), &docs); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.Match.MatchCriteriaID
+	}
+
+	return ids, nil
+
+}
+
+func (db *MongoDB) CreateSubscription(sub Subscription) error {
+	_, err := db.SubscriptionCollection.InsertOne(context.TODO(), sub)
+	return err
+}
+
+func (db *MongoDB) GetSubscriptions() ([]Subscription, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := db.SubscriptionCollection.Find(context.TODO(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Subscription{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+
+}
+
+func (db *MongoDB) GetSubscription(id string) (*Subscription, error) {
+
+	filter := bson.D{{Key: "id", Value: id}}
+
+	var result Subscription
+	err := db.SubscriptionCollection.FindOne(context.TODO(), filter).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+
+}
+
+// SetSubscriptionEnabled turns a subscription on or off, returning it as it now is. Either way its failures are
+// cleared, along with why the notifier disabled it if it did
+func (db *MongoDB) SetSubscriptionEnabled(id string, enabled bool) (*Subscription, error) {
+
+	filter := bson.D{{Key: "id", Value: id}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "enabled", Value: enabled}, {Key: "consecutiveFailures", Value: 0}}},
+		{Key: "$unset", Value: bson.D{{Key: "disabledAt", Value: ""}, {Key: "disabledReason", Value: ""}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var result Subscription
+	err := db.SubscriptionCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+
+}
+
+// DeleteSubscription removes a subscription, along with its delivery log
+func (db *MongoDB) DeleteSubscription(id string) error {
+
+	result, err := db.SubscriptionCollection.DeleteOne(context.TODO(), bson.D{{Key: "id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	_, err = db.DeliveryCollection.DeleteMany(context.TODO(), bson.D{{Key: "subscriptionId", Value: id}})
+	return err
+
+}
+
+func (db *MongoDB) GetDeliveries(subscriptionID string, limit int) ([]Delivery, error) {
+
+	filter := bson.D{{Key: "subscriptionId", Value: subscriptionID}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(limit))
+
+	cursor, err := db.DeliveryCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Delivery{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+
+}
+
+func (db *MongoDB) CreateAsset(asset Asset) error {
+	_, err := db.AssetCollection.InsertOne(context.TODO(), asset)
+	return err
+}
+
+// GetAssets lists the assets we know of by name, only those belonging to a team if one is given
+func (db *MongoDB) GetAssets(team string) ([]Asset, error) {
+
+	filter := bson.D{}
+	if team != "" {
+		filter = bson.D{{Key: "team", Value: team}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "id", Value: 1}})
+
+	cursor, err := db.AssetCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Asset{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+
+}
+
+func (db *MongoDB) GetAsset(id string) (*Asset, error) {
+
+	filter := bson.D{{Key: "id", Value: id}}
+
+	var result Asset
+	err := db.AssetCollection.FindOne(context.TODO(), filter).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+
+}
+
+func (db *MongoDB) UpdateAsset(asset Asset) error {
+
+	result, err := db.AssetCollection.ReplaceOne(context.TODO(), bson.D{{Key: "id", Value: asset.ID}}, asset)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+
+}
+
+// DeleteAsset removes an asset, along with the annotations made for it
+func (db *MongoDB) DeleteAsset(id string) error {
+
+	result, err := db.AssetCollection.DeleteOne(context.TODO(), bson.D{{Key: "id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	_, err = db.AnnotationCollection.DeleteMany(context.TODO(), bson.D{{Key: "asset", Value: id}})
+	return err
+
+}
+
+// AnnotationFilter narrows down which annotations to fetch. Given both an asset and products, annotations for
+// either are fetched
+type AnnotationFilter struct {
+	Asset    string
+	Products []string
+	CveID    string
+	Status   string
+}
+
+func (db *MongoDB) CreateAnnotation(annotation Annotation) error {
+	_, err := db.AnnotationCollection.InsertOne(context.TODO(), annotation)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (db *MongoDB) GetAnnotations(filter AnnotationFilter) ([]Annotation, error) {
+
+	query := bson.D{}
+
+	subjects := bson.A{}
+	if filter.Asset != "" {
+		subjects = append(subjects, bson.D{{Key: "asset", Value: filter.Asset}})
+	}
+	if len(filter.Products) > 0 {
+		subjects = append(subjects, bson.D{{Key: "product", Value: bson.D{{Key: "$in", Value: filter.Products}}}})
+	}
+	if len(subjects) > 0 {
+		query = append(query, bson.E{Key: "$or", Value: subjects})
+	}
+
+	if filter.CveID != "" {
+		query = append(query, bson.E{Key: "cveId", Value: filter.CveID})
+	}
+	if filter.Status != "" {
+		query = append(query, bson.E{Key: "status", Value: filter.Status})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "cveId", Value: 1}, {Key: "updatedAt", Value: -1}})
+	cursor, err := db.AnnotationCollection.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Annotation{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+
+}
+
+func (db *MongoDB) GetAnnotation(id string) (*Annotation, error) {
+
+	filter := bson.D{{Key: "id", Value: id}}
+
+	var result Annotation
+	err := db.AnnotationCollection.FindOne(context.TODO(), filter).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+
+}
+
+func (db *MongoDB) UpdateAnnotation(annotation Annotation) error {
+
+	result, err := db.AnnotationCollection.ReplaceOne(context.TODO(), bson.D{{Key: "id", Value: annotation.ID}}, annotation)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+
+}
+
+func (db *MongoDB) DeleteAnnotation(id string) error {
+
+	result, err := db.AnnotationCollection.DeleteOne(context.TODO(), bson.D{{Key: "id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+
+}
+
+func (db *MongoDB) CreateApiKey(key ApiKey) error {
+	_, err := db.ApiKeyCollection.InsertOne(context.TODO(), key)
+	return err
+}
+
+func (db *MongoDB) GetApiKeys() ([]ApiKey, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := db.ApiKeyCollection.Find(context.TODO(), bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ApiKey{}
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+
+}
+
+func (db *MongoDB) GetApiKey(id string) (*ApiKey, error) {
+
+	filter := bson.D{{Key: "id", Value: id}}
+
+	var result ApiKey
+	err := db.ApiKeyCollection.FindOne(context.TODO(), filter).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+
+}
+
+// RevokeApiKey marks a key as revoked. A key that's already been revoked keeps the time it was first revoked at
+func (db *MongoDB) RevokeApiKey(id string, revokedAt string) error {
+
+	filter := bson.D{{Key: "id", Value: id}}
+	result, err := db.ApiKeyCollection.UpdateOne(context.TODO(), filter, bson.A{
+		bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$revokedAt", revokedAt}}}}}}},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
 
 }
 
@@ -107,10 +1324,25 @@ func (db *MongoDB) InsertMetaDoc(doc MetaDoc) error {
 // An object to hold our connection config for databases
 
 type DBConnConfig struct {
-	Url            string
-	Username       string
-	Password       string
-	Database       string
-	CveCollection  string
-	MetaCollection string
+	Url                    string
+	Username               string
+	Password               string
+	Database               string
+	CveCollection          string
+	MetaCollection         string
+	EpssCollection         string
+	CweCollection          string
+	CpeCollection          string
+	CpeMatchCollection     string
+	ChangeCollection       string
+	DistroCollection       string
+	AdvisoryCollection     string
+	SubscriptionCollection string
+	DeliveryCollection     string
+	AssetCollection        string
+	AnnotationCollection   string
+	ApiKeyCollection       string
+	TextIndex              string        // mongo, or memory for an in-process index
+	TextLanguage           string        // the language the mongo text index stems words in
+	TextIndexRefresh       time.Duration // how often the in-process index is rebuilt
 }
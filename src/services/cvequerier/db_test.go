@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestJsonFallbackRegistry_Decodes_Stored_Field_Names(t *testing.T) {
+
+	// the shape cvewriter stores NVD records in
+	stored := bson.D{{Key: "cvedata", Value: bson.D{
+		{Key: "id", Value: "CVE-0000-0000"},
+		{Key: "sourceIdentifier", Value: "nvd@nist.gov"},
+		{Key: "metrics", Value: bson.D{{Key: "cvssMetricV40", Value: bson.A{
+			bson.D{{Key: "type", Value: "Primary"}, {Key: "cvssData", Value: bson.D{{Key: "baseScore", Value: 9.3}}}},
+		}}}},
+	}}, {Key: "preferredScore", Value: bson.D{{Key: "baseScore", Value: 9.3}}}}
+
+	raw, err := bson.Marshal(stored)
+	assert.NoError(t, err)
+
+	var cve CveMsg
+	assert.NoError(t, bson.UnmarshalWithRegistry(jsonFallbackRegistry, raw, &cve))
+	assert.Equal(t, "nvd@nist.gov", cve.CveData.SourceIdentifier)
+	if assert.Len(t, cve.CveData.Metrics.CvssMetricV40, 1) {
+		assert.Equal(t, 9.3, cve.CveData.Metrics.CvssMetricV40[0].CvssData.BaseScore)
+	}
+	if assert.NotNil(t, cve.PreferredScore) {
+		assert.Equal(t, 9.3, cve.PreferredScore.BaseScore)
+	}
+
+}
+
+func TestBuildCpeQuery(t *testing.T) {
+
+	// CPE names are matched as a prefix, with their special characters escaped
+	query := buildCpeQuery("cpe:2.3:a:apache:log4j:2.14.1")
+	assert.Equal(t, primitive.Regex{Pattern: `^cpe:2\.3:a:apache:log4j:2\.14\.1`}, query.Map()["cpedata.cpeName"])
+
+	// anything else needs each of its words to appear somewhere
+	query = buildCpeQuery("apache  log4j")
+	if assert.Contains(t, query.Map(), "$and") {
+		assert.Len(t, query.Map()["$and"], 2)
+	}
+
+}
+
+func TestJsonFallbackRegistry_Decodes_Stored_Advisories(t *testing.T) {
+
+	// OSV's field names are snake_case, unlike NVD's
+	stored := bson.D{{Key: "source", Value: "GHSA"}, {Key: "osvdata", Value: bson.D{
+		{Key: "id", Value: "GHSA-jfh8-c2jp-5v3q"},
+		{Key: "schema_version", Value: "1.4.0"},
+		{Key: "affected", Value: bson.A{bson.D{{Key: "ranges", Value: bson.A{bson.D{{Key: "events", Value: bson.A{
+			bson.D{{Key: "last_affected", Value: "2.14.1"}},
+		}}}}}}}},
+		{Key: "database_specific", Value: bson.D{{Key: "severity", Value: "CRITICAL"}}},
+	}}}
+
+	raw, err := bson.Marshal(stored)
+	assert.NoError(t, err)
+
+	var advisory Advisory
+	assert.NoError(t, bson.UnmarshalWithRegistry(jsonFallbackRegistry, raw, &advisory))
+	assert.Equal(t, "1.4.0", advisory.Osv.SchemaVersion)
+	assert.Equal(t, "CRITICAL", advisory.Severity())
+	assert.Equal(t, "2.14.1", advisory.Osv.Affected[0].Ranges[0].Events[0].LastAffected)
+
+}
+
+func TestBuildCveQuery_Vendor_And_Cpe(t *testing.T) {
+
+	// a product and a vendor may be matched by different criteria in the same CVE
+	query := buildCveQuery(CveFilter{Cpe: "cpe:2.3:a:apache:log4j:2.14.1:*:*:*:*:*:*:*", MatchCriteriaIDs: []string{"id"}, Vendor: "apache"})
+	cpeMatch := query.Map()["cvedata.configurations.nodes.cpeMatch"].(bson.D)
+	if assert.Equal(t, "$all", cpeMatch[0].Key) {
+		assert.Len(t, cpeMatch[0].Value, 2)
+	}
+
+	// a vendor on its own only needs the one
+	query = buildCveQuery(CveFilter{Vendor: "a.b", Keyword: "jndi (lookup"})
+	vendorMatch := bson.D{
+		{Key: "criteria", Value: primitive.Regex{Pattern: `^cpe:2\.3:[aho]:a\.b:`}},
+		{Key: "vulnerable", Value: true},
+	}
+	assert.Equal(t, bson.D{{Key: "$elemMatch", Value: vendorMatch}}, query.Map()["cvedata.configurations.nodes.cpeMatch"])
+	assert.Equal(t, primitive.Regex{Pattern: `jndi \(lookup`, Options: "i"}, query.Map()["cvedata.descriptions.value"])
+
+}
+
+func TestBuildCveQuery_Distro(t *testing.T) {
+
+	// the distro filters narrow the search to the CVEs they resolved to, even when that's none
+	query := buildCveQuery(CveFilter{DistroStatuses: []string{"affected"}, DistroCveIDs: []string{}})
+	assert.Equal(t, bson.D{{Key: "$in", Value: []string{}}}, query.Map()["cvedata.id"])
+
+	query = buildCveQuery(CveFilter{})
+	assert.NotContains(t, query.Map(), "cvedata.id")
+
+}
+
+func TestIsIndexConflict(t *testing.T) {
+
+	assert.True(t, isIndexConflict(fmt.Errorf("wrapped: %w", mongo.CommandError{Code: 85, Name: "IndexOptionsConflict"})))
+	assert.True(t, isIndexConflict(mongo.CommandError{Code: 86, Name: "IndexKeySpecsConflict"}))
+	assert.False(t, isIndexConflict(mongo.CommandError{Code: 13, Name: "Unauthorized"}))
+	assert.False(t, isIndexConflict(nil))
+
+}
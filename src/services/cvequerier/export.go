@@ -0,0 +1,508 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a column of an export, flattened out of a CVE's record. Values are strings, numbers, booleans, or nil when the
+// CVE has nothing for the column
+type exportColumn struct {
+	name  string
+	value func(cve *CveMsg) interface{}
+}
+
+// the columns an export can be made up of, in the order they're listed when asked for all of them
+var exportColumns = []exportColumn{
+	{"id", func(cve *CveMsg) interface{} { return cve.CveData.ID }},
+	{"published", func(cve *CveMsg) interface{} { return cve.CveData.Published }},
+	{"lastModified", func(cve *CveMsg) interface{} { return cve.CveData.LastModified }},
+	{"status", func(cve *CveMsg) interface{} { return cve.CveData.VulnStatus }},
+	{"score", func(cve *CveMsg) interface{} {
+		if cve.PreferredScore == nil {
+			return nil
+		}
+		return cve.PreferredScore.BaseScore
+	}},
+	{"severity", func(cve *CveMsg) interface{} {
+		if cve.PreferredScore == nil {
+			return nil
+		}
+		return cve.PreferredScore.BaseSeverity
+	}},
+	{"vector", func(cve *CveMsg) interface{} {
+		if cve.PreferredScore == nil {
+			return nil
+		}
+		return cve.PreferredScore.VectorString
+	}},
+	{"cwe", func(cve *CveMsg) interface{} { return strings.Join(cve.WeaknessIDs(), ",") }},
+	{"epss", func(cve *CveMsg) interface{} {
+		if cve.Epss == nil {
+			return nil
+		}
+		return cve.Epss.Score
+	}},
+	{"kev", func(cve *CveMsg) interface{} { return cve.Kev != nil }},
+	{"description", func(cve *CveMsg) interface{} { return firstDescription(cve.CveData.Descriptions) }},
+}
+
+// the columns exported when the caller doesn't pick any
+const defaultExportColumns = "id,published,score,severity,cwe,description"
+
+// firstDescription returns the CVE's first English description, or its first in any language if it has none
+func firstDescription(descriptions []LangString) string {
+	for _, desc := range descriptions {
+		if desc.Lang == "en" {
+			return desc.Value
+		}
+	}
+	if len(descriptions) > 0 {
+		return descriptions[0].Value
+	}
+	return ""
+}
+
+// parseExportColumns parses a comma separated list of column names, where 'all' asks for every one of them
+func parseExportColumns(v string) ([]exportColumn, error) {
+	if v == "all" {
+		return exportColumns, nil
+	}
+	columns := []exportColumn{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, column := range exportColumns {
+			if strings.EqualFold(column.name, name) {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column: %s", name)
+		}
+	}
+	return columns, nil
+}
+
+// cveWriter writes out an export a CVE at a time, in one of the formats we support
+type cveWriter interface {
+	WriteCve(cve *CveMsg) error
+	Close() error
+}
+
+// rowWriter writes out a tabular export a row at a time
+type rowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// starts an export in one of our formats, writing it to w
+type newCveWriter func(s *Server, w io.Writer, columns []exportColumn) (cveWriter, error)
+
+// the formats an export can be made in, with the media type, file extension and a writer for each. The tabular
+// formats are made up of the columns asked for, while STIX and CSAF have fixed structures of their own
+var exportFormats = map[string]struct {
+	mediaType string
+	extension string
+	newWriter newCveWriter
+}{
+	"csv":    {"text/csv", "csv", tabular(newCsvRowWriter)},
+	"ndjson": {"application/x-ndjson", "ndjson", tabular(newNdjsonRowWriter)},
+	"xlsx":   {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx", tabular(newXlsxRowWriter)},
+	"stix":   {mimeStix, "stix.json", (*Server).newStixWriter},
+	"csaf":   {mimeCsaf, "csaf.json", (*Server).newCsafWriter},
+}
+
+// the export formats in the order we offer them when negotiating, the first being the default
+var exportFormatNames = []string{"csv", "ndjson", "xlsx", "stix", "csaf"}
+
+// negotiateExportFormat picks the format asked for by the format parameter or, failing that, the Accept header
+func negotiateExportFormat(c *gin.Context) (string, bool) {
+
+	if v := c.Query("format"); v != "" {
+		_, ok := exportFormats[v]
+		return v, ok
+	}
+
+	offered := make([]string, len(exportFormatNames))
+	for i, name := range exportFormatNames {
+		offered[i] = exportFormats[name].mediaType
+	}
+	accepted := c.NegotiateFormat(offered...)
+	for _, name := range exportFormatNames {
+		if exportFormats[name].mediaType == accepted {
+			return name, true
+		}
+	}
+	return exportFormatNames[0], true
+}
+
+// exportCves streams every CVE matching a search out as a file for spreadsheets and other tools. It takes the same
+// filters as /cves, except that the whole result set is exported unless a limit is given
+func (s *Server) exportCves(c *gin.Context) {
+
+	name, ok := negotiateExportFormat(c)
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "format must be one of " + strings.Join(exportFormatNames, ", ")})
+		return
+	}
+	format := exportFormats[name]
+
+	columns, err := parseExportColumns(c.DefaultQuery("columns", defaultExportColumns))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, err := parseCveFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if c.Query("limit") == "" {
+		filter.Limit = 0
+	}
+
+	if err := resolveFilter(s.db, &filter); err != nil {
+		log.Printf("Failed to resolve search filters: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "export failed"})
+		return
+	}
+
+	contentType := format.mediaType
+	if name == "csv" {
+		contentType += "; charset=utf-8"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="cves.%s"`, format.extension))
+	c.Status(http.StatusOK)
+
+	// once we've started writing the status is sent, so all we can do about a failure is cut the export short
+	w, err := format.newWriter(s, c.Writer, columns)
+	if err != nil {
+		log.Printf("Failed to start export: %s", err)
+		return
+	}
+
+	count := 0
+	err = s.db.ExportCves(c.Request.Context(), filter, func(cve *CveMsg) error {
+		count++
+		return w.WriteCve(cve)
+	})
+	if err != nil {
+		log.Printf("Export failed after %d CVEs: %s", count, err)
+		return
+	}
+
+	if err := w.Close(); err != nil {
+		log.Printf("Failed to finish export: %s", err)
+		return
+	}
+
+	log.Printf("Exported %d CVEs", count)
+
+}
+
+// formatValue renders a column's value as text, for the formats that don't keep their types
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// tabularWriter flattens each CVE into a row of the columns asked for
+type tabularWriter struct {
+	rowWriter
+	columns []exportColumn
+	values  []interface{}
+}
+
+// tabular adapts a row writer for a tabular format to write the columns of each CVE, after a header row
+func tabular(newRowWriter func(w io.Writer, columns []string) (rowWriter, error)) newCveWriter {
+	return func(s *Server, w io.Writer, columns []exportColumn) (cveWriter, error) {
+		names := make([]string, len(columns))
+		for i, column := range columns {
+			names[i] = column.name
+		}
+		rows, err := newRowWriter(w, names)
+		if err != nil {
+			return nil, err
+		}
+		return &tabularWriter{rows, columns, make([]interface{}, len(columns))}, nil
+	}
+}
+
+func (t *tabularWriter) WriteCve(cve *CveMsg) error {
+	for i, column := range t.columns {
+		t.values[i] = column.value(cve)
+	}
+	return t.WriteRow(t.values)
+}
+
+// jsonArrayWriter streams a JSON document with an array in it, writing everything up to the array's first element
+// when it's created, then the elements one by one, then whatever follows the array when it's closed
+type jsonArrayWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func newJsonArrayWriter(w io.Writer, prefix string) *jsonArrayWriter {
+	a := &jsonArrayWriter{w: bufio.NewWriter(w)}
+	a.w.WriteString(prefix)
+	return a
+}
+
+func (a *jsonArrayWriter) writeElement(v interface{}) error {
+	element, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if a.count > 0 {
+		a.w.WriteByte(',')
+	}
+	a.count++
+	_, err = a.w.Write(element)
+	return err
+}
+
+func (a *jsonArrayWriter) close(suffix string) error {
+	a.w.WriteString(suffix)
+	return a.w.Flush()
+}
+
+// stixWriter streams a STIX bundle of vulnerabilities
+type stixWriter struct {
+	*jsonArrayWriter
+}
+
+func (s *Server) newStixWriter(w io.Writer, columns []exportColumn) (cveWriter, error) {
+	prefix := fmt.Sprintf(`{"type":"bundle","id":"bundle--%s","objects":[`, randomUUID())
+	return &stixWriter{newJsonArrayWriter(w, prefix)}, nil
+}
+
+func (x *stixWriter) WriteCve(cve *CveMsg) error {
+	return x.writeElement(newStixVulnerability(cve))
+}
+
+func (x *stixWriter) Close() error {
+	return x.close("]}")
+}
+
+// csafWriter streams a CSAF document. Its product tree can only be written once we've seen every CVE, so it goes
+// after the vulnerabilities
+type csafWriter struct {
+	*jsonArrayWriter
+	builder *csafBuilder
+}
+
+func (s *Server) newCsafWriter(w io.Writer, columns []exportColumn) (cveWriter, error) {
+	meta, err := json.Marshal(newSearchCsafMeta(s.csafPublisher, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	prefix := `{"document":` + string(meta) + `,"vulnerabilities":[`
+	return &csafWriter{newJsonArrayWriter(w, prefix), newCsafBuilder()}, nil
+}
+
+func (x *csafWriter) WriteCve(cve *CveMsg) error {
+	return x.writeElement(x.builder.vulnerability(cve))
+}
+
+func (x *csafWriter) Close() error {
+	tree := x.builder.productTree()
+	if tree == nil {
+		return x.close("]}")
+	}
+	suffix, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return x.close(`],"product_tree":` + string(suffix) + "}")
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func newCsvRowWriter(w io.Writer, columns []string) (rowWriter, error) {
+	writer := &csvRowWriter{csv.NewWriter(w)}
+	return writer, writer.w.Write(columns)
+}
+
+func (c *csvRowWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatValue(v)
+		// spreadsheets run cells starting with these as formulas, and the text we export comes from outside. A
+		// leading ' has them show the text as it is
+		if _, ok := v.(string); ok && record[i] != "" && strings.ContainsRune("=+-@\t\r", rune(record[i][0])) {
+			record[i] = "'" + record[i]
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvRowWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonRowWriter writes each row as a JSON object on a line of its own, keyed by column name in column order
+type ndjsonRowWriter struct {
+	w       *bufio.Writer
+	columns []string
+}
+
+func newNdjsonRowWriter(w io.Writer, columns []string) (rowWriter, error) {
+	return &ndjsonRowWriter{bufio.NewWriter(w), columns}, nil
+}
+
+func (n *ndjsonRowWriter) WriteRow(values []interface{}) error {
+	n.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(n.columns[i])
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		n.w.Write(key)
+		n.w.WriteByte(':')
+		n.w.Write(value)
+	}
+	_, err := n.w.WriteString("}\n")
+	return err
+}
+
+func (n *ndjsonRowWriter) Close() error {
+	return n.w.Flush()
+}
+
+// the parts of a workbook that don't depend on its contents. The zip is streamed, so these all go out before the
+// worksheet, which is written a row at a time
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="CVEs" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+}
+
+// xlsxRowWriter writes a single sheet workbook. Strings are written inline rather than to a shared strings table,
+// which would mean holding every one of them until the end
+type xlsxRowWriter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+func newXlsxRowWriter(w io.Writer, columns []string) (rowWriter, error) {
+
+	x := &xlsxRowWriter{zip: zip.NewWriter(w)}
+
+	for _, part := range xlsxParts {
+		f, err := x.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := x.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	x.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+	return x, x.WriteRow(header)
+}
+
+func (x *xlsxRowWriter) WriteRow(values []interface{}) error {
+
+	x.row++
+	fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+	for i, v := range values {
+		ref := columnName(i) + strconv.Itoa(x.row)
+		switch v := v.(type) {
+		case nil:
+		case float64:
+			fmt.Fprintf(x.sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			fmt.Fprintf(x.sheet, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		default:
+			fmt.Fprintf(x.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			if err := xml.EscapeText(x.sheet, []byte(formatValue(v))); err != nil {
+				return err
+			}
+			x.sheet.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := x.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxRowWriter) Close() error {
+	x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
+
+// columnName returns the spreadsheet name of a zero based column index, e.g. A, Z, AA
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultFeedLimit = 50
+
+// an Atom 1.0 feed
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  AtomPerson  `xml:"author"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomPerson struct {
+	Name string `xml:"name"`
+}
+
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type AtomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published,omitempty"`
+	Author     *AtomPerson    `xml:"author,omitempty"`
+	Link       AtomLink       `xml:"link"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []AtomCategory `xml:"category"`
+}
+
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// an RSS 2.0 feed
+type RssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel RssChannel `xml:"channel"`
+}
+
+type RssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	SelfLink      AtomLink  `xml:"atom:link"`
+	Items         []RssItem `xml:"item"`
+}
+
+type RssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description,omitempty"`
+	GUID        RssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+}
+
+type RssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// a CVE as it appears in a feed, whichever format that's in
+type feedEntry struct {
+	ID         string
+	Title      string
+	Updated    time.Time
+	Published  time.Time
+	Author     string
+	Summary    string
+	Categories []string
+}
+
+// newFeedEntry describes a CVE for feed readers. Its ID is the CVE's NVD page, which never changes, and it's
+// updated whenever NVD last modified the CVE, so readers see changes as updates to the same entry
+func newFeedEntry(cve *CveMsg) feedEntry {
+
+	entry := feedEntry{
+		ID:        "https://nvd.nist.gov/vuln/detail/" + cve.CveData.ID,
+		Title:     cve.CveData.ID,
+		Updated:   parseNvdTime(cve.CveData.LastModified),
+		Published: parseNvdTime(cve.CveData.Published),
+		Author:    cve.CveData.SourceIdentifier,
+		Summary:   firstDescription(cve.CveData.Descriptions),
+	}
+
+	if cve.PreferredScore != nil {
+		entry.Title += fmt.Sprintf(" (%s %.1f)", cve.PreferredScore.BaseSeverity, cve.PreferredScore.BaseScore)
+		entry.Categories = append(entry.Categories, cve.PreferredScore.BaseSeverity)
+	}
+	if cve.Kev != nil {
+		entry.Categories = append(entry.Categories, "KEV")
+	}
+	entry.Categories = append(entry.Categories, cve.WeaknessIDs()...)
+
+	return entry
+}
+
+// parseNvdTime parses one of NVD's timestamps, which are in UTC without saying so. Anything unparseable is the
+// zero time
+func parseNvdTime(v string) time.Time {
+	t, err := time.Parse("2006-01-02T15:04:05.999999999", strings.TrimSuffix(v, "Z"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// the parameters that narrow a feed down, in the order we describe them in its title
+var feedParams = []string{"severity", "cwe", "vendor", "keyword", "kev", "scoreMin", "cpe", "epssMin", "percentileMin"}
+
+// feedTitle names a feed after the filters it was asked for with
+func feedTitle(query url.Values) string {
+	filters := []string{}
+	for _, param := range feedParams {
+		if v := query.Get(param); v != "" {
+			filters = append(filters, param+"="+v)
+		}
+	}
+	if len(filters) == 0 {
+		return "New and updated CVEs"
+	}
+	return "New and updated CVEs: " + strings.Join(filters, ", ")
+}
+
+// getFeed lists the most recently modified CVEs matching a search for feed readers, in the given format. Feeds take
+// the same filters as /cves, but are always ordered by when the CVEs were last modified
+func (s *Server) getFeed(format string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		if c.Query("sort") != "" {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "feeds are ordered by lastModified, and can't be sorted"})
+			return
+		}
+
+		filter, err := parseCveFilter(c)
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filter.Sort, filter.Descending = "lastModified", true
+		if c.Query("limit") == "" {
+			filter.Limit = defaultFeedLimit
+		}
+
+		if err := resolveFilter(s.db, &filter); err != nil {
+			log.Printf("Failed to resolve search filters: %s", err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to build feed"})
+			return
+		}
+
+		cves, err := s.db.SearchCves(filter)
+		if err != nil {
+			log.Printf("Failed to fetch CVEs for feed: %s", err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to build feed"})
+			return
+		}
+
+		entries := make([]feedEntry, len(cves))
+		for i := range cves {
+			entries[i] = newFeedEntry(&cves[i])
+		}
+
+		// the feed was last updated when its most recently modified CVE was, or never if it's empty
+		var updated time.Time
+		if len(entries) > 0 {
+			updated = entries[0].Updated
+		}
+
+		// the feed is identified by what's in it, so the same filters in any order make the same feed
+		query := c.Request.URL.Query()
+		query.Del("limit")
+		id := "urn:melaka:feed:cves"
+		if encoded := query.Encode(); encoded != "" {
+			id += ":" + encoded
+		}
+
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		self := scheme + "://" + c.Request.Host + c.Request.URL.RequestURI()
+		title := feedTitle(query)
+
+		var feed interface{}
+		contentType := "application/atom+xml; charset=utf-8"
+		if format == "rss" {
+			feed = newRssFeed(title, self, updated, entries)
+			contentType = "application/rss+xml; charset=utf-8"
+		} else {
+			feed = newAtomFeed(id, title, self, updated, entries)
+		}
+
+		body, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			log.Printf("Failed to encode %s feed: %s", format, err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to build feed"})
+			return
+		}
+
+		c.Data(http.StatusOK, contentType, append([]byte(xml.Header), body...))
+
+	}
+}
+
+func newAtomFeed(id, title, self string, updated time.Time, entries []feedEntry) AtomFeed {
+
+	feed := AtomFeed{
+		ID:      id,
+		Title:   title,
+		Updated: updated.Format(time.RFC3339),
+		Author:  AtomPerson{Name: "Melaka"},
+		Links:   []AtomLink{{Rel: "self", Href: self}},
+		Entries: []AtomEntry{},
+	}
+
+	for _, e := range entries {
+		entry := AtomEntry{
+			ID:      e.ID,
+			Title:   e.Title,
+			Updated: e.Updated.Format(time.RFC3339),
+			Link:    AtomLink{Rel: "alternate", Href: e.ID},
+			Summary: e.Summary,
+		}
+		if !e.Published.IsZero() {
+			entry.Published = e.Published.Format(time.RFC3339)
+		}
+		if e.Author != "" {
+			entry.Author = &AtomPerson{Name: e.Author}
+		}
+		for _, term := range e.Categories {
+			entry.Categories = append(entry.Categories, AtomCategory{Term: term})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return feed
+}
+
+func newRssFeed(title, self string, updated time.Time, entries []feedEntry) RssFeed {
+
+	feed := RssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: RssChannel{
+			Title:         title,
+			Link:          self,
+			Description:   "CVEs from Melaka, most recently modified first",
+			LastBuildDate: updated.Format(time.RFC1123Z),
+			SelfLink:      AtomLink{Rel: "self", Href: self},
+			Items:         []RssItem{},
+		},
+	}
+
+	// RSS has nowhere to say an item was updated, so items are dated by their last modification
+	for _, e := range entries {
+		feed.Channel.Items = append(feed.Channel.Items, RssItem{
+			Title:       e.Title,
+			Link:        e.ID,
+			Description: e.Summary,
+			GUID:        RssGUID{IsPermaLink: true, Value: e.ID},
+			PubDate:     e.Updated.Format(time.RFC1123Z),
+			Categories:  e.Categories,
+		})
+	}
+
+	return feed
+}
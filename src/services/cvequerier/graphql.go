@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	defaultGraphqlMaxDepth      = 10
+	defaultGraphqlMaxComplexity = 5000
+
+	// the most CVEs the cves field takes at once
+	maxGraphqlCves = 100
+
+	// what a field that's looked up separately costs, over the one any field does
+	graphqlRelationCost = 5
+
+	// introspection has a fixed budget rather than the configured limits, which the standard introspection query
+	// GraphiQL and code generators send fits in with room for the schema to grow. Its lists are costed as the most
+	// our schema has of each, so anything nesting them much further than that query does is turned away
+	graphqlIntrospectionMaxDepth      = 15
+	graphqlIntrospectionMaxComplexity = 1000000
+)
+
+// GraphqlLimits bound how much work a GraphQL query can ask for. Queries nested deeper than MaxDepth fields, or
+// costing more than MaxComplexity, are turned away before anything is looked up
+type GraphqlLimits struct {
+	MaxDepth      int
+	MaxComplexity int
+}
+
+type GraphqlRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// doc-only shape of what graphql-go responds with
+type GraphqlResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []GraphqlError         `json:"errors,omitempty"`
+}
+
+type GraphqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+type graphqlLoadersKey struct{}
+
+func loaders(p graphql.ResolveParams) *graphqlLoaders {
+	return p.Context.Value(graphqlLoadersKey{}).(*graphqlLoaders)
+}
+
+// the fields that are looked up separately from what they're on, and so cost graphqlRelationCost
+var graphqlRelations = map[string]bool{"epssHistory": true, "changes": true, "cwes": true, "parents": true}
+
+// graphqlTypeBuilder describes Go types as GraphQL object types, going by their json tags, like schemaGenerator
+// does for our OpenAPI document. Fields are resolved straight from the structs, and more can be added to a type
+// until the schema is built
+type graphqlTypeBuilder struct {
+	objects map[string]*graphql.Object
+	fields  map[string]graphql.Fields
+}
+
+func (b *graphqlTypeBuilder) outputType(t reflect.Type, name string) graphql.Output {
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.outputType(t.Elem(), name)
+	case reflect.Bool:
+		return graphql.Boolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return graphql.Int
+	case reflect.Float32, reflect.Float64:
+		return graphql.Float
+	case reflect.String:
+		return graphql.String
+	case reflect.Slice:
+		elem := b.outputType(t.Elem(), name)
+		if elem == nil {
+			return nil
+		}
+		return graphql.NewList(graphql.NewNonNull(elem))
+	case reflect.Struct:
+		return b.object(t, name)
+	}
+
+	// maps and the like have no GraphQL type
+	return nil
+}
+
+// object describes a struct. Anonymous structs are named for the field they're in
+func (b *graphqlTypeBuilder) object(t reflect.Type, name string) *graphql.Object {
+
+	if t.Name() != "" {
+		name = t.Name()
+	}
+	if o, ok := b.objects[name]; ok {
+		return o
+	}
+
+	fields := graphql.Fields{}
+	b.fields[name] = fields
+	b.objects[name] = graphql.NewObject(graphql.ObjectConfig{
+		Name:   name,
+		Fields: graphql.FieldsThunk(func() graphql.Fields { return fields }),
+	})
+	b.addFields(name, t, nil)
+
+	return b.objects[name]
+}
+
+// addFields adds a struct's fields to an object type, resolving them from sources at the index given
+func (b *graphqlTypeBuilder) addFields(name string, t reflect.Type, index []int, skip ...string) {
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName == "-" || !field.IsExported() || contains(skip, jsonName) {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if field.Anonymous && jsonName == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(name, field.Type, fieldIndex, skip...)
+			continue
+		}
+
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		fieldType := b.outputType(field.Type, name+field.Name)
+		if fieldType == nil {
+			continue
+		}
+		b.fields[name][jsonName] = &graphql.Field{Type: fieldType, Resolve: resolveStructField(fieldIndex)}
+	}
+
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveStructField(index []int) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		v := reflect.ValueOf(p.Source)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil, nil
+			}
+			v = v.Elem()
+		}
+		f := v.FieldByIndex(index)
+		if (f.Kind() == reflect.Ptr || f.Kind() == reflect.Slice) && f.IsNil() {
+			return nil, nil
+		}
+		return f.Interface(), nil
+	}
+}
+
+// cveSource is the CVE a Cve field is being resolved on
+func cveSource(p graphql.ResolveParams) *CveMsg {
+	return p.Source.(*CveMsg)
+}
+
+// graphqlArgs reads GraphQL arguments as parseCveFilterFrom expects them, with lists separated by commas
+func graphqlArgs(args map[string]interface{}) func(name string) string {
+	return func(name string) string {
+		switch v := args[name].(type) {
+		case nil:
+			return ""
+		case []interface{}:
+			values := make([]string, len(v))
+			for i, value := range v {
+				values[i] = fmt.Sprint(value)
+			}
+			return strings.Join(values, ",")
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+}
+
+// buildGraphqlSchema describes CVEs as NVD does, along with their CWEs, EPSS and change histories, which are looked
+// up in batches across the whole query
+func buildGraphqlSchema() graphql.Schema {
+
+	b := &graphqlTypeBuilder{objects: map[string]*graphql.Object{}, fields: map[string]graphql.Fields{}}
+
+	cwe := b.object(reflect.TypeOf(Cwe{}), "")
+	b.fields["Cwe"]["parents"] = &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(cwe))),
+		Description: "The CWEs this one belongs to in the CWE hierarchy",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return loaders(p).cwes.loadAll(p.Source.(*Cwe).Parents), nil
+		},
+	}
+
+	b.object(reflect.TypeOf(Weakness{}), "")
+	b.fields["Weakness"]["cwes"] = &graphql.Field{
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(cwe))),
+		Description: "The CWEs the weakness names, as described in the CWE catalog",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			ids := []string{}
+			for _, description := range p.Source.(Weakness).Description {
+				ids = append(ids, description.Value)
+			}
+			return loaders(p).cwes.loadAll(ids), nil
+		},
+	}
+
+	// a CVE has NVD's fields, along with those we add to it
+	cveFields := graphql.Fields{}
+	b.fields["Cve"] = cveFields
+	cve := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "Cve",
+		Fields: graphql.FieldsThunk(func() graphql.Fields { return cveFields }),
+	})
+	b.objects["Cve"] = cve
+	cveDataField, _ := reflect.TypeOf(CveMsg{}).FieldByName("CveData")
+	b.addFields("Cve", cveDataField.Type, cveDataField.Index)
+	b.addFields("Cve", reflect.TypeOf(CveMsg{}), nil, "cvedata", "weaknessNames", "environmentalScores", "distroStatus", "advisories")
+
+	cveFields["description"] = &graphql.Field{
+		Type:        graphql.String,
+		Description: "The CVE's description in a language, English unless another is asked for",
+		Args:        graphql.FieldConfigArgument{"lang": {Type: graphql.String, DefaultValue: "en"}},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			for _, description := range cveSource(p).CveData.Descriptions {
+				if description.Lang == p.Args["lang"] {
+					return description.Value, nil
+				}
+			}
+			return nil, nil
+		},
+	}
+	cveFields["epssHistory"] = &graphql.Field{
+		Type:        graphql.NewNonNull(b.outputType(reflect.TypeOf([]EpssData{}), "")),
+		Description: "The CVE's EPSS scores over time, oldest first",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return loaders(p).epssHistory.load(cveSource(p).CveData.ID), nil
+		},
+	}
+	cveFields["changes"] = &graphql.Field{
+		Type:        graphql.NewNonNull(b.outputType(reflect.TypeOf([]CveChange{}), "")),
+		Description: "NVD's history of changes to the CVE, oldest first",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return loaders(p).changes.load(cveSource(p).CveData.ID), nil
+		},
+	}
+
+	searchArgs := graphql.FieldConfigArgument{
+		"kev":           {Type: graphql.Boolean, Description: "Only CVEs in, or with false not in, CISA's KEV catalog"},
+		"cwe":           {Type: graphql.NewList(graphql.NewNonNull(graphql.String)), Description: "CWE IDs, with or without their CWE- prefix"},
+		"epssMin":       {Type: graphql.Float, Description: "The lowest EPSS score"},
+		"percentileMin": {Type: graphql.Float, Description: "The lowest EPSS percentile"},
+		"cpe":           {Type: graphql.String, Description: "A CPE 2.3 name the CVEs affect"},
+		"vendor":        {Type: graphql.String, Description: "A vendor as named in CPEs"},
+		"keyword":       {Type: graphql.String, Description: "Text the CVE's description contains"},
+		"distro":        {Type: graphql.NewList(graphql.NewNonNull(graphql.String)), Description: "Distros that have assessed the CVEs"},
+		"distroStatus":  {Type: graphql.NewList(graphql.NewNonNull(graphql.String)), Description: "What a distro assessed one of its packages as"},
+		"scoreMin":      {Type: graphql.Float, Description: "The lowest preferred CVSS base score"},
+		"severity":      {Type: graphql.NewList(graphql.NewNonNull(graphql.String)), Description: "Severities of the preferred score"},
+		"sort":          {Type: graphql.String, Description: "What to sort by, descending with a - prefix. Defaults to -published"},
+		"limit":         {Type: graphql.Int, Description: fmt.Sprintf("How many CVEs to return, defaults to %d", defaultSearchLimit)},
+		"offset":        {Type: graphql.Int, Description: "How many CVEs to skip"},
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"cve": &graphql.Field{
+				Type:        cve,
+				Description: "A CVE by its ID, e.g. CVE-2021-44228",
+				Args:        graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loaders(p).cves.load(p.Args["id"].(string)), nil
+				},
+			},
+			"cves": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(cve)),
+				Description: fmt.Sprintf("Up to %d CVEs by their IDs, in the same order, with null for any we don't have", maxGraphqlCves),
+				Args:        graphql.FieldConfigArgument{"ids": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					ids := p.Args["ids"].([]interface{})
+					if len(ids) > maxGraphqlCves {
+						return nil, fmt.Errorf("ids must have no more than %d CVEs", maxGraphqlCves)
+					}
+					thunks := make([]func() (interface{}, error), len(ids))
+					for i, id := range ids {
+						thunks[i] = loaders(p).cves.load(id.(string))
+					}
+					return func() (interface{}, error) {
+						cves := make([]interface{}, len(thunks))
+						for i, thunk := range thunks {
+							var err error
+							if cves[i], err = thunk(); err != nil {
+								return nil, err
+							}
+						}
+						return cves, nil
+					}, nil
+				},
+			},
+			"search": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(cve))),
+				Description: "CVEs matching filters, as /cves finds them",
+				Args:        searchArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					filter, err := parseCveFilterFrom(graphqlArgs(p.Args))
+					if err != nil {
+						return nil, err
+					}
+					if err := resolveFilter(loaders(p).db, &filter); err != nil {
+						log.Printf("Failed to resolve search filters: %s", err)
+						return nil, errGraphqlFetch
+					}
+					cves, err := loaders(p).db.SearchCves(filter)
+					if err != nil {
+						log.Printf("Failed to search CVEs: %s", err)
+						return nil, errGraphqlFetch
+					}
+					results := make([]*CveMsg, len(cves))
+					for i := range cves {
+						results[i] = &cves[i]
+					}
+					return results, nil
+				},
+			},
+			"cwe": &graphql.Field{
+				Type:        cwe,
+				Description: "A CWE by its ID, with or without its CWE- prefix",
+				Args:        graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loaders(p).cwes.load(normalizeCweID(p.Args["id"].(string))), nil
+				},
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(err)
+	}
+
+	return schema
+}
+
+var graphqlSchema = buildGraphqlSchema()
+
+// graphqlIntrospectionLists is the most of each list introspection could give back from our schema, which is what
+// its fields are costed as holding
+var graphqlIntrospectionLists = func() map[string]int {
+
+	lists := map[string]int{"types": len(graphqlSchema.TypeMap()), "directives": len(graphqlSchema.Directives())}
+	most := func(name string, n int) {
+		if n > lists[name] {
+			lists[name] = n
+		}
+	}
+
+	for _, directive := range graphqlSchema.Directives() {
+		most("args", len(directive.Args))
+	}
+	for _, t := range graphqlSchema.TypeMap() {
+		switch t := t.(type) {
+		case *graphql.Object:
+			most("fields", len(t.Fields()))
+			most("interfaces", len(t.Interfaces()))
+			for _, field := range t.Fields() {
+				most("args", len(field.Args))
+			}
+		case *graphql.Interface:
+			most("fields", len(t.Fields()))
+			most("possibleTypes", len(graphqlSchema.PossibleTypes(t)))
+		case *graphql.Union:
+			most("possibleTypes", len(t.Types()))
+		case *graphql.InputObject:
+			most("inputFields", len(t.Fields()))
+		case *graphql.Enum:
+			most("enumValues", len(t.Values()))
+		}
+	}
+
+	return lists
+}()
+
+// graphqlCost works out how deeply nested a query's fields are, and its complexity: a point for each field it
+// selects and graphqlRelationCost more for each it looks up separately, times how many of them a list could hold.
+// Introspection is costed the same way, but separately, as it has a budget of its own
+type graphqlCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+
+	// whether we're within an introspection field, and the cost and depth of those seen so far
+	introspecting           bool
+	introspectionComplexity int
+	introspectionDepth      int
+}
+
+func (g *graphqlCost) selections(set *ast.SelectionSet, depth int) (complexity int, maxDepth int) {
+
+	if set == nil {
+		return 0, depth - 1
+	}
+	maxDepth = depth - 1
+
+	for _, selection := range set.Selections {
+		var cost, nested int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if !g.introspecting && strings.HasPrefix(selection.Name.Value, "__") {
+				g.introspecting = true
+				cost, nested = g.selections(selection.SelectionSet, depth+1)
+				g.introspecting = false
+				g.introspectionComplexity += 1 + cost
+				if nested < depth {
+					nested = depth
+				}
+				if nested > g.introspectionDepth {
+					g.introspectionDepth = nested
+				}
+				continue
+			}
+			cost, nested = g.selections(selection.SelectionSet, depth+1)
+			cost = 1 + cost*g.multiplier(selection)
+			if !g.introspecting && graphqlRelations[selection.Name.Value] {
+				cost += graphqlRelationCost
+			}
+			if nested < depth {
+				nested = depth
+			}
+		case *ast.InlineFragment:
+			cost, nested = g.selections(selection.SelectionSet, depth)
+		case *ast.FragmentSpread:
+			if fragment, ok := g.fragments[selection.Name.Value]; ok {
+				cost, nested = g.selections(fragment.SelectionSet, depth)
+			}
+		}
+		complexity += cost
+		if nested > maxDepth {
+			maxDepth = nested
+		}
+	}
+
+	return complexity, maxDepth
+}
+
+// multiplier is how many of what's selected on a field there could be, for the fields that list CVEs
+func (g *graphqlCost) multiplier(field *ast.Field) int {
+
+	if g.introspecting {
+		if n, ok := graphqlIntrospectionLists[field.Name.Value]; ok {
+			return n
+		}
+		return 1
+	}
+
+	switch field.Name.Value {
+	case "cves":
+		if ids, ok := g.argument(field, "ids").([]interface{}); ok {
+			return len(ids)
+		}
+		return maxGraphqlCves
+	case "search":
+		// a limit search would reject still has to be costed as the most it could be, as a negative one would
+		// otherwise take off the cost of everything else asked for
+		limit := g.argument(field, "limit")
+		switch n := limit.(type) {
+		case nil:
+			return defaultSearchLimit
+		case int:
+			if n >= 1 && n <= maxSearchLimit {
+				return n
+			}
+		case float64:
+			if n >= 1 && n <= maxSearchLimit {
+				return int(n)
+			}
+		}
+		return maxSearchLimit
+	}
+
+	return 1
+}
+
+// argument reads the value given for an argument, as far as multiplier needs it
+func (g *graphqlCost) argument(field *ast.Field, name string) interface{} {
+
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != name {
+			continue
+		}
+		switch value := arg.Value.(type) {
+		case *ast.Variable:
+			return g.variables[value.Name.Value]
+		case *ast.IntValue:
+			if i, err := strconv.Atoi(value.Value); err == nil {
+				return i
+			}
+			return value.Value
+		case *ast.ListValue:
+			return make([]interface{}, len(value.Values))
+		}
+	}
+
+	return nil
+}
+
+// executeGraphql runs a query. Queries that can't be run at all, because they're invalid or would be too much work,
+// are bad requests, whereas errors resolving fields are reported alongside whatever else could be
+func (s *Server) executeGraphql(ctx context.Context, req GraphqlRequest) (*graphql.Result, bool) {
+
+	fail := func(err error) (*graphql.Result, bool) {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}, false
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})})
+	if err != nil {
+		return fail(err)
+	}
+	if validation := graphql.ValidateDocument(&graphqlSchema, doc, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}, false
+	}
+
+	cost := &graphqlCost{fragments: map[string]*ast.FragmentDefinition{}, variables: req.Variables}
+	var operation *ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			cost.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if req.OperationName == "" || (definition.Name != nil && definition.Name.Value == req.OperationName) {
+				operation = definition
+			}
+		}
+	}
+	if operation == nil {
+		return fail(fmt.Errorf("unknown operation %s", req.OperationName))
+	}
+
+	complexity, depth := cost.selections(operation.SelectionSet, 1)
+	if depth > s.graphqlLimits.MaxDepth {
+		return fail(fmt.Errorf("query is nested %d fields deep, more than the %d allowed", depth, s.graphqlLimits.MaxDepth))
+	}
+	if complexity > s.graphqlLimits.MaxComplexity {
+		return fail(fmt.Errorf("query has a complexity of %d, more than the %d allowed", complexity, s.graphqlLimits.MaxComplexity))
+	}
+	if cost.introspectionDepth > graphqlIntrospectionMaxDepth {
+		return fail(fmt.Errorf("introspection is nested %d fields deep, more than the %d allowed", cost.introspectionDepth, graphqlIntrospectionMaxDepth))
+	}
+	if cost.introspectionComplexity > graphqlIntrospectionMaxComplexity {
+		return fail(fmt.Errorf("introspection has a complexity of %d, more than the %d allowed", cost.introspectionComplexity, graphqlIntrospectionMaxComplexity))
+	}
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphqlSchema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       context.WithValue(ctx, graphqlLoadersKey{}, newGraphqlLoaders(s.db)),
+	})
+	result.Extensions = map[string]interface{}{"complexity": complexity}
+
+	return result, true
+}
+
+func (s *Server) graphql(c *gin.Context) {
+
+	var req GraphqlRequest
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if v := c.Query("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "variables must be a JSON object"})
+				return
+			}
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	result, ok := s.executeGraphql(c.Request.Context(), req)
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, result)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, result)
+
+}
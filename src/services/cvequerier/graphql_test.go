@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/graphql-go/graphql/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchCountingDatabase counts the lookups GraphQL queries make
+type batchCountingDatabase struct {
+	MockDatabase
+	calls map[string]int
+}
+
+func (m *batchCountingDatabase) GetCveFromID(id string) (*CveMsg, error) {
+	m.calls["GetCveFromID"]++
+	return m.MockDatabase.GetCveFromID(id)
+}
+
+func (m *batchCountingDatabase) GetCvesFromIDs(ids []string) ([]CveMsg, error) {
+	m.calls["GetCvesFromIDs"]++
+	return m.MockDatabase.GetCvesFromIDs(ids)
+}
+
+func (m *batchCountingDatabase) GetCwes(ids []string) ([]Cwe, error) {
+	m.calls["GetCwes"]++
+	return m.MockDatabase.GetCwes(ids)
+}
+
+func (m *batchCountingDatabase) GetCveChangesForIDs(ids []string) ([]CveChange, error) {
+	m.calls["GetCveChangesForIDs"]++
+	return m.MockDatabase.GetCveChangesForIDs(ids)
+}
+
+func (m *batchCountingDatabase) GetEpssHistoryForIDs(ids []string) ([]EpssData, error) {
+	m.calls["GetEpssHistoryForIDs"]++
+	return m.MockDatabase.GetEpssHistoryForIDs(ids)
+}
+
+func postGraphql(server *Server, query string, variables map[string]interface{}) (int, map[string]interface{}) {
+	body, _ := json.Marshal(GraphqlRequest{Query: query, Variables: variables})
+	req, _ := http.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	var result map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &result)
+	return resp.Code, result
+}
+
+func TestGraphql(t *testing.T) {
+
+	db := &batchCountingDatabase{calls: map[string]int{}}
+	server := buildServer(db)
+
+	status, result := postGraphql(server, `query ($ids: [String!]!) {
+		cves(ids: $ids) {
+			id
+			published
+			description
+			weaknesses { cwes { name } }
+			configurations { nodes { cpeMatch { criteria versionEndExcluding } } }
+			changes { eventName details { action } }
+			epssHistory { score }
+		}
+	}`, map[string]interface{}{"ids": []string{"CVE-2021-44228", missingCveID, "CVE-2023-0001"}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, result["errors"])
+
+	cves := result["data"].(map[string]interface{})["cves"].([]interface{})
+	assert.Len(t, cves, 3)
+	log4shell := cves[0].(map[string]interface{})
+	assert.Equal(t, "CVE-2021-44228", log4shell["id"])
+	assert.Equal(t, `Apache Log4j2 <=2.14.1 JNDI features, "lookups"`, log4shell["description"])
+	assert.Equal(t, "2.15.0", log4shell["configurations"].([]interface{})[0].(map[string]interface{})["nodes"].([]interface{})[0].(map[string]interface{})["cpeMatch"].([]interface{})[0].(map[string]interface{})["versionEndExcluding"])
+	assert.Len(t, log4shell["changes"], 2)
+	assert.Len(t, log4shell["epssHistory"], 1)
+	assert.Nil(t, cves[1])
+	assert.Equal(t, "CVE-2023-0001", cves[2].(map[string]interface{})["id"])
+
+	// each relation is looked up once for all the CVEs
+	assert.Equal(t, map[string]int{"GetCvesFromIDs": 1, "GetCwes": 1, "GetCveChangesForIDs": 1, "GetEpssHistoryForIDs": 1}, db.calls)
+
+	// a lone CVE comes the same way as it would through /cve, and CWEs resolve up the hierarchy
+	db.calls = map[string]int{}
+	status, result = postGraphql(server, `{ cve(id: "CVE-2020-0001") { ...names } }
+	fragment names on Cve { weaknesses { cwes { id name parents { name } } } }`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, result["errors"])
+	cwe := result["data"].(map[string]interface{})["cve"].(map[string]interface{})["weaknesses"].([]interface{})[0].(map[string]interface{})["cwes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "Cross-site Scripting", cwe["name"])
+	assert.Equal(t, "Injection", cwe["parents"].([]interface{})[0].(map[string]interface{})["name"])
+	assert.Equal(t, map[string]int{"GetCveFromID": 1, "GetCwes": 2}, db.calls)
+
+	// what's been looked up once isn't looked up again for the same request
+	db.calls = map[string]int{}
+	status, result = postGraphql(server, `{ a: cwe(id: "80") { parents { name } } b: cwe(id: "CWE-79") { name } }`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	data := result["data"].(map[string]interface{})
+	assert.Equal(t, "Cross-site Scripting", data["a"].(map[string]interface{})["parents"].([]interface{})[0].(map[string]interface{})["name"])
+	assert.Equal(t, "Cross-site Scripting", data["b"].(map[string]interface{})["name"])
+	assert.Equal(t, map[string]int{"GetCwes": 1}, db.calls)
+
+	// searches filter as /cves does
+	status, result = postGraphql(server, `{ search(kev: true, severity: ["high", "critical"], limit: 5) { id } }`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, result["errors"])
+	assert.True(t, *db.lastFilter.Kev)
+	assert.Equal(t, []string{"HIGH", "CRITICAL"}, db.lastFilter.Severities)
+	assert.Equal(t, 5, db.lastFilter.Limit)
+
+	status, result = postGraphql(server, `{ search(limit: 1000) { id } }`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "limit must be a number between 1 and 500", result["errors"].([]interface{})[0].(map[string]interface{})["message"])
+
+	// queries can be sent with GET too
+	req, _ := http.NewRequest("GET", "/graphql?"+url.Values{"query": {`query ($id: String!) { cve(id: $id) { id } }`}, "variables": {`{"id": "CVE-2021-44228"}`}}.Encode(), nil)
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"id": "CVE-2021-44228"`)
+
+}
+
+func TestGraphql_Reject_Invalid_Queries(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+	server.graphqlLimits = GraphqlLimits{MaxDepth: 4, MaxComplexity: 100}
+
+	message := func(query string, variables map[string]interface{}) string {
+		status, result := postGraphql(server, query, variables)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+		assert.Nil(t, result["data"], query)
+		return result["errors"].([]interface{})[0].(map[string]interface{})["message"].(string)
+	}
+
+	assert.Contains(t, message(`{ cve(id: "CVE-2021-44228") { id `, nil), "Syntax Error")
+	assert.Contains(t, message(`{ cve(id: "CVE-2021-44228") { secret } }`, nil), `Cannot query field "secret" on type "Cve"`)
+
+	// deeply nested, even through fragments
+	assert.Equal(t, "query is nested 5 fields deep, more than the 4 allowed",
+		message(`{ cwe(id: "79") { ...up } } fragment up on Cwe { parents { parents { parents { name } } } }`, nil))
+
+	// lists multiply what's asked for on each of what they hold
+	assert.Equal(t, "query has a complexity of 301, more than the 100 allowed",
+		message(`{ search(limit: 100) { id published lastModified } }`, nil))
+	assert.Equal(t, "query has a complexity of 201, more than the 100 allowed",
+		message(`query ($ids: [String!]!) { cves(ids: $ids) { id published } }`, map[string]interface{}{"ids": make([]string, 100)}))
+
+	// limits search would reject are costed as the most it allows, so can't offset the rest of the query
+	assert.Equal(t, "query has a complexity of 1002, more than the 100 allowed",
+		message(`{ a: search(limit: -100000) { id } b: search(limit: 500) { id } }`, nil))
+	assert.Equal(t, "query has a complexity of 501, more than the 100 allowed",
+		message(`query ($limit: Int) { search(limit: $limit) { id } }`, map[string]interface{}{"limit": -5}))
+
+	// introspection has a budget of its own, which the standard introspection query fits in whatever the limits
+	status, result := postGraphql(server, testutil.IntrospectionQuery, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, result["errors"])
+
+	// but not queries nesting its lists over and over
+	assert.Equal(t, "introspection is nested 16 fields deep, more than the 15 allowed",
+		message(`{ __type(name: "Cve") { fields { type { fields { type { fields { type { fields { type { fields { type { fields { type { fields { type { name } } } } } } } } } } } } } } } }`, nil))
+	assert.Regexp(t, `^introspection has a complexity of \d+, more than the 1000000 allowed$`,
+		message(`{ __schema { types { fields { type { fields { type { fields { type { name } } } } } } } } }`, nil))
+
+}
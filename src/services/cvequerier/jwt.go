@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jwtLeeway         = time.Minute     // how far out our clock and the issuer's can be
+	jwksRefreshPeriod = 5 * time.Minute // how often we'll refetch a JWKS URL to find a key we don't know
+	jwksFetchTimeout  = 10 * time.Second
+)
+
+// the signing algorithms we accept, and the hash each signs
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+}
+
+// JWTVerifier validates OIDC bearer tokens against the keys in a JWKS, which can be a file or a URL. Keys from a URL
+// are refetched when a token is signed with one we don't know, so the issuer can rotate them
+type JWTVerifier struct {
+	source     string
+	issuer     string // if set, tokens must have been issued by it
+	audience   string // if set, tokens must be meant for it
+	scopeClaim string // the claim listing the token's scopes, e.g. scope
+
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func NewJWTVerifier(source, issuer, audience, scopeClaim string) (*JWTVerifier, error) {
+
+	v := &JWTVerifier{
+		source:     source,
+		issuer:     issuer,
+		audience:   audience,
+		scopeClaim: scopeClaim,
+		client:     &http.Client{Timeout: jwksFetchTimeout},
+		now:        time.Now,
+	}
+
+	if err := v.loadKeys(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// a JSON Web Key. We only use the members describing RSA and EC public keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadKeys (re)reads the JWKS from its file or URL
+func (v *JWTVerifier) loadKeys() error {
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(v.source, "https://") || strings.HasPrefix(v.source, "http://") {
+		data, err = v.fetchKeys()
+	} else {
+		data, err = os.ReadFile(v.source)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS from %s: %w", v.source, err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("invalid JWKS at %s: %w", v.source, err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return fmt.Errorf("invalid key %q in JWKS at %s: %w", k.Kid, v.source, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("JWKS at %s has no RSA or EC signing keys", v.source)
+	}
+
+	v.keys, v.fetched = keys, v.now()
+	return nil
+}
+
+func (v *JWTVerifier) fetchKeys() ([]byte, error) {
+
+	resp, err := v.client.Get(v.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// publicKey decodes an RSA or EC key. Keys of any other type are skipped, so come back nil
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+	}
+
+	return nil, nil
+}
+
+// key finds the key a token was signed with. A token not naming one is taken to be signed with the only key there
+// is, if there's only one
+func (v *JWTVerifier) key(kid string) (crypto.PublicKey, error) {
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	find := func() crypto.PublicKey {
+		if kid == "" && len(v.keys) == 1 {
+			for _, key := range v.keys {
+				return key
+			}
+		}
+		return v.keys[kid]
+	}
+
+	if key := find(); key != nil {
+		return key, nil
+	}
+
+	// the issuer may have rotated its keys since we last looked, but we don't refetch for every bad token
+	if v.now().Sub(v.fetched) > jwksRefreshPeriod {
+		if err := v.loadKeys(); err != nil {
+			return nil, err
+		}
+		if key := find(); key != nil {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Verify checks a token's signature and claims, returning who it was issued to and with what scopes
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	if err := verifySignature(key, header.Alg, hash, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+
+	return v.checkClaims(claims)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, signed, signature []byte) error {
+
+	var digest []byte
+	switch hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signed)
+		digest = sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(signed)
+		digest = sum[:]
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("key can't verify %s signatures", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+			return errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("key can't verify %s signatures", alg)
+		}
+		// JWS signatures are r and s side by side, each padded out to the size of the curve
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	default:
+		return errors.New("unsupported signing key")
+	}
+
+	return nil
+}
+
+// checkClaims checks a token is current, and from and for who we expect
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}) (*Principal, error) {
+
+	now := v.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return nil, errors.New("token is from an unexpected issuer")
+	}
+
+	if v.audience != "" {
+		found := false
+		for _, aud := range claimStrings(claims["aud"]) {
+			found = found || aud == v.audience
+		}
+		if !found {
+			return nil, errors.New("token is not meant for us")
+		}
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	// scopes are usually a space separated string, but some issuers list them
+	scopes := []string{}
+	for _, value := range claimStrings(claims[v.scopeClaim]) {
+		for _, scope := range strings.Fields(value) {
+			if scopeGrants[scope] != nil {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return &Principal{Subject: subject, Method: "jwt", Scopes: scopes, key: "jwt:" + subject}, nil
+}
+
+// claimStrings reads a claim that can be either a string or a list of them
+func claimStrings(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return []string{claim}
+	case []interface{}:
+		values := []string{}
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
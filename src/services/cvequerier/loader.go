@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// batchLoader gathers up the keys a GraphQL query asks for, and looks them all up at once when the first of them is
+// needed. graphql-go resolves a query breadth first, only calling the thunks a level of the query returned once it's
+// resolved the whole level, so each level costs one lookup per loader however many CVEs it covers. What's looked up
+// is kept for the rest of the request
+type batchLoader struct {
+	fetch func(keys []string) (map[string]interface{}, error)
+
+	mu      sync.Mutex
+	seen    map[string]bool
+	pending []string
+	results map[string]interface{}
+	errs    map[string]error
+}
+
+func newBatchLoader(fetch func(keys []string) (map[string]interface{}, error)) *batchLoader {
+	return &batchLoader{
+		fetch:   fetch,
+		seen:    map[string]bool{},
+		results: map[string]interface{}{},
+		errs:    map[string]error{},
+	}
+}
+
+// load asks for a key, returning a thunk that gives what it's for, or nil if there's nothing
+func (l *batchLoader) load(key string) func() (interface{}, error) {
+
+	l.mu.Lock()
+	if !l.seen[key] {
+		l.seen[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if len(l.pending) > 0 {
+			l.dispatch()
+		}
+		return l.results[key], l.errs[key]
+	}
+}
+
+// loadAll asks for several keys, returning a thunk that gives what's found for them in order, leaving out any
+// there's nothing for
+func (l *batchLoader) loadAll(keys []string) func() (interface{}, error) {
+
+	thunks := make([]func() (interface{}, error), len(keys))
+	for i, key := range keys {
+		thunks[i] = l.load(key)
+	}
+
+	return func() (interface{}, error) {
+		results := []interface{}{}
+		for _, thunk := range thunks {
+			result, err := thunk()
+			if err != nil {
+				return nil, err
+			}
+			if result != nil {
+				results = append(results, result)
+			}
+		}
+		return results, nil
+	}
+}
+
+func (l *batchLoader) dispatch() {
+
+	keys := l.pending
+	l.pending = nil
+
+	results, err := l.fetch(keys)
+	for _, key := range keys {
+		if err != nil {
+			l.errs[key] = err
+		} else if result, ok := results[key]; ok {
+			l.results[key] = result
+		}
+	}
+
+}
+
+// graphqlLoaders are the loaders for everything a GraphQL query can ask for, made afresh for each request
+type graphqlLoaders struct {
+	db          DBConnector
+	cves        *batchLoader
+	cwes        *batchLoader
+	epssHistory *batchLoader
+	changes     *batchLoader
+}
+
+var errGraphqlFetch = errors.New("failed to fetch data")
+
+func newGraphqlLoaders(db DBConnector) *graphqlLoaders {
+
+	return &graphqlLoaders{
+		db: db,
+
+		cves: newBatchLoader(func(ids []string) (map[string]interface{}, error) {
+			results := map[string]interface{}{}
+
+			// a single CVE can come from the CVE cache, if we have one
+			if len(ids) == 1 {
+				cve, err := db.GetCveFromID(ids[0])
+				if errors.Is(err, ErrNotFound) {
+					return results, nil
+				}
+				if err != nil {
+					log.Printf("Failed to fetch CVE %s: %s", ids[0], err)
+					return nil, errGraphqlFetch
+				}
+				results[ids[0]] = cve
+				return results, nil
+			}
+
+			cves, err := db.GetCvesFromIDs(ids)
+			if err != nil {
+				log.Printf("Failed to fetch %d CVEs: %s", len(ids), err)
+				return nil, errGraphqlFetch
+			}
+			for i := range cves {
+				results[cves[i].CveData.ID] = &cves[i]
+			}
+			return results, nil
+		}),
+
+		cwes: newBatchLoader(func(ids []string) (map[string]interface{}, error) {
+			cwes, err := db.GetCwes(ids)
+			if err != nil {
+				log.Printf("Failed to fetch %d CWEs: %s", len(ids), err)
+				return nil, errGraphqlFetch
+			}
+			results := map[string]interface{}{}
+			for i := range cwes {
+				results[cwes[i].ID] = &cwes[i]
+			}
+			return results, nil
+		}),
+
+		epssHistory: newBatchLoader(func(ids []string) (map[string]interface{}, error) {
+			history, err := db.GetEpssHistoryForIDs(ids)
+			if err != nil {
+				log.Printf("Failed to fetch EPSS history of %d CVEs: %s", len(ids), err)
+				return nil, errGraphqlFetch
+			}
+			byCve := map[string][]EpssData{}
+			for _, id := range ids {
+				byCve[id] = []EpssData{}
+			}
+			for _, score := range history {
+				byCve[score.Cve] = append(byCve[score.Cve], score)
+			}
+			results := map[string]interface{}{}
+			for id, scores := range byCve {
+				results[id] = scores
+			}
+			return results, nil
+		}),
+
+		changes: newBatchLoader(func(ids []string) (map[string]interface{}, error) {
+			changes, err := db.GetCveChangesForIDs(ids)
+			if err != nil {
+				log.Printf("Failed to fetch changes to %d CVEs: %s", len(ids), err)
+				return nil, errGraphqlFetch
+			}
+			byCve := map[string][]CveChange{}
+			for _, id := range ids {
+				byCve[id] = []CveChange{}
+			}
+			for _, change := range changes {
+				byCve[change.CveID] = append(byCve[change.CveID], change)
+			}
+			results := map[string]interface{}{}
+			for id, history := range byCve {
+				results[id] = history
+			}
+			return results, nil
+		}),
+	}
+
+}
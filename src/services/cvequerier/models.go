@@ -1,86 +1,209 @@
 package main
 
-// structure of the 'Cve' object used by the NVD API to describe individual CVEs
+import "melaka/cvequerier/cvss"
+
+// structure of the 'Cve' object used by the NVD API to describe individual CVEs, per the NVD 2.0 CVE API schema
 type NvdCveData struct {
-	ID               string `json:"id"`
-	SourceIdentifier string `json:"sourceIdentifier"`
-	Published        string `json:"published"`
-	LastModified     string `json:"lastModified"`
-	VulnStatus       string `json:"vulnStatus"`
-	Descriptions     []struct {
-		Lang  string `json:"lang"`
-		Value string `json:"value"`
-	} `json:"descriptions"`
-	Metrics struct {
-		CvssMetricV31 []struct {
-			Source   string `json:"source"`
-			Type     string `json:"type"`
-			CvssData struct {
-				Version               string  `json:"version"`
-				VectorString          string  `json:"vectorString"`
-				AttackVector          string  `json:"attackVector"`
-				AttackComplexity      string  `json:"attackComplexity"`
-				PrivilegesRequired    string  `json:"privilegesRequired"`
-				UserInteraction       string  `json:"userInteraction"`
-				Scope                 string  `json:"scope"`
-				ConfidentialityImpact string  `json:"confidentialityImpact"`
-				IntegrityImpact       string  `json:"integrityImpact"`
-				AvailabilityImpact    string  `json:"availabilityImpact"`
-				BaseScore             float64 `json:"baseScore"`
-				BaseSeverity          string  `json:"baseSeverity"`
-			} `json:"cvssData"`
-			ExploitabilityScore float64 `json:"exploitabilityScore"`
-			ImpactScore         float64 `json:"impactScore"`
-		} `json:"cvssMetricV31"`
-		CvssMetricV2 []struct {
-			Source   string `json:"source"`
-			Type     string `json:"type"`
-			CvssData struct {
-				Version               string  `json:"version"`
-				VectorString          string  `json:"vectorString"`
-				AccessVector          string  `json:"accessVector"`
-				AccessComplexity      string  `json:"accessComplexity"`
-				Authentication        string  `json:"authentication"`
-				ConfidentialityImpact string  `json:"confidentialityImpact"`
-				IntegrityImpact       string  `json:"integrityImpact"`
-				AvailabilityImpact    string  `json:"availabilityImpact"`
-				BaseScore             float64 `json:"baseScore"`
-			} `json:"cvssData"`
-			BaseSeverity            string  `json:"baseSeverity"`
-			ExploitabilityScore     float64 `json:"exploitabilityScore"`
-			ImpactScore             float64 `json:"impactScore"`
-			AcInsufInfo             bool    `json:"acInsufInfo"`
-			ObtainAllPrivilege      bool    `json:"obtainAllPrivilege"`
-			ObtainUserPrivilege     bool    `json:"obtainUserPrivilege"`
-			ObtainOtherPrivilege    bool    `json:"obtainOtherPrivilege"`
-			UserInteractionRequired bool    `json:"userInteractionRequired"`
-		} `json:"cvssMetricV2"`
-	} `json:"metrics"`
-	Weaknesses []struct {
-		Source      string `json:"source"`
-		Type        string `json:"type"`
-		Description []struct {
-			Lang  string `json:"lang"`
-			Value string `json:"value"`
-		} `json:"description"`
-	} `json:"weaknesses"`
-	Configurations []struct {
-		Nodes []struct {
-			Operator string `json:"operator"`
-			Negate   bool   `json:"negate"`
-			CpeMatch []struct {
-				Vulnerable          bool   `json:"vulnerable"`
-				Criteria            string `json:"criteria"`
-				VersionEndIncluding string `json:"versionEndIncluding"`
-				MatchCriteriaID     string `json:"matchCriteriaId"`
-			} `json:"cpeMatch"`
-		} `json:"nodes"`
-	} `json:"configurations"`
-	References []struct {
-		URL    string   `json:"url"`
-		Source string   `json:"source"`
-		Tags   []string `json:"tags"`
-	} `json:"references"`
+	ID                    string          `json:"id"`
+	SourceIdentifier      string          `json:"sourceIdentifier"`
+	VulnStatus            string          `json:"vulnStatus"`
+	Published             string          `json:"published"`
+	LastModified          string          `json:"lastModified"`
+	EvaluatorComment      string          `json:"evaluatorComment"`
+	EvaluatorSolution     string          `json:"evaluatorSolution"`
+	EvaluatorImpact       string          `json:"evaluatorImpact"`
+	CisaExploitAdd        string          `json:"cisaExploitAdd"`
+	CisaActionDue         string          `json:"cisaActionDue"`
+	CisaRequiredAction    string          `json:"cisaRequiredAction"`
+	CisaVulnerabilityName string          `json:"cisaVulnerabilityName"`
+	CveTags               []CveTag        `json:"cveTags"`
+	Descriptions          []LangString    `json:"descriptions"`
+	References            []Reference     `json:"references"`
+	Metrics               Metrics         `json:"metrics"`
+	Weaknesses            []Weakness      `json:"weaknesses"`
+	Configurations        []Configuration `json:"configurations"`
+	VendorComments        []VendorComment `json:"vendorComments"`
+}
+
+// a piece of text in a given language
+type LangString struct {
+	Lang  string `json:"lang"`
+	Value string `json:"value"`
+}
+
+// tags a source has put on the CVE, e.g. disputed or unsupported-when-assigned
+type CveTag struct {
+	SourceIdentifier string   `json:"sourceIdentifier"`
+	Tags             []string `json:"tags"`
+}
+
+type Reference struct {
+	URL    string   `json:"url"`
+	Source string   `json:"source"`
+	Tags   []string `json:"tags"`
+}
+
+type Metrics struct {
+	CvssMetricV40 []CvssMetricV40 `json:"cvssMetricV40"`
+	CvssMetricV31 []CvssMetricV3  `json:"cvssMetricV31"`
+	CvssMetricV30 []CvssMetricV3  `json:"cvssMetricV30"`
+	CvssMetricV2  []CvssMetricV2  `json:"cvssMetricV2"`
+}
+
+type Weakness struct {
+	Source      string       `json:"source"`
+	Type        string       `json:"type"`
+	Description []LangString `json:"description"`
+}
+
+// a configuration describes the platforms that are affected, as a tree of nodes combining CPE matches
+type Configuration struct {
+	Operator string `json:"operator"`
+	Negate   bool   `json:"negate"`
+	Nodes    []Node `json:"nodes"`
+}
+
+type Node struct {
+	Operator string     `json:"operator"`
+	Negate   bool       `json:"negate"`
+	CpeMatch []CpeMatch `json:"cpeMatch"`
+}
+
+// a CPE match criteria, along with the range of versions it's affected in. Any of the bounds may be empty
+type CpeMatch struct {
+	Vulnerable            bool   `json:"vulnerable"`
+	Criteria              string `json:"criteria"`
+	MatchCriteriaID       string `json:"matchCriteriaId"`
+	VersionStartExcluding string `json:"versionStartExcluding"`
+	VersionStartIncluding string `json:"versionStartIncluding"`
+	VersionEndExcluding   string `json:"versionEndExcluding"`
+	VersionEndIncluding   string `json:"versionEndIncluding"`
+}
+
+// a statement from a vendor about the CVE
+type VendorComment struct {
+	Organization string `json:"organization"`
+	Comment      string `json:"comment"`
+	LastModified string `json:"lastModified"`
+}
+
+// a CVSS v4.0 score, as published by NVD or a CNA
+type CvssMetricV40 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                           string  `json:"version"`
+		VectorString                      string  `json:"vectorString"`
+		BaseScore                         float64 `json:"baseScore"`
+		BaseSeverity                      string  `json:"baseSeverity"`
+		AttackVector                      string  `json:"attackVector"`
+		AttackComplexity                  string  `json:"attackComplexity"`
+		AttackRequirements                string  `json:"attackRequirements"`
+		PrivilegesRequired                string  `json:"privilegesRequired"`
+		UserInteraction                   string  `json:"userInteraction"`
+		VulnConfidentialityImpact         string  `json:"vulnConfidentialityImpact"`
+		VulnIntegrityImpact               string  `json:"vulnIntegrityImpact"`
+		VulnAvailabilityImpact            string  `json:"vulnAvailabilityImpact"`
+		SubConfidentialityImpact          string  `json:"subConfidentialityImpact"`
+		SubIntegrityImpact                string  `json:"subIntegrityImpact"`
+		SubAvailabilityImpact             string  `json:"subAvailabilityImpact"`
+		ExploitMaturity                   string  `json:"exploitMaturity"`
+		ConfidentialityRequirement        string  `json:"confidentialityRequirement"`
+		IntegrityRequirement              string  `json:"integrityRequirement"`
+		AvailabilityRequirement           string  `json:"availabilityRequirement"`
+		ModifiedAttackVector              string  `json:"modifiedAttackVector"`
+		ModifiedAttackComplexity          string  `json:"modifiedAttackComplexity"`
+		ModifiedAttackRequirements        string  `json:"modifiedAttackRequirements"`
+		ModifiedPrivilegesRequired        string  `json:"modifiedPrivilegesRequired"`
+		ModifiedUserInteraction           string  `json:"modifiedUserInteraction"`
+		ModifiedVulnConfidentialityImpact string  `json:"modifiedVulnConfidentialityImpact"`
+		ModifiedVulnIntegrityImpact       string  `json:"modifiedVulnIntegrityImpact"`
+		ModifiedVulnAvailabilityImpact    string  `json:"modifiedVulnAvailabilityImpact"`
+		ModifiedSubConfidentialityImpact  string  `json:"modifiedSubConfidentialityImpact"`
+		ModifiedSubIntegrityImpact        string  `json:"modifiedSubIntegrityImpact"`
+		ModifiedSubAvailabilityImpact     string  `json:"modifiedSubAvailabilityImpact"`
+		Safety                            string  `json:"Safety"`
+		Automatable                       string  `json:"Automatable"`
+		Recovery                          string  `json:"Recovery"`
+		ValueDensity                      string  `json:"valueDensity"`
+		VulnerabilityResponseEffort       string  `json:"vulnerabilityResponseEffort"`
+		ProviderUrgency                   string  `json:"providerUrgency"`
+	} `json:"cvssData"`
+}
+
+// a CVSS v3.0 or v3.1 score - the two versions share their metrics
+type CvssMetricV3 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                       string  `json:"version"`
+		VectorString                  string  `json:"vectorString"`
+		AttackVector                  string  `json:"attackVector"`
+		AttackComplexity              string  `json:"attackComplexity"`
+		PrivilegesRequired            string  `json:"privilegesRequired"`
+		UserInteraction               string  `json:"userInteraction"`
+		Scope                         string  `json:"scope"`
+		ConfidentialityImpact         string  `json:"confidentialityImpact"`
+		IntegrityImpact               string  `json:"integrityImpact"`
+		AvailabilityImpact            string  `json:"availabilityImpact"`
+		BaseScore                     float64 `json:"baseScore"`
+		BaseSeverity                  string  `json:"baseSeverity"`
+		ExploitCodeMaturity           string  `json:"exploitCodeMaturity"`
+		RemediationLevel              string  `json:"remediationLevel"`
+		ReportConfidence              string  `json:"reportConfidence"`
+		TemporalScore                 float64 `json:"temporalScore"`
+		TemporalSeverity              string  `json:"temporalSeverity"`
+		ConfidentialityRequirement    string  `json:"confidentialityRequirement"`
+		IntegrityRequirement          string  `json:"integrityRequirement"`
+		AvailabilityRequirement       string  `json:"availabilityRequirement"`
+		ModifiedAttackVector          string  `json:"modifiedAttackVector"`
+		ModifiedAttackComplexity      string  `json:"modifiedAttackComplexity"`
+		ModifiedPrivilegesRequired    string  `json:"modifiedPrivilegesRequired"`
+		ModifiedUserInteraction       string  `json:"modifiedUserInteraction"`
+		ModifiedScope                 string  `json:"modifiedScope"`
+		ModifiedConfidentialityImpact string  `json:"modifiedConfidentialityImpact"`
+		ModifiedIntegrityImpact       string  `json:"modifiedIntegrityImpact"`
+		ModifiedAvailabilityImpact    string  `json:"modifiedAvailabilityImpact"`
+		EnvironmentalScore            float64 `json:"environmentalScore"`
+		EnvironmentalSeverity         string  `json:"environmentalSeverity"`
+	} `json:"cvssData"`
+	ExploitabilityScore float64 `json:"exploitabilityScore"`
+	ImpactScore         float64 `json:"impactScore"`
+}
+
+// a CVSS v2.0 score, which carries its severity and NVD's flags outside of the cvssData block
+type CvssMetricV2 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                    string  `json:"version"`
+		VectorString               string  `json:"vectorString"`
+		AccessVector               string  `json:"accessVector"`
+		AccessComplexity           string  `json:"accessComplexity"`
+		Authentication             string  `json:"authentication"`
+		ConfidentialityImpact      string  `json:"confidentialityImpact"`
+		IntegrityImpact            string  `json:"integrityImpact"`
+		AvailabilityImpact         string  `json:"availabilityImpact"`
+		BaseScore                  float64 `json:"baseScore"`
+		Exploitability             string  `json:"exploitability"`
+		RemediationLevel           string  `json:"remediationLevel"`
+		ReportConfidence           string  `json:"reportConfidence"`
+		TemporalScore              float64 `json:"temporalScore"`
+		CollateralDamagePotential  string  `json:"collateralDamagePotential"`
+		TargetDistribution         string  `json:"targetDistribution"`
+		ConfidentialityRequirement string  `json:"confidentialityRequirement"`
+		IntegrityRequirement       string  `json:"integrityRequirement"`
+		AvailabilityRequirement    string  `json:"availabilityRequirement"`
+		EnvironmentalScore         float64 `json:"environmentalScore"`
+	} `json:"cvssData"`
+	BaseSeverity            string  `json:"baseSeverity"`
+	ExploitabilityScore     float64 `json:"exploitabilityScore"`
+	ImpactScore             float64 `json:"impactScore"`
+	AcInsufInfo             bool    `json:"acInsufInfo"`
+	ObtainAllPrivilege      bool    `json:"obtainAllPrivilege"`
+	ObtainUserPrivilege     bool    `json:"obtainUserPrivilege"`
+	ObtainOtherPrivilege    bool    `json:"obtainOtherPrivilege"`
+	UserInteractionRequired bool    `json:"userInteractionRequired"`
 }
 
 type Vulnerability struct {
@@ -104,6 +227,82 @@ type CveMsg struct {
 	Timestamp string     `json:"timestamp"`
 	Source    string     `json:"source"`
 	CveData   NvdCveData `json:"cvedata"`
+	Aliases   []string   `json:"aliases"` // IDs of advisories from other sources (e.g. OSV) describing this CVE
+	Kev       *KevData   `json:"kev,omitempty"`
+	Epss      *EpssData  `json:"epss,omitempty"`
+
+	// the score cvewriter files the CVE under: the primary source's, using the newest CVSS version
+	PreferredScore *PreferredScore `bson:"preferredScore,omitempty" json:"preferredScore,omitempty"`
+
+	// names of the CWEs in CveData.Weaknesses, filled in from the CWE catalog when we respond
+	WeaknessNames map[string]string `bson:"-" json:"weaknessNames,omitempty"`
+
+	// scores recomputed from CveData's vectors with the caller's environmental modifiers, when asked for
+	EnvironmentalScores []cvss.Scores `bson:"-" json:"environmentalScores,omitempty"`
+
+	// the distros' own assessments of the CVE, when asked for
+	DistroStatus []DistroStatus `bson:"-" json:"distroStatus,omitempty"`
+
+	// the text cvewriter indexes the CVE under for full-text search
+	Search *SearchText `bson:"search,omitempty" json:"-"`
+
+	// the OSV and GHSA advisories describing this CVE, merged in when we respond
+	Advisories []Advisory `bson:"-" json:"advisories,omitempty"`
+}
+
+// the text we index a CVE's record for full-text search under, kept in its `search` block by cvewriter
+type SearchText struct {
+	Description string   `bson:"description" json:"description"`
+	References  []string `bson:"references" json:"references"`
+	Products    []string `bson:"products" json:"products"`
+}
+
+// WeaknessIDs returns the distinct CWE IDs NVD has assigned to the CVE
+func (c *CveMsg) WeaknessIDs() []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, weakness := range c.CveData.Weaknesses {
+		for _, desc := range weakness.Description {
+			if !seen[desc.Value] {
+				seen[desc.Value] = true
+				ids = append(ids, desc.Value)
+			}
+		}
+	}
+	return ids
+}
+
+// VectorStrings returns the CVSS vectors of every metric NVD holds for the CVE
+func (c *CveMsg) VectorStrings() []string {
+	vectors := []string{}
+	for _, metric := range c.CveData.Metrics.CvssMetricV40 {
+		vectors = append(vectors, metric.CvssData.VectorString)
+	}
+	for _, metric := range append(c.CveData.Metrics.CvssMetricV31, c.CveData.Metrics.CvssMetricV30...) {
+		vectors = append(vectors, metric.CvssData.VectorString)
+	}
+	for _, metric := range c.CveData.Metrics.CvssMetricV2 {
+		vectors = append(vectors, metric.CvssData.VectorString)
+	}
+	return vectors
+}
+
+// the single score a CVE is filed under, picked by cvewriter from the CVE's metrics
+type PreferredScore struct {
+	Source       string  `bson:"source" json:"source"`
+	Type         string  `bson:"type" json:"type"`
+	Version      string  `bson:"version" json:"version"`
+	VectorString string  `bson:"vectorString" json:"vectorString"`
+	BaseScore    float64 `bson:"baseScore" json:"baseScore"`
+	BaseSeverity string  `bson:"baseSeverity" json:"baseSeverity"`
+}
+
+// the block cvewriter attaches to CVEs listed in the CISA Known Exploited Vulnerabilities catalog
+type KevData struct {
+	DateAdded                  string `bson:"dateAdded" json:"dateAdded"`
+	DueDate                    string `bson:"dueDate" json:"dueDate"`
+	RequiredAction             string `bson:"requiredAction" json:"requiredAction"`
+	KnownRansomwareCampaignUse string `bson:"knownRansomwareCampaignUse" json:"knownRansomwareCampaignUse"`
 }
 
 func NewCveMsg(cve NvdCveData, timestamp string) (*CveMsg, error) {
@@ -115,6 +314,173 @@ func NewCveMsg(cve NvdCveData, timestamp string) (*CveMsg, error) {
 	return msg, nil
 }
 
+// an EPSS score, as stored in the history collection and as the latest score on a CVE's record
+type EpssData struct {
+	Cve          string  `bson:"cve" json:"cve"`
+	Score        float64 `bson:"score" json:"score"`
+	Percentile   float64 `bson:"percentile" json:"percentile"`
+	Date         string  `bson:"date" json:"date"`
+	ModelVersion string  `bson:"modelVersion" json:"modelVersion"`
+}
+
+// a weakness or category from MITRE's CWE catalog, as stored by cvewriter
+type Cwe struct {
+	ID          string   `bson:"id" json:"id"`
+	Type        string   `bson:"type" json:"type"`
+	Name        string   `bson:"name" json:"name"`
+	Description string   `bson:"description" json:"description"`
+	Abstraction string   `bson:"abstraction" json:"abstraction"`
+	Status      string   `bson:"status" json:"status"`
+	Parents     []string `bson:"parents" json:"parents"`
+}
+
+// a brief reference to another CWE, for describing relations
+type CweRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// a CWE along with its direct relations in the hierarchy
+type CweDetail struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Abstraction string   `json:"abstraction"`
+	Status      string   `json:"status"`
+	Parents     []CweRef `json:"parents"`
+	Children    []CweRef `json:"children"`
+}
+
+// an advisory from an OSV-format source - OSV itself or the GitHub Security Advisory database - as cvewriter stores it
+type Advisory struct {
+	Timestamp string      `json:"timestamp"`
+	Source    string      `json:"source"`
+	Osv       OsvAdvisory `json:"osvdata"`
+}
+
+// Severity returns the source's own rating of the advisory, e.g. GHSA's CRITICAL, HIGH, MODERATE or LOW
+func (a Advisory) Severity() string {
+	severity, _ := a.Osv.DatabaseSpecific["severity"].(string)
+	return severity
+}
+
+// structure of an advisory in the OSV schema (https://ossf.github.io/osv-schema/)
+type OsvAdvisory struct {
+	SchemaVersion    string                 `json:"schema_version"`
+	ID               string                 `json:"id"`
+	Modified         string                 `json:"modified"`
+	Published        string                 `json:"published"`
+	Withdrawn        string                 `json:"withdrawn"`
+	Aliases          []string               `json:"aliases"`
+	Related          []string               `json:"related"`
+	Summary          string                 `json:"summary"`
+	Details          string                 `json:"details"`
+	Severity         []OsvSeverity          `json:"severity"`
+	Affected         []OsvAffected          `json:"affected"`
+	References       []OsvReference         `json:"references"`
+	Credits          []OsvCredit            `json:"credits"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+type OsvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type OsvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+		Purl      string `json:"purl"`
+	} `json:"package"`
+	Severity []OsvSeverity `json:"severity"`
+	Ranges   []struct {
+		Type   string `json:"type"`
+		Repo   string `json:"repo"`
+		Events []struct {
+			Introduced   string `json:"introduced,omitempty"`
+			Fixed        string `json:"fixed,omitempty"`
+			LastAffected string `json:"last_affected,omitempty"`
+			Limit        string `json:"limit,omitempty"`
+		} `json:"events"`
+	} `json:"ranges"`
+	Versions          []string               `json:"versions"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+}
+
+type OsvReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type OsvCredit struct {
+	Name    string   `json:"name"`
+	Contact []string `json:"contact"`
+	Type    string   `json:"type"`
+}
+
+// a distribution's assessment of a CVE for one of its packages in one of its releases
+type DistroStatus struct {
+	Cve          string `bson:"cve" json:"cve"`
+	Distro       string `bson:"distro" json:"distro"`
+	Release      string `bson:"release" json:"release"`
+	Package      string `bson:"package" json:"package"`
+	Status       string `bson:"status" json:"status"` // fixed, affected, not-affected, will-not-fix, deferred or under-investigation
+	FixedVersion string `bson:"fixedVersion" json:"fixedVersion,omitempty"`
+	Severity     string `bson:"severity" json:"severity,omitempty"`
+	Notes        string `bson:"notes" json:"notes,omitempty"`
+}
+
+// a change made to a CVE, from NVD's change history: who made it, when, and the details of each edit
+type CveChange struct {
+	CveID            string `json:"cveId"`
+	EventName        string `json:"eventName"`
+	CveChangeID      string `json:"cveChangeId"`
+	SourceIdentifier string `json:"sourceIdentifier"`
+	Created          string `json:"created"`
+	Details          []struct {
+		Action   string `json:"action"`
+		Type     string `json:"type"`
+		OldValue string `json:"oldValue"`
+		NewValue string `json:"newValue"`
+	} `json:"details"`
+}
+
+// a product from the NVD CPE dictionary, as stored by cvewriter
+type Cpe struct {
+	CpeName      string `json:"cpeName"`
+	CpeNameID    string `json:"cpeNameId"`
+	Deprecated   bool   `json:"deprecated"`
+	LastModified string `json:"lastModified"`
+	Created      string `json:"created"`
+	Titles       []struct {
+		Title string `json:"title"`
+		Lang  string `json:"lang"`
+	} `json:"titles"`
+}
+
+// Title returns the product's English title, or failing that whichever it has
+func (c *Cpe) Title() string {
+	for _, title := range c.Titles {
+		if title.Lang == "en" {
+			return title.Title
+		}
+	}
+	if len(c.Titles) > 0 {
+		return c.Titles[0].Title
+	}
+	return ""
+}
+
+// a product suggested for a partial name
+type CpeSuggestion struct {
+	CpeName   string `json:"cpeName"`
+	CpeNameID string `json:"cpeNameId"`
+	Title     string `json:"title"`
+}
+
 type MetaDoc struct {
 	InitComplete bool `bson:"initComplete" json:"initComplete"`
 }
@@ -125,3 +491,12 @@ func InitMetaDoc() (*MetaDoc, error) {
 	}
 	return meta, nil
 }
+
+// the message cvewriter publishes whenever a source creates or changes a CVE's record
+type CveUpdateMsg struct {
+	Timestamp string `json:"timestamp"`
+	CveID     string `json:"cveId"`
+	Event     string `json:"event"`  // created or updated
+	Source    string `json:"source"` // the source whose update it was, e.g. nvd
+	Revision  int64  `json:"revision"`
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"melaka/cvequerier/cvss"
+)
+
+const openApiVersion = "3.1.0"
+
+// the parts of an OpenAPI 3.1 document (https://spec.openapis.org/oas/v3.1.0) we describe our API with
+
+type OpenApiDocument struct {
+	OpenApi    string                                  `json:"openapi"`
+	Info       OpenApiInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenApiOperation `json:"paths"`
+	Components OpenApiComponents                       `json:"components"`
+}
+
+type OpenApiInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenApiComponents struct {
+	Schemas         map[string]*Schema                `json:"schemas"`
+	Responses       map[string]*OpenApiResponse       `json:"responses"`
+	SecuritySchemes map[string]*OpenApiSecurityScheme `json:"securitySchemes"`
+}
+
+type OpenApiSecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+type OpenApiOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Tags        []string                    `json:"tags"`
+	Parameters  []*OpenApiParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenApiRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenApiResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security"`
+}
+
+type OpenApiParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path or query
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+	Style       string  `json:"style,omitempty"`
+	Explode     *bool   `json:"explode,omitempty"`
+}
+
+type OpenApiRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*OpenApiMediaType `json:"content"`
+}
+
+type OpenApiResponse struct {
+	Ref         string                       `json:"$ref,omitempty"`
+	Description string                       `json:"description,omitempty"`
+	Content     map[string]*OpenApiMediaType `json:"content,omitempty"`
+}
+
+type OpenApiMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON Schema, as much of one as we need to describe our API and validate requests against it
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"` // a type name, or a list of them
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// types lists the types a schema allows, which is none at all for a schema allowing anything
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	}
+	return nil
+}
+
+func (s *Schema) allows(t string) bool {
+	for _, allowed := range s.types() {
+		if allowed == t || (allowed == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaGenerator describes Go types as JSON Schemas, going by their json tags. Named structs become component
+// schemas that are referred to, so each is described once
+type schemaGenerator struct {
+	schemas map[string]*Schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *Schema {
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schemaFor(t.Elem())
+		if s.Ref == "" && s.Type != nil {
+			s.Type = []string{s.Type.(string), "null"}
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = &Schema{} // a placeholder, for types that refer to themselves
+			*g.schemas[t.Name()] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	// interface{} and the like could be anything
+	return &Schema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		// embedded structs without a name of their own have their fields flattened into ours
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for k, v := range g.structSchema(embedded).Properties {
+					s.Properties[k] = v
+				}
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = g.schemaFor(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return s
+}
+
+// a route of our API, as we describe it in our OpenAPI document and validate requests to it
+type apiRoute struct {
+	method   string
+	path     string // as gin has it, e.g. /cve/:id
+	id       string
+	summary  string
+	tag      string
+	scope    string // the scope the route needs, if any
+	params   []*OpenApiParameter
+	body     interface{}       // a value of the type the route takes as its JSON body, if it takes one
+	status   int               // what it responds with when all goes well
+	response interface{}       // a value of the type it responds with as JSON, if it does
+	formats  map[string]string // other media types it can respond with, and what they're called
+}
+
+// doc-only shapes of the responses our handlers build as gin.H
+
+type CveSearchPage struct {
+	Results []CveMsg `json:"results"`
+	Limit   int      `json:"limit"`
+	Offset  int      `json:"offset"`
+}
+
+type TextSearchPage struct {
+	Results []SearchResult `json:"results"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
+
+type StatsPage struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Results []StatsCount `json:"results"`
+}
+
+type AnalysisTimePage struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Results AnalysisTime `json:"results"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func stringSchema(description string) *Schema {
+	return &Schema{Type: "string", Description: description}
+}
+
+func integerSchema(min, max int) *Schema {
+	s := &Schema{Type: "integer", Minimum: float(float64(min))}
+	if max > 0 {
+		s.Maximum = float(float64(max))
+	}
+	return s
+}
+
+func numberSchema(min, max float64) *Schema {
+	return &Schema{Type: "number", Minimum: float(min), Maximum: float(max)}
+}
+
+func enumSchema(values ...string) *Schema {
+	return &Schema{Type: "string", Enum: values}
+}
+
+func pathParam(name, description string) *OpenApiParameter {
+	return &OpenApiParameter{Name: name, In: "path", Description: description, Required: true, Schema: &Schema{Type: "string"}}
+}
+
+func queryParam(name, description string, schema *Schema) *OpenApiParameter {
+	return &OpenApiParameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// listParam is a comma separated list, e.g. severity=HIGH,CRITICAL
+func listParam(name, description string, items *Schema) *OpenApiParameter {
+	explode := false
+	return &OpenApiParameter{Name: name, In: "query", Description: description, Schema: &Schema{Type: "array", Items: items}, Style: "form", Explode: &explode}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cveFilterParams are the filters CVE searches take, as parseCveFilter reads them. Searches that can't be sorted
+// leave sort out
+func cveFilterParams(sortable bool) []*OpenApiParameter {
+
+	params := []*OpenApiParameter{
+		queryParam("kev", "Only CVEs in, or with false not in, CISA's Known Exploited Vulnerabilities catalog", &Schema{Type: "boolean"}),
+		listParam("cwe", "CWE IDs, with or without their CWE- prefix", &Schema{Type: "string"}),
+		queryParam("epssMin", "The lowest EPSS score", numberSchema(0, 1)),
+		queryParam("percentileMin", "The lowest EPSS percentile", numberSchema(0, 1)),
+		queryParam("cpe", "A CPE 2.3 name the CVEs affect", &Schema{Type: "string", Pattern: `^cpe:2\.3:`}),
+		queryParam("vendor", "A vendor as named in CPEs, e.g. apache", &Schema{Type: "string", Pattern: `^[^:*?]+$`}),
+		queryParam("keyword", "Text the CVE's description contains", &Schema{Type: "string"}),
+		queryParam("scoreMin", "The lowest preferred CVSS base score", numberSchema(0, 10)),
+		listParam("severity", "Severities of the preferred score, any of "+strings.Join(sortedKeys(severities), ", "), &Schema{Type: "string"}),
+		listParam("distro", "Distros that have assessed the CVEs, any of "+strings.Join(sortedKeys(distros), ", ")+" or all", &Schema{Type: "string"}),
+		listParam("distroStatus", "What a distro assessed one of its packages as, any of "+strings.Join(sortedKeys(distroStatuses), ", "), &Schema{Type: "string"}),
+	}
+
+	if sortable {
+		keys := []string{}
+		for _, key := range sortedKeys(sortKeys) {
+			keys = append(keys, key, "-"+key)
+		}
+		params = append(params, queryParam("sort", "What to sort by, descending with a - prefix. Defaults to -published", enumSchema(keys...)))
+	}
+
+	return append(params,
+		queryParam("limit", fmt.Sprintf("How many CVEs to return, defaults to %d", defaultSearchLimit), integerSchema(1, maxSearchLimit)),
+		queryParam("offset", "How many CVEs to skip", integerSchema(0, 0)),
+	)
+}
+
+func statsParams() []*OpenApiParameter {
+	date := &Schema{Type: "string", Format: "date", Pattern: `^\d{4}-\d{2}-\d{2}$`}
+	return []*OpenApiParameter{
+		queryParam("from", "The first day of CVEs published to count, e.g. 2023-01-01", date),
+		queryParam("to", "The last day of CVEs published to count, inclusive", date),
+		queryParam("limit", fmt.Sprintf("How many of the top values to list, defaults to %d", defaultStatsLimit), integerSchema(1, maxStatsLimit)),
+	}
+}
+
+func annotationFilterParams() []*OpenApiParameter {
+	return []*OpenApiParameter{
+		queryParam("asset", "The ID of an asset", &Schema{Type: "string"}),
+		queryParam("product", "A CPE name or package URL", &Schema{Type: "string"}),
+		queryParam("cve", "A CVE ID", &Schema{Type: "string"}),
+		queryParam("status", "A VEX status", enumSchema(sortedKeys(vexStatuses)...)),
+	}
+}
+
+var cveFormats = map[string]string{mimeStix: "a STIX 2.1 bundle", mimeCsaf: "a CSAF 2.0 document"}
+
+// apiRoutes describes every route buildServer maps
+var apiRoutes = func() []apiRoute {
+
+	exportFormatParam := queryParam("format", "The format to export in, if not negotiated with the Accept header", enumSchema(exportFormatNames...))
+	columnNames := []string{}
+	for _, column := range exportColumns {
+		columnNames = append(columnNames, column.name)
+	}
+	columnsParam := listParam("columns", "The columns of a tabular export, or all. Any of "+strings.Join(columnNames, ", "), &Schema{Type: "string"})
+	exportMedia := map[string]string{}
+	for _, name := range exportFormatNames {
+		exportMedia[exportFormats[name].mediaType] = name
+	}
+
+	routes := []apiRoute{
+		{method: "GET", path: "/cve/:id", id: "getCve", summary: "Get a CVE's consolidated record", tag: "CVEs", scope: ScopeRead,
+			params: []*OpenApiParameter{
+				pathParam("id", "A CVE ID, e.g. CVE-2021-44228"),
+				queryParam("distro", "Distros whose assessments to include, a comma separated list of "+strings.Join(sortedKeys(distros), ", ")+". Defaults to all", &Schema{Type: "string"}),
+				queryParam("env", "CVSS environmental modifiers to rescore the CVE with, e.g. CR:H/MAV:L", &Schema{Type: "string"}),
+			},
+			response: CveMsg{}, formats: cveFormats},
+		{method: "GET", path: "/cve/:id/epss", id: "getEpssHistory", summary: "Get a CVE's EPSS score history", tag: "CVEs", scope: ScopeRead,
+			params: []*OpenApiParameter{pathParam("id", "A CVE ID")}, response: []EpssData{}},
+		{method: "GET", path: "/cve/:id/changes", id: "getCveChanges", summary: "Get NVD's change history for a CVE", tag: "CVEs", scope: ScopeRead,
+			params: []*OpenApiParameter{pathParam("id", "A CVE ID")}, response: []CveChange{}},
+		{method: "GET", path: "/cves", id: "searchCves", summary: "Search CVEs", tag: "CVEs", scope: ScopeRead,
+			params: cveFilterParams(true), response: CveSearchPage{}, formats: cveFormats},
+		{method: "GET", path: "/search", id: "searchText", summary: "Search CVEs' text, best match first", tag: "CVEs", scope: ScopeRead,
+			params:   append([]*OpenApiParameter{{Name: "q", In: "query", Description: "The words to search for", Required: true, Schema: &Schema{Type: "string"}}}, cveFilterParams(false)...),
+			response: TextSearchPage{}, formats: cveFormats},
+		{method: "GET", path: "/export", id: "exportCves", summary: "Export CVEs matching a search", tag: "CVEs", scope: ScopeRead,
+			params: append([]*OpenApiParameter{exportFormatParam, columnsParam}, cveFilterParams(true)...), formats: exportMedia},
+		{method: "GET", path: "/feeds/cves.atom", id: "getAtomFeed", summary: "An Atom feed of recently modified CVEs", tag: "Feeds", scope: ScopeRead,
+			params: cveFilterParams(false), formats: map[string]string{"application/atom+xml": "an Atom feed"}},
+		{method: "GET", path: "/feeds/cves.rss", id: "getRssFeed", summary: "An RSS feed of recently modified CVEs", tag: "Feeds", scope: ScopeRead,
+			params: cveFilterParams(false), formats: map[string]string{"application/rss+xml": "an RSS feed"}},
+		{method: "GET", path: "/cwe/:id", id: "getCwe", summary: "Get a CWE with its parents and children", tag: "CWEs", scope: ScopeRead,
+			params: []*OpenApiParameter{pathParam("id", "A CWE ID, with or without its CWE- prefix")}, response: CweDetail{}},
+		{method: "GET", path: "/cwe/:id/cves", id: "getCweCves", summary: "Search CVEs with a weakness", tag: "CWEs", scope: ScopeRead,
+			params: append([]*OpenApiParameter{
+				pathParam("id", "A CWE ID, with or without its CWE- prefix"),
+				queryParam("descendants", "Include CVEs with any weakness beneath this one in the hierarchy", &Schema{Type: "boolean"}),
+			}, cveFilterParams(true)...),
+			response: CveSearchPage{}, formats: cveFormats},
+		{method: "POST", path: "/cvss/calculate", id: "calculateCvss", summary: "Score a CVSS vector", tag: "CVSS", scope: ScopeRead,
+			body: CvssRequest{}, response: cvss.Scores{}},
+		{method: "GET", path: "/cpes", id: "searchCpes", summary: "Suggest products from the CPE dictionary", tag: "CPEs", scope: ScopeRead,
+			params: []*OpenApiParameter{
+				{Name: "q", In: "query", Description: "Part of a product's name", Required: true, Schema: &Schema{Type: "string"}},
+				queryParam("limit", fmt.Sprintf("How many products to suggest, defaults to %d", defaultCpeLimit), integerSchema(1, maxCpeLimit)),
+			},
+			response: []CpeSuggestion{}},
+		{method: "GET", path: "/stats/published", id: "getPublishedStats", summary: "Count CVEs published each month", tag: "Stats", scope: ScopeRead,
+			params: statsParams(), response: StatsPage{}},
+		{method: "GET", path: "/stats/severity", id: "getSeverityStats", summary: "Count CVEs by severity", tag: "Stats", scope: ScopeRead,
+			params: statsParams(), response: StatsPage{}},
+		{method: "GET", path: "/stats/status", id: "getStatusStats", summary: "Count CVEs by NVD's analysis status", tag: "Stats", scope: ScopeRead,
+			params: statsParams(), response: StatsPage{}},
+		{method: "GET", path: "/stats/cwes", id: "getCweStats", summary: "The most common CWEs", tag: "Stats", scope: ScopeRead,
+			params: statsParams(), response: StatsPage{}},
+		{method: "GET", path: "/stats/vendors", id: "getVendorStats", summary: "The vendors with the most CVEs", tag: "Stats", scope: ScopeRead,
+			params: statsParams(), response: StatsPage{}},
+		{method: "GET", path: "/stats/products", id: "getProductStats", summary: "The products with the most CVEs", tag: "Stats", scope: ScopeRead,
+			params: statsParams(), response: StatsPage{}},
+		{method: "GET", path: "/stats/analysis-time", id: "getAnalysisTimeStats", summary: "How long NVD takes to analyse CVEs", tag: "Stats", scope: ScopeRead,
+			params: statsParams(), response: AnalysisTimePage{}},
+		{method: "GET", path: "/assets", id: "getAssets", summary: "List assets", tag: "Assets", scope: ScopeRead,
+			params: []*OpenApiParameter{queryParam("team", "Only the team's assets", &Schema{Type: "string"})}, response: []Asset{}},
+		{method: "GET", path: "/assets/:id", id: "getAsset", summary: "Get an asset", tag: "Assets", scope: ScopeRead,
+			params: []*OpenApiParameter{pathParam("id", "An asset's ID")}, response: Asset{}},
+		{method: "GET", path: "/assets/:id/vulnerabilities", id: "getAssetVulnerabilities", summary: "The CVEs affecting an asset", tag: "Assets", scope: ScopeRead,
+			params: []*OpenApiParameter{pathParam("id", "An asset's ID")}, response: AssetExposure{}},
+		{method: "GET", path: "/teams/:team/exposure", id: "getTeamExposure", summary: "The CVEs affecting a team's assets", tag: "Assets", scope: ScopeRead,
+			params: []*OpenApiParameter{pathParam("team", "A team's name")}, response: TeamExposure{}},
+		{method: "GET", path: "/annotations", id: "getAnnotations", summary: "List triage annotations", tag: "Annotations", scope: ScopeRead,
+			params: annotationFilterParams(), response: []Annotation{}},
+		{method: "GET", path: "/annotations/:id", id: "getAnnotation", summary: "Get a triage annotation", tag: "Annotations", scope: ScopeRead,
+			params: []*OpenApiParameter{pathParam("id", "An annotation's ID")}, response: Annotation{}},
+		{method: "GET", path: "/vex/openvex", id: "exportOpenVex", summary: "Export triage annotations as an OpenVEX document", tag: "Annotations", scope: ScopeRead,
+			params: annotationFilterParams(), response: OpenVexDocument{}},
+		{method: "GET", path: "/auth/whoami", id: "whoami", summary: "Who the caller is authenticated as", tag: "Auth", scope: ScopeRead,
+			response: Principal{}},
+		{method: "GET", path: "/graphql", id: "getGraphql", summary: "Run a GraphQL query over CVEs, CWEs and their histories", tag: "GraphQL", scope: ScopeRead,
+			params: []*OpenApiParameter{
+				{Name: "query", In: "query", Description: "The GraphQL query", Required: true, Schema: &Schema{Type: "string"}},
+				queryParam("operationName", "Which of the query's operations to run", &Schema{Type: "string"}),
+				queryParam("variables", "The query's variables, as a JSON object", &Schema{Type: "string"}),
+			},
+			response: GraphqlResponse{}},
+		{method: "POST", path: "/graphql", id: "postGraphql", summary: "Run a GraphQL query over CVEs, CWEs and their histories", tag: "GraphQL", scope: ScopeRead,
+			body: GraphqlRequest{}, response: GraphqlResponse{}},
+
+		{method: "POST", path: "/annotations", id: "createAnnotation", summary: "Triage a CVE for an asset or product", tag: "Annotations", scope: ScopeAnnotate,
+			body: AnnotationRequest{}, status: http.StatusCreated, response: Annotation{}},
+		{method: "PUT", path: "/annotations/:id", id: "updateAnnotation", summary: "Re-triage a CVE", tag: "Annotations", scope: ScopeAnnotate,
+			params: []*OpenApiParameter{pathParam("id", "An annotation's ID")}, body: AnnotationRequest{}, response: Annotation{}},
+		{method: "DELETE", path: "/annotations/:id", id: "deleteAnnotation", summary: "Delete a triage annotation", tag: "Annotations", scope: ScopeAnnotate,
+			params: []*OpenApiParameter{pathParam("id", "An annotation's ID")}, status: http.StatusNoContent},
+
+		{method: "POST", path: "/subscriptions", id: "createSubscription", summary: "Subscribe a webhook to CVE updates", tag: "Subscriptions", scope: ScopeAdmin,
+			body: SubscriptionRequest{}, status: http.StatusCreated, response: Subscription{}},
+		{method: "GET", path: "/subscriptions", id: "getSubscriptions", summary: "List subscriptions", tag: "Subscriptions", scope: ScopeAdmin,
+			response: []Subscription{}},
+		{method: "GET", path: "/subscriptions/:id", id: "getSubscription", summary: "Get a subscription", tag: "Subscriptions", scope: ScopeAdmin,
+			params: []*OpenApiParameter{pathParam("id", "A subscription's ID")}, response: Subscription{}},
+		{method: "PATCH", path: "/subscriptions/:id", id: "updateSubscription", summary: "Enable or disable a subscription", tag: "Subscriptions", scope: ScopeAdmin,
+			params: []*OpenApiParameter{pathParam("id", "A subscription's ID")}, body: SubscriptionUpdate{}, response: Subscription{}},
+		{method: "DELETE", path: "/subscriptions/:id", id: "deleteSubscription", summary: "Delete a subscription", tag: "Subscriptions", scope: ScopeAdmin,
+			params: []*OpenApiParameter{pathParam("id", "A subscription's ID")}, status: http.StatusNoContent},
+		{method: "GET", path: "/subscriptions/:id/deliveries", id: "getDeliveries", summary: "A subscription's latest deliveries", tag: "Subscriptions", scope: ScopeAdmin,
+			params: []*OpenApiParameter{
+				pathParam("id", "A subscription's ID"),
+				queryParam("limit", fmt.Sprintf("How many deliveries to list, defaults to %d", defaultDeliveryLimit), integerSchema(1, maxDeliveryLimit)),
+			},
+			response: []Delivery{}},
+		{method: "POST", path: "/assets", id: "createAsset", summary: "Register an asset", tag: "Assets", scope: ScopeAdmin,
+			body: AssetRequest{}, status: http.StatusCreated, response: Asset{}},
+		{method: "PUT", path: "/assets/:id", id: "updateAsset", summary: "Update an asset", tag: "Assets", scope: ScopeAdmin,
+			params: []*OpenApiParameter{pathParam("id", "An asset's ID")}, body: AssetRequest{}, response: Asset{}},
+		{method: "DELETE", path: "/assets/:id", id: "deleteAsset", summary: "Delete an asset and its annotations", tag: "Assets", scope: ScopeAdmin,
+			params: []*OpenApiParameter{pathParam("id", "An asset's ID")}, status: http.StatusNoContent},
+		{method: "POST", path: "/auth/keys", id: "createApiKey", summary: "Mint an API key, which is only ever shown in this response", tag: "Auth", scope: ScopeAdmin,
+			body: ApiKeyRequest{}, status: http.StatusCreated, response: MintedApiKey{}},
+		{method: "GET", path: "/auth/keys", id: "getApiKeys", summary: "List API keys", tag: "Auth", scope: ScopeAdmin,
+			response: []ApiKey{}},
+		{method: "DELETE", path: "/auth/keys/:id", id: "revokeApiKey", summary: "Revoke an API key", tag: "Auth", scope: ScopeAdmin,
+			params: []*OpenApiParameter{pathParam("id", "An API key's ID")}, status: http.StatusNoContent},
+
+		{method: "GET", path: "/metrics", id: "getMetrics", summary: "Prometheus metrics", tag: "Meta",
+			formats: map[string]string{"text/plain": "Prometheus' text format"}},
+		{method: "GET", path: "/openapi.json", id: "getOpenApi", summary: "This document", tag: "Meta",
+			formats: map[string]string{gin.MIMEJSON: "an OpenAPI 3.1 document"}},
+		{method: "GET", path: "/docs", id: "getDocs", summary: "Browsable documentation of this API", tag: "Meta",
+			formats: map[string]string{gin.MIMEHTML: "a Redoc page"}},
+		{method: "GET", path: "/docs/redoc.standalone.js", id: "getDocsScript", summary: "The Redoc bundle the documentation page runs", tag: "Meta",
+			formats: map[string]string{"application/javascript": "Redoc's standalone bundle"}},
+	}
+
+	for i := range routes {
+		if routes[i].status == 0 {
+			routes[i].status = http.StatusOK
+		}
+	}
+
+	return routes
+}()
+
+// openApiPath turns a gin path into an OpenAPI one, e.g. /cve/:id into /cve/{id}
+func openApiPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func errorResponse(description string) *OpenApiResponse {
+	return &OpenApiResponse{
+		Description: description,
+		Content:     map[string]*OpenApiMediaType{gin.MIMEJSON: {Schema: &Schema{Ref: "#/components/schemas/ErrorResponse"}}},
+	}
+}
+
+func responseRef(name string) *OpenApiResponse {
+	return &OpenApiResponse{Ref: "#/components/responses/" + name}
+}
+
+// buildOpenApiDocument describes apiRoutes, with schemas for the types they take and respond with
+func buildOpenApiDocument() *OpenApiDocument {
+
+	g := &schemaGenerator{schemas: map[string]*Schema{}}
+	g.schemaFor(reflect.TypeOf(ErrorResponse{}))
+
+	doc := &OpenApiDocument{
+		OpenApi: openApiVersion,
+		Info: OpenApiInfo{
+			Title:   "Melaka CVE API",
+			Version: "1.0",
+			Description: "CVEs consolidated from NVD, OSV, GitHub advisories and CISA's KEV catalog, along with the assets " +
+				"they affect and how they've been triaged. Routes need the read, annotate or admin scope, granted by an " +
+				"API key or an OIDC token, unless authentication is turned off.",
+		},
+		Paths: map[string]map[string]*OpenApiOperation{},
+		Components: OpenApiComponents{
+			Schemas: g.schemas,
+			Responses: map[string]*OpenApiResponse{
+				"BadRequest":      errorResponse("The request's parameters or body are invalid"),
+				"Unauthorized":    errorResponse("No valid API key or bearer token was given"),
+				"Forbidden":       errorResponse("The caller hasn't been granted the scope the route needs"),
+				"NotFound":        errorResponse("There's nothing with that ID"),
+				"TooManyRequests": errorResponse("The caller has used up its quota of requests, and should retry after Retry-After seconds"),
+			},
+			SecuritySchemes: map[string]*OpenApiSecurityScheme{
+				"apiKey": {Type: "apiKey", In: "header", Name: "X-API-Key", Description: "An API key, which can also be given as a bearer token"},
+				"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "An OIDC token, with its scopes in its scope claim"},
+			},
+		},
+	}
+
+	for _, route := range apiRoutes {
+
+		op := &OpenApiOperation{
+			OperationID: route.id,
+			Summary:     route.summary,
+			Tags:        []string{route.tag},
+			Parameters:  route.params,
+			Responses:   map[string]*OpenApiResponse{},
+			Security:    []map[string][]string{},
+		}
+
+		if route.body != nil {
+			op.RequestBody = &OpenApiRequestBody{
+				Required: true,
+				Content:  map[string]*OpenApiMediaType{gin.MIMEJSON: {Schema: g.schemaFor(reflect.TypeOf(route.body))}},
+			}
+		}
+
+		success := &OpenApiResponse{Description: http.StatusText(route.status), Content: map[string]*OpenApiMediaType{}}
+		if route.response != nil {
+			success.Content[gin.MIMEJSON] = &OpenApiMediaType{Schema: g.schemaFor(reflect.TypeOf(route.response))}
+		}
+		for mediaType, description := range route.formats {
+			success.Content[mediaType] = &OpenApiMediaType{Schema: &Schema{Type: "string", Description: description}}
+		}
+		if len(success.Content) == 0 {
+			success.Content = nil
+		}
+		op.Responses[fmt.Sprint(route.status)] = success
+
+		if len(route.params) > 0 || route.body != nil {
+			op.Responses["400"] = responseRef("BadRequest")
+		}
+		if strings.Contains(route.path, ":") {
+			op.Responses["404"] = responseRef("NotFound")
+		}
+		if route.scope != "" {
+			op.Security = []map[string][]string{{"apiKey": {route.scope}}, {"bearer": {route.scope}}}
+			op.Responses["401"] = responseRef("Unauthorized")
+			op.Responses["403"] = responseRef("Forbidden")
+			op.Responses["429"] = responseRef("TooManyRequests")
+		}
+
+		path := openApiPath(route.path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*OpenApiOperation{}
+		}
+		doc.Paths[path][strings.ToLower(route.method)] = op
+	}
+
+	return doc
+}
+
+var (
+	openApiDocument = buildOpenApiDocument()
+	openApiJSON     = func() []byte {
+		data, err := json.MarshalIndent(openApiDocument, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		return data
+	}()
+)
+
+func (s *Server) getOpenApi(c *gin.Context) {
+	c.Data(http.StatusOK, gin.MIMEJSON, openApiJSON)
+}
+
+// the Redoc page rendering our OpenAPI document, with Redoc served from our own route rather than a CDN
+const docsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Melaka CVE API</title>
+    <meta charset="utf-8"/>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+  </head>
+  <body>
+    <redoc spec-url="openapi.json"></redoc>
+    <script src="docs/redoc.standalone.js"></script>
+  </body>
+</html>
+`
+
+func (s *Server) getDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
+
+// Redoc's standalone bundle, vendored into static so the docs page only runs code we ship
+//
+//go:embed static/redoc.standalone.js
+var redocScript []byte
+
+func (s *Server) getDocsScript(c *gin.Context) {
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, "application/javascript; charset=utf-8", redocScript)
+
+}
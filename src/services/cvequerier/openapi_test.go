@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenApi_Describes_Every_Route(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	mapped := map[string]bool{}
+	for _, route := range server.router.Routes() {
+		mapped[route.Method+" "+route.Path] = true
+	}
+
+	described := map[string]bool{}
+	for _, route := range apiRoutes {
+		described[route.method+" "+route.path] = true
+	}
+
+	assert.Equal(t, mapped, described)
+
+}
+
+func TestGetOpenApi(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &doc))
+	assert.Equal(t, "3.1.0", doc["openapi"])
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	assert.Contains(t, schemas, "CveMsg")
+	assert.Contains(t, schemas, "Annotation")
+
+	// every reference resolves
+	var check func(v interface{})
+	check = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				var target interface{} = doc
+				for _, part := range bytes.Split([]byte(ref[2:]), []byte("/")) {
+					target = target.(map[string]interface{})[string(part)]
+				}
+				assert.NotNil(t, target, ref)
+			}
+			for _, child := range v {
+				check(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				check(child)
+			}
+		}
+	}
+	check(doc)
+
+	req, _ = http.NewRequest("GET", "/docs", nil)
+	resp = httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `spec-url="openapi.json"`)
+	assert.NotContains(t, resp.Body.String(), "https://")
+
+}
+
+func TestGetDocsScript(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+	get := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/docs/redoc.standalone.js", nil)
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// the vendored bundle, rather than anything from a CDN
+	resp := get()
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/javascript; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=3600", resp.Header().Get("Cache-Control"))
+	assert.Contains(t, resp.Body.String(), `ReDoc Version: ","2.0.0-rc.59"`)
+
+}
+
+func TestValidateRequest(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	send := func(method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		var result struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		return resp.Code, result.Error
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		message            string
+	}{
+		{"GET", "/cves?limit=0", "", http.StatusBadRequest, "limit must be a number between 1 and 500"},
+		{"GET", "/cves?limit=ten", "", http.StatusBadRequest, "limit must be a number between 1 and 500"},
+		{"GET", "/cves?offset=-1", "", http.StatusBadRequest, "offset must be a non-negative number"},
+		{"GET", "/cves?kev=maybe", "", http.StatusBadRequest, "kev must be true or false"},
+		{"GET", "/cves?epssMin=2", "", http.StatusBadRequest, "epssMin must be a number between 0 and 1"},
+		{"GET", "/cves?sort=name", "", http.StatusBadRequest, ""},
+		{"GET", "/cves?cpe=apache", "", http.StatusBadRequest, `cpe must match ^cpe:2\.3:`},
+		{"GET", "/search", "", http.StatusBadRequest, "q is required"},
+		{"GET", "/export?format=pdf", "", http.StatusBadRequest, ""},
+		{"GET", "/stats/severity?from=last-week", "", http.StatusBadRequest, `from must match ^\d{4}-\d{2}-\d{2}$`},
+		{"GET", "/annotations?status=fixed_maybe", "", http.StatusBadRequest, ""},
+		{"POST", "/cvss/calculate", "", http.StatusBadRequest, "invalid request: a JSON body is required"},
+		{"POST", "/cvss/calculate", `{"modifiers": "CR:H"}`, http.StatusBadRequest, "vector is required"},
+		{"POST", "/cvss/calculate", `{"vector": 3.1}`, http.StatusBadRequest, "vector must be a string"},
+		{"POST", "/cvss/calculate", `{"vector": `, http.StatusBadRequest, "invalid request: unexpected EOF"},
+		{"POST", "/subscriptions", `{"url": "https://example.com", "filter": {"scoreMin": "high"}}`, http.StatusBadRequest, "filter.scoreMin must be a number"},
+		{"PATCH", "/subscriptions/abc", `{"enabled": "yes"}`, http.StatusBadRequest, "enabled must be true or false"},
+		{"POST", "/assets", `{"name": "web", "team": "red", "components": [{"cpe": 1}]}`, http.StatusBadRequest, "components[0].cpe must be a string"},
+
+		// valid requests get through to their handlers, whatever they make of them
+		{"GET", "/cves?limit=10&kev=true&sort=-score&severity=high,critical", "", http.StatusOK, ""},
+		{"GET", "/cves?limit=", "", http.StatusOK, ""},
+		{"POST", "/cvss/calculate", `{"vector": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", "unknown": true, "modifiers": null}`, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		status, message := send(test.method, test.path, test.body)
+		assert.Equal(t, test.status, status, test.method+" "+test.path)
+		if test.message != "" {
+			assert.Equal(t, test.message, message, test.method+" "+test.path)
+		}
+	}
+
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+
+	"melaka/shared/purl"
+)
+
+// purlAffected reports whether an advisory's entry for a package covers the purl's version. A purl without a version
+// is taken to be affected, as we can't tell otherwise
+func purlAffected(p purl.Purl, affected OsvAffected) bool {
+
+	if !p.Is(affected.Package.Ecosystem, affected.Package.Name) {
+		return false
+	}
+
+	if p.Version == "" {
+		return true
+	}
+	for _, version := range affected.Versions {
+		if version == p.Version {
+			return true
+		}
+	}
+
+	for _, r := range affected.Ranges {
+		// git ranges are of commits, which we can't place a version among
+		if r.Type == "GIT" {
+			continue
+		}
+
+		type event struct {
+			kind, version string
+		}
+		events := []event{}
+		for _, e := range r.Events {
+			switch {
+			case e.Introduced != "":
+				events = append(events, event{"introduced", e.Introduced})
+			case e.Fixed != "":
+				events = append(events, event{"fixed", e.Fixed})
+			case e.LastAffected != "":
+				events = append(events, event{"last_affected", e.LastAffected})
+			case e.Limit != "":
+				events = append(events, event{"limit", e.Limit})
+			}
+		}
+
+		// OSV doesn't promise its events are in order, so we put them in order and replay them up to our version
+		sort.SliceStable(events, func(i, j int) bool {
+			return purl.CompareVersions(events[i].version, events[j].version) < 0
+		})
+
+		inRange := false
+		for _, e := range events {
+			c := purl.CompareVersions(p.Version, e.version)
+			switch e.kind {
+			case "introduced":
+				if e.version == "0" || c >= 0 {
+					inRange = true
+				}
+			case "fixed", "limit":
+				if c >= 0 {
+					inRange = false
+				}
+			case "last_affected":
+				if c > 0 {
+					inRange = false
+				}
+			}
+		}
+		if inRange {
+			return true
+		}
+	}
+
+	return false
+}
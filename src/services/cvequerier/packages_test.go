@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"melaka/shared/purl"
+)
+
+func TestPurlAffected_Replays_Range_Events(t *testing.T) {
+
+	var affected OsvAffected
+	data := `{"package": {"ecosystem": "Maven", "name": "org.apache.logging.log4j:log4j-core"},
+		"ranges": [{"type": "ECOSYSTEM", "events": [{"fixed": "2.3.1"}, {"introduced": "2.0-beta9"}, {"introduced": "2.4"}, {"fixed": "2.12.2"}]},
+			{"type": "GIT", "events": [{"introduced": "0"}]}],
+		"versions": ["2.0-beta8-patched"]}`
+	if err := json.Unmarshal([]byte(data), &affected); err != nil {
+		t.Fatal(err)
+	}
+
+	for version, expected := range map[string]bool{
+		"":                  true,
+		"2.0-beta8":         false,
+		"2.0-beta8-patched": true,
+		"2.0-beta9":         true,
+		"2.3":               true,
+		"2.3.1":             false,
+		"2.3.2":             false,
+		"2.11.0":            true,
+		"2.12.2":            false,
+		"2.17.1":            false,
+	} {
+		p := purl.Purl{Ecosystem: "Maven", Name: "org.apache.logging.log4j:log4j-core", Version: version}
+		assert.Equal(t, expected, purlAffected(p, affected), version)
+	}
+
+	assert.False(t, purlAffected(purl.Purl{Ecosystem: "npm", Name: "org.apache.logging.log4j:log4j-core"}, affected))
+
+}
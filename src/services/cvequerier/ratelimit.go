@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// how often the in-process limiter forgets clients whose buckets have filled back up
+const rateLimitSweepPeriod = time.Minute
+
+// the quota each address has for requests that fail to authenticate, alongside the quotas for each scope's routes
+const rateLimitAuthFailures = "auth-failures"
+
+// RateLimit is a quota of requests per period. Each client gets a token bucket holding that many requests, which
+// refills at an even rate over the period, so a client can burst up to the quota and then keep going at its rate
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// parseRateLimit reads a quota like 600/1m or 20/s. An empty quota, or one of 0 requests, is no limit at all
+func parseRateLimit(v string) (RateLimit, error) {
+
+	if v = strings.TrimSpace(v); v == "" {
+		return RateLimit{}, nil
+	}
+
+	count, period, ok := strings.Cut(v, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit must be given as requests/period, e.g. 600/1m")
+	}
+
+	requests, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || requests < 0 {
+		return RateLimit{}, fmt.Errorf("invalid number of requests in rate limit %s", v)
+	}
+
+	// allow 20/s as well as 20/1s
+	period = strings.TrimSpace(period)
+	if period != "" && !strings.ContainsAny(period[:1], "0123456789") {
+		period = "1" + period
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid period in rate limit %s", v)
+	}
+
+	return RateLimit{Requests: requests, Per: per}, nil
+}
+
+func (l RateLimit) unlimited() bool {
+	return l.Requests == 0
+}
+
+// rate is how many tokens the bucket gains a second
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// decide says what to tell a client whose bucket has the given tokens left after it asked for one
+func (l RateLimit) decide(tokens float64, allowed bool) RateDecision {
+
+	d := RateDecision{
+		Allowed:   allowed,
+		Limit:     l.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(l.Requests) - tokens) / l.rate() * float64(time.Second)),
+	}
+	if !allowed {
+		d.RetryAfter = time.Duration((1 - tokens) / l.rate() * float64(time.Second))
+	}
+
+	return d
+}
+
+// RateDecision is whether a request can go ahead, and what to tell the client about its quota
+type RateDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the client's bucket is full again
+	RetryAfter time.Duration // until the client can make another request, if this one wasn't allowed
+}
+
+// RateLimiter takes a token from a client's bucket, if there's one to take. Peek says whether there is one without
+// taking it
+type RateLimiter interface {
+	Take(key string, limit RateLimit) (RateDecision, error)
+	Peek(key string, limit RateLimit) (RateDecision, error)
+}
+
+// memoryRateLimiter keeps buckets in-process. Each replica counts separately, so a client gets its quota from each
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   RateLimit
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+func (m *memoryRateLimiter) Take(key string, limit RateLimit) (RateDecision, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Requests), updated: now}
+		m.buckets[key] = b
+	}
+	b.limit = limit
+
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+now.Sub(b.updated).Seconds()*limit.rate())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	return limit.decide(b.tokens, allowed), nil
+}
+
+func (m *memoryRateLimiter) Peek(key string, limit RateLimit) (RateDecision, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens := float64(limit.Requests)
+	if b, ok := m.buckets[key]; ok {
+		tokens = math.Min(tokens, b.tokens+m.now().Sub(b.updated).Seconds()*limit.rate())
+	}
+
+	return limit.decide(tokens, tokens >= 1), nil
+}
+
+// sweep drops the buckets that will have filled back up by now, as a full bucket is the same as none at all
+func (m *memoryRateLimiter) sweep(now time.Time) {
+
+	if now.Sub(m.lastSweep) < rateLimitSweepPeriod {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.rate() >= float64(b.limit.Requests) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// mongoRateLimiter keeps buckets in a collection, so that every replica counts against the same quota. Each take
+// is a single atomic update, refilling the bucket for the time since it was last touched and then taking from it
+type mongoRateLimiter struct {
+	collection *mongo.Collection
+	now        func() time.Time
+}
+
+func newMongoRateLimiter(collection *mongo.Collection) *mongoRateLimiter {
+
+	// buckets expire once they'd have filled back up, which is the same as there being none
+	expiry := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := collection.Indexes().CreateOne(context.TODO(), expiry); err != nil {
+		log.Printf("Failed to create TTL index on rate limits, idle clients' buckets won't be cleaned up: %s", err)
+	}
+
+	return &mongoRateLimiter{collection: collection, now: time.Now}
+}
+
+func (m *mongoRateLimiter) Take(key string, limit RateLimit) (RateDecision, error) {
+
+	now := m.now()
+	seconds := float64(now.UnixNano()) / float64(time.Second)
+	burst := float64(limit.Requests)
+
+	// the first stage refills the bucket, the second takes a token from it if there's one to take. Fields set in a
+	// stage aren't seen until the next, so allowed and tokens are both worked out from the refilled bucket
+	refilled := bson.D{{Key: "$min", Value: bson.A{burst, bson.D{{Key: "$add", Value: bson.A{
+		bson.D{{Key: "$ifNull", Value: bson.A{"$tokens", burst}}},
+		bson.D{{Key: "$multiply", Value: bson.A{
+			bson.D{{Key: "$max", Value: bson.A{0, bson.D{{Key: "$subtract", Value: bson.A{seconds, bson.D{{Key: "$ifNull", Value: bson.A{"$updated", seconds}}}}}}}}},
+			limit.rate(),
+		}}},
+	}}}}}}
+	hasToken := bson.D{{Key: "$gte", Value: bson.A{"$tokens", 1}}}
+	update := bson.A{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "tokens", Value: refilled},
+			{Key: "updated", Value: seconds},
+			{Key: "expires", Value: now.Add(limit.Per)},
+		}}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "allowed", Value: hasToken},
+			{Key: "tokens", Value: bson.D{{Key: "$cond", Value: bson.A{hasToken, bson.D{{Key: "$subtract", Value: bson.A{"$tokens", 1}}}, "$tokens"}}}},
+		}}},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var bucket struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	err := m.collection.FindOneAndUpdate(context.TODO(), bson.D{{Key: "_id", Value: key}}, update, opts).Decode(&bucket)
+	if err != nil {
+		return RateDecision{}, err
+	}
+
+	return limit.decide(bucket.Tokens, bucket.Allowed), nil
+}
+
+func (m *mongoRateLimiter) Peek(key string, limit RateLimit) (RateDecision, error) {
+
+	var bucket struct {
+		Tokens  float64 `bson:"tokens"`
+		Updated float64 `bson:"updated"`
+	}
+	tokens := float64(limit.Requests)
+	err := m.collection.FindOne(context.TODO(), bson.D{{Key: "_id", Value: key}}).Decode(&bucket)
+	if err == nil {
+		seconds := float64(m.now().UnixNano()) / float64(time.Second)
+		tokens = math.Min(tokens, bucket.Tokens+math.Max(0, seconds-bucket.Updated)*limit.rate())
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return RateDecision{}, err
+	}
+
+	return limit.decide(tokens, tokens >= 1), nil
+}
+
+// rateLimitClient says who a request counts against: the API key or token it was made with, or failing that the
+// address it came from
+func rateLimitClient(c *gin.Context) string {
+	if p := principal(c); p != nil {
+		return p.key
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// limitFailedAuth holds each address to a quota of requests that fail to authenticate, so keys can't be guessed at
+// any faster than the quota allows. It comes before authentication, which the per-client quotas come after, as
+// until a request has authenticated there's no client to count it against. Only failures count, and an address
+// that's run out is turned away before its request is authenticated at all
+func (s *Server) limitFailedAuth(c *gin.Context) {
+
+	limit := s.rateLimits[rateLimitAuthFailures]
+	if s.auth == nil || s.limiter == nil || limit.unlimited() {
+		c.Next()
+		return
+	}
+
+	key := rateLimitAuthFailures + "|ip:" + c.ClientIP()
+	decision, err := s.limiter.Peek(key, limit)
+	if err != nil {
+		log.Printf("Failed to check failed authentication limit for %s: %s", c.Request.URL.Path, err)
+	} else if !decision.Allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts to authenticate, try again later"})
+		return
+	}
+
+	c.Next()
+
+	if c.Writer.Status() == http.StatusUnauthorized {
+		if _, err := s.limiter.Take(key, limit); err != nil {
+			log.Printf("Failed to count failed authentication to %s: %s", c.Request.URL.Path, err)
+		}
+	}
+}
+
+// rateLimit holds each client to the quota configured for a group of routes, counting requests to the group
+// separately from the rest. Requests over quota get a 429 saying when to try again
+func (s *Server) rateLimit(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		limit := s.rateLimits[group]
+		if s.limiter == nil || limit.unlimited() {
+			c.Next()
+			return
+		}
+
+		decision, err := s.limiter.Take(group+"|"+rateLimitClient(c), limit)
+		if err != nil {
+			// we'd rather serve a request than refuse it because we couldn't count it
+			log.Printf("Failed to check rate limit for %s: %s", c.Request.URL.Path, err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(decision.Reset.Seconds()))))
+
+		if !decision.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded, try again later"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimit(t *testing.T) {
+
+	valid := map[string]RateLimit{
+		"":          {},
+		"0/s":       {Requests: 0, Per: time.Second},
+		"20/s":      {Requests: 20, Per: time.Second},
+		"600/1m":    {Requests: 600, Per: time.Minute},
+		" 100 / h ": {Requests: 100, Per: time.Hour},
+	}
+	for v, expected := range valid {
+		limit, err := parseRateLimit(v)
+		if assert.NoError(t, err, v) {
+			assert.Equal(t, expected, limit, v)
+		}
+	}
+
+	for _, v := range []string{"600", "x/s", "-1/s", "10/fortnight", "10/0s"} {
+		_, err := parseRateLimit(v)
+		assert.Error(t, err, v)
+	}
+
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+
+	now := time.Date(2023, 7, 13, 12, 0, 0, 0, time.UTC)
+	limiter := newMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := RateLimit{Requests: 3, Per: 3 * time.Second}
+
+	// a client can burst up to its quota
+	for remaining := 2; remaining >= 0; remaining-- {
+		d, _ := limiter.Take("alice", limit)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, remaining, d.Remaining)
+	}
+	d, _ := limiter.Take("alice", limit)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, time.Second, d.RetryAfter)
+	assert.Equal(t, 3*time.Second, d.Reset)
+
+	// peeking doesn't take a token
+	d, _ = limiter.Peek("bob", limit)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 3, d.Remaining)
+	d, _ = limiter.Peek("alice", limit)
+	assert.False(t, d.Allowed)
+
+	// other clients have their own buckets
+	d, _ = limiter.Take("bob", limit)
+	assert.True(t, d.Allowed)
+
+	// and the bucket refills at the quota's rate
+	now = now.Add(1500 * time.Millisecond)
+	d, _ = limiter.Take("alice", limit)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	limiter.Take("carol", limit)
+	assert.Len(t, limiter.buckets, 1)
+
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+	server.limiter = newMemoryRateLimiter()
+	server.rateLimits = map[string]RateLimit{ScopeRead: {Requests: 2, Per: time.Minute}}
+
+	do := func(method, path, addr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.RemoteAddr = addr
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := do("GET", "/cve/CVE-2021-44228", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "2", resp.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "30", resp.Header().Get("X-RateLimit-Reset"))
+
+	do("GET", "/cves", "10.0.0.1:1234")
+	resp = do("GET", "/cve/CVE-2021-44228", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "30", resp.Header().Get("Retry-After"))
+	assert.Equal(t, "0", resp.Header().Get("X-RateLimit-Remaining"))
+
+	// clients are counted separately, as are groups of routes
+	resp = do("GET", "/cve/CVE-2021-44228", "10.0.0.2:1234")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	resp = do("DELETE", "/annotations/missing", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Empty(t, resp.Header().Get("X-RateLimit-Limit"))
+
+	// authenticated clients are counted by their key, wherever they call from
+	server.auth = &AuthConfig{BootstrapKey: testBootstrapKey}
+	for i, addr := range []string{"10.0.0.3:1234", "10.0.0.4:1234", "10.0.0.5:1234"} {
+		req, _ := http.NewRequest("GET", "/cves", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("X-API-Key", testBootstrapKey)
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		if i < 2 {
+			assert.Equal(t, http.StatusOK, resp.Code)
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		}
+	}
+
+}
+
+func TestLimitFailedAuth(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+	server.auth = &AuthConfig{BootstrapKey: testBootstrapKey}
+	server.limiter = newMemoryRateLimiter()
+	server.rateLimits = map[string]RateLimit{rateLimitAuthFailures: {Requests: 2, Per: time.Minute}}
+
+	do := func(key, addr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/cve/CVE-2021-44228", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("X-API-Key", key)
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// requests that authenticate don't count
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, do(testBootstrapKey, "10.0.0.1:1234").Code)
+	}
+
+	// an address gets as many failures as its quota allows, then is turned away even with a good key
+	assert.Equal(t, http.StatusUnauthorized, do("guess-1", "10.0.0.1:1234").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("guess-2", "10.0.0.1:1234").Code)
+	resp := do(testBootstrapKey, "10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "30", resp.Header().Get("Retry-After"))
+
+	// other addresses are counted separately
+	assert.Equal(t, http.StatusUnauthorized, do("guess-3", "10.0.0.2:1234").Code)
+	assert.Equal(t, http.StatusOK, do(testBootstrapKey, "10.0.0.2:1234").Code)
+
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,6 +21,24 @@ type Runnable interface {
 type Server struct {
 	db     DBConnector
 	router *gin.Engine
+	stats  *ttlCache
+
+	// who the CSAF documents we respond with say they're from
+	csafPublisher CsafPublisher
+
+	// how callers authenticate. Without it every route is open
+	auth *AuthConfig
+
+	// the quota each client has for each group of routes, and where their usage is counted. Without a limiter
+	// there are no limits
+	limiter    RateLimiter
+	rateLimits map[string]RateLimit
+
+	// how much work a GraphQL query can ask for
+	graphqlLimits GraphqlLimits
+
+	// resolves the hosts of subscriptions' webhook URLs, to check they're not internal addresses
+	lookupHost func(ctx context.Context, host string) ([]net.IPAddr, error)
 }
 
 func (s *Server) Run(addr string) error {
@@ -34,11 +58,70 @@ func buildServer(database DBConnector) *Server {
 	var s Server = Server{
 		db:     database,
 		router: engine,
+		stats:  newTTLCache(defaultStatsTTL),
+
+		csafPublisher: defaultCsafPublisher,
+		graphqlLimits: GraphqlLimits{MaxDepth: defaultGraphqlMaxDepth, MaxComplexity: defaultGraphqlMaxComplexity},
+		lookupHost:    net.DefaultResolver.LookupIPAddr,
 	}
 
-	// map routes
-	engine.GET("/cve/:id", s.getCve)
+	// map routes, grouped by the scope they need and rate limited as a group, with requests validated against our
+	// OpenAPI document. Failed attempts to authenticate are limited by address ahead of all that. /metrics is left
+	// open for prometheus to scrape, and the document and its docs for anyone to read
+	reader := engine.Group("", s.limitFailedAuth, s.requireScope(ScopeRead), s.rateLimit(ScopeRead), s.validateRequest)
+	reader.GET("/cve/:id", s.getCve)
+	reader.GET("/cve/:id/epss", s.getEpssHistory)
+	reader.GET("/cve/:id/changes", s.getCveChanges)
+	reader.GET("/cves", s.searchCves)
+	reader.GET("/search", s.searchText)
+	reader.GET("/export", s.exportCves)
+	reader.GET("/feeds/cves.atom", s.getFeed("atom"))
+	reader.GET("/feeds/cves.rss", s.getFeed("rss"))
+	reader.GET("/cwe/:id", s.getCwe)
+	reader.GET("/cwe/:id/cves", s.getCweCves)
+	reader.POST("/cvss/calculate", s.calculateCvss)
+	reader.GET("/cpes", s.searchCpes)
+	reader.GET("/stats/published", s.getPublishedStats)
+	reader.GET("/stats/severity", s.getSeverityStats)
+	reader.GET("/stats/status", s.getStatusStats)
+	reader.GET("/stats/cwes", s.getCweStats)
+	reader.GET("/stats/vendors", s.getVendorStats)
+	reader.GET("/stats/products", s.getProductStats)
+	reader.GET("/stats/analysis-time", s.getAnalysisTimeStats)
+	reader.GET("/assets", s.getAssets)
+	reader.GET("/assets/:id", s.getAsset)
+	reader.GET("/assets/:id/vulnerabilities", s.getAssetVulnerabilities)
+	reader.GET("/teams/:team/exposure", s.getTeamExposure)
+	reader.GET("/annotations", s.getAnnotations)
+	reader.GET("/annotations/:id", s.getAnnotation)
+	reader.GET("/vex/openvex", s.exportOpenVex)
+	reader.GET("/auth/whoami", s.whoami)
+	reader.GET("/graphql", s.graphql)
+	reader.POST("/graphql", s.graphql)
+
+	annotator := engine.Group("", s.limitFailedAuth, s.requireScope(ScopeAnnotate), s.rateLimit(ScopeAnnotate), s.validateRequest)
+	annotator.POST("/annotations", s.createAnnotation)
+	annotator.PUT("/annotations/:id", s.updateAnnotation)
+	annotator.DELETE("/annotations/:id", s.deleteAnnotation)
+
+	admin := engine.Group("", s.limitFailedAuth, s.requireScope(ScopeAdmin), s.rateLimit(ScopeAdmin), s.validateRequest)
+	admin.POST("/subscriptions", s.createSubscription)
+	admin.GET("/subscriptions", s.getSubscriptions)
+	admin.GET("/subscriptions/:id", s.getSubscription)
+	admin.PATCH("/subscriptions/:id", s.updateSubscription)
+	admin.DELETE("/subscriptions/:id", s.deleteSubscription)
+	admin.GET("/subscriptions/:id/deliveries", s.getDeliveries)
+	admin.POST("/assets", s.createAsset)
+	admin.PUT("/assets/:id", s.updateAsset)
+	admin.DELETE("/assets/:id", s.deleteAsset)
+	admin.POST("/auth/keys", s.createApiKey)
+	admin.GET("/auth/keys", s.getApiKeys)
+	admin.DELETE("/auth/keys/:id", s.revokeApiKey)
+
 	engine.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	engine.GET("/openapi.json", s.getOpenApi)
+	engine.GET("/docs", s.getDocs)
+	engine.GET("/docs/redoc.standalone.js", s.getDocsScript)
 
 	return &s
 
@@ -50,10 +133,424 @@ func (s *Server) getCve(c *gin.Context) {
 	log.Printf("CVE %s requested", id)
 
 	cve, err := s.db.GetCveFromID(id)
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "CVE not found"})
+		return
+	}
 	if err != nil {
 		// TODO need to add some error middleware to our API
-		log.Printf("Instantiation of db connection failed: %s", err)
+		log.Printf("Failed to fetch CVE %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch CVE"})
+		return
 	}
-	c.IndentedJSON(http.StatusOK, cve)
+
+	advisories, err := s.db.GetAdvisories(id)
+	if err != nil {
+		log.Printf("Failed to fetch advisories for %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch CVE"})
+		return
+	}
+
+	// every distro's statuses are included, unless the request narrows them down to some
+	var distros []string
+	if v := c.Query("distro"); v != "" {
+		if distros, err = parseDistros(v); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	statuses, err := s.db.GetDistroStatuses(id, distros)
+	if err != nil {
+		log.Printf("Failed to fetch distro statuses for %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch CVE"})
+		return
+	}
+
+	// a client that already has the CVE as it stands doesn't need us to merge anything into it again
+	if etag, lastModified, err := cveValidators(c, cve, advisories, statuses); err != nil {
+		log.Printf("Failed to work out the ETag of CVE %s: %s", id, err)
+	} else if notModified(c, etag, lastModified) {
+		return
+	}
+
+	s.addWeaknessNames([]*CveMsg{cve})
+	mergeAdvisories(cve, advisories)
+	cve.DistroStatus = statuses
+
+	if env := c.Query("env"); env != "" {
+		if err := addEnvironmentalScores(cve, env); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	s.respondWithCves(c, cve, []*CveMsg{cve})
+
+}
+
+// the media types CVEs can be asked for in, besides our own JSON
+const (
+	mimeStix = "application/stix+json"
+	mimeCsaf = "application/csaf+json"
+)
+
+// respondWithCves writes out CVEs in whichever format the request's Accept header asks for. Our own JSON responses
+// are given by body, while STIX bundles and CSAF documents describe the CVEs themselves. A lone CVE gets a CSAF
+// document tracked under its own ID
+func (s *Server) respondWithCves(c *gin.Context, body interface{}, cves []*CveMsg) {
+
+	switch c.NegotiateFormat(gin.MIMEJSON, mimeStix, mimeCsaf) {
+	case mimeStix:
+		c.Header("Content-Type", mimeStix+";version="+stixSpecVersion)
+		c.IndentedJSON(http.StatusOK, newStixBundle(cves))
+	case mimeCsaf:
+		c.Header("Content-Type", mimeCsaf)
+		if cve, ok := body.(*CveMsg); ok {
+			c.IndentedJSON(http.StatusOK, newCveCsafDocument(s.csafPublisher, cve))
+		} else {
+			c.IndentedJSON(http.StatusOK, newSearchCsafDocument(s.csafPublisher, cves))
+		}
+	default:
+		c.IndentedJSON(http.StatusOK, body)
+	}
+
+}
+
+func (s *Server) calculateCvss(c *gin.Context) {
+
+	var req CvssRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	scores, err := calculateScores(req.Vector, req.Modifiers)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, scores)
+
+}
+
+func (s *Server) searchCves(c *gin.Context) {
+
+	filter, err := parseCveFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.respondWithSearch(c, filter)
+
+}
+
+// respondWithSearch runs a CVE search and writes out a page of its results
+func (s *Server) respondWithSearch(c *gin.Context, filter CveFilter) {
+
+	if err := resolveFilter(s.db, &filter); err != nil {
+		log.Printf("Failed to resolve search filters: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "CVE search failed"})
+		return
+	}
+
+	cves, err := s.db.SearchCves(filter)
+	if err != nil {
+		log.Printf("CVE search failed: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "CVE search failed"})
+		return
+	}
+
+	results := make([]*CveMsg, len(cves))
+	for i := range cves {
+		results[i] = &cves[i]
+	}
+	s.addWeaknessNames(results)
+
+	s.respondWithCves(c, gin.H{
+		"results": cves,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	}, results)
+
+}
+
+// resolveFilter looks up the match criteria a search's CPE falls under, as CVEs' configurations refer to them by ID,
+// and the CVEs its distro filters cover, as distros' statuses are kept apart from the CVEs' records
+func resolveFilter(db DBConnector, filter *CveFilter) error {
+	if filter.Cpe != "" {
+		ids, err := db.GetMatchCriteriaIDs(filter.Cpe)
+		if err != nil {
+			return fmt.Errorf("failed to resolve CPE %s: %w", filter.Cpe, err)
+		}
+		filter.MatchCriteriaIDs = ids
+	}
+	if filter.hasDistroFilter() {
+		ids, err := db.GetDistroCveIDs(filter.Distros, filter.DistroStatuses)
+		if err != nil {
+			return fmt.Errorf("failed to resolve distro statuses: %w", err)
+		}
+		filter.DistroCveIDs = ids
+	}
+	return nil
+}
+
+// searchText runs a free text search over CVEs' descriptions, reference URLs and products, best match first. It
+// takes the same filters as /cves, but always ranks its results by relevance
+func (s *Server) searchText(c *gin.Context) {
+
+	q := strings.TrimSpace(c.Query("q"))
+	if len(queryTerms(q)) == 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "q must have some words to search for"})
+		return
+	}
+	if c.Query("sort") != "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "search results are ranked by relevance, and can't be sorted"})
+		return
+	}
+
+	filter, err := parseCveFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := resolveFilter(s.db, &filter); err != nil {
+		log.Printf("Failed to resolve search filters: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
+		return
+	}
+
+	results, err := s.db.SearchText(q, filter)
+	if errors.Is(err, ErrIndexNotReady) {
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Text search for %q failed: %s", q, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
+		return
+	}
+
+	cves := make([]*CveMsg, len(results))
+	for i := range results {
+		cves[i] = &results[i].CveMsg
+		results[i].Highlights = highlight(q, results[i].Search)
+	}
+	s.addWeaknessNames(cves)
+
+	s.respondWithCves(c, gin.H{
+		"results": results,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+	}, cves)
+
+}
+
+func (s *Server) getEpssHistory(c *gin.Context) {
+
+	id := c.Param("id")
+
+	history, err := s.db.GetEpssHistory(id)
+	if err != nil {
+		log.Printf("Failed to fetch EPSS history for %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch EPSS history"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, history)
+
+}
+
+// getCveChanges returns NVD's change log for a CVE, oldest change first
+func (s *Server) getCveChanges(c *gin.Context) {
+
+	id := c.Param("id")
+
+	changes, err := s.db.GetCveChanges(id)
+	if err != nil {
+		log.Printf("Failed to fetch changes to %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch CVE changes"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, changes)
+
+}
+
+// addWeaknessNames looks up the names of every CWE referenced by the given CVEs in one go, and attaches them
+// to each. We'd rather respond without names than fail the request, so lookup errors are only logged
+func (s *Server) addWeaknessNames(cves []*CveMsg) {
+
+	ids := []string{}
+	for _, cve := range cves {
+		ids = append(ids, cve.WeaknessIDs()...)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	cwes, err := s.db.GetCwes(ids)
+	if err != nil {
+		log.Printf("Failed to look up weakness names: %s", err)
+		return
+	}
+
+	names := make(map[string]string, len(cwes))
+	for _, cwe := range cwes {
+		names[cwe.ID] = cwe.Name
+	}
+
+	for _, cve := range cves {
+		for _, id := range cve.WeaknessIDs() {
+			if name, ok := names[id]; ok {
+				if cve.WeaknessNames == nil {
+					cve.WeaknessNames = map[string]string{}
+				}
+				cve.WeaknessNames[id] = name
+			}
+		}
+	}
+
+}
+
+func (s *Server) getCwe(c *gin.Context) {
+
+	id := normalizeCweID(c.Param("id"))
+
+	cwe, err := s.db.GetCwe(id)
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "CWE not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch CWE %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch CWE"})
+		return
+	}
+
+	parents, err := s.db.GetCwes(cwe.Parents)
+	if err != nil {
+		log.Printf("Failed to fetch parents of CWE %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch CWE"})
+		return
+	}
+
+	children, err := s.db.GetCweChildren(id)
+	if err != nil {
+		log.Printf("Failed to fetch children of CWE %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch CWE"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, CweDetail{
+		ID:          cwe.ID,
+		Type:        cwe.Type,
+		Name:        cwe.Name,
+		Description: cwe.Description,
+		Abstraction: cwe.Abstraction,
+		Status:      cwe.Status,
+		Parents:     toCweRefs(parents),
+		Children:    toCweRefs(children),
+	})
+
+}
+
+func toCweRefs(cwes []Cwe) []CweRef {
+	refs := make([]CweRef, len(cwes))
+	for i, cwe := range cwes {
+		refs[i] = CweRef{ID: cwe.ID, Name: cwe.Name}
+	}
+	return refs
+}
+
+// getCweCves searches for CVEs with the given weakness, optionally including any of its descendants in the hierarchy
+func (s *Server) getCweCves(c *gin.Context) {
+
+	filter, err := parseCveFilter(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	descendants := false
+	if v := c.Query("descendants"); v != "" {
+		if descendants, err = strconv.ParseBool(v); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid value for descendants: " + v})
+			return
+		}
+	}
+
+	id := normalizeCweID(c.Param("id"))
+	filter.Cwes = []string{id}
+
+	if descendants {
+		if filter.Cwes, err = s.cweDescendants(id); err != nil {
+			log.Printf("Failed to fetch descendants of CWE %s: %s", id, err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch CWE descendants"})
+			return
+		}
+	}
+
+	s.respondWithSearch(c, filter)
+
+}
+
+// cweDescendants walks down the hierarchy breadth first, returning the given CWE and everything beneath it
+func (s *Server) cweDescendants(id string) ([]string, error) {
+
+	seen := map[string]bool{id: true}
+	ids := []string{id}
+
+	for i := 0; i < len(ids); i++ {
+		children, err := s.db.GetCweChildren(ids[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			// the hierarchy is a graph rather than a tree, so a CWE can be reached along more than one path
+			if !seen[child.ID] {
+				seen[child.ID] = true
+				ids = append(ids, child.ID)
+			}
+		}
+	}
+
+	return ids, nil
+
+}
+
+// searchCpes suggests products from the CPE dictionary for a partial name, for autocomplete
+func (s *Server) searchCpes(c *gin.Context) {
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit := defaultCpeLimit
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxCpeLimit {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxCpeLimit)})
+			return
+		}
+	}
+
+	cpes, err := s.db.SearchCpes(q, limit)
+	if err != nil {
+		log.Printf("CPE search for %s failed: %s", q, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "CPE search failed"})
+		return
+	}
+
+	suggestions := make([]CpeSuggestion, len(cpes))
+	for i, cpe := range cpes {
+		suggestions[i] = CpeSuggestion{CpeName: cpe.CpeName, CpeNameID: cpe.CpeNameID, Title: cpe.Title()}
+	}
+
+	c.IndentedJSON(http.StatusOK, suggestions)
 
 }
@@ -1,28 +1,508 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 // Create a type that implements DBConnector so we can mock our db requests
-type MockDatabase struct{}
+type MockDatabase struct {
+	lastFilter CveFilter
+	lastRange  DateRange
+	statsCalls int
+
+	packageLookups [][]string
+	advisories     []Advisory // in place of the usual mock advisories, if set
+
+	subscriptions map[string]Subscription
+	assets        map[string]Asset
+	annotations   map[string]Annotation
+	apiKeys       map[string]ApiKey
+}
 
 func (m *MockDatabase) Connect() error {
 	return nil
 }
 
-func (m *MockDatabase) GetCveFromID(id string) (interface{}, error) {
-	return CveMsg{CveData: NvdCveData{ID: id}}, nil
+func (m *MockDatabase) GetCveFromID(id string) (*CveMsg, error) {
+	if id == missingCveID {
+		return nil, ErrNotFound
+	}
+	cve := cveWithWeakness(id, "CWE-79")
+	return &cve, nil
+}
+
+func (m *MockDatabase) SearchCves(filter CveFilter) ([]CveMsg, error) {
+	m.lastFilter = filter
+	return []CveMsg{{CveData: NvdCveData{ID: "CVE-0000-0000"}}}, nil
+}
+
+var mockExportCves = []CveMsg{
+	{
+		CveData: NvdCveData{
+			ID:           "CVE-2021-44228",
+			Published:    "2021-12-10T10:15:09.143",
+			Descriptions: []LangString{{Lang: "es", Value: "Apache Log4j2 ..."}, {Lang: "en", Value: "Apache Log4j2 <=2.14.1 JNDI features, \"lookups\""}},
+			Weaknesses:   []Weakness{{Description: []LangString{{Lang: "en", Value: "CWE-502"}, {Lang: "en", Value: "CWE-400"}}}},
+			References:   []Reference{{URL: "https://logging.apache.org/log4j/2.x/security.html", Tags: []string{"Vendor Advisory"}}},
+			Configurations: []Configuration{{Nodes: []Node{{CpeMatch: []CpeMatch{
+				{Vulnerable: true, Criteria: "cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*", VersionStartIncluding: "2.0.1", VersionEndExcluding: "2.15.0"},
+				{Vulnerable: false, Criteria: "cpe:2.3:o:linux:linux_kernel:-:*:*:*:*:*:*:*"},
+			}}}}},
+		},
+		PreferredScore: &PreferredScore{BaseScore: 10, BaseSeverity: "CRITICAL"},
+		Kev:            &KevData{DateAdded: "2021-12-10"},
+	},
+	{CveData: NvdCveData{ID: "CVE-2023-0001", Published: "2023-01-01T00:00:00.000"}},
+}
+
+func (m *MockDatabase) ExportCves(ctx context.Context, filter CveFilter, each func(cve *CveMsg) error) error {
+	m.lastFilter = filter
+	for i := range mockExportCves {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := each(&mockExportCves[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MockDatabase) CreateSubscription(sub Subscription) error {
+	if m.subscriptions == nil {
+		m.subscriptions = map[string]Subscription{}
+	}
+	m.subscriptions[sub.ID] = sub
+	return nil
+}
+
+func (m *MockDatabase) GetSubscriptions() ([]Subscription, error) {
+	subs := []Subscription{}
+	for _, sub := range m.subscriptions {
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func (m *MockDatabase) GetSubscription(id string) (*Subscription, error) {
+	sub, ok := m.subscriptions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &sub, nil
+}
+
+func (m *MockDatabase) SetSubscriptionEnabled(id string, enabled bool) (*Subscription, error) {
+	sub, ok := m.subscriptions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	sub.Enabled, sub.ConsecutiveFailures, sub.DisabledAt, sub.DisabledReason = enabled, 0, "", ""
+	m.subscriptions[id] = sub
+	return &sub, nil
+}
+
+func (m *MockDatabase) DeleteSubscription(id string) error {
+	if _, ok := m.subscriptions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.subscriptions, id)
+	return nil
+}
+
+func (m *MockDatabase) GetDeliveries(subscriptionID string, limit int) ([]Delivery, error) {
+	return []Delivery{{
+		ID:             "delivery-1",
+		SubscriptionID: subscriptionID,
+		CveID:          "CVE-2021-44228",
+		Event:          "created",
+		Status:         "delivered",
+		Attempts:       []DeliveryAttempt{{At: "2023-07-13T00:00:00Z", StatusCode: 500}, {At: "2023-07-13T00:00:01Z", StatusCode: 200}},
+	}}, nil
+}
+
+func (m *MockDatabase) CreateAsset(asset Asset) error {
+	if m.assets == nil {
+		m.assets = map[string]Asset{}
+	}
+	m.assets[asset.ID] = asset
+	return nil
+}
+
+func (m *MockDatabase) GetAssets(team string) ([]Asset, error) {
+	assets := []Asset{}
+	for _, asset := range m.assets {
+		if team == "" || asset.Team == team {
+			assets = append(assets, asset)
+		}
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Name < assets[j].Name })
+	return assets, nil
+}
+
+func (m *MockDatabase) GetAsset(id string) (*Asset, error) {
+	asset, ok := m.assets[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &asset, nil
+}
+
+func (m *MockDatabase) UpdateAsset(asset Asset) error {
+	if _, ok := m.assets[asset.ID]; !ok {
+		return ErrNotFound
+	}
+	m.assets[asset.ID] = asset
+	return nil
+}
+
+func (m *MockDatabase) DeleteAsset(id string) error {
+	if _, ok := m.assets[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.assets, id)
+	return nil
+}
+
+func (m *MockDatabase) CreateAnnotation(annotation Annotation) error {
+	if m.annotations == nil {
+		m.annotations = map[string]Annotation{}
+	}
+	for _, a := range m.annotations {
+		if a.Asset == annotation.Asset && a.Product == annotation.Product && a.CveID == annotation.CveID {
+			return ErrConflict
+		}
+	}
+	m.annotations[annotation.ID] = annotation
+	return nil
+}
+
+func (m *MockDatabase) GetAnnotations(filter AnnotationFilter) ([]Annotation, error) {
+	annotations := []Annotation{}
+	for _, a := range m.annotations {
+		subject := filter.Asset == "" && len(filter.Products) == 0
+		if filter.Asset != "" && a.Asset == filter.Asset {
+			subject = true
+		}
+		for _, product := range filter.Products {
+			if a.Product == product {
+				subject = true
+			}
+		}
+		if subject && (filter.CveID == "" || a.CveID == filter.CveID) && (filter.Status == "" || a.Status == filter.Status) {
+			annotations = append(annotations, a)
+		}
+	}
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].CveID+annotations[i].ID < annotations[j].CveID+annotations[j].ID
+	})
+	return annotations, nil
+}
+
+func (m *MockDatabase) GetAnnotation(id string) (*Annotation, error) {
+	annotation, ok := m.annotations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &annotation, nil
+}
+
+func (m *MockDatabase) UpdateAnnotation(annotation Annotation) error {
+	if _, ok := m.annotations[annotation.ID]; !ok {
+		return ErrNotFound
+	}
+	m.annotations[annotation.ID] = annotation
+	return nil
+}
+
+func (m *MockDatabase) DeleteAnnotation(id string) error {
+	if _, ok := m.annotations[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.annotations, id)
+	return nil
+}
+
+func (m *MockDatabase) CreateApiKey(key ApiKey) error {
+	if m.apiKeys == nil {
+		m.apiKeys = map[string]ApiKey{}
+	}
+	m.apiKeys[key.ID] = key
+	return nil
+}
+
+func (m *MockDatabase) GetApiKeys() ([]ApiKey, error) {
+	keys := []ApiKey{}
+	for _, key := range m.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+func (m *MockDatabase) GetApiKey(id string) (*ApiKey, error) {
+	key, ok := m.apiKeys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &key, nil
+}
+
+func (m *MockDatabase) RevokeApiKey(id string, revokedAt string) error {
+	key, ok := m.apiKeys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if key.RevokedAt == "" {
+		key.RevokedAt = revokedAt
+	}
+	m.apiKeys[id] = key
+	return nil
+}
+
+func (m *MockDatabase) GetEpssHistory(id string) ([]EpssData, error) {
+	return []EpssData{{Cve: id, Score: 0.5, Percentile: 0.9, Date: "2023-07-13"}}, nil
+}
+
+func (m *MockDatabase) GetEpssHistoryForIDs(ids []string) ([]EpssData, error) {
+	history := []EpssData{}
+	for _, id := range ids {
+		h, _ := m.GetEpssHistory(id)
+		history = append(history, h...)
+	}
+	return history, nil
+}
+
+func (m *MockDatabase) GetCveChangesForIDs(ids []string) ([]CveChange, error) {
+	changes := []CveChange{}
+	for _, id := range ids {
+		c, _ := m.GetCveChanges(id)
+		changes = append(changes, c...)
+	}
+	return changes, nil
+}
+
+func (m *MockDatabase) GetCveChanges(id string) ([]CveChange, error) {
+	var changes []CveChange
+	data := `[{"cveId": "` + id + `", "eventName": "Initial Analysis", "cveChangeId": "1", "sourceIdentifier": "nvd@nist.gov", "created": "2021-12-10T10:15:09.143",
+		"details": [{"action": "Added", "type": "CPE Configuration", "newValue": "OR *cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*"}]},
+		{"cveId": "` + id + `", "eventName": "CVE Reanalysis", "cveChangeId": "2", "sourceIdentifier": "nvd@nist.gov", "created": "2022-02-01T09:00:00.000"}]`
+	if err := json.Unmarshal([]byte(data), &changes); err != nil {
+		panic(err)
+	}
+	return changes, nil
+}
+
+var mockDistroStatuses = []DistroStatus{
+	{Cve: "CVE-0000-0000", Distro: "debian", Release: "bookworm", Package: "openssl", Status: "fixed", FixedVersion: "3.0.8-1"},
+	{Cve: "CVE-0000-0000", Distro: "ubuntu", Release: "jammy", Package: "openssl", Status: "not-affected"},
+}
+
+func (m *MockDatabase) GetDistroStatuses(id string, distros []string) ([]DistroStatus, error) {
+	statuses := []DistroStatus{}
+	for _, status := range mockDistroStatuses {
+		for _, distro := range distros {
+			if status.Distro == distro {
+				statuses = append(statuses, status)
+			}
+		}
+		if len(distros) == 0 {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+func (m *MockDatabase) GetDistroCveIDs(distros []string, statuses []string) ([]string, error) {
+	matches := func(list []string, v string) bool {
+		for _, item := range list {
+			if item == v {
+				return true
+			}
+		}
+		return len(list) == 0
+	}
+	ids, seen := []string{}, map[string]bool{}
+	for _, status := range mockDistroStatuses {
+		if matches(distros, status.Distro) && matches(statuses, status.Status) && !seen[status.Cve] {
+			ids, seen[status.Cve] = append(ids, status.Cve), true
+		}
+	}
+	return ids, nil
+}
+
+func (m *MockDatabase) GetAdvisories(cveID string) ([]Advisory, error) {
+	if m.advisories != nil {
+		return m.advisories, nil
+	}
+	var advisories []Advisory
+	data := `[{"source": "GHSA", "osvdata": {"id": "GHSA-jfh8-c2jp-5v3q", "aliases": ["` + cveID + `"],
+		"severity": [{"type": "CVSS_V3", "score": "` + mockVector + `"}],
+		"affected": [{"package": {"ecosystem": "Maven", "name": "org.apache.logging.log4j:log4j-core"},
+			"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "2.0-beta9"}, {"fixed": "2.3.1"}]}]}],
+		"database_specific": {"severity": "CRITICAL"}}},
+		{"source": "OSV", "osvdata": {"id": "GO-0000-0000", "aliases": ["` + cveID + `"], "withdrawn": "2022-01-01T00:00:00Z"}}]`
+	if err := json.Unmarshal([]byte(data), &advisories); err != nil {
+		panic(err)
+	}
+	return advisories, nil
+}
+
+func (m *MockDatabase) GetPackageAdvisories(packages []string) ([]Advisory, error) {
+	m.packageLookups = append(m.packageLookups, packages)
+	for _, key := range packages {
+		if key == "maven/org.apache.logging.log4j:log4j-core" {
+			return m.GetAdvisories("CVE-2021-44228")
+		}
+	}
+	return []Advisory{}, nil
+}
+
+func (m *MockDatabase) GetCvesFromIDs(ids []string) ([]CveMsg, error) {
+	cves := []CveMsg{}
+	for _, cve := range mockExportCves {
+		for _, id := range ids {
+			if cve.CveData.ID == id {
+				cves = append(cves, cve)
+			}
+		}
+	}
+	return cves, nil
+}
+
+func (m *MockDatabase) SearchText(q string, filter CveFilter) ([]SearchResult, error) {
+	m.lastFilter = filter
+	cve := cveWithWeakness("CVE-2020-36518", "CWE-787")
+	cve.Search = &SearchText{
+		Description: "jackson-databind before 2.13.0 allows a Java StackOverflow exception and denial of service via a large depth of nested objects.",
+		References:  []string{"https://github.com/FasterXML/jackson-databind/issues/2816"},
+		Products:    []string{"fasterxml jackson-databind", "oracle weblogic server"},
+	}
+	return []SearchResult{{CveMsg: cve, Score: 1.5}}, nil
+}
+
+func (m *MockDatabase) CountPublishedByMonth(r DateRange) ([]StatsCount, error) {
+	m.lastRange = r
+	m.statsCalls++
+	return []StatsCount{{Key: "2023-06", Count: 2310}, {Key: "2023-07", Count: 2404}}, nil
+}
+
+func (m *MockDatabase) CountBySeverity(r DateRange) ([]StatsCount, error) {
+	return []StatsCount{{Key: "HIGH", Count: 12}, {Key: "UNSCORED", Count: 3}}, nil
+}
+
+func (m *MockDatabase) CountByStatus(r DateRange) ([]StatsCount, error) {
+	return []StatsCount{{Key: "Analyzed", Count: 40}, {Key: "Awaiting Analysis", Count: 9}}, nil
+}
+
+func (m *MockDatabase) TopCwes(r DateRange, limit int) ([]StatsCount, error) {
+	return []StatsCount{{Key: "CWE-79", Count: 120}, {Key: "CWE-80", Count: 7}}[:limit], nil
+}
+
+func (m *MockDatabase) TopVendors(r DateRange, limit int) ([]StatsCount, error) {
+	return []StatsCount{{Key: "apache", Count: 30}}, nil
+}
+
+func (m *MockDatabase) TopProducts(r DateRange, limit int) ([]StatsCount, error) {
+	return []StatsCount{{Key: "apache:log4j", Count: 5}}, nil
+}
+
+func (m *MockDatabase) GetAnalysisTime(r DateRange) (*AnalysisTime, error) {
+	return &AnalysisTime{AverageDays: 3.5, Count: 2}, nil
+}
+
+// a small slice of the CWE hierarchy: CWE-74 -> CWE-79 -> CWE-80
+var mockCwes = []Cwe{
+	{ID: "CWE-74", Name: "Injection", Parents: []string{}},
+	{ID: "CWE-79", Name: "Cross-site Scripting", Parents: []string{"CWE-74"}},
+	{ID: "CWE-80", Name: "Basic XSS", Parents: []string{"CWE-79"}},
+}
+
+func (m *MockDatabase) GetCwe(id string) (*Cwe, error) {
+	for _, cwe := range mockCwes {
+		if cwe.ID == id {
+			return &cwe, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MockDatabase) GetCwes(ids []string) ([]Cwe, error) {
+	cwes := []Cwe{}
+	for _, cwe := range mockCwes {
+		for _, id := range ids {
+			if cwe.ID == id {
+				cwes = append(cwes, cwe)
+			}
+		}
+	}
+	return cwes, nil
+}
+
+func (m *MockDatabase) GetCweChildren(id string) ([]Cwe, error) {
+	cwes := []Cwe{}
+	for _, cwe := range mockCwes {
+		for _, parent := range cwe.Parents {
+			if parent == id {
+				cwes = append(cwes, cwe)
+			}
+		}
+	}
+	return cwes, nil
+}
+
+// the one product in our mock CPE dictionary, and the match criteria that expands to it
+const mockCpeName = "cpe:2.3:a:apache:log4j:2.14.1:*:*:*:*:*:*:*"
+const mockMatchCriteriaID = "D31D423D-FC4D-428A-B863-55AF472B80DC"
+
+func (m *MockDatabase) SearchCpes(q string, limit int) ([]Cpe, error) {
+	var cpe Cpe
+	data := `{"cpeName": "` + mockCpeName + `", "cpeNameId": "1", "titles": [{"title": "Apache Log4j 2.14.1", "lang": "en"}]}`
+	if err := json.Unmarshal([]byte(data), &cpe); err != nil {
+		panic(err)
+	}
+	return []Cpe{cpe}, nil
+}
+
+func (m *MockDatabase) GetMatchCriteriaIDs(cpeName string) ([]string, error) {
+	if cpeName == mockCpeName {
+		return []string{mockMatchCriteriaID}, nil
+	}
+	return []string{}, nil
 }
 
 func (m *MockDatabase) GetMetaDoc(createIfMissing bool) (interface{}, error) {
 	return nil, nil
 }
 
+const missingCveID = "CVE-0000-0404"
+
+const mockVector = "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"
+
+func cveWithWeakness(id string, cweID string) CveMsg {
+	var cve CveMsg
+	data := `{"cvedata": {"id": "` + id + `", "weaknesses": [{"description": [{"lang": "en", "value": "` + cweID + `"}]}],
+		"metrics": {"cvssMetricV31": [{"cvssData": {"vectorString": "` + mockVector + `"}}]}}}`
+	if err := json.Unmarshal([]byte(data), &cve); err != nil {
+		panic(err)
+	}
+	return cve
+}
+
 func TestCveGetHandler(t *testing.T) {
 
 	m := &MockDatabase{}
@@ -43,3 +523,1281 @@ func TestCveGetHandler(t *testing.T) {
 	assert.Contains(t, resp.Body.String(), id)
 
 }
+
+func TestCveSearchHandler_Parses_Kev_Filter(t *testing.T) {
+
+	m := &MockDatabase{}
+
+	server := buildServer(m)
+
+	req, err := http.NewRequest("GET", "/cves?kev=true&limit=10", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "CVE-0000-0000")
+	if assert.NotNil(t, m.lastFilter.Kev) {
+		assert.True(t, *m.lastFilter.Kev)
+	}
+	assert.Equal(t, 10, m.lastFilter.Limit)
+
+}
+
+func TestCveSearchHandler_Rejects_Invalid_Filter(t *testing.T) {
+
+	m := &MockDatabase{}
+
+	server := buildServer(m)
+
+	for _, query := range []string{"kev=maybe", "limit=0", "offset=-1", "epssMin=2", "sort=vendor", "scoreMin=11", "severity=SEVERE", "cpe=log4j", "distro=arch", "distroStatus=vulnerable"} {
+		req, err := http.NewRequest("GET", "/cves?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+
+}
+
+func TestCveSearchHandler_Parses_Epss_Filter_And_Sort(t *testing.T) {
+
+	m := &MockDatabase{}
+
+	server := buildServer(m)
+
+	req, err := http.NewRequest("GET", "/cves?epssMin=0.1&percentileMin=0.95&sort=-epss", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	if assert.NotNil(t, m.lastFilter.EpssMin) && assert.NotNil(t, m.lastFilter.PercentileMin) {
+		assert.Equal(t, 0.1, *m.lastFilter.EpssMin)
+		assert.Equal(t, 0.95, *m.lastFilter.PercentileMin)
+	}
+	assert.Equal(t, "epss", m.lastFilter.Sort)
+	assert.True(t, m.lastFilter.Descending)
+
+}
+
+func TestCveGetHandler_Returns_404_For_Unknown_CVE(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cve/"+missingCveID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+}
+
+func TestCveGetHandler_Adds_Weakness_Names(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cve/CVE-0000-0000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	var cve CveMsg
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &cve))
+	assert.Equal(t, map[string]string{"CWE-79": "Cross-site Scripting"}, cve.WeaknessNames)
+
+}
+
+func TestCweGetHandler_Includes_Relations(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cwe/79", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	var cwe CweDetail
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &cwe))
+	assert.Equal(t, "CWE-79", cwe.ID)
+	assert.Equal(t, []CweRef{{ID: "CWE-74", Name: "Injection"}}, cwe.Parents)
+	assert.Equal(t, []CweRef{{ID: "CWE-80", Name: "Basic XSS"}}, cwe.Children)
+
+}
+
+func TestCweCvesHandler_Includes_Descendants_When_Asked(t *testing.T) {
+
+	m := &MockDatabase{}
+	server := buildServer(m)
+
+	for query, expected := range map[string][]string{
+		"":                  {"CWE-74"},
+		"?descendants=true": {"CWE-74", "CWE-79", "CWE-80"},
+	} {
+		req, err := http.NewRequest("GET", "/cwe/CWE-74/cves"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, expected, m.lastFilter.Cwes)
+	}
+
+}
+
+func TestCvssCalculateHandler(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	for body, expected := range map[string]int{
+		`{"vector": "` + mockVector + `"}`:                            http.StatusOK,
+		`{"vector": "` + mockVector + `", "modifiers": "MAV:L/CR:H"}`: http.StatusOK,
+		`{"vector": "` + mockVector + `", "modifiers": "CDP:H"}`:      http.StatusBadRequest,
+		`{"vector": "CVSS:3.1/AV:N"}`:                                 http.StatusBadRequest,
+		`{}`:                                                          http.StatusBadRequest,
+	} {
+		req, err := http.NewRequest("POST", "/cvss/calculate", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+
+		assert.Equal(t, expected, resp.Code, body)
+	}
+
+}
+
+func TestCveGetHandler_Applies_Environmental_Modifiers(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cve/CVE-0000-0000?env=MAV:L/CR:L/IR:L/AR:L", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	var cve CveMsg
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &cve))
+	if assert.Len(t, cve.EnvironmentalScores, 1) {
+		assert.Equal(t, 9.8, cve.EnvironmentalScores[0].BaseScore)
+		assert.Equal(t, 6.6, cve.EnvironmentalScores[0].EnvironmentalScore)
+	}
+
+	req, err = http.NewRequest("GET", "/cve/CVE-0000-0000?env=TD:H", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp = httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+}
+
+func TestCveSearchHandler_Parses_Score_Filter(t *testing.T) {
+
+	m := &MockDatabase{}
+
+	server := buildServer(m)
+
+	req, err := http.NewRequest("GET", "/cves?scoreMin=7.5&severity=high,Critical&sort=-score", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	if assert.NotNil(t, m.lastFilter.ScoreMin) {
+		assert.Equal(t, 7.5, *m.lastFilter.ScoreMin)
+	}
+	assert.Equal(t, []string{"HIGH", "CRITICAL"}, m.lastFilter.Severities)
+	assert.Equal(t, "score", m.lastFilter.Sort)
+	assert.True(t, m.lastFilter.Descending)
+
+}
+
+func TestCpeSearchHandler(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	for query, expected := range map[string]int{
+		"":                 http.StatusBadRequest,
+		"?q=log4j&limit=0": http.StatusBadRequest,
+		"?q=apache+log4j":  http.StatusOK,
+	} {
+		req, err := http.NewRequest("GET", "/cpes"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+
+		assert.Equal(t, expected, resp.Code, query)
+		if resp.Code == http.StatusOK {
+			var suggestions []CpeSuggestion
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &suggestions))
+			assert.Equal(t, []CpeSuggestion{{CpeName: mockCpeName, CpeNameID: "1", Title: "Apache Log4j 2.14.1"}}, suggestions)
+		}
+	}
+
+}
+
+func TestCveSearchHandler_Resolves_Cpe_To_Match_Criteria(t *testing.T) {
+
+	m := &MockDatabase{}
+
+	server := buildServer(m)
+
+	req, err := http.NewRequest("GET", "/cves?cpe="+mockCpeName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, mockCpeName, m.lastFilter.Cpe)
+	assert.Equal(t, []string{mockMatchCriteriaID}, m.lastFilter.MatchCriteriaIDs)
+
+}
+
+func TestCveSearchHandler_Resolves_Distro_Filters(t *testing.T) {
+
+	m := &MockDatabase{}
+
+	server := buildServer(m)
+
+	for query, expected := range map[string][]string{
+		"distro=debian":                        {"CVE-0000-0000"},
+		"distro=Ubuntu&distroStatus=affected":  {},
+		"distro=all&distroStatus=Not-Affected": {"CVE-0000-0000"},
+	} {
+		req, err := http.NewRequest("GET", "/cves?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code, query)
+		assert.Equal(t, expected, m.lastFilter.DistroCveIDs, query)
+	}
+
+	// without a distro filter, nothing's looked up
+	req, _ := http.NewRequest("GET", "/cves?distro=all", nil)
+	server.router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, m.lastFilter.hasDistroFilter())
+	assert.Nil(t, m.lastFilter.DistroCveIDs)
+
+}
+
+func TestCveChangesHandler(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cve/CVE-2021-44228/changes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	var changes []CveChange
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &changes))
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, "CPE Configuration", changes[0].Details[0].Type)
+		assert.Equal(t, "CVE Reanalysis", changes[1].EventName)
+	}
+
+}
+
+func TestCveGetHandler_Adds_Distro_Statuses(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	for query, expected := range map[string][]DistroStatus{
+		"":                      mockDistroStatuses,
+		"?distro=debian":        mockDistroStatuses[:1],
+		"?distro=Debian,ubuntu": mockDistroStatuses,
+		"?distro=all":           mockDistroStatuses,
+	} {
+		req, err := http.NewRequest("GET", "/cve/CVE-0000-0000"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+
+		var cve CveMsg
+		assert.Equal(t, http.StatusOK, resp.Code, query)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &cve))
+		assert.Equal(t, expected, cve.DistroStatus, query)
+	}
+
+	req, err := http.NewRequest("GET", "/cve/CVE-0000-0000?distro=arch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+}
+
+func TestCveGetHandler_Merges_Advisories(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cve/CVE-0000-0000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+
+	var cve CveMsg
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &cve))
+
+	// the withdrawn advisory is dropped
+	if assert.Len(t, cve.Advisories, 1) {
+		advisory := cve.Advisories[0]
+		assert.Equal(t, "GHSA", advisory.Source)
+		assert.Equal(t, "CRITICAL", advisory.Severity())
+		assert.Equal(t, "2.3.1", advisory.Osv.Affected[0].Ranges[0].Events[1].Fixed)
+	}
+
+	// and with no score from NVD, the advisory's stands in
+	if assert.NotNil(t, cve.PreferredScore) {
+		assert.Equal(t, "GHSA-jfh8-c2jp-5v3q", cve.PreferredScore.Source)
+		assert.Equal(t, 9.8, cve.PreferredScore.BaseScore)
+		assert.Equal(t, "CRITICAL", cve.PreferredScore.BaseSeverity)
+	}
+
+}
+
+func TestMergeAdvisories_Keeps_Nvd_Score(t *testing.T) {
+
+	cve := CveMsg{PreferredScore: &PreferredScore{Source: "nvd@nist.gov", Type: "Primary", BaseScore: 5.3}}
+	advisories, _ := (&MockDatabase{}).GetAdvisories("CVE-0000-0000")
+
+	mergeAdvisories(&cve, advisories)
+
+	assert.Len(t, cve.Advisories, 1)
+	assert.Equal(t, "nvd@nist.gov", cve.PreferredScore.Source)
+
+}
+
+func TestTextSearchHandler(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+
+	req, err := http.NewRequest("GET", "/search?q=jackson+denial&kev=true&limit=10", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var body struct {
+		Results []SearchResult `json:"results"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+
+	// the structured search's filters apply too
+	if assert.NotNil(t, db.lastFilter.Kev) {
+		assert.True(t, *db.lastFilter.Kev)
+	}
+	assert.Equal(t, 10, db.lastFilter.Limit)
+
+	if assert.Len(t, body.Results, 1) {
+		result := body.Results[0]
+		assert.Equal(t, "CVE-2020-36518", result.CveData.ID)
+		assert.Equal(t, 1.5, result.Score)
+		assert.Equal(t, []string{"<mark>jackson</mark>-databind before 2.13.0 allows a Java StackOverflow exception and <mark>denial</mark> of service via a large depth of nested objects."}, result.Highlights["description"])
+		assert.Equal(t, []string{"fasterxml <mark>jackson</mark>-databind"}, result.Highlights["products"])
+	}
+
+	for _, query := range []string{"", "?q=", "?q=the+of", "?q=jackson&sort=published", "?q=jackson&scoreMin=11"} {
+		req, err := http.NewRequest("GET", "/search"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+
+}
+
+func TestStatsHandlers(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+
+	for path, expected := range map[string]string{
+		"/stats/published?from=2023-06-01&to=2023-07-31": `[{"key": "2023-06", "count": 2310}, {"key": "2023-07", "count": 2404}]`,
+		"/stats/severity":      `[{"key": "HIGH", "count": 12}, {"key": "UNSCORED", "count": 3}]`,
+		"/stats/status":        `[{"key": "Analyzed", "count": 40}, {"key": "Awaiting Analysis", "count": 9}]`,
+		"/stats/cwes?limit=1":  `[{"key": "CWE-79", "name": "Cross-site Scripting", "count": 120}]`,
+		"/stats/vendors":       `[{"key": "apache", "count": 30}]`,
+		"/stats/products":      `[{"key": "apache:log4j", "count": 5}]`,
+		"/stats/analysis-time": `{"averageDays": 3.5, "count": 2}`,
+	} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code, path)
+
+		var body struct {
+			Results json.RawMessage `json:"results"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.JSONEq(t, expected, string(body.Results), path)
+	}
+
+	// the day after the end of the range is what publish dates are compared against
+	assert.Equal(t, DateRange{From: "2023-06-01", To: "2023-07-31", Before: "2023-08-01"}, db.lastRange)
+
+}
+
+func TestStatsHandlers_Cache_Results(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+
+	for _, path := range []string{"/stats/published", "/stats/published", "/stats/published?from=2023-01-01"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code, path)
+	}
+
+	// the repeat was served from the cache, while a different range wasn't
+	assert.Equal(t, 2, db.statsCalls)
+
+	// until the cached results expire
+	server.stats.now = func() time.Time { return time.Now().Add(defaultStatsTTL + time.Second) }
+	req, err := http.NewRequest("GET", "/stats/published", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 3, db.statsCalls)
+
+}
+
+func TestStatsHandlers_Reject_Invalid_Params(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	for _, path := range []string{
+		"/stats/published?from=2023",
+		"/stats/published?to=yesterday",
+		"/stats/published?from=2023-07-01&to=2023-06-30",
+		"/stats/cwes?limit=0",
+		"/stats/vendors?limit=101",
+	} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, path)
+	}
+
+}
+
+func TestExportHandler_CSV(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+
+	req, err := http.NewRequest("GET", "/export?kev=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="cves.csv"`, resp.Header().Get("Content-Disposition"))
+
+	expected := "id,published,score,severity,cwe,description\n" +
+		"CVE-2021-44228,2021-12-10T10:15:09.143,10,CRITICAL,\"CWE-502,CWE-400\",\"Apache Log4j2 <=2.14.1 JNDI features, \"\"lookups\"\"\"\n" +
+		"CVE-2023-0001,2023-01-01T00:00:00.000,,,,\n"
+	assert.Equal(t, expected, resp.Body.String())
+
+	// the search's filters are applied, but not its default page size
+	if assert.NotNil(t, db.lastFilter.Kev) {
+		assert.True(t, *db.lastFilter.Kev)
+	}
+	assert.Equal(t, 0, db.lastFilter.Limit)
+
+	// an export stops when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ = http.NewRequestWithContext(ctx, "GET", "/export", nil)
+	resp = httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.NotContains(t, resp.Body.String(), "CVE-2021-44228")
+
+}
+
+func TestCsvRowWriter_Escapes_Formulas(t *testing.T) {
+
+	var b bytes.Buffer
+	w, err := newCsvRowWriter(&b, []string{"id", "score", "description"})
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteRow([]interface{}{"CVE-0000-0001", -1.5, `=HYPERLINK("https://example.com")`}))
+	assert.NoError(t, w.WriteRow([]interface{}{"@SUM(A1)", nil, "+1 -1"}))
+	assert.NoError(t, w.WriteRow([]interface{}{"-2", 0.0, "a = b"}))
+	assert.NoError(t, w.Close())
+
+	expected := "id,score,description\n" +
+		"CVE-0000-0001,-1.5,\"'=HYPERLINK(\"\"https://example.com\"\")\"\n" +
+		"'@SUM(A1),,'+1 -1\n" +
+		"'-2,0,a = b\n"
+	assert.Equal(t, expected, b.String())
+
+}
+
+func TestExportHandler_NDJSON(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/export?format=ndjson&columns=id,score,kev", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/x-ndjson", resp.Header().Get("Content-Type"))
+
+	expected := `{"id":"CVE-2021-44228","score":10,"kev":true}` + "\n" + `{"id":"CVE-2023-0001","score":null,"kev":false}` + "\n"
+	assert.Equal(t, expected, resp.Body.String())
+
+}
+
+func TestExportHandler_XLSX(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/export?format=xlsx&columns=id,score,description", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	archive, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(content)
+	}
+
+	assert.Contains(t, parts, "[Content_Types].xml")
+	assert.Contains(t, parts, "xl/workbook.xml")
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="B2"><v>10</v></c>`)
+	assert.Contains(t, sheet, `Apache Log4j2 &lt;=2.14.1 JNDI features, &#34;lookups&#34;`)
+	assert.Contains(t, sheet, `<row r="3"><c r="A3" t="inlineStr"><is><t xml:space="preserve">CVE-2023-0001</t></is></c><c r="C3"`)
+
+	// the sheet must be well formed
+	decoder := xml.NewDecoder(strings.NewReader(sheet))
+	for {
+		if _, err := decoder.Token(); err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+			break
+		}
+	}
+
+}
+
+func TestExportHandler_Reject_Invalid_Params(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	for _, path := range []string{"/export?format=pdf", "/export?columns=id,nope", "/export?limit=0"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, path)
+	}
+
+}
+
+func TestColumnName(t *testing.T) {
+	for i, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, expected, columnName(i))
+	}
+}
+
+func TestCveGetHandler_Negotiates_Stix(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cve/CVE-2021-44228", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/stix+json;version=2.1")
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/stix+json;version=2.1", resp.Header().Get("Content-Type"))
+
+	var bundle StixBundle
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &bundle))
+	assert.Equal(t, "bundle", bundle.Type)
+	assert.True(t, strings.HasPrefix(bundle.ID, "bundle--"))
+
+	if assert.Len(t, bundle.Objects, 1) {
+		vuln := bundle.Objects[0]
+		assert.Equal(t, "vulnerability", vuln.Type)
+		assert.Equal(t, "2.1", vuln.SpecVersion)
+		assert.Equal(t, "vulnerability--62c63c28-2d53-5f57-8ac6-55c5a6667b1d", vuln.ID)
+		assert.Equal(t, "CVE-2021-44228", vuln.Name)
+		assert.Equal(t, []StixExternalReference{
+			{SourceName: "cve", ExternalID: "CVE-2021-44228", URL: "https://nvd.nist.gov/vuln/detail/CVE-2021-44228"},
+			{SourceName: "cwe", ExternalID: "CWE-79", URL: "https://cwe.mitre.org/data/definitions/79.html", Description: "Cross-site Scripting"},
+			{SourceName: "ghsa", ExternalID: "GHSA-jfh8-c2jp-5v3q"},
+		}, vuln.ExternalReferences)
+	}
+
+}
+
+func TestCveGetHandler_Negotiates_Csaf(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cve/CVE-2021-44228", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/csaf+json")
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/csaf+json", resp.Header().Get("Content-Type"))
+
+	var doc CsafDocument
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &doc))
+	assert.Equal(t, "csaf_base", doc.Document.Category)
+	assert.Equal(t, "2.0", doc.Document.CsafVersion)
+	assert.Equal(t, defaultCsafPublisher, doc.Document.Publisher)
+	assert.Equal(t, "CVE-2021-44228", doc.Document.Tracking.ID)
+	assert.Len(t, doc.Document.Tracking.RevisionHistory, 1)
+
+	if assert.Len(t, doc.Vulnerabilities, 1) {
+		vuln := doc.Vulnerabilities[0]
+		assert.Equal(t, "CVE-2021-44228", vuln.Cve)
+		assert.Equal(t, &CsafCwe{ID: "CWE-79", Name: "Cross-site Scripting"}, vuln.Cwe)
+		assert.Equal(t, []CsafID{{SystemName: "GHSA", Text: "GHSA-jfh8-c2jp-5v3q"}}, vuln.Ids)
+	}
+
+	// the CVE has no configurations, so there are no products to describe
+	assert.Nil(t, doc.ProductTree)
+
+}
+
+func TestCveSearchHandler_Negotiates_Stix(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/cves", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/stix+json")
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var bundle StixBundle
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &bundle))
+	if assert.Len(t, bundle.Objects, 1) {
+		assert.Equal(t, "CVE-0000-0000", bundle.Objects[0].Name)
+	}
+
+}
+
+func TestExportHandler_Negotiates_Stix(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/stix+json")
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/stix+json", resp.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="cves.stix.json"`, resp.Header().Get("Content-Disposition"))
+
+	var bundle StixBundle
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &bundle))
+	if assert.Len(t, bundle.Objects, 2) {
+		assert.Equal(t, "CVE-2021-44228", bundle.Objects[0].Name)
+		assert.Equal(t, []string{"severity:critical", "known-exploited"}, bundle.Objects[0].Labels)
+		assert.Equal(t, "2021-12-10T10:15:09.143Z", bundle.Objects[0].Created)
+		assert.Equal(t, "CVE-2023-0001", bundle.Objects[1].Name)
+	}
+
+}
+
+func TestExportHandler_CSAF(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/export?format=csaf", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var doc CsafDocument
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &doc))
+	assert.True(t, strings.HasPrefix(doc.Document.Tracking.ID, "cves-"))
+
+	// only the vulnerable configuration is a product, named for its range of versions
+	if assert.NotNil(t, doc.ProductTree) && assert.Len(t, doc.ProductTree.FullProductNames, 1) {
+		product := doc.ProductTree.FullProductNames[0]
+		assert.Equal(t, "CSAFPID-0001", product.ProductID)
+		assert.Equal(t, "apache log4j >=2.0.1 <2.15.0", product.Name)
+		assert.Equal(t, "cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*", product.ProductIdentificationHelper.Cpe)
+	}
+
+	if assert.Len(t, doc.Vulnerabilities, 2) {
+		vuln := doc.Vulnerabilities[0]
+		assert.Equal(t, &CsafProductStatus{KnownAffected: []string{"CSAFPID-0001"}}, vuln.ProductStatus)
+		assert.Equal(t, "Apache Log4j2 <=2.14.1 JNDI features, \"lookups\"", vuln.Notes[0].Text)
+		assert.Equal(t, CsafReference{Category: "external", Summary: "Vendor Advisory", URL: "https://logging.apache.org/log4j/2.x/security.html"}, vuln.References[1])
+		if assert.Len(t, vuln.Threats, 1) {
+			assert.Equal(t, "exploit_status", vuln.Threats[0].Category)
+			assert.Equal(t, "2021-12-10T00:00:00Z", vuln.Threats[0].Date)
+		}
+
+		assert.Nil(t, doc.Vulnerabilities[1].ProductStatus)
+		assert.Empty(t, doc.Vulnerabilities[1].Scores)
+	}
+
+}
+
+func TestFeedHandler_Atom(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+
+	req, err := http.NewRequest("GET", "/feeds/cves.atom?vendor=Apache&severity=critical&keyword=jndi", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/atom+xml; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(resp.Body.String(), xml.Header))
+
+	// feeds are the most recently modified CVEs matching the filters
+	assert.Equal(t, "lastModified", db.lastFilter.Sort)
+	assert.True(t, db.lastFilter.Descending)
+	assert.Equal(t, defaultFeedLimit, db.lastFilter.Limit)
+	assert.Equal(t, "apache", db.lastFilter.Vendor)
+	assert.Equal(t, "jndi", db.lastFilter.Keyword)
+	assert.Equal(t, []string{"CRITICAL"}, db.lastFilter.Severities)
+
+	var feed AtomFeed
+	assert.NoError(t, xml.Unmarshal(resp.Body.Bytes(), &feed))
+	assert.Equal(t, "urn:melaka:feed:cves:keyword=jndi&severity=critical&vendor=Apache", feed.ID)
+	assert.Equal(t, "New and updated CVEs: severity=critical, vendor=Apache, keyword=jndi", feed.Title)
+	if assert.Len(t, feed.Entries, 1) {
+		assert.Equal(t, "https://nvd.nist.gov/vuln/detail/CVE-0000-0000", feed.Entries[0].ID)
+	}
+
+}
+
+func TestFeedHandler_Rejects_Sort(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	req, err := http.NewRequest("GET", "/feeds/cves.rss?sort=epss", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := httptest.NewRecorder()
+	server.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+}
+
+func TestNewAtomFeed(t *testing.T) {
+
+	entries := []feedEntry{newFeedEntry(&mockExportCves[0]), newFeedEntry(&mockExportCves[1])}
+	entries[0].Updated = time.Date(2023, 11, 7, 4, 9, 12, 0, time.UTC)
+
+	feed := newAtomFeed("urn:melaka:feed:cves", "New and updated CVEs", "http://example.com/feeds/cves.atom", entries[0].Updated, entries)
+	assert.Equal(t, "2023-11-07T04:09:12Z", feed.Updated)
+
+	if assert.Len(t, feed.Entries, 2) {
+		entry := feed.Entries[0]
+		assert.Equal(t, "CVE-2021-44228 (CRITICAL 10.0)", entry.Title)
+		assert.Equal(t, "2023-11-07T04:09:12Z", entry.Updated)
+		assert.Equal(t, "2021-12-10T10:15:09Z", entry.Published)
+		assert.Equal(t, AtomLink{Rel: "alternate", Href: "https://nvd.nist.gov/vuln/detail/CVE-2021-44228"}, entry.Link)
+		assert.Equal(t, []AtomCategory{{"CRITICAL"}, {"KEV"}, {"CWE-502"}, {"CWE-400"}}, entry.Categories)
+	}
+
+}
+
+func TestNewRssFeed(t *testing.T) {
+
+	entries := []feedEntry{newFeedEntry(&mockExportCves[1])}
+	entries[0].Updated = time.Date(2023, 11, 7, 4, 9, 12, 0, time.UTC)
+
+	feed := newRssFeed("New and updated CVEs", "http://example.com/feeds/cves.rss", entries[0].Updated, entries)
+	out, err := xml.Marshal(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Contains(t, string(out), `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">`)
+	assert.Contains(t, string(out), `<atom:link rel="self" href="http://example.com/feeds/cves.rss"></atom:link>`)
+	assert.Contains(t, string(out), `<guid isPermaLink="true">https://nvd.nist.gov/vuln/detail/CVE-2023-0001</guid>`)
+	assert.Contains(t, string(out), `<pubDate>Tue, 07 Nov 2023 04:09:12 +0000</pubDate>`)
+
+}
+
+// lookupTestHost resolves the hosts subscription tests send webhooks to, without going to DNS
+func lookupTestHost(ctx context.Context, host string) ([]net.IPAddr, error) {
+	switch host {
+	case "hooks.example.com":
+		return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+	case "internal.example.com":
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.12")}}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestSubscriptionHandlers(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+	server.lookupHost = lookupTestHost
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// the secret is generated when it's not given, and only shown on creation
+	resp := do("POST", "/subscriptions", `{"url": "https://hooks.example.com/melaka", "filter": {"cpe": "cpe:2.3:a:apache:log4j", "scoreMin": 9}}`)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	var created Subscription
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ID)
+	assert.Len(t, created.Secret, 64)
+	assert.True(t, created.Enabled)
+	if assert.NotNil(t, created.Filter.ScoreMin) {
+		assert.Equal(t, 9.0, *created.Filter.ScoreMin)
+	}
+	assert.Equal(t, created.Secret, db.subscriptions[created.ID].Secret)
+
+	resp = do("GET", "/subscriptions/"+created.ID, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), created.Secret)
+
+	resp = do("GET", "/subscriptions", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), created.Secret)
+
+	// a subscription the notifier disabled can be turned back on
+	sub := db.subscriptions[created.ID]
+	sub.Enabled, sub.ConsecutiveFailures, sub.DisabledReason = false, 5, "5 deliveries in a row failed"
+	db.subscriptions[created.ID] = sub
+
+	resp = do("PATCH", "/subscriptions/"+created.ID, `{"enabled": true}`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.True(t, db.subscriptions[created.ID].Enabled)
+	assert.Equal(t, 0, db.subscriptions[created.ID].ConsecutiveFailures)
+
+	resp = do("GET", "/subscriptions/"+created.ID+"/deliveries", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var deliveries []Delivery
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &deliveries))
+	if assert.Len(t, deliveries, 1) {
+		assert.Len(t, deliveries[0].Attempts, 2)
+	}
+
+	resp = do("DELETE", "/subscriptions/"+created.ID, "")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Empty(t, db.subscriptions)
+
+	for _, path := range []string{"/subscriptions/" + created.ID, "/subscriptions/" + created.ID + "/deliveries"} {
+		assert.Equal(t, http.StatusNotFound, do("GET", path, "").Code, path)
+	}
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/subscriptions/"+created.ID, "").Code)
+
+}
+
+func TestSubscriptionHandlers_Reject_Invalid_Subscriptions(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+	server.lookupHost = lookupTestHost
+
+	for _, body := range []string{
+		`{"url": "https://hooks.example.com", "filter": {}}`,
+		`{"url": "ftp://hooks.example.com", "filter": {"kev": true}}`,
+		`{"url": "/hooks", "filter": {"kev": true}}`,
+		`{"url": "https://hooks.example.com", "filter": {"cpe": "apache:log4j"}}`,
+		`{"url": "https://hooks.example.com", "filter": {"purl": "maven/log4j"}}`,
+		`{"url": "https://hooks.example.com", "filter": {"purl": "pkg:npm"}}`,
+		`{"url": "https://hooks.example.com", "filter": {"purl": "pkg:npm/%zz"}}`,
+		`{"url": "https://hooks.example.com", "filter": {"scoreMin": 11}}`,
+		`{"url": "https://hooks.example.com", "secret": "short", "filter": {"kev": true}}`,
+		`{"url": "http://127.0.0.1:27017", "filter": {"kev": true}}`,
+		`{"url": "http://[::1]/hooks", "filter": {"kev": true}}`,
+		`{"url": "http://169.254.169.254/latest/meta-data", "filter": {"kev": true}}`,
+		`{"url": "https://internal.example.com", "filter": {"kev": true}}`,
+		`{"url": "https://missing.example.com", "filter": {"kev": true}}`,
+		`not json`,
+	} {
+		req, err := http.NewRequest("POST", "/subscriptions", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, body)
+	}
+
+}
+
+func TestAssetHandlers(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := do("POST", "/assets", `{"name": "payments-api", "team": "payments", "components": [{"purl": "pkg:maven/org.apache.logging.log4j/log4j-core@2.3"}]}`)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	var created Asset
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, "payments", db.assets[created.ID].Team)
+
+	// 2.3 is within the advisory's range, up to 2.3.1
+	resp = do("GET", "/assets/"+created.ID+"/vulnerabilities", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var exposure AssetExposure
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &exposure))
+	if assert.Len(t, exposure.Vulnerabilities, 1) {
+		assert.Equal(t, "CVE-2021-44228", exposure.Vulnerabilities[0].CveID)
+		assert.True(t, exposure.Vulnerabilities[0].Kev)
+		assert.Equal(t, []string{"pkg:maven/org.apache.logging.log4j/log4j-core@2.3"}, exposure.Vulnerabilities[0].Components)
+	}
+	assert.Equal(t, ExposureSummary{Total: 1, Kev: 1, BySeverity: map[string]int{"CRITICAL": 1}}, exposure.Summary)
+
+	// once upgraded past the fix, it's no longer exposed
+	resp = do("PUT", "/assets/"+created.ID, `{"name": "payments-api", "team": "payments", "components": [{"purl": "pkg:maven/org.apache.logging.log4j/log4j-core@2.17.1"}]}`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = do("GET", "/assets/"+created.ID+"/vulnerabilities", "")
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &exposure))
+	assert.Empty(t, exposure.Vulnerabilities)
+
+	resp = do("POST", "/assets", `{"name": "checkout", "team": "payments", "components": [{"cpe": "`+mockCpeName+`"}, {"purl": "pkg:maven/org.apache.logging.log4j/log4j-core@2.0"}, {"purl": "pkg:npm/Lodash@4.17.20"}]}`)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	db.packageLookups = nil
+	resp = do("GET", "/teams/payments/exposure", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var team TeamExposure
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &team))
+	assert.Len(t, team.Assets, 2)
+	assert.Equal(t, 2, team.Summary.Total)
+	if assert.Len(t, team.Vulnerabilities, 2) {
+		assert.Equal(t, "CVE-2021-44228", team.Vulnerabilities[0].CveID)
+		assert.Equal(t, "CVE-0000-0000", team.Vulnerabilities[1].CveID)
+	}
+	assert.Equal(t, []string{mockMatchCriteriaID}, db.lastFilter.MatchCriteriaIDs)
+
+	// every asset's packages are looked up at once, by their normalised keys
+	assert.Equal(t, [][]string{{"maven/org.apache.logging.log4j:log4j-core", "npm/lodash"}}, db.packageLookups)
+
+	assert.Equal(t, http.StatusNotFound, do("GET", "/teams/unknown/exposure", "").Code)
+
+	resp = do("DELETE", "/assets/"+created.ID, "")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	for _, path := range []string{"/assets/" + created.ID, "/assets/" + created.ID + "/vulnerabilities"} {
+		assert.Equal(t, http.StatusNotFound, do("GET", path, "").Code, path)
+	}
+
+}
+
+func TestAssetHandlers_Reject_Invalid_Assets(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	for _, body := range []string{
+		`{"team": "payments", "components": [{"cpe": "cpe:2.3:a:apache:log4j:2.14.1"}]}`,
+		`{"name": "api", "components": [{"cpe": "cpe:2.3:a:apache:log4j:2.14.1"}]}`,
+		`{"name": "api", "team": "payments", "components": []}`,
+		`{"name": "api", "team": "payments", "components": [{}]}`,
+		`{"name": "api", "team": "payments", "components": [{"cpe": "apache:log4j"}]}`,
+		`{"name": "api", "team": "payments", "components": [{"purl": "maven/log4j"}]}`,
+		`{"name": "api", "team": "payments", "components": [{"cpe": "cpe:2.3:a:apache:log4j", "purl": "pkg:npm/lodash"}]}`,
+		`not json`,
+	} {
+		req, err := http.NewRequest("POST", "/assets", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, body)
+	}
+
+}
+
+func TestAnnotationHandlers(t *testing.T) {
+
+	db := &MockDatabase{}
+	server := buildServer(db)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	var asset Asset
+	resp := do("POST", "/assets", `{"name": "payments-api", "team": "payments", "components": [{"purl": "pkg:maven/org.apache.logging.log4j/log4j-core@2.3"}, {"cpe": "`+mockCpeName+`"}]}`)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &asset))
+
+	// triaged for every version of the package, and for the asset itself
+	resp = do("POST", "/annotations", `{"product": "pkg:maven/org.apache.logging.log4j/log4j-core", "cveId": "cve-2021-44228", "status": "affected",
+		"actionStatement": "Upgrade to 2.17.1", "author": "alice@example.com"}`)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	var created Annotation
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	assert.Equal(t, "CVE-2021-44228", created.CveID)
+
+	resp = do("POST", "/annotations", `{"product": "pkg:maven/org.apache.logging.log4j/log4j-core", "cveId": "CVE-2021-44228", "status": "fixed", "author": "bob@example.com"}`)
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = do("POST", "/annotations", `{"asset": "`+asset.ID+`", "cveId": "CVE-0000-0000", "status": "not_affected",
+		"justification": "vulnerable_code_not_in_execute_path", "author": "alice@example.com"}`)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+
+	// the annotations are applied to the asset's vulnerabilities, and those not affecting it are resolved
+	resp = do("GET", "/assets/"+asset.ID+"/vulnerabilities", "")
+	var exposure AssetExposure
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &exposure))
+	if assert.Len(t, exposure.Vulnerabilities, 2) {
+		if assert.NotNil(t, exposure.Vulnerabilities[0].Annotation) {
+			assert.Equal(t, "affected", exposure.Vulnerabilities[0].Annotation.Status)
+		}
+		if assert.NotNil(t, exposure.Vulnerabilities[1].Annotation) {
+			assert.Equal(t, "not_affected", exposure.Vulnerabilities[1].Annotation.Status)
+		}
+	}
+	assert.Equal(t, 1, exposure.Summary.Total)
+	assert.Equal(t, 1, exposure.Summary.Resolved)
+
+	resp = do("GET", "/teams/payments/exposure", "")
+	var team TeamExposure
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &team))
+	if assert.Len(t, team.Vulnerabilities, 2) {
+		assert.Equal(t, []string{}, team.Vulnerabilities[1].Assets)
+		assert.Equal(t, []string{asset.ID}, team.Vulnerabilities[1].ResolvedAssets)
+	}
+	assert.Equal(t, 1, team.Summary.Resolved)
+
+	resp = do("PUT", "/annotations/"+created.ID, `{"status": "fixed", "notes": "Upgraded in release 42", "author": "bob@example.com"}`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "fixed", db.annotations[created.ID].Status)
+	assert.Equal(t, "pkg:maven/org.apache.logging.log4j/log4j-core", db.annotations[created.ID].Product)
+
+	resp = do("PUT", "/annotations/"+created.ID, `{"cveId": "CVE-2022-0001", "status": "fixed", "author": "bob@example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = do("GET", "/annotations?status=fixed", "")
+	var annotations []Annotation
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &annotations))
+	assert.Len(t, annotations, 1)
+
+	// and exported as OpenVEX
+	resp = do("GET", "/vex/openvex", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var doc OpenVexDocument
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &doc))
+	assert.Equal(t, openVexContext, doc.Context)
+	assert.True(t, strings.HasPrefix(doc.ID, "urn:uuid:"))
+	assert.Equal(t, "Melaka", doc.Author)
+	if assert.Len(t, doc.Statements, 2) {
+		assetStatement := doc.Statements[0]
+		assert.Equal(t, "CVE-0000-0000", assetStatement.Vulnerability.Name)
+		assert.Equal(t, "vulnerable_code_not_in_execute_path", assetStatement.Justification)
+		if assert.Len(t, assetStatement.Products, 1) {
+			assert.Equal(t, "urn:melaka:asset:"+asset.ID, assetStatement.Products[0].ID)
+			assert.Len(t, assetStatement.Products[0].Subcomponents, 2)
+		}
+
+		productStatement := doc.Statements[1]
+		assert.Equal(t, "fixed", productStatement.Status)
+		assert.Equal(t, "Upgraded in release 42", productStatement.StatusNotes)
+		assert.Equal(t, []OpenVexProduct{{ID: "pkg:maven/org.apache.logging.log4j/log4j-core",
+			Identifiers: map[string]string{"purl": "pkg:maven/org.apache.logging.log4j/log4j-core"}}}, productStatement.Products)
+	}
+
+	resp = do("DELETE", "/annotations/"+created.ID, "")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/annotations/"+created.ID, "").Code)
+
+}
+
+func TestAnnotationHandlers_Reject_Invalid_Annotations(t *testing.T) {
+
+	server := buildServer(&MockDatabase{})
+
+	for _, body := range []string{
+		`{"cveId": "CVE-2021-44228", "status": "fixed", "author": "alice"}`,
+		`{"asset": "unknown", "cveId": "CVE-2021-44228", "status": "fixed", "author": "alice"}`,
+		`{"product": "log4j", "cveId": "CVE-2021-44228", "status": "fixed", "author": "alice"}`,
+		`{"product": "pkg:npm/lodash", "cveId": "GHSA-1234", "status": "fixed", "author": "alice"}`,
+		`{"product": "pkg:npm/lodash", "cveId": "CVE-2021-44228", "status": "fixed"}`,
+		`{"product": "pkg:npm/lodash", "cveId": "CVE-2021-44228", "status": "ignored", "author": "alice"}`,
+		`{"product": "pkg:npm/lodash", "cveId": "CVE-2021-44228", "status": "not_affected", "author": "alice"}`,
+		`{"product": "pkg:npm/lodash", "cveId": "CVE-2021-44228", "status": "not_affected", "justification": "not_my_problem", "author": "alice"}`,
+		`{"product": "pkg:npm/lodash", "cveId": "CVE-2021-44228", "status": "fixed", "justification": "component_not_present", "author": "alice"}`,
+		`{"product": "pkg:npm/lodash", "cveId": "CVE-2021-44228", "status": "affected", "author": "alice"}`,
+	} {
+		req, err := http.NewRequest("POST", "/annotations", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := httptest.NewRecorder()
+		server.router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, body)
+	}
+
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"melaka/cvequerier/cvss"
+)
+
+// a request to score a CVSS vector, optionally overriding some of its metrics with a partial vector (e.g. CR:H/MAV:L)
+type CvssRequest struct {
+	Vector    string `json:"vector" binding:"required"`
+	Modifiers string `json:"modifiers"`
+}
+
+func calculateScores(vector string, modifiers string) (cvss.Scores, error) {
+
+	v, err := cvss.Parse(vector)
+	if err != nil {
+		return cvss.Scores{}, err
+	}
+
+	if err := v.Apply(modifiers); err != nil {
+		return cvss.Scores{}, err
+	}
+
+	return v.Scores(), nil
+}
+
+// addEnvironmentalScores rescores each of the CVE's vectors with the given modifiers. Metrics differ between CVSS
+// versions, so vectors the modifiers don't apply to are skipped - but if none of them take, the modifiers are invalid
+func addEnvironmentalScores(cve *CveMsg, modifiers string) error {
+
+	var lastErr error
+	for _, vector := range cve.VectorStrings() {
+		scores, err := calculateScores(vector, modifiers)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cve.EnvironmentalScores = append(cve.EnvironmentalScores, scores)
+	}
+
+	if len(cve.EnvironmentalScores) == 0 && lastErr != nil {
+		return fmt.Errorf("invalid env: %w", lastErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+	defaultCpeLimit    = 20
+	maxCpeLimit        = 100
+)
+
+// the fields search results can be sorted on, prefixed with '-' for descending order
+var sortKeys = map[string]bool{
+	"published":    true,
+	"lastModified": true,
+	"epss":         true,
+	"score":        true,
+}
+
+// the qualitative ratings a preferred score can have, across all CVSS versions
+var severities = map[string]bool{
+	"NONE":     true,
+	"LOW":      true,
+	"MEDIUM":   true,
+	"HIGH":     true,
+	"CRITICAL": true,
+}
+
+// the distros we have security tracker data for
+var distros = map[string]bool{
+	"debian": true,
+	"redhat": true,
+	"ubuntu": true,
+}
+
+// the statuses distros can give a CVE for one of their packages, normalised across their trackers
+var distroStatuses = map[string]bool{
+	"fixed":               true,
+	"affected":            true,
+	"not-affected":        true,
+	"will-not-fix":        true,
+	"deferred":            true,
+	"under-investigation": true,
+}
+
+// parseDistros parses a comma separated list of distros, where 'all' asks for every one we know of
+func parseDistros(v string) ([]string, error) {
+	if v == "all" {
+		return nil, nil
+	}
+	list := []string{}
+	for _, distro := range strings.Split(v, ",") {
+		distro = strings.ToLower(strings.TrimSpace(distro))
+		if !distros[distro] {
+			return nil, fmt.Errorf("unknown distro: %s", distro)
+		}
+		list = append(list, distro)
+	}
+	return list, nil
+}
+
+// CveFilter holds the criteria a CVE search can be narrowed by, parsed from the request's query string
+type CveFilter struct {
+	Kev              *bool // nil when we don't care whether the CVE is in the KEV catalog
+	Cwes             []string
+	EpssMin          *float64
+	PercentileMin    *float64
+	ScoreMin         *float64 // compared against the CVE's preferred score
+	Severities       []string
+	Cpe              string   // a concrete CPE name, e.g. cpe:2.3:a:apache:log4j:2.14.1:*:*:*:*:*:*:*
+	MatchCriteriaIDs []string // the CPE match criteria Cpe resolves to, looked up before searching
+	Vendor           string   // as named in CPEs, e.g. apache
+	Keyword          string   // a piece of text the CVE's description must contain, ignoring case
+	Distros          []string // distros that have assessed the CVE, nil for any
+	DistroStatuses   []string // what one of those distros assessed one of its packages as, e.g. affected
+	DistroCveIDs     []string // the CVEs Distros and DistroStatuses resolve to, looked up before searching
+	Sort             string
+	Descending       bool
+	Limit            int
+	Offset           int
+}
+
+func parseCveFilter(c *gin.Context) (CveFilter, error) {
+	return parseCveFilterFrom(c.Query)
+}
+
+// parseCveFilterFrom reads a CVE filter from named values, each empty if not given, so that searches other than
+// through query parameters filter the same way
+func parseCveFilterFrom(get func(name string) string) (CveFilter, error) {
+
+	filter := CveFilter{
+		Sort:       "published",
+		Descending: true,
+		Limit:      defaultSearchLimit,
+	}
+
+	if v := get("kev"); v != "" {
+		kev, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid value for kev: %s", v)
+		}
+		filter.Kev = &kev
+	}
+
+	if v := get("cwe"); v != "" {
+		for _, id := range strings.Split(v, ",") {
+			filter.Cwes = append(filter.Cwes, normalizeCweID(id))
+		}
+	}
+
+	if v := get("epssMin"); v != "" {
+		epssMin, err := parseProbability(v)
+		if err != nil {
+			return filter, fmt.Errorf("epssMin %s", err)
+		}
+		filter.EpssMin = &epssMin
+	}
+
+	if v := get("percentileMin"); v != "" {
+		percentileMin, err := parseProbability(v)
+		if err != nil {
+			return filter, fmt.Errorf("percentileMin %s", err)
+		}
+		filter.PercentileMin = &percentileMin
+	}
+
+	if v := get("cpe"); v != "" {
+		if !strings.HasPrefix(v, "cpe:2.3:") {
+			return filter, fmt.Errorf("cpe must be a CPE 2.3 name")
+		}
+		filter.Cpe = v
+	}
+
+	if v := get("vendor"); v != "" {
+		if strings.ContainsAny(v, ":*?") {
+			return filter, fmt.Errorf("vendor must be a vendor name as used in CPEs, e.g. apache")
+		}
+		filter.Vendor = strings.ToLower(v)
+	}
+
+	filter.Keyword = strings.TrimSpace(get("keyword"))
+
+	if v := get("distro"); v != "" {
+		list, err := parseDistros(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Distros = list
+	}
+
+	if v := get("distroStatus"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			status = strings.ToLower(strings.TrimSpace(status))
+			if !distroStatuses[status] {
+				return filter, fmt.Errorf("invalid distro status: %s", status)
+			}
+			filter.DistroStatuses = append(filter.DistroStatuses, status)
+		}
+	}
+
+	if v := get("scoreMin"); v != "" {
+		scoreMin, err := strconv.ParseFloat(v, 64)
+		if err != nil || scoreMin < 0 || scoreMin > 10 {
+			return filter, fmt.Errorf("scoreMin must be a number between 0 and 10")
+		}
+		filter.ScoreMin = &scoreMin
+	}
+
+	if v := get("severity"); v != "" {
+		for _, severity := range strings.Split(v, ",") {
+			severity = strings.ToUpper(strings.TrimSpace(severity))
+			if !severities[severity] {
+				return filter, fmt.Errorf("invalid severity: %s", severity)
+			}
+			filter.Severities = append(filter.Severities, severity)
+		}
+	}
+
+	if v := get("sort"); v != "" {
+		key := strings.TrimPrefix(v, "-")
+		if !sortKeys[key] {
+			return filter, fmt.Errorf("cannot sort by %s", key)
+		}
+		filter.Sort = key
+		filter.Descending = strings.HasPrefix(v, "-")
+	}
+
+	if v := get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return filter, fmt.Errorf("limit must be a number between 1 and %d", maxSearchLimit)
+		}
+		filter.Limit = limit
+	}
+
+	if v := get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative number")
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
+
+// hasDistroFilter reports whether the filter narrows CVEs by what distros made of them
+func (f CveFilter) hasDistroFilter() bool {
+	return len(f.Distros) > 0 || len(f.DistroStatuses) > 0
+}
+
+// parseProbability parses a value that must lie between 0 and 1, like EPSS scores and percentiles
+func parseProbability(v string) (float64, error) {
+	p, err := strconv.ParseFloat(v, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, fmt.Errorf("must be a number between 0 and 1")
+	}
+	return p, nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultStatsLimit = 10
+	maxStatsLimit     = 100
+	defaultStatsTTL   = 10 * time.Minute
+)
+
+// DateRange narrows statistics down to the CVEs published in it. Either end may be left open
+type DateRange struct {
+	From   string // the first day, e.g. 2023-01-01
+	To     string // the last day, inclusive
+	Before string // the day after To, which is what we actually compare publish dates against
+}
+
+// a count of the CVEs sharing some value, e.g. a month or a CWE
+type StatsCount struct {
+	Key   string `bson:"_id" json:"key"`
+	Name  string `bson:"-" json:"name,omitempty"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// how long NVD has taken to analyse CVEs after they were published
+type AnalysisTime struct {
+	AverageDays float64 `bson:"averageDays" json:"averageDays"`
+	Count       int     `bson:"count" json:"count"`
+}
+
+func parseDateRange(c *gin.Context) (DateRange, error) {
+
+	var r DateRange
+	var from, to time.Time
+	var err error
+
+	if r.From = c.Query("from"); r.From != "" {
+		if from, err = time.Parse("2006-01-02", r.From); err != nil {
+			return r, fmt.Errorf("from must be a date, e.g. 2023-01-01")
+		}
+	}
+
+	if r.To = c.Query("to"); r.To != "" {
+		if to, err = time.Parse("2006-01-02", r.To); err != nil {
+			return r, fmt.Errorf("to must be a date, e.g. 2023-12-31")
+		}
+		if r.From != "" && to.Before(from) {
+			return r, fmt.Errorf("from must not be after to")
+		}
+		r.Before = to.AddDate(0, 0, 1).Format("2006-01-02")
+	}
+
+	return r, nil
+}
+
+// ttlCache holds on to computed statistics for a while, as they're expensive to work out and dashboards ask for
+// the same ones over and over
+type ttlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{
+		ttl:     ttl,
+		entries: map[string]cacheEntry{},
+		now:     time.Now,
+	}
+}
+
+func (t *ttlCache) get(key string) (interface{}, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[key]
+	if !ok || t.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (t *ttlCache) set(key string, value interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// callers choose the date ranges, so drop anything expired rather than letting it pile up
+	now := t.now()
+	for k, entry := range t.entries {
+		if now.After(entry.expires) {
+			delete(t.entries, k)
+		}
+	}
+	t.entries[key] = cacheEntry{value: value, expires: now.Add(t.ttl)}
+}
+
+// respondWithStats works out a statistic for the request's date range (and how many of the top values to list,
+// where that applies), or takes it from the cache if it was worked out recently
+func (s *Server) respondWithStats(c *gin.Context, name string, compute func(r DateRange, limit int) (interface{}, error)) {
+
+	r, err := parseDateRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := defaultStatsLimit
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxStatsLimit {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxStatsLimit)})
+			return
+		}
+	}
+
+	key := fmt.Sprintf("%s?from=%s&to=%s&limit=%d", name, r.From, r.To, limit)
+	results, ok := s.stats.get(key)
+	if !ok {
+		if results, err = compute(r, limit); err != nil {
+			log.Printf("Failed to compute %s stats: %s", name, err)
+			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to compute stats"})
+			return
+		}
+		s.stats.set(key, results)
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"from":    r.From,
+		"to":      r.To,
+		"results": results,
+	})
+
+}
+
+func (s *Server) getPublishedStats(c *gin.Context) {
+	s.respondWithStats(c, "published", func(r DateRange, limit int) (interface{}, error) {
+		return s.db.CountPublishedByMonth(r)
+	})
+}
+
+func (s *Server) getSeverityStats(c *gin.Context) {
+	s.respondWithStats(c, "severity", func(r DateRange, limit int) (interface{}, error) {
+		return s.db.CountBySeverity(r)
+	})
+}
+
+func (s *Server) getStatusStats(c *gin.Context) {
+	s.respondWithStats(c, "status", func(r DateRange, limit int) (interface{}, error) {
+		return s.db.CountByStatus(r)
+	})
+}
+
+func (s *Server) getCweStats(c *gin.Context) {
+	s.respondWithStats(c, "cwes", func(r DateRange, limit int) (interface{}, error) {
+		counts, err := s.db.TopCwes(r, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]string, len(counts))
+		for i, count := range counts {
+			ids[i] = count.Key
+		}
+		cwes, err := s.db.GetCwes(ids)
+		if err != nil {
+			return nil, err
+		}
+		names := map[string]string{}
+		for _, cwe := range cwes {
+			names[cwe.ID] = cwe.Name
+		}
+		for i := range counts {
+			counts[i].Name = names[counts[i].Key]
+		}
+
+		return counts, nil
+	})
+}
+
+func (s *Server) getVendorStats(c *gin.Context) {
+	s.respondWithStats(c, "vendors", func(r DateRange, limit int) (interface{}, error) {
+		return s.db.TopVendors(r, limit)
+	})
+}
+
+func (s *Server) getProductStats(c *gin.Context) {
+	s.respondWithStats(c, "products", func(r DateRange, limit int) (interface{}, error) {
+		return s.db.TopProducts(r, limit)
+	})
+}
+
+func (s *Server) getAnalysisTimeStats(c *gin.Context) {
+	s.respondWithStats(c, "analysis-time", func(r DateRange, limit int) (interface{}, error) {
+		return s.db.GetAnalysisTime(r)
+	})
+}
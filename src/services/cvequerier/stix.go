@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+)
+
+const stixSpecVersion = "2.1"
+
+// the namespace STIX 2.1 sets aside for deterministic identifiers. We derive each CVE's vulnerability ID from its CVE
+// ID with it, so that the same CVE is the same object however many bundles it turns up in
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// a STIX 2.1 bundle, which is how STIX objects are passed around
+type StixBundle struct {
+	Type    string              `json:"type"`
+	ID      string              `json:"id"`
+	Objects []StixVulnerability `json:"objects"`
+}
+
+// a STIX 2.1 vulnerability domain object
+type StixVulnerability struct {
+	Type               string                  `json:"type"`
+	SpecVersion        string                  `json:"spec_version"`
+	ID                 string                  `json:"id"`
+	Created            string                  `json:"created"`
+	Modified           string                  `json:"modified"`
+	Name               string                  `json:"name"`
+	Description        string                  `json:"description,omitempty"`
+	Labels             []string                `json:"labels,omitempty"`
+	ExternalReferences []StixExternalReference `json:"external_references"`
+}
+
+type StixExternalReference struct {
+	SourceName  string `json:"source_name"`
+	ExternalID  string `json:"external_id,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func newStixBundle(cves []*CveMsg) StixBundle {
+	bundle := StixBundle{
+		Type:    "bundle",
+		ID:      "bundle--" + randomUUID(),
+		Objects: []StixVulnerability{},
+	}
+	for _, cve := range cves {
+		bundle.Objects = append(bundle.Objects, newStixVulnerability(cve))
+	}
+	return bundle
+}
+
+// newStixVulnerability describes a CVE as a STIX vulnerability. The CVE ID goes first in its external references,
+// as STIX consumers expect, followed by its CWEs, the advisories we have for it, and NVD's references
+func newStixVulnerability(cve *CveMsg) StixVulnerability {
+
+	vuln := StixVulnerability{
+		Type:        "vulnerability",
+		SpecVersion: stixSpecVersion,
+		ID:          "vulnerability--" + nameUUID(stixNamespace, cve.CveData.ID),
+		Created:     utcTimestamp(cve.CveData.Published),
+		Modified:    utcTimestamp(cve.CveData.LastModified),
+		Name:        cve.CveData.ID,
+		Description: firstDescription(cve.CveData.Descriptions),
+		ExternalReferences: []StixExternalReference{{
+			SourceName: "cve",
+			ExternalID: cve.CveData.ID,
+			URL:        "https://nvd.nist.gov/vuln/detail/" + cve.CveData.ID,
+		}},
+	}
+	if vuln.Modified < vuln.Created {
+		vuln.Modified = vuln.Created
+	}
+
+	if cve.PreferredScore != nil {
+		vuln.Labels = append(vuln.Labels, "severity:"+strings.ToLower(cve.PreferredScore.BaseSeverity))
+	}
+	if cve.Kev != nil {
+		vuln.Labels = append(vuln.Labels, "known-exploited")
+	}
+
+	for _, id := range cve.WeaknessIDs() {
+		if !strings.HasPrefix(id, "CWE-") {
+			continue
+		}
+		vuln.ExternalReferences = append(vuln.ExternalReferences, StixExternalReference{
+			SourceName:  "cwe",
+			ExternalID:  id,
+			URL:         fmt.Sprintf("https://cwe.mitre.org/data/definitions/%s.html", strings.TrimPrefix(id, "CWE-")),
+			Description: cve.WeaknessNames[id],
+		})
+	}
+
+	for _, advisory := range cve.Advisories {
+		vuln.ExternalReferences = append(vuln.ExternalReferences, StixExternalReference{
+			SourceName: strings.ToLower(advisory.Source),
+			ExternalID: advisory.Osv.ID,
+		})
+	}
+
+	for _, ref := range cve.CveData.References {
+		vuln.ExternalReferences = append(vuln.ExternalReferences, StixExternalReference{
+			SourceName:  ref.Source,
+			URL:         ref.URL,
+			Description: strings.Join(ref.Tags, ", "),
+		})
+	}
+
+	return vuln
+}
+
+// utcTimestamp marks one of NVD's timestamps, which are in UTC but don't say so, as UTC. STIX and CSAF both
+// insist on it
+func utcTimestamp(nvd string) string {
+	if nvd == "" || strings.HasSuffix(nvd, "Z") {
+		return nvd
+	}
+	return nvd + "Z"
+}
+
+// nameUUID returns the version 5 UUID for a name in the given namespace
+func nameUUID(namespace [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	var uuid [16]byte
+	copy(uuid[:], h.Sum(nil))
+	uuid[6] = (uuid[6] & 0x0f) | 0x50
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return formatUUID(uuid)
+}
+
+// randomUUID returns a new version 4 UUID
+func randomUUID() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic(err)
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return formatUUID(uuid)
+}
+
+func formatUUID(uuid [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"melaka/shared/netguard"
+	"melaka/shared/purl"
+)
+
+const (
+	minSecretLength      = 16
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+// what a CVE has to match for a subscription to be notified about it. Every criterion given has to match
+type SubscriptionFilter struct {
+	Cpe      string   `bson:"cpe,omitempty" json:"cpe,omitempty"`           // a CPE 2.3 name, e.g. cpe:2.3:a:apache:log4j:2.14.1
+	Purl     string   `bson:"purl,omitempty" json:"purl,omitempty"`         // a package URL, e.g. pkg:maven/org.apache.logging.log4j/log4j-core@2.14.1
+	Keyword  string   `bson:"keyword,omitempty" json:"keyword,omitempty"`   // text the CVE's description must contain
+	ScoreMin *float64 `bson:"scoreMin,omitempty" json:"scoreMin,omitempty"` // compared against the CVE's consolidated score
+	Kev      bool     `bson:"kev,omitempty" json:"kev,omitempty"`           // only CVEs in the KEV catalog
+}
+
+// a webhook to notify whenever a CVE matching its filter is created or updated. The notifier service signs each
+// delivery with the secret, and disables the subscription if deliveries to it keep failing
+type Subscription struct {
+	ID                  string             `bson:"id" json:"id"`
+	URL                 string             `bson:"url" json:"url"`
+	Secret              string             `bson:"secret" json:"secret,omitempty"` // only ever shown when created
+	Filter              SubscriptionFilter `bson:"filter" json:"filter"`
+	Enabled             bool               `bson:"enabled" json:"enabled"`
+	CreatedAt           string             `bson:"createdAt" json:"createdAt"`
+	ConsecutiveFailures int                `bson:"consecutiveFailures" json:"consecutiveFailures"`
+	DisabledAt          string             `bson:"disabledAt,omitempty" json:"disabledAt,omitempty"`
+	DisabledReason      string             `bson:"disabledReason,omitempty" json:"disabledReason,omitempty"`
+}
+
+// the notifier's log of a delivery to a subscription, with each attempt it made
+type Delivery struct {
+	ID             string            `bson:"id" json:"id"`
+	SubscriptionID string            `bson:"subscriptionId" json:"subscriptionId"`
+	CveID          string            `bson:"cveId" json:"cveId"`
+	Event          string            `bson:"event" json:"event"`
+	Status         string            `bson:"status" json:"status"` // delivered or failed
+	CreatedAt      string            `bson:"createdAt" json:"createdAt"`
+	Attempts       []DeliveryAttempt `bson:"attempts" json:"attempts"`
+}
+
+type DeliveryAttempt struct {
+	At         string `bson:"at" json:"at"`
+	StatusCode int    `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error      string `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs int64  `bson:"durationMs" json:"durationMs"`
+}
+
+type SubscriptionRequest struct {
+	URL    string             `json:"url"`
+	Secret string             `json:"secret"` // generated for the caller if they don't give one
+	Filter SubscriptionFilter `json:"filter"`
+}
+
+// SubscriptionUpdate turns a subscription on or off
+type SubscriptionUpdate struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// newSubscription validates a request for a subscription, and builds it
+func newSubscription(req SubscriptionRequest, now time.Time) (*Subscription, error) {
+
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("url must be an absolute http or https URL")
+	}
+
+	f := req.Filter
+	if f.Cpe == "" && f.Purl == "" && f.Keyword == "" && f.ScoreMin == nil && !f.Kev {
+		return nil, fmt.Errorf("filter must have at least one of cpe, purl, keyword, scoreMin or kev")
+	}
+	if f.Cpe != "" && !strings.HasPrefix(f.Cpe, "cpe:2.3:") {
+		return nil, fmt.Errorf("cpe must be a CPE 2.3 name")
+	}
+	// the notifier matches on the parsed purl, so one it can't parse would never match anything
+	if f.Purl != "" {
+		if _, err := purl.Parse(f.Purl); err != nil {
+			return nil, fmt.Errorf("invalid purl: %s", err)
+		}
+	}
+	if f.ScoreMin != nil && (*f.ScoreMin < 0 || *f.ScoreMin > 10) {
+		return nil, fmt.Errorf("scoreMin must be a number between 0 and 10")
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("secret must be at least %d characters long", minSecretLength)
+	}
+
+	return &Subscription{
+		ID:        randomUUID(),
+		URL:       req.URL,
+		Secret:    secret,
+		Filter:    f,
+		Enabled:   true,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// createSubscription registers a webhook. The response is the only time its secret is shown
+func (s *Server) createSubscription(c *gin.Context) {
+
+	var req SubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	sub, err := newSubscription(req, time.Now())
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// webhooks are sent from inside our network, so mustn't be able to reach anything that's only reachable from
+	// there. The notifier checks again when it connects, in case the name has been pointed elsewhere since
+	target, _ := url.Parse(sub.URL)
+	if err := netguard.CheckHost(c.Request.Context(), target.Hostname(), s.lookupHost); err != nil {
+		log.Printf("Rejected subscription to %s: %s", target.Hostname(), err)
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "url must resolve to public addresses"})
+		return
+	}
+
+	if err := s.db.CreateSubscription(*sub); err != nil {
+		log.Printf("Failed to create subscription: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to create subscription"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, sub)
+
+}
+
+func (s *Server) getSubscriptions(c *gin.Context) {
+
+	subs, err := s.db.GetSubscriptions()
+	if err != nil {
+		log.Printf("Failed to fetch subscriptions: %s", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch subscriptions"})
+		return
+	}
+
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+
+	c.IndentedJSON(http.StatusOK, subs)
+
+}
+
+// respondWithSubscription writes out a subscription fetched or updated by ID, without its secret
+func respondWithSubscription(c *gin.Context, sub *Subscription, err error) {
+
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch subscription %s: %s", c.Param("id"), err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch subscription"})
+		return
+	}
+
+	sub.Secret = ""
+	c.IndentedJSON(http.StatusOK, sub)
+
+}
+
+func (s *Server) getSubscription(c *gin.Context) {
+	sub, err := s.db.GetSubscription(c.Param("id"))
+	respondWithSubscription(c, sub, err)
+}
+
+// updateSubscription turns a subscription on or off. Turning one back on that the notifier disabled gives it a
+// clean slate of failures
+func (s *Server) updateSubscription(c *gin.Context) {
+
+	var req SubscriptionUpdate
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "request must set enabled to true or false"})
+		return
+	}
+
+	sub, err := s.db.SetSubscriptionEnabled(c.Param("id"), *req.Enabled)
+	respondWithSubscription(c, sub, err)
+
+}
+
+func (s *Server) deleteSubscription(c *gin.Context) {
+
+	err := s.db.DeleteSubscription(c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete subscription %s: %s", c.Param("id"), err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to delete subscription"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+
+}
+
+// getDeliveries returns the notifier's log of deliveries to a subscription, most recent first
+func (s *Server) getDeliveries(c *gin.Context) {
+
+	id := c.Param("id")
+
+	limit := defaultDeliveryLimit
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxDeliveryLimit {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be a number between 1 and %d", maxDeliveryLimit)})
+			return
+		}
+	}
+
+	if _, err := s.db.GetSubscription(id); err != nil {
+		respondWithSubscription(c, nil, err)
+		return
+	}
+
+	deliveries, err := s.db.GetDeliveries(id, limit)
+	if err != nil {
+		log.Printf("Failed to fetch deliveries for subscription %s: %s", id, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch deliveries"})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, deliveries)
+
+}
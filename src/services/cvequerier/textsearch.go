@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrIndexNotReady is returned by text searches made before the in-process index has been built
+var ErrIndexNotReady = errors.New("search index is still being built")
+
+// TextIndex finds the CVEs matching a free text query that also pass a search filter, best match first
+type TextIndex interface {
+	Search(q string, filter CveFilter) ([]SearchResult, error)
+}
+
+// a CVE matching a text search, with how well it matched and the bits of its text that did
+type SearchResult struct {
+	CveMsg     `bson:",inline"`
+	Score      float64             `bson:"score" json:"score"`
+	Highlights map[string][]string `bson:"-" json:"highlights,omitempty"`
+}
+
+// the fields of a CVE's search block, and how much a match in each counts for. Matches on a description say far
+// more about a CVE than a word that happens to be in one of its reference URLs
+var textFields = []struct {
+	name   string
+	weight float64
+	text   func(s SearchText) []string
+}{
+	{"description", 10, func(s SearchText) []string { return []string{s.Description} }},
+	{"products", 5, func(s SearchText) []string { return s.Products }},
+	{"references", 1, func(s SearchText) []string { return s.References }},
+}
+
+// words too common to be worth matching on
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true, "can": true,
+	"for": true, "from": true, "has": true, "have": true, "in": true, "is": true, "it": true, "its": true, "may": true,
+	"of": true, "on": true, "or": true, "that": true, "the": true, "this": true, "to": true, "via": true, "was": true,
+	"when": true, "which": true, "with": true,
+}
+
+// suffixes we strip to bring a word's forms together, longest first, and what each is replaced with
+var suffixes = []struct{ suffix, replacement string }{
+	{"izations", "iz"}, {"ization", "iz"}, {"ations", "ate"}, {"ation", "ate"}, {"izing", "iz"}, {"ized", "iz"},
+	{"izes", "iz"}, {"ize", "iz"}, {"ing", ""}, {"ies", "y"}, {"ed", ""}, {"s", ""},
+}
+
+// stem reduces a lowercased word to a rough root, so that e.g. deserialization and deserialized match. It's a long
+// way short of a real stemmer like the one in Mongo's text indexes, but catches the forms that matter most
+func stem(word string) string {
+	for _, s := range suffixes {
+		// a trailing s isn't a plural in e.g. access, status or analysis
+		if s.suffix == "s" && (strings.HasSuffix(word, "ss") || strings.HasSuffix(word, "us") || strings.HasSuffix(word, "is")) {
+			continue
+		}
+		if strings.HasSuffix(word, s.suffix) && len(word)-len(s.suffix) >= 3 {
+			return word[:len(word)-len(s.suffix)] + s.replacement
+		}
+	}
+	return word
+}
+
+// a word in a piece of text: where it is, and the term it's indexed as
+type token struct {
+	start, end int
+	term       string
+}
+
+// tokenize splits text into words on anything that isn't a letter or digit, skipping stop words
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range text + " " {
+		isWordChar := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWordChar && start < 0 {
+			start = i
+		}
+		if !isWordChar && start >= 0 {
+			word := strings.ToLower(text[start:i])
+			if !stopWords[word] {
+				tokens = append(tokens, token{start, i, stem(word)})
+			}
+			start = -1
+		}
+	}
+	return tokens
+}
+
+// queryTerms returns the distinct terms in a query
+func queryTerms(q string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	for _, t := range tokenize(q) {
+		if !seen[t.term] {
+			seen[t.term] = true
+			terms = append(terms, t.term)
+		}
+	}
+	return terms
+}
+
+const (
+	snippetLength    = 200 // roughly how many characters of a description we show around its matches
+	maxHighlightURLs = 3
+)
+
+// highlight picks out where a CVE's search text matches the query, marking each matching word with <mark>. The
+// text around the marks is HTML escaped, so the snippets are safe to render as they are
+func highlight(q string, search *SearchText) map[string][]string {
+
+	if search == nil {
+		return nil
+	}
+
+	terms := map[string]bool{}
+	for _, term := range queryTerms(q) {
+		terms[term] = true
+	}
+
+	highlights := map[string][]string{}
+
+	if snippet, ok := markSnippet(search.Description, terms); ok {
+		highlights["description"] = []string{snippet}
+	}
+
+	for _, product := range search.Products {
+		if marked, ok := markAll(product, terms); ok {
+			highlights["products"] = append(highlights["products"], marked)
+		}
+	}
+
+	for _, url := range search.References {
+		if len(highlights["references"]) == maxHighlightURLs {
+			break
+		}
+		if marked, ok := markAll(url, terms); ok {
+			highlights["references"] = append(highlights["references"], marked)
+		}
+	}
+
+	return highlights
+}
+
+// markAll marks every match in a short piece of text, reporting whether there were any
+func markAll(text string, terms map[string]bool) (string, bool) {
+	return mark(text, tokenize(text), terms, 0, len(text))
+}
+
+// markSnippet marks the matches in the stretch of text with the most of them, trimmed to about snippetLength
+func markSnippet(text string, terms map[string]bool) (string, bool) {
+
+	tokens := tokenize(text)
+	matches := []token{}
+	for _, t := range tokens {
+		if terms[t.term] {
+			matches = append(matches, t)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	// the window starting a little before whichever match has the most others following closely behind it
+	best, bestCount := 0, 0
+	for i, m := range matches {
+		count := 0
+		for _, other := range matches[i:] {
+			if other.end-m.start <= snippetLength {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = i, count
+		}
+	}
+
+	start := matches[best].start - snippetLength/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetLength
+	if end > len(text) {
+		end = len(text)
+	}
+
+	// don't cut words in half
+	for start > 0 && !isBoundary(text, start) {
+		start--
+	}
+	for end < len(text) && !isBoundary(text, end) {
+		end++
+	}
+
+	snippet, _ := mark(text, tokens, terms, start, end)
+	if start > 0 {
+		snippet = "…" + strings.TrimLeft(snippet, " ")
+	}
+	if end < len(text) {
+		snippet = strings.TrimRight(snippet, " ") + "…"
+	}
+
+	return snippet, true
+}
+
+// isBoundary reports whether a word starts or ends at the given byte offset
+func isBoundary(text string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// mark escapes text[start:end], wrapping the tokens in it that match a term in <mark>
+func mark(text string, tokens []token, terms map[string]bool, start, end int) (string, bool) {
+	var b strings.Builder
+	pos, marked := start, false
+	for _, t := range tokens {
+		if t.start < start || t.end > end || !terms[t.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:t.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[t.start:t.end]) + "</mark>")
+		pos, marked = t.end, true
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	return b.String(), marked
+}
+
+// how many of its ranked matches an in-process search has the database filter at a time. A narrow filter on a broad
+// query can take a few batches to fill a page
+const textCandidateBatch = 1000
+
+// BM25 tuning: how quickly repeated matches stop counting for more, and how much a long text is penalized
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// a document's weighted count of a term
+type posting struct {
+	doc int
+	tf  float64
+}
+
+// invertedIndex maps each term to the CVEs whose search text contains it, for ranking with BM25
+type invertedIndex struct {
+	ids       []string
+	lengths   []float64
+	avgLength float64
+	postings  map[string][]posting
+}
+
+// a CVE's ID and search text, as the in-process index is built from
+type indexedDoc struct {
+	ID     string
+	Search SearchText
+}
+
+func newInvertedIndex(docs []indexedDoc) *invertedIndex {
+
+	index := &invertedIndex{
+		ids:      make([]string, len(docs)),
+		lengths:  make([]float64, len(docs)),
+		postings: map[string][]posting{},
+	}
+
+	total := 0.0
+	for i, doc := range docs {
+		index.ids[i] = doc.ID
+
+		counts := map[string]float64{}
+		for _, field := range textFields {
+			for _, text := range field.text(doc.Search) {
+				for _, t := range tokenize(text) {
+					counts[t.term] += field.weight
+					index.lengths[i] += field.weight
+				}
+			}
+		}
+
+		for term, tf := range counts {
+			index.postings[term] = append(index.postings[term], posting{i, tf})
+		}
+		total += index.lengths[i]
+	}
+	if len(docs) > 0 {
+		index.avgLength = total / float64(len(docs))
+	}
+
+	return index
+}
+
+// a CVE's ID and how well it matched a query
+type rankedID struct {
+	ID    string
+	Score float64
+}
+
+// rank scores every CVE containing any of the query's terms, returning the best first
+func (index *invertedIndex) rank(q string, limit int) []rankedID {
+
+	scores := map[int]float64{}
+	n := float64(len(index.ids))
+	for _, term := range queryTerms(q) {
+		postings := index.postings[term]
+		idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for _, p := range postings {
+			norm := bm25K1 * (1 - bm25B + bm25B*index.lengths[p.doc]/index.avgLength)
+			scores[p.doc] += idf * p.tf * (bm25K1 + 1) / (p.tf + norm)
+		}
+	}
+
+	ranked := make([]rankedID, 0, len(scores))
+	for doc, score := range scores {
+		ranked = append(ranked, rankedID{index.ids[doc], score})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	return ranked
+}
+
+// memoryTextIndex is the in-process alternative to a Mongo text index. It ranks matches itself, then has the
+// database filter them, best first, until it has the page asked for. The index is rebuilt from the database every so
+// often
+type memoryTextIndex struct {
+	mu     sync.RWMutex
+	index  *invertedIndex
+	load   func() ([]indexedDoc, error)
+	filter func(ranked []rankedID, filter CveFilter) ([]SearchResult, error) // the ranked matches passing the filter, in rank order
+}
+
+func (m *memoryTextIndex) Search(q string, filter CveFilter) ([]SearchResult, error) {
+
+	m.mu.RLock()
+	index := m.index
+	m.mu.RUnlock()
+	if index == nil {
+		return nil, ErrIndexNotReady
+	}
+
+	ranked := index.rank(q, len(index.ids))
+	results := []SearchResult{}
+	for start := 0; start < len(ranked) && len(results) < filter.Offset+filter.Limit; start += textCandidateBatch {
+		end := start + textCandidateBatch
+		if end > len(ranked) {
+			end = len(ranked)
+		}
+		batch, err := m.filter(ranked[start:end], filter)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+	}
+
+	if filter.Offset >= len(results) {
+		return []SearchResult{}, nil
+	}
+	results = results[filter.Offset:]
+	if len(results) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+
+	return results, nil
+}
+
+// rebuild reloads every CVE's search text and swaps in a fresh index built from it
+func (m *memoryTextIndex) rebuild() error {
+
+	docs, err := m.load()
+	if err != nil {
+		return err
+	}
+	index := newInvertedIndex(docs)
+
+	m.mu.Lock()
+	m.index = index
+	m.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryTerms_Stems_And_Drops_Stop_Words(t *testing.T) {
+
+	assert.Equal(t, []string{"deserializ", "jackson"}, queryTerms("Deserialization of the Jackson"))
+	assert.Equal(t, []string{"deserializ"}, queryTerms("deserialized deserializing deserialize"))
+	assert.Equal(t, []string{"vulnerability", "access", "package"}, queryTerms("vulnerabilities access packages"))
+
+}
+
+func TestHighlight_Picks_The_Best_Snippet(t *testing.T) {
+
+	description := strings.Repeat("filler words that go on ", 20) + "until unsafe deserialization in Jackson finally turns up, " +
+		strings.Repeat("followed by more filler ", 20)
+
+	highlights := highlight("jackson deserialization", &SearchText{
+		Description: description,
+		References:  []string{"https://example.com/a<b", "https://github.com/FasterXML/jackson-databind/issues/2798"},
+	})
+
+	if assert.Len(t, highlights["description"], 1) {
+		snippet := highlights["description"][0]
+		assert.True(t, strings.HasPrefix(snippet, "…"), snippet)
+		assert.True(t, strings.HasSuffix(snippet, "…"), snippet)
+		assert.Contains(t, snippet, "unsafe <mark>deserialization</mark> in <mark>Jackson</mark> finally")
+		assert.LessOrEqual(t, len(snippet), snippetLength+60)
+	}
+	assert.Equal(t, []string{"https://github.com/FasterXML/<mark>jackson</mark>-databind/issues/2798"}, highlights["references"])
+	assert.NotContains(t, highlights, "products")
+
+	// the text around the marks is escaped
+	highlights = highlight("example", &SearchText{References: []string{"https://example.com/a<b"}})
+	assert.Equal(t, []string{"https://<mark>example</mark>.com/a&lt;b"}, highlights["references"])
+
+}
+
+func TestInvertedIndex_Ranks_By_Relevance(t *testing.T) {
+
+	index := newInvertedIndex([]indexedDoc{
+		{ID: "CVE-1", Search: SearchText{Description: "Deserialization of untrusted data in Jackson allows remote code execution."}},
+		{ID: "CVE-2", Search: SearchText{Description: "A cross-site scripting issue.", References: []string{"https://example.com/jackson"}}},
+		{ID: "CVE-3", Search: SearchText{Description: "Unsafe deserialization in the XStream library."}},
+		{ID: "CVE-4", Search: SearchText{Description: "Buffer overflow in the kernel."}},
+	})
+
+	ranked := index.rank("deserialization jackson", 10)
+	ids := []string{}
+	for _, r := range ranked {
+		ids = append(ids, r.ID)
+	}
+
+	// matching both terms beats one, and a match in the description beats one in a reference URL
+	assert.Equal(t, []string{"CVE-1", "CVE-3", "CVE-2"}, ids)
+	assert.Len(t, index.rank("deserialization jackson", 1), 1)
+	assert.Empty(t, index.rank("sql injection", 10))
+
+}
+
+func TestMemoryTextIndex_Is_Not_Ready_Until_Built(t *testing.T) {
+
+	index := &memoryTextIndex{
+		load: func() ([]indexedDoc, error) {
+			return []indexedDoc{{ID: "CVE-1", Search: SearchText{Description: "jackson"}}}, nil
+		},
+		filter: func(ranked []rankedID, filter CveFilter) ([]SearchResult, error) {
+			results := []SearchResult{}
+			for _, r := range ranked {
+				results = append(results, SearchResult{CveMsg: CveMsg{CveData: NvdCveData{ID: r.ID}}, Score: r.Score})
+			}
+			return results, nil
+		},
+	}
+
+	_, err := index.Search("jackson", CveFilter{Limit: 10})
+	assert.ErrorIs(t, err, ErrIndexNotReady)
+
+	assert.NoError(t, index.rebuild())
+	results, err := index.Search("jackson", CveFilter{Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+}
+
+func TestMemoryTextIndex_Filters_Past_The_First_Batch(t *testing.T) {
+
+	// more matches than a batch, the best of which the filter turns away
+	docs := []indexedDoc{}
+	for i := 0; i < 2*textCandidateBatch+500; i++ {
+		docs = append(docs, indexedDoc{ID: fmt.Sprintf("CVE-%05d", i), Search: SearchText{Description: "jackson " + strings.Repeat("padding ", i)}})
+	}
+	batches := 0
+	index := &memoryTextIndex{
+		load: func() ([]indexedDoc, error) { return docs, nil },
+		filter: func(ranked []rankedID, filter CveFilter) ([]SearchResult, error) {
+			batches++
+			results := []SearchResult{}
+			for _, r := range ranked {
+				if r.ID >= "CVE-02000" {
+					results = append(results, SearchResult{CveMsg: CveMsg{CveData: NvdCveData{ID: r.ID}}, Score: r.Score})
+				}
+			}
+			return results, nil
+		},
+	}
+	assert.NoError(t, index.rebuild())
+
+	results, err := index.Search("jackson", CveFilter{Limit: 3, Offset: 1})
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, r := range results {
+		ids = append(ids, r.CveData.ID)
+	}
+	assert.Equal(t, []string{"CVE-02001", "CVE-02002", "CVE-02003"}, ids)
+	assert.Equal(t, 3, batches)
+
+}
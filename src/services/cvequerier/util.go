@@ -1,6 +1,10 @@
 package main
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+)
 
 func readFromENV(key, defaultVal string) string {
 	if value := os.Getenv(key); value != "" {
@@ -8,3 +12,15 @@ func readFromENV(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+// normalizeCweID accepts CWE IDs with or without their prefix, e.g. 79 or cwe-79, as NVD stores them: CWE-79
+func normalizeCweID(id string) string {
+	id = strings.TrimSpace(id)
+	if _, err := strconv.Atoi(id); err == nil {
+		return "CWE-" + id
+	}
+	if strings.HasPrefix(strings.ToUpper(id), "CWE-") {
+		return "CWE-" + id[4:]
+	}
+	return id
+}
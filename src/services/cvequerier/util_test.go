@@ -22,3 +22,17 @@ func TestReadFromENV_Returns_Default_Value_When_Env_Is_Not_Set(t *testing.T) {
 	assert.Equal(t, readFromENV(key, defaultVal), defaultVal)
 
 }
+
+func TestNormalizeCweID(t *testing.T) {
+
+	for input, expected := range map[string]string{
+		"79":             "CWE-79",
+		"CWE-79":         "CWE-79",
+		"cwe-79":         "CWE-79",
+		" 79 ":           "CWE-79",
+		"NVD-CWE-noinfo": "NVD-CWE-noinfo",
+	} {
+		assert.Equal(t, expected, normalizeCweID(input), input)
+	}
+
+}
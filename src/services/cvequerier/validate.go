@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the most we'll read of a request's body. Nothing we take comes anywhere near it
+const maxBodyBytes = 1 << 20
+
+// routesByKey indexes apiRoutes by method and gin path, as validateRequest looks them up
+var routesByKey = func() map[string]*apiRoute {
+	routes := map[string]*apiRoute{}
+	for i := range apiRoutes {
+		routes[apiRoutes[i].method+" "+apiRoutes[i].path] = &apiRoutes[i]
+	}
+	return routes
+}()
+
+// the patterns in our schemas, compiled once
+var schemaPatterns = map[string]*regexp.Regexp{}
+
+func init() {
+	var compile func(s *Schema)
+	compile = func(s *Schema) {
+		if s == nil {
+			return
+		}
+		if s.Pattern != "" {
+			schemaPatterns[s.Pattern] = regexp.MustCompile(s.Pattern)
+		}
+		compile(s.Items)
+		compile(s.AdditionalProperties)
+		for _, p := range s.Properties {
+			compile(p)
+		}
+	}
+	for _, route := range apiRoutes {
+		for _, param := range route.params {
+			compile(param.Schema)
+		}
+	}
+	for _, s := range openApiDocument.Components.Schemas {
+		compile(s)
+	}
+}
+
+// validateRequest checks a request's parameters and body against what our OpenAPI document says the route takes,
+// so bad requests get the same 400 whichever route they're to. Handlers still check what the document can't say,
+// like what an annotation needs for its status
+func (s *Server) validateRequest(c *gin.Context) {
+
+	route, ok := routesByKey[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		c.Next()
+		return
+	}
+
+	for _, param := range route.params {
+		var v string
+		if param.In == "path" {
+			v = c.Param(param.Name)
+		} else {
+			v = c.Query(param.Name)
+		}
+		if err := validateParam(param, v); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if route.body != nil {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodyBytes+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+		if len(body) > maxBodyBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request must be no more than %d bytes", maxBodyBytes)})
+			return
+		}
+		if err := validateBody(openApiDocument.Paths[openApiPath(route.path)][strings.ToLower(route.method)].RequestBody, body); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// put the body back for the handler to bind
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	c.Next()
+
+}
+
+// validateParam checks a path or query parameter. Query parameters left empty are as good as not given
+func validateParam(param *OpenApiParameter, v string) error {
+
+	if v == "" {
+		if param.Required {
+			return fmt.Errorf("%s is required", param.Name)
+		}
+		return nil
+	}
+
+	if param.Schema.allows("array") {
+		for _, item := range strings.Split(v, ",") {
+			if err := validateString(param.Name, param.Schema.Items, strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return validateString(param.Name, param.Schema, v)
+}
+
+// validateString checks a parameter's value, parsing it as whatever type its schema says it is
+func validateString(name string, s *Schema, v string) error {
+
+	switch {
+	case s.allows("integer") && !s.allows("number"):
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return rangeError(name, s)
+		}
+		return validateNumber(name, s, float64(i))
+	case s.allows("number"):
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return rangeError(name, s)
+		}
+		return validateNumber(name, s, f)
+	case s.allows("boolean"):
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("%s must be true or false", name)
+		}
+		return nil
+	}
+
+	return validateText(name, s, v)
+}
+
+func validateNumber(name string, s *Schema, f float64) error {
+	if (s.Minimum != nil && f < *s.Minimum) || (s.Maximum != nil && f > *s.Maximum) {
+		return rangeError(name, s)
+	}
+	return nil
+}
+
+// rangeError says what numbers a schema allows, in the same words our handlers always have
+func rangeError(name string, s *Schema) error {
+	switch {
+	case s.Minimum != nil && s.Maximum != nil:
+		return fmt.Errorf("%s must be a number between %v and %v", name, *s.Minimum, *s.Maximum)
+	case s.Minimum != nil && *s.Minimum == 0:
+		return fmt.Errorf("%s must be a non-negative number", name)
+	case s.Minimum != nil:
+		return fmt.Errorf("%s must be a number no less than %v", name, *s.Minimum)
+	case s.Maximum != nil:
+		return fmt.Errorf("%s must be a number no more than %v", name, *s.Maximum)
+	}
+	return fmt.Errorf("%s must be a number", name)
+}
+
+func validateText(name string, s *Schema, v string) error {
+
+	if len(s.Enum) > 0 {
+		for _, allowed := range s.Enum {
+			if v == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of %s", name, strings.Join(s.Enum, ", "))
+	}
+
+	if s.Pattern != "" && !schemaPatterns[s.Pattern].MatchString(v) {
+		return fmt.Errorf("%s must match %s", name, s.Pattern)
+	}
+
+	return nil
+}
+
+// validateBody checks a JSON request body against the route's schema for it
+func validateBody(requestBody *OpenApiRequestBody, body []byte) error {
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return fmt.Errorf("invalid request: a JSON body is required")
+	}
+
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("invalid request: %s", err)
+	}
+
+	return validateValue("request", requestBody.Content[gin.MIMEJSON].Schema, v)
+}
+
+// resolveSchema follows a schema's reference to our components, if it has one
+func resolveSchema(s *Schema) *Schema {
+	if s.Ref != "" {
+		return openApiDocument.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// jsonType names the JSON Schema type of a value decoded with UseNumber
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// validateValue checks part of a request body against its schema, naming it by its path from the body's root
+// (e.g. filter.scoreMin) when it isn't valid
+func validateValue(name string, s *Schema, v interface{}) error {
+
+	s = resolveSchema(s)
+	if len(s.types()) == 0 {
+		return nil
+	}
+
+	t := jsonType(v)
+	if !s.allows(t) {
+		return fmt.Errorf("%s must be %s", name, describeType(s))
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return validateNumber(name, s, f)
+	case string:
+		return validateText(name, s, v)
+	case []interface{}:
+		for i, item := range v {
+			if err := validateValue(fmt.Sprintf("%s[%d]", name, i), s.Items, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, required := range s.Required {
+			if _, ok := v[required]; !ok {
+				return fmt.Errorf("%s is required", childName(name, required))
+			}
+		}
+
+		// validate in a stable order, so the same body always gets the same error
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			property, ok := s.Properties[k]
+			if !ok {
+				property = s.AdditionalProperties
+			}
+
+			// we ignore properties we don't know, as does encoding/json, and nulls, which leave fields unset
+			if property == nil || v[k] == nil {
+				continue
+			}
+			if err := validateValue(childName(name, k), property, v[k]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// childName names a property of part of a request body. Properties of the body itself are named alone
+func childName(parent, property string) string {
+	if parent == "request" {
+		return property
+	}
+	return parent + "." + property
+}
+
+func describeType(s *Schema) string {
+	names := map[string]string{
+		"boolean": "true or false",
+		"integer": "an integer",
+		"number":  "a number",
+		"string":  "a string",
+		"array":   "a list",
+		"object":  "an object",
+	}
+	described := []string{}
+	for _, t := range s.types() {
+		if t != "null" {
+			described = append(described, names[t])
+		}
+	}
+	return strings.Join(described, " or ")
+}
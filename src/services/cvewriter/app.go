@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,24 +18,97 @@ import (
 )
 
 var (
-	kafkaNvdReader *kafka.Reader
-	dbCollection   *mongo.Collection
-	wg             sync.WaitGroup
-	maxWorkers     = 10 // Maximum number of concurrent goroutines
+	kafkaNvdReader      *kafka.Reader
+	kafkaOsvReader      *kafka.Reader
+	kafkaKevReader      *kafka.Reader
+	kafkaEpssReader     *kafka.Reader
+	kafkaCweReader      *kafka.Reader
+	kafkaCpeReader      *kafka.Reader
+	kafkaCpeMatchReader *kafka.Reader
+	kafkaChangeReader   *kafka.Reader
+	kafkaDistroReader   *kafka.Reader
+	kafkaUpdateWriter   *kafka.Writer
+	dbCollection        *mongo.Collection
+	advisoryCollection  *mongo.Collection
+	kevCollection       *mongo.Collection
+	epssCollection      *mongo.Collection
+	cweCollection       *mongo.Collection
+	cpeCollection       *mongo.Collection
+	cpeMatchCollection  *mongo.Collection
+	changeCollection    *mongo.Collection
+	distroCollection    *mongo.Collection
+	searchLanguage      string
+	wg                  sync.WaitGroup
+	maxWorkers          = 10 // Maximum number of concurrent goroutines
 )
 
-func init() {
+// setup connects to Kafka and MongoDB, and reads how we merge sources
+func setup() {
 	// Connect to Kafka broker and create a reader for each source topic
 	kafkaServer := readFromENV("KAFKA_BROKER", "localhost:9092")
 	kafkaNvdTopic := readFromENV("KAFKA_NVD_TOPIC", "nvd-cves")
 	kafkaNvdReader = newKafkaReader(kafkaServer, kafkaNvdTopic)
+	kafkaOsvTopic := readFromENV("KAFKA_OSV_TOPIC", "osv-advisories")
+	kafkaOsvReader = newKafkaReader(kafkaServer, kafkaOsvTopic)
+	kafkaKevTopic := readFromENV("KAFKA_KEV_TOPIC", "kev-entries")
+	kafkaKevReader = newKafkaReader(kafkaServer, kafkaKevTopic)
+	kafkaEpssTopic := readFromENV("KAFKA_EPSS_TOPIC", "epss-scores")
+	kafkaEpssReader = newKafkaReader(kafkaServer, kafkaEpssTopic)
+	kafkaCweTopic := readFromENV("KAFKA_CWE_TOPIC", "cwe-entries")
+	kafkaCweReader = newKafkaReader(kafkaServer, kafkaCweTopic)
+	kafkaCpeTopic := readFromENV("KAFKA_CPE_TOPIC", "cpe-products")
+	kafkaCpeReader = newKafkaReader(kafkaServer, kafkaCpeTopic)
+	kafkaCpeMatchTopic := readFromENV("KAFKA_CPEMATCH_TOPIC", "cpe-matches")
+	kafkaCpeMatchReader = newKafkaReader(kafkaServer, kafkaCpeMatchTopic)
+	kafkaChangeTopic := readFromENV("KAFKA_CHANGE_TOPIC", "cve-changes")
+	kafkaChangeReader = newKafkaReader(kafkaServer, kafkaChangeTopic)
+	kafkaDistroTopic := readFromENV("KAFKA_DISTRO_TOPIC", "distro-status")
+	kafkaDistroReader = newKafkaReader(kafkaServer, kafkaDistroTopic)
+	kafkaUpdateTopic := readFromENV("KAFKA_UPDATE_TOPIC", "cve-updates")
+	kafkaUpdateWriter = &kafka.Writer{
+		Addr:     kafka.TCP(kafkaServer), // TODO handling for multiple brokers
+		Topic:    kafkaUpdateTopic,
+		Balancer: &kafka.Hash{}, // keyed by CVE ID, so each CVE's updates stay in order
+	}
 	fmt.Println("Kafka Broker - ", kafkaServer)
-	fmt.Println("Kafka Topic - ", kafkaNvdTopic)
+	fmt.Println("Kafka Topics - ", kafkaNvdTopic, kafkaOsvTopic, kafkaKevTopic, kafkaEpssTopic, kafkaCweTopic, kafkaCpeTopic, kafkaCpeMatchTopic, kafkaChangeTopic, kafkaDistroTopic)
+	fmt.Println("Kafka Update Topic - ", kafkaUpdateTopic)
 
 	// Connect to MongoDB
 	mongoServer := readFromENV("MONGO_URL", "mongodb://localhost:27017")
 	mongoDatabaseName := readFromENV("MONGO_DB", "melakaDB")
 	mongoCollectionName := readFromENV("MONGO_COLLECTION", "cves")
+	mongoAdvisoryCollectionName := readFromENV("MONGO_ADVISORY_COLLECTION", "advisories")
+	mongoKevCollectionName := readFromENV("MONGO_KEV_COLLECTION", "kev")
+	mongoEpssCollectionName := readFromENV("MONGO_EPSS_COLLECTION", "epsshistory")
+	mongoCweCollectionName := readFromENV("MONGO_CWE_COLLECTION", "cwes")
+	mongoCpeCollectionName := readFromENV("MONGO_CPE_COLLECTION", "cpes")
+	mongoCpeMatchCollectionName := readFromENV("MONGO_CPEMATCH_COLLECTION", "cpematches")
+	mongoChangeCollectionName := readFromENV("MONGO_CHANGE_COLLECTION", "cvechanges")
+	mongoDistroCollectionName := readFromENV("MONGO_DISTRO_COLLECTION", "distrostatus")
+
+	// the language of the descriptions we index CVEs' records for full-text search under, which cvequerier's text
+	// index stems words in too. Mongo takes the same ISO 639-1 codes as NVD's descriptions are tagged with
+	searchLanguage = readFromENV("SEARCH_LANGUAGE", "en")
+
+	// which source's word to take for each field of a CVE's consolidated view. By default NVD's analysis wins for the
+	// description and severity, but the advisories' package ranges are more precise than NVD's CPE configurations
+	for _, field := range []struct {
+		key        string
+		defaultVal string
+		precedence *[]string
+	}{
+		{"MERGE_DESCRIPTION_PRECEDENCE", "nvd,ghsa,osv,kev", &mergePrecedence.Description},
+		{"MERGE_SEVERITY_PRECEDENCE", "nvd,ghsa,osv", &mergePrecedence.Severity},
+		{"MERGE_AFFECTED_PRECEDENCE", "ghsa,osv,nvd", &mergePrecedence.Affected},
+	} {
+		precedence, err := parsePrecedence(readFromENV(field.key, field.defaultVal))
+		if err != nil {
+			log.Fatalf("Invalid %s: %s", field.key, err)
+		}
+		*field.precedence = precedence
+	}
+	fmt.Printf("Merge precedence - description %v, severity %v, affected %v\n", mergePrecedence.Description, mergePrecedence.Severity, mergePrecedence.Affected)
 
 	credentials := options.Credential{
 		Username: readFromENV("MONGO_ROOT_USERNAME", "dev"),
@@ -52,16 +126,125 @@ func init() {
 	}
 
 	dbCollection = dbClient.Database(mongoDatabaseName).Collection(mongoCollectionName)
+	advisoryCollection = dbClient.Database(mongoDatabaseName).Collection(mongoAdvisoryCollectionName)
+	kevCollection = dbClient.Database(mongoDatabaseName).Collection(mongoKevCollectionName)
+	epssCollection = dbClient.Database(mongoDatabaseName).Collection(mongoEpssCollectionName)
+	cweCollection = dbClient.Database(mongoDatabaseName).Collection(mongoCweCollectionName)
+	cpeCollection = dbClient.Database(mongoDatabaseName).Collection(mongoCpeCollectionName)
+	cpeMatchCollection = dbClient.Database(mongoDatabaseName).Collection(mongoCpeMatchCollectionName)
+	changeCollection = dbClient.Database(mongoDatabaseName).Collection(mongoChangeCollectionName)
+	distroCollection = dbClient.Database(mongoDatabaseName).Collection(mongoDistroCollectionName)
+	mergeStore = dbCollection
+
+	// merging sources into a CVE's record relies on there only ever being one record per CVE
+	index := mongo.IndexModel{Keys: bson.D{{Key: "cvedata.id", Value: 1}}, Options: options.Index().SetUnique(true)}
+	if _, err := dbCollection.Indexes().CreateOne(context.TODO(), index); err != nil {
+		log.Printf("Failed to create unique index on cvedata.id, concurrent updates to a new CVE may duplicate it: %s", err)
+	}
+
+	// KEV entries are upserted by their CVE, and looked up by it whenever NVD creates a CVE's record
+	index = mongo.IndexModel{Keys: bson.D{{Key: "kevdata.cveID", Value: 1}}, Options: options.Index().SetUnique(true)}
+	if _, err := kevCollection.Indexes().CreateOne(context.TODO(), index); err != nil {
+		log.Printf("Failed to create unique index on kevdata.cveID, storing KEV entries will be slow: %s", err)
+	}
+
+	// likewise scores are upserted by their CVE and day, and a CVE's latest looked up when NVD creates its record
+	index = mongo.IndexModel{
+		Keys:    bson.D{{Key: "cve", Value: 1}, {Key: "date", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := epssCollection.Indexes().CreateOne(context.TODO(), index); err != nil {
+		log.Printf("Failed to create unique index on EPSS history, storing scores will be slow: %s", err)
+	}
+
+	// each distro status is upserted by its CVE, distro, release and package, which without an index means a scan of
+	// every status we hold for each one
+	index = mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "cve", Value: 1},
+			{Key: "distro", Value: 1},
+			{Key: "release", Value: 1},
+			{Key: "package", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	if _, err := distroCollection.Indexes().CreateOne(context.TODO(), index); err != nil {
+		log.Printf("Failed to create unique index on distro statuses, upserting them will be slow: %s", err)
+	}
 }
 
 func main() {
+	setup()
 	defer kafkaNvdReader.Close()
+	defer kafkaOsvReader.Close()
+	defer kafkaKevReader.Close()
+	defer kafkaEpssReader.Close()
+	defer kafkaCweReader.Close()
+	defer kafkaCpeReader.Close()
+	defer kafkaCpeMatchReader.Close()
+	defer kafkaChangeReader.Close()
+	defer kafkaDistroReader.Close()
+	defer kafkaUpdateWriter.Close()
 
-	// Create a channel to limit the number of goroutines
+	go backfillPackageKeys()
+
+	// Create a channel to limit the number of goroutines, shared by the consumers of every source topic
 	workerChan := make(chan struct{}, maxWorkers)
 
+	go consume(kafkaOsvReader, handleOsvMsg, workerChan)
+	go consume(kafkaKevReader, handleKevMsg, workerChan)
+	go consume(kafkaEpssReader, handleEpssMsg, workerChan)
+	go consume(kafkaCweReader, handleCweMsg, workerChan)
+	go consume(kafkaCpeReader, handleCpeMsg, workerChan)
+	go consume(kafkaCpeMatchReader, handleCpeMatchMsg, workerChan)
+	go consume(kafkaChangeReader, handleCveChangeMsg, workerChan)
+	go consume(kafkaDistroReader, handleDistroMsg, workerChan)
+	consume(kafkaNvdReader, handleNvdMsg, workerChan)
+
+}
+
+// backfillPackageKeys stores the package keys of any advisory written before we kept them, so cvequerier can find
+// them by package without waiting for the scrapers to send them again
+func backfillPackageKeys() {
+
+	filter := bson.D{{Key: "packages", Value: bson.D{{Key: "$exists", Value: false}}}}
+	cursor, err := advisoryCollection.Find(context.TODO(), filter)
+	if err != nil {
+		log.Printf("Failed to find advisories without package keys: %s", err)
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	count := 0
+	for cursor.Next(context.TODO()) {
+		var doc struct {
+			ID  interface{} `bson:"_id"`
+			Osv OsvAdvisory `bson:"osvdata"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			log.Printf("Failed to decode advisory: %s", err)
+			continue
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "packages", Value: doc.Osv.PackageKeys()}}}}
+		if _, err := advisoryCollection.UpdateByID(context.TODO(), doc.ID, update); err != nil {
+			log.Printf("Failed to store package keys of advisory %s: %s", doc.Osv.ID, err)
+			continue
+		}
+		count++
+	}
+
+	if count > 0 {
+		fmt.Printf("Stored package keys of %d advisories\n", count)
+	}
+}
+
+// consume reads messages from a source topic forever, handing each to the given handler
+// on its own goroutine once a worker is available
+func consume(reader *kafka.Reader, handle func(kafka.Message) error, workerChan chan struct{}) {
+
 	for {
-		m, err := kafkaNvdReader.ReadMessage(context.Background())
+		m, err := reader.ReadMessage(context.Background())
 		if err != nil {
 			continue
 		}
@@ -79,8 +262,8 @@ func main() {
 				wg.Done()    // Decrement the WaitGroup when the goroutine completes
 			}()
 
-			if err := handleNvdMsg(msg); err != nil {
-				fmt.Printf("Failed to handle message: %s", err)
+			if err := handle(msg); err != nil {
+				fmt.Printf("Failed to handle message: %s\n", err)
 			}
 		}(m)
 	}
@@ -101,6 +284,211 @@ func handleNvdMsg(msg kafka.Message) error {
 		return error(fmt.Errorf("CVE ID is empty"))
 	}
 
+	var updateDoc bson.D
+	if err := bson.UnmarshalExtJSON(msg.Value, false, &updateDoc); err != nil {
+		return err
+	}
+	updateDoc = append(updateDoc,
+		bson.E{Key: "preferredScore", Value: cveMsg.Cve.PreferredScore()},
+		bson.E{Key: "search", Value: cveMsg.Cve.SearchText(searchLanguage)},
+	)
+
+	record := NewNvdSourceRecord(cveMsg.Cve)
+	result, err := mergeSources(cveMsg.Cve.ID, "nvd", updateDoc, func(sources *CveSources) {
+		sources.Nvd = &record
+	})
+	if err != nil {
+		return err
+	}
+
+	logUpsert(result, "CVE", cveMsg.Cve.ID)
+
+	return nil
+}
+
+func handleOsvMsg(msg kafka.Message) error {
+
+	// deserialize the json in the kafka message
+	var osvMsg OsvMsg
+	if err := json.Unmarshal(msg.Value, &osvMsg); err != nil {
+		return err
+	}
+
+	if osvMsg.Osv.ID == "" {
+		return error(fmt.Errorf("advisory ID is empty"))
+	}
+
+	var updateDoc bson.D
+	if err := bson.UnmarshalExtJSON(msg.Value, false, &updateDoc); err != nil {
+		return err
+	}
+	updateDoc = append(updateDoc, bson.E{Key: "packages", Value: osvMsg.Osv.PackageKeys()})
+
+	update := bson.D{{Key: "$set", Value: updateDoc}}
+
+	filter := bson.D{{Key: "osvdata.id", Value: osvMsg.Osv.ID}}
+	opts := options.Update().SetUpsert(true)
+	result, err := advisoryCollection.UpdateOne(context.TODO(), filter, update, opts)
+	if err != nil {
+		return err
+	}
+
+	logUpsert(result, "advisory", osvMsg.Osv.ID)
+
+	// merge the advisory into each CVE it describes that we have a record for, which lists it among its aliases
+	source := "osv"
+	if osvMsg.IsGhsa() {
+		source = "ghsa"
+	}
+	for _, cveID := range osvMsg.Osv.CveIDs() {
+		if _, err := mergeSources(cveID, source, nil, func(sources *CveSources) {
+			sources.addAdvisory(osvMsg)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func handleKevMsg(msg kafka.Message) error {
+
+	// deserialize the json in the kafka message
+	var kevMsg KevMsg
+	if err := json.Unmarshal(msg.Value, &kevMsg); err != nil {
+		return err
+	}
+
+	if kevMsg.Kev.CveID == "" {
+		return error(fmt.Errorf("CVE ID is empty"))
+	}
+
+	// the entry is kept as it was sent, so it can be merged in when NVD creates the CVE's record if it hasn't yet
+	var entryDoc bson.D
+	if err := bson.UnmarshalExtJSON(msg.Value, false, &entryDoc); err != nil {
+		return err
+	}
+	filter := bson.D{{Key: "kevdata.cveID", Value: kevMsg.Kev.CveID}}
+	if _, err := kevCollection.UpdateOne(context.TODO(), filter, bson.D{{Key: "$set", Value: entryDoc}}, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+
+	// and its KEV block attached to the CVE's record
+	set := bson.D{{Key: "kev", Value: NewKevData(kevMsg.Kev)}}
+
+	record := NewKevSourceRecord(kevMsg.Kev)
+	result, err := mergeSources(kevMsg.Kev.CveID, "kev", set, func(sources *CveSources) {
+		sources.Kev = &record
+	})
+	if err != nil {
+		return err
+	}
+
+	logUpsert(result, "KEV", kevMsg.Kev.CveID)
+
+	return nil
+}
+
+func handleEpssMsg(msg kafka.Message) error {
+
+	// deserialize the json in the kafka message
+	var epssMsg EpssMsg
+	if err := json.Unmarshal(msg.Value, &epssMsg); err != nil {
+		return err
+	}
+
+	if epssMsg.Epss.Cve == "" {
+		return error(fmt.Errorf("CVE ID is empty"))
+	}
+
+	score := NewEpssData(epssMsg.Epss)
+	opts := options.Update().SetUpsert(true)
+
+	// keep the full history of scores, one document per CVE per day
+	historyFilter := bson.D{{Key: "cve", Value: score.Cve}, {Key: "date", Value: score.Date}}
+	historyUpdate := bson.D{{Key: "$set", Value: score}}
+	if _, err := epssCollection.UpdateOne(context.TODO(), historyFilter, historyUpdate, opts); err != nil {
+		return err
+	}
+
+	// and the latest score on the CVE's record, if NVD has created it - one it hasn't picks the score up from the
+	// history when it does. We use a pipeline update so that replaying an older file can't overwrite a newer score:
+	// the existing block is kept unless ours is from a later day, and the revision only moves on if the block changes
+	changed := bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "$gte", Value: bson.A{score.Date, bson.D{{Key: "$ifNull", Value: bson.A{"$epss.date", ""}}}}}},
+		bson.D{{Key: "$ne", Value: bson.A{"$epss", score}}},
+	}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "epss", Value: bson.D{{Key: "$cond", Value: bson.A{changed, score, "$epss"}}}},
+		{Key: "revision", Value: bson.D{{Key: "$cond", Value: bson.A{
+			changed,
+			bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$revision", 0}}}, 1}}},
+			"$revision",
+		}}}},
+	}}}}
+
+	filter := bson.D{{Key: "cvedata.id", Value: score.Cve}}
+	result, err := dbCollection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		result = nil
+	}
+
+	logUpsert(result, "EPSS", score.Cve)
+
+	if result != nil && result.ModifiedCount > 0 {
+		var doc mergeDoc
+		projection := options.FindOne().SetProjection(bson.D{{Key: "revision", Value: 1}})
+		if err := dbCollection.FindOne(context.TODO(), filter, projection).Decode(&doc); err != nil {
+			return err
+		}
+		mergePublish(score.Cve, "updated", "epss", doc.Revision)
+	}
+
+	return nil
+}
+
+func handleCweMsg(msg kafka.Message) error {
+
+	// deserialize the json in the kafka message
+	var cweMsg CweMsg
+	if err := json.Unmarshal(msg.Value, &cweMsg); err != nil {
+		return err
+	}
+
+	if cweMsg.Cwe.ID == "" {
+		return error(fmt.Errorf("CWE ID is empty"))
+	}
+
+	// catalog entries are reference data, so we store the entry itself rather than the message wrapping it
+	update := bson.D{{Key: "$set", Value: cweMsg.Cwe}}
+
+	filter := bson.D{{Key: "id", Value: cweMsg.Cwe.ID}}
+	opts := options.Update().SetUpsert(true)
+	result, err := cweCollection.UpdateOne(context.TODO(), filter, update, opts)
+	if err != nil {
+		return err
+	}
+
+	logUpsert(result, "CWE", cweMsg.Cwe.ID)
+
+	return nil
+}
+
+func handleCpeMsg(msg kafka.Message) error {
+
+	// deserialize the json in the kafka message
+	var cpeMsg CpeMsg
+	if err := json.Unmarshal(msg.Value, &cpeMsg); err != nil {
+		return err
+	}
+
+	if cpeMsg.Cpe.CpeNameID == "" {
+		return error(fmt.Errorf("CPE name ID is empty"))
+	}
+
 	var updateDoc interface{}
 	if err := bson.UnmarshalExtJSON(msg.Value, false, &updateDoc); err != nil {
 		return err
@@ -108,22 +496,147 @@ func handleNvdMsg(msg kafka.Message) error {
 
 	update := bson.D{{Key: "$set", Value: updateDoc}}
 
-	filter := bson.D{{Key: "cvedata.id", Value: cveMsg.Cve.ID}}
+	filter := bson.D{{Key: "cpedata.cpeNameId", Value: cpeMsg.Cpe.CpeNameID}}
 	opts := options.Update().SetUpsert(true)
-	result, err := dbCollection.UpdateOne(context.TODO(), filter, update, opts)
+	result, err := cpeCollection.UpdateOne(context.TODO(), filter, update, opts)
 	if err != nil {
 		return err
 	}
 
-	if result.ModifiedCount > 0 {
-		fmt.Printf("Updated record for CVE %s\n", cveMsg.Cve.ID)
-	} else {
-		fmt.Printf("Inserted record with ID %s\n", result.UpsertedID.(primitive.ObjectID).Hex())
+	logUpsert(result, "CPE", cpeMsg.Cpe.CpeName)
+
+	return nil
+}
+
+func handleCpeMatchMsg(msg kafka.Message) error {
+
+	// deserialize the json in the kafka message
+	var matchMsg CpeMatchMsg
+	if err := json.Unmarshal(msg.Value, &matchMsg); err != nil {
+		return err
+	}
+
+	if matchMsg.Match.MatchCriteriaID == "" {
+		return error(fmt.Errorf("match criteria ID is empty"))
+	}
+
+	var updateDoc interface{}
+	if err := bson.UnmarshalExtJSON(msg.Value, false, &updateDoc); err != nil {
+		return err
+	}
+
+	update := bson.D{{Key: "$set", Value: updateDoc}}
+
+	filter := bson.D{{Key: "matchdata.matchCriteriaId", Value: matchMsg.Match.MatchCriteriaID}}
+	opts := options.Update().SetUpsert(true)
+	result, err := cpeMatchCollection.UpdateOne(context.TODO(), filter, update, opts)
+	if err != nil {
+		return err
+	}
+
+	logUpsert(result, "CPE match", matchMsg.Match.MatchCriteriaID)
+
+	return nil
+}
+
+func handleCveChangeMsg(msg kafka.Message) error {
+
+	// deserialize the json in the kafka message
+	var changeMsg CveChangeMsg
+	if err := json.Unmarshal(msg.Value, &changeMsg); err != nil {
+		return err
+	}
+
+	if changeMsg.Change.CveChangeID == "" {
+		return error(fmt.Errorf("CVE change ID is empty"))
+	}
+
+	var updateDoc interface{}
+	if err := bson.UnmarshalExtJSON(msg.Value, false, &updateDoc); err != nil {
+		return err
+	}
+
+	update := bson.D{{Key: "$set", Value: updateDoc}}
+
+	// the history windows we fetch can overlap, so the same change may arrive more than once
+	filter := bson.D{{Key: "changedata.cveChangeId", Value: changeMsg.Change.CveChangeID}}
+	opts := options.Update().SetUpsert(true)
+	result, err := changeCollection.UpdateOne(context.TODO(), filter, update, opts)
+	if err != nil {
+		return err
+	}
+
+	logUpsert(result, "CVE change", changeMsg.Change.CveChangeID)
+
+	return nil
+}
+
+func handleDistroMsg(msg kafka.Message) error {
+
+	// deserialize the json in the kafka message
+	var distroMsg DistroMsg
+	if err := json.Unmarshal(msg.Value, &distroMsg); err != nil {
+		return err
+	}
+
+	status := distroMsg.Status
+	if status.Cve == "" || status.Distro == "" || status.Package == "" {
+		return error(fmt.Errorf("distro status is missing its CVE, distro or package"))
+	}
+
+	// a distro's assessment is per package per release, each overwriting the last
+	update := bson.D{{Key: "$set", Value: status}}
+
+	filter := bson.D{
+		{Key: "cve", Value: status.Cve},
+		{Key: "distro", Value: status.Distro},
+		{Key: "release", Value: status.Release},
+		{Key: "package", Value: status.Package},
+	}
+	opts := options.Update().SetUpsert(true)
+	result, err := distroCollection.UpdateOne(context.TODO(), filter, update, opts)
+	if err != nil {
+		return err
 	}
 
+	logUpsert(result, "distro status", fmt.Sprintf("%s %s/%s/%s", status.Cve, status.Distro, status.Release, status.Package))
+
 	return nil
 }
 
+// publishUpdate lets the services that react to CVE updates know about one. A CVE's record is still written if
+// we can't, so failures are only logged
+func publishUpdate(cveID, event, source string, revision int64) {
+
+	value, err := json.Marshal(CveUpdateMsg{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		CveID:     cveID,
+		Event:     event,
+		Source:    source,
+		Revision:  revision,
+	})
+	if err != nil {
+		fmt.Printf("Failed to encode update to %s: %s\n", cveID, err)
+		return
+	}
+
+	if err := kafkaUpdateWriter.WriteMessages(context.TODO(), kafka.Message{Key: []byte(cveID), Value: value}); err != nil {
+		fmt.Printf("Failed to publish update to %s: %s\n", cveID, err)
+	}
+}
+
+func logUpsert(result *mongo.UpdateResult, kind string, id string) {
+	if result == nil {
+		fmt.Printf("No CVE record for %s yet, skipped %s record\n", id, kind)
+	} else if result.UpsertedID != nil {
+		fmt.Printf("Inserted %s record with ID %s\n", kind, result.UpsertedID.(primitive.ObjectID).Hex())
+	} else if result.ModifiedCount > 0 {
+		fmt.Printf("Updated %s record for %s\n", kind, id)
+	} else {
+		fmt.Printf("No changes to %s record for %s\n", kind, id)
+	}
+}
+
 func newKafkaReader(kafkaURL, topic string) *kafka.Reader {
 	return kafka.NewReader(kafka.ReaderConfig{
 		Brokers:   []string{kafkaURL}, // TODO handling for multiple brokers
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// the sources that keep a record on a CVE's document, in the order we list them
+var sourceNames = []string{"nvd", "osv", "ghsa", "kev"}
+
+// MergePrecedence is the order we trust the sources in for each consolidated field
+type MergePrecedence struct {
+	Description []string
+	Severity    []string
+	Affected    []string
+}
+
+var mergePrecedence MergePrecedence
+
+// parsePrecedence parses a comma separated list of source names, e.g. "ghsa,nvd"
+func parsePrecedence(v string) ([]string, error) {
+	precedence := []string{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, source := range sourceNames {
+			known = known || source == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown source: %s", name)
+		}
+		precedence = append(precedence, name)
+	}
+	return precedence, nil
+}
+
+// records returns the source's records, or nil if it hasn't reported on the CVE
+func (s *CveSources) records(name string) []SourceRecord {
+	switch name {
+	case "nvd":
+		if s.Nvd != nil {
+			return []SourceRecord{*s.Nvd}
+		}
+	case "osv":
+		return s.Osv
+	case "ghsa":
+		return s.Ghsa
+	case "kev":
+		if s.Kev != nil {
+			return []SourceRecord{*s.Kev}
+		}
+	}
+	return nil
+}
+
+// first returns the first record with something to say, going through the sources in the given order, along with
+// the name of its source. The name is empty if none of them do
+func (s *CveSources) first(precedence []string, has func(SourceRecord) bool) (SourceRecord, string) {
+	for _, name := range precedence {
+		for _, record := range s.records(name) {
+			if has(record) {
+				return record, name
+			}
+		}
+	}
+	return SourceRecord{}, ""
+}
+
+// setAdvisory adds or replaces an advisory's record, keeping them in ID order. Withdrawn advisories are removed
+func setAdvisory(records []SourceRecord, record SourceRecord, withdrawn bool) []SourceRecord {
+	kept := []SourceRecord{}
+	for _, r := range records {
+		if r.ID != record.ID {
+			kept = append(kept, r)
+		}
+	}
+	if !withdrawn {
+		kept = append(kept, record)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].ID < kept[j].ID })
+	return kept
+}
+
+// addAdvisory adds or replaces an OSV-format advisory's record under GHSA if it's one of GitHub's, or OSV otherwise
+func (s *CveSources) addAdvisory(msg OsvMsg) {
+	record, withdrawn := NewOsvSourceRecord(msg.Osv), msg.Osv.Withdrawn != ""
+	if msg.IsGhsa() {
+		s.Ghsa = setAdvisory(s.Ghsa, record, withdrawn)
+	} else {
+		s.Osv = setAdvisory(s.Osv, record, withdrawn)
+	}
+}
+
+// aliases returns the IDs of the advisories describing the CVE, which its document lists as its aliases
+func (s *CveSources) aliases() []string {
+	aliases := []string{}
+	for _, record := range append(append([]SourceRecord{}, s.Osv...), s.Ghsa...) {
+		aliases = append(aliases, record.ID)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// consolidate builds a CVE's consolidated view from its sources' records
+func consolidate(s CveSources, precedence MergePrecedence) ConsolidatedView {
+
+	view := ConsolidatedView{Sources: []string{}, Affected: []Affected{}}
+
+	for _, name := range sourceNames {
+		if len(s.records(name)) > 0 {
+			view.Sources = append(view.Sources, name)
+		}
+	}
+
+	if record, name := s.first(precedence.Description, func(r SourceRecord) bool { return r.Description != "" }); name != "" {
+		view.Description, view.DescriptionSource = record.Description, name
+	}
+
+	if record, name := s.first(precedence.Severity, func(r SourceRecord) bool { return r.Severity != nil }); name != "" {
+		view.Severity, view.SeveritySource = record.Severity, name
+	}
+
+	// a source's advisories tend to each cover a different ecosystem, so we take everything they list between them
+	for _, name := range precedence.Affected {
+		for _, record := range s.records(name) {
+			view.Affected = append(view.Affected, record.Affected...)
+		}
+		if len(view.Affected) > 0 {
+			view.AffectedSource = name
+			break
+		}
+	}
+
+	return view
+}
+
+// the parts of a CVE's document we read back to merge an update into
+type mergeDoc struct {
+	Sources  CveSources `bson:"sources"`
+	Revision int64      `bson:"revision"`
+}
+
+// how many times we'll re-read a CVE that another worker updated under us before giving up
+const maxMergeAttempts = 10
+
+// the parts of the CVE collection merging reads and writes
+type cveStore interface {
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+}
+
+// where merging keeps CVEs' records, publishes their updates and finds what we already hold about a CVE it's
+// creating the record for. They're dbCollection, publishUpdate and storedSources outside of the tests
+var (
+	mergeStore   cveStore
+	mergePublish = publishUpdate
+	mergeHeld    = storedSources
+)
+
+// what other sources told us about a CVE before NVD published it, kept until NVD creates its record
+type heldSources struct {
+	Advisories []OsvMsg
+	Kev        *KevEntry
+	Epss       *EpssData
+}
+
+// storedSources returns the advisories, KEV entry and latest EPSS score we've stored for a CVE
+func storedSources(cveID string) (heldSources, error) {
+
+	advisories, err := storedAdvisories(cveID)
+	if err != nil {
+		return heldSources{}, err
+	}
+	kev, err := storedKev(cveID)
+	if err != nil {
+		return heldSources{}, err
+	}
+	epss, err := storedEpss(cveID)
+	if err != nil {
+		return heldSources{}, err
+	}
+
+	return heldSources{Advisories: advisories, Kev: kev, Epss: epss}, nil
+}
+
+// storedEpss returns the latest EPSS score in a CVE's history, or nil if it hasn't been scored
+func storedEpss(cveID string) (*EpssData, error) {
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "date", Value: -1}})
+	var score EpssData
+	err := epssCollection.FindOne(context.TODO(), bson.D{{Key: "cve", Value: cveID}}, opts).Decode(&score)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &score, nil
+}
+
+// storedKev returns the KEV catalog's entry for a CVE, or nil if it isn't in the catalog
+func storedKev(cveID string) (*KevEntry, error) {
+
+	raw, err := kevCollection.FindOne(context.TODO(), bson.D{{Key: "kevdata.cveID", Value: cveID}}).DecodeBytes()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// entries are stored as the scraper sent them, like advisories
+	data, err := bson.MarshalExtJSON(raw, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var msg KevMsg
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+
+	return &msg.Kev, nil
+}
+
+// storedAdvisories returns the OSV-format advisories in the advisory collection that describe a CVE
+func storedAdvisories(cveID string) ([]OsvMsg, error) {
+
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "osvdata.id", Value: cveID}},
+		bson.D{{Key: "osvdata.aliases", Value: cveID}},
+	}}}
+	cursor, err := advisoryCollection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	// advisories are stored as the scrapers sent them, so are read back the same way
+	advisories := []OsvMsg{}
+	for cursor.Next(context.TODO()) {
+		data, err := bson.MarshalExtJSON(cursor.Current, false, false)
+		if err != nil {
+			return nil, err
+		}
+		var msg OsvMsg
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		advisories = append(advisories, msg)
+	}
+
+	return advisories, cursor.Err()
+}
+
+// mergeSources applies a source's update to a CVE's record and recomputes its consolidated view. The update is
+// made with optimistic concurrency: we read the document's revision along with its sources, and only write back
+// if the revision hasn't moved on since, trying again from the top if it has. Any fields in set are set alongside.
+// If the update created the record or changed what the source says about the CVE, we publish it to the updates topic.
+//
+// Only NVD creates a CVE's record, so an advisory or KEV entry for a CVE NVD hasn't published leaves no stub behind,
+// and nil is returned. Both are stored in their own collections, as are EPSS scores, and merged in when NVD does
+// create the record
+func mergeSources(cveID string, source string, set bson.D, update func(*CveSources)) (*mongo.UpdateResult, error) {
+
+	filter := bson.D{{Key: "cvedata.id", Value: cveID}}
+	projection := options.FindOne().SetProjection(bson.D{{Key: "sources", Value: 1}, {Key: "revision", Value: 1}})
+
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+
+		var doc mergeDoc
+		err := mergeStore.FindOne(context.TODO(), filter, projection).Decode(&doc)
+		exists := !errors.Is(err, mongo.ErrNoDocuments)
+		if err != nil && exists {
+			return nil, err
+		}
+		if !exists && source != "nvd" {
+			return nil, nil
+		}
+		fields := append(bson.D{}, set...)
+		if !exists {
+			held, err := mergeHeld(cveID)
+			if err != nil {
+				return nil, err
+			}
+			for _, advisory := range held.Advisories {
+				doc.Sources.addAdvisory(advisory)
+			}
+			if held.Kev != nil {
+				record := NewKevSourceRecord(*held.Kev)
+				doc.Sources.Kev = &record
+				fields = append(fields, bson.E{Key: "kev", Value: NewKevData(*held.Kev)})
+			}
+			if held.Epss != nil {
+				fields = append(fields, bson.E{Key: "epss", Value: *held.Epss})
+			}
+		}
+
+		before, err := bson.Marshal(doc.Sources)
+		if err != nil {
+			return nil, err
+		}
+		update(&doc.Sources)
+		after, err := bson.Marshal(doc.Sources)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields,
+			bson.E{Key: "sources", Value: doc.Sources},
+			bson.E{Key: "consolidated", Value: consolidate(doc.Sources, mergePrecedence)},
+			bson.E{Key: "aliases", Value: doc.Sources.aliases()},
+			bson.E{Key: "revision", Value: doc.Revision + 1},
+		)
+
+		// documents from before we kept a revision match on it being missing. Only a CVE we haven't seen yet is
+		// inserted - if another worker inserts it first, the unique index on cvedata.id turns ours away
+		revisionFilter := bson.D{{Key: "cvedata.id", Value: cveID}, {Key: "revision", Value: nil}}
+		if doc.Revision > 0 {
+			revisionFilter = bson.D{{Key: "cvedata.id", Value: cveID}, {Key: "revision", Value: doc.Revision}}
+		}
+
+		opts := options.Update().SetUpsert(!exists)
+		result, err := mergeStore.UpdateOne(context.TODO(), revisionFilter, bson.D{{Key: "$set", Value: fields}}, opts)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+			continue
+		}
+
+		// sources are stored as we marshal them, so re-sending a record we already have leaves them byte for byte
+		// the same
+		if !exists {
+			mergePublish(cveID, "created", source, doc.Revision+1)
+		} else if !bytes.Equal(before, after) {
+			mergePublish(cveID, "updated", source, doc.Revision+1)
+		}
+
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("gave up merging update to %s after %d attempts, it kept being updated under us", cveID, maxMergeAttempts)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var defaultPrecedence = MergePrecedence{
+	Description: []string{"nvd", "ghsa", "osv", "kev"},
+	Severity:    []string{"nvd", "ghsa", "osv"},
+	Affected:    []string{"ghsa", "osv", "nvd"},
+}
+
+func TestParsePrecedence(t *testing.T) {
+
+	tests := []struct {
+		value    string
+		expected []string
+	}{
+		{"nvd,ghsa,osv,kev", []string{"nvd", "ghsa", "osv", "kev"}},
+		{" GHSA , nvd", []string{"ghsa", "nvd"}},
+		{"kev", []string{"kev"}},
+	}
+
+	for _, test := range tests {
+		actual, err := parsePrecedence(test.value)
+		if err != nil {
+			t.Errorf("%q: %s", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.value, test.expected, actual)
+		}
+	}
+
+	for _, invalid := range []string{"", "nvd,", "nvd,redhat"} {
+		if _, err := parsePrecedence(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestConsolidate(t *testing.T) {
+
+	nvd := &SourceRecord{ID: "CVE-2021-44228", Description: "from NVD", Severity: &Severity{BaseScore: 10},
+		Affected: []Affected{{Cpe: "cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*"}}}
+	ghsa := []SourceRecord{
+		{ID: "GHSA-jfh8-c2jp-5v3q", Description: "from GHSA", Severity: &Severity{BaseSeverity: "CRITICAL"},
+			Affected: []Affected{{Ecosystem: "Maven", Package: "org.apache.logging.log4j:log4j-core"}}},
+		{ID: "GHSA-xxxx-xxxx-xxxx", Affected: []Affected{{Ecosystem: "npm", Package: "log4js"}}},
+	}
+	osv := []SourceRecord{{ID: "PYSEC-0000-0", Description: "from OSV"}}
+	kev := &SourceRecord{ID: "CVE-2021-44228", Description: "from KEV"}
+
+	// the MERGE_*_PRECEDENCE settings, as they'd be overridden
+	override := func(description, severity, affected string) MergePrecedence {
+		var p MergePrecedence
+		var err error
+		for _, field := range []struct {
+			value      string
+			precedence *[]string
+		}{{description, &p.Description}, {severity, &p.Severity}, {affected, &p.Affected}} {
+			if *field.precedence, err = parsePrecedence(field.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return p
+	}
+
+	tests := []struct {
+		name                                           string
+		sources                                        CveSources
+		precedence                                     MergePrecedence
+		description, descriptionSource, severitySource string
+		affectedSource                                 string
+		affected                                       int
+		sourceNames                                    []string
+	}{
+		{"every source, by default", CveSources{Nvd: nvd, Osv: osv, Ghsa: ghsa, Kev: kev}, defaultPrecedence,
+			"from NVD", "nvd", "nvd", "ghsa", 2, []string{"nvd", "osv", "ghsa", "kev"}},
+		{"NVD yet to analyse", CveSources{Nvd: &SourceRecord{ID: "CVE-2021-44228"}, Ghsa: ghsa, Kev: kev}, defaultPrecedence,
+			"from GHSA", "ghsa", "ghsa", "ghsa", 2, []string{"nvd", "ghsa", "kev"}},
+		{"only NVD", CveSources{Nvd: nvd}, defaultPrecedence,
+			"from NVD", "nvd", "nvd", "nvd", 1, []string{"nvd"}},
+		{"overridden", CveSources{Nvd: nvd, Osv: osv, Ghsa: ghsa, Kev: kev}, override("kev,nvd", "ghsa,nvd", "nvd"),
+			"from KEV", "kev", "ghsa", "nvd", 1, []string{"nvd", "osv", "ghsa", "kev"}},
+		{"overridden to sources with nothing to say", CveSources{Nvd: nvd, Kev: kev}, override("osv", "kev", "osv,ghsa"),
+			"", "", "", "", 0, []string{"nvd", "kev"}},
+	}
+
+	for _, test := range tests {
+		view := consolidate(test.sources, test.precedence)
+		if view.Description != test.description || view.DescriptionSource != test.descriptionSource {
+			t.Errorf("%s: expected description %q from %q, got %q from %q", test.name, test.description, test.descriptionSource, view.Description, view.DescriptionSource)
+		}
+		if view.SeveritySource != test.severitySource || (test.severitySource == "") != (view.Severity == nil) {
+			t.Errorf("%s: expected severity from %q, got %+v from %q", test.name, test.severitySource, view.Severity, view.SeveritySource)
+		}
+		if view.AffectedSource != test.affectedSource || len(view.Affected) != test.affected {
+			t.Errorf("%s: expected %d affected from %q, got %+v from %q", test.name, test.affected, test.affectedSource, view.Affected, view.AffectedSource)
+		}
+		if !reflect.DeepEqual(view.Sources, test.sourceNames) {
+			t.Errorf("%s: expected sources %v, got %v", test.name, test.sourceNames, view.Sources)
+		}
+	}
+}
+
+func TestSetAdvisory(t *testing.T) {
+
+	ids := func(records []SourceRecord) []string {
+		ids := []string{}
+		for _, r := range records {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+	existing := []SourceRecord{{ID: "GHSA-a", Modified: "1"}, {ID: "GHSA-c", Modified: "1"}}
+
+	tests := []struct {
+		name      string
+		record    SourceRecord
+		withdrawn bool
+		expected  []string
+	}{
+		{"added in order", SourceRecord{ID: "GHSA-b"}, false, []string{"GHSA-a", "GHSA-b", "GHSA-c"}},
+		{"replaced", SourceRecord{ID: "GHSA-c", Modified: "2"}, false, []string{"GHSA-a", "GHSA-c"}},
+		{"withdrawn", SourceRecord{ID: "GHSA-a"}, true, []string{"GHSA-c"}},
+		{"withdrawn before we had it", SourceRecord{ID: "GHSA-b"}, true, []string{"GHSA-a", "GHSA-c"}},
+	}
+
+	for _, test := range tests {
+		actual := setAdvisory(append([]SourceRecord{}, existing...), test.record, test.withdrawn)
+		if !reflect.DeepEqual(ids(actual), test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, ids(actual))
+		}
+		for _, r := range actual {
+			if r.ID == test.record.ID && r.Modified != test.record.Modified {
+				t.Errorf("%s: expected %s to be replaced, got %+v", test.name, r.ID, r)
+			}
+		}
+	}
+}
+
+func TestNewOsvSourceRecord_Pairs_Range_Events(t *testing.T) {
+
+	// a fixed event with no range open closes nothing, and an introduced one that's never closed stays open
+	var advisory OsvAdvisory
+	data := `{"id": "PYSEC-2023-1", "summary": "summary", "affected": [{"package": {"ecosystem": "PyPI", "name": "django"},
+		"ranges": [{"type": "ECOSYSTEM", "events": [{"fixed": "0.9"}, {"introduced": "0"}, {"fixed": "1.0"},
+			{"introduced": "2.0"}, {"last_affected": "2.5"}, {"fixed": "3.0"}, {"introduced": "4.0"}]}]}]}`
+	if err := json.Unmarshal([]byte(data), &advisory); err != nil {
+		t.Fatal(err)
+	}
+
+	record := NewOsvSourceRecord(advisory)
+	expected := []AffectedRange{{Introduced: "0", Fixed: "1.0"}, {Introduced: "2.0", LastAffected: "2.5"}, {Introduced: "4.0"}}
+	if len(record.Affected) != 1 || !reflect.DeepEqual(record.Affected[0].Ranges, expected) {
+		t.Errorf("expected ranges %+v, got %+v", expected, record.Affected)
+	}
+	if record.Description != "summary" {
+		t.Errorf("expected the summary to stand in for missing details, got %q", record.Description)
+	}
+}
+
+// fakeCveStore keeps a single CVE's document in memory, and can turn updates away as though another worker had
+// updated the document under them
+type fakeCveStore struct {
+	doc     *mergeDoc
+	set     bson.D   // the fields the last update that went through set
+	filters []bson.D // the filters each update was made with
+	finds   int
+	stale   int // how many updates to turn away
+}
+
+func (f *fakeCveStore) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	f.finds++
+	if f.doc == nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(f.doc, nil, nil)
+}
+
+func (f *fakeCveStore) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	f.filters = append(f.filters, filter.(bson.D))
+	if f.stale > 0 {
+		f.stale--
+		f.doc.Revision++
+		return &mongo.UpdateResult{}, nil
+	}
+
+	fields := update.(bson.D)[0].Value.(bson.D)
+	data, err := bson.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var doc mergeDoc
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	created := f.doc == nil
+	f.doc, f.set = &doc, fields
+	if created {
+		return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: primitive.NewObjectID()}, nil
+	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+type publishedUpdate struct {
+	cveID, event, source string
+	revision             int64
+}
+
+// withFakeMerge stands in for the database and the updates topic while a test merges, giving back what's published
+func withFakeMerge(t *testing.T, store *fakeCveStore, held heldSources) *[]publishedUpdate {
+
+	published := &[]publishedUpdate{}
+	oldStore, oldPublish, oldHeld, oldPrecedence := mergeStore, mergePublish, mergeHeld, mergePrecedence
+	t.Cleanup(func() {
+		mergeStore, mergePublish, mergeHeld, mergePrecedence = oldStore, oldPublish, oldHeld, oldPrecedence
+	})
+
+	mergeStore, mergePrecedence = store, defaultPrecedence
+	mergePublish = func(cveID, event, source string, revision int64) {
+		*published = append(*published, publishedUpdate{cveID, event, source, revision})
+	}
+	mergeHeld = func(cveID string) (heldSources, error) {
+		return held, nil
+	}
+	return published
+}
+
+func TestMergeSources_Retries_Revision_Conflicts(t *testing.T) {
+
+	store := &fakeCveStore{doc: &mergeDoc{Sources: CveSources{Nvd: &SourceRecord{ID: "CVE-2021-44228"}}, Revision: 3}, stale: 1}
+	published := withFakeMerge(t, store, heldSources{})
+
+	kev := SourceRecord{ID: "CVE-2021-44228", Description: "from KEV"}
+	result, err := mergeSources("CVE-2021-44228", "kev", bson.D{{Key: "kev", Value: "block"}}, func(sources *CveSources) {
+		sources.Kev = &kev
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.ModifiedCount != 1 {
+		t.Errorf("expected the record to be updated, got %+v", result)
+	}
+
+	// the first update is turned away for the revision it read, and the record read again at the next
+	expectedFilters := []bson.D{
+		{{Key: "cvedata.id", Value: "CVE-2021-44228"}, {Key: "revision", Value: int64(3)}},
+		{{Key: "cvedata.id", Value: "CVE-2021-44228"}, {Key: "revision", Value: int64(4)}},
+	}
+	if store.finds != 2 || !reflect.DeepEqual(store.filters, expectedFilters) {
+		t.Errorf("expected the update to be retried at revision 4, got %d reads and filters %v", store.finds, store.filters)
+	}
+	if store.doc.Revision != 5 || store.doc.Sources.Kev == nil || store.set[0].Key != "kev" {
+		t.Errorf("expected the KEV record and block to be written at revision 5, got %+v", store.doc)
+	}
+	if expected := []publishedUpdate{{"CVE-2021-44228", "updated", "kev", 5}}; !reflect.DeepEqual(*published, expected) {
+		t.Errorf("expected %v to be published, got %v", expected, *published)
+	}
+
+	// re-sending the same record changes nothing worth publishing
+	if _, err := mergeSources("CVE-2021-44228", "kev", nil, func(sources *CveSources) { sources.Kev = &kev }); err != nil {
+		t.Fatal(err)
+	}
+	if len(*published) != 1 {
+		t.Errorf("expected an unchanged record not to be published, got %v", *published)
+	}
+
+	// a record that keeps being updated under us is given up on
+	store.stale = maxMergeAttempts
+	if _, err := mergeSources("CVE-2021-44228", "kev", nil, func(sources *CveSources) {}); err == nil {
+		t.Error("expected merging to give up")
+	}
+}
+
+func TestMergeSources_Only_Nvd_Creates_Records(t *testing.T) {
+
+	ghsa := OsvMsg{Source: "GHSA"}
+	ghsa.Osv.ID, ghsa.Osv.Aliases, ghsa.Osv.Summary = "GHSA-jfh8-c2jp-5v3q", []string{"CVE-2021-44228"}, "from GHSA"
+	withdrawn := OsvMsg{Source: "OSV"}
+	withdrawn.Osv.ID, withdrawn.Osv.Withdrawn = "GO-2021-0001", "2022-01-01T00:00:00Z"
+
+	store := &fakeCveStore{}
+	kev := KevEntry{CveID: "CVE-2021-44228", DateAdded: "2021-12-10", ShortDescription: "from KEV"}
+	epss := EpssData{Cve: "CVE-2021-44228", Score: 0.97, Percentile: 0.99, Date: "2021-12-20"}
+	published := withFakeMerge(t, store, heldSources{Advisories: []OsvMsg{ghsa, withdrawn}, Kev: &kev, Epss: &epss})
+
+	// an advisory or KEV entry for a CVE NVD hasn't published leaves nothing behind
+	for _, source := range []string{"ghsa", "kev"} {
+		result, err := mergeSources("CVE-2021-44228", source, nil, func(sources *CveSources) {
+			sources.addAdvisory(ghsa)
+		})
+		if err != nil || result != nil {
+			t.Errorf("%s: expected nothing to be written, got %+v, %v", source, result, err)
+		}
+	}
+	if len(store.filters) != 0 || len(*published) != 0 {
+		t.Fatalf("expected no record to be created, got updates %v and published %v", store.filters, *published)
+	}
+
+	// once NVD publishes it, the advisories, KEV entry and EPSS score we already have are merged in, apart from
+	// withdrawn advisories
+	nvd := SourceRecord{ID: "CVE-2021-44228", Description: "from NVD"}
+	result, err := mergeSources("CVE-2021-44228", "nvd", nil, func(sources *CveSources) { sources.Nvd = &nvd })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || result.UpsertedCount != 1 {
+		t.Errorf("expected the record to be created, got %+v", result)
+	}
+	if len(store.doc.Sources.Ghsa) != 1 || len(store.doc.Sources.Osv) != 0 || store.doc.Sources.Nvd == nil || store.doc.Sources.Kev == nil {
+		t.Errorf("expected the NVD, GHSA and KEV records, got %+v", store.doc.Sources)
+	}
+	kevBlock, epssBlock := false, false
+	for _, field := range store.set {
+		if field.Key == "aliases" && !reflect.DeepEqual(field.Value, []string{"GHSA-jfh8-c2jp-5v3q"}) {
+			t.Errorf("expected the GHSA advisory to be listed as an alias, got %v", field.Value)
+		}
+		if field.Key == "kev" {
+			kevBlock = reflect.DeepEqual(field.Value, NewKevData(kev))
+		}
+		if field.Key == "epss" {
+			epssBlock = reflect.DeepEqual(field.Value, epss)
+		}
+	}
+	if !kevBlock || !epssBlock {
+		t.Errorf("expected the KEV and EPSS blocks to be set, got %v", store.set)
+	}
+	if expected := []publishedUpdate{{"CVE-2021-44228", "created", "nvd", 1}}; !reflect.DeepEqual(*published, expected) {
+		t.Errorf("expected %v to be published, got %v", expected, *published)
+	}
+}
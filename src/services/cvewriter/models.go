@@ -1,86 +1,251 @@
 package main
 
-// structure of the 'Cve' object used by the NVD API to describe individual CVEs
+import (
+	"strings"
+
+	"melaka/shared/purl"
+)
+
+// structure of the 'Cve' object used by the NVD API to describe individual CVEs, per the NVD 2.0 CVE API schema
 type NvdCveData struct {
-	ID               string `json:"id"`
-	SourceIdentifier string `json:"sourceIdentifier"`
-	Published        string `json:"published"`
-	LastModified     string `json:"lastModified"`
-	VulnStatus       string `json:"vulnStatus"`
-	Descriptions     []struct {
-		Lang  string `json:"lang"`
-		Value string `json:"value"`
-	} `json:"descriptions"`
-	Metrics struct {
-		CvssMetricV31 []struct {
-			Source   string `json:"source"`
-			Type     string `json:"type"`
-			CvssData struct {
-				Version               string  `json:"version"`
-				VectorString          string  `json:"vectorString"`
-				AttackVector          string  `json:"attackVector"`
-				AttackComplexity      string  `json:"attackComplexity"`
-				PrivilegesRequired    string  `json:"privilegesRequired"`
-				UserInteraction       string  `json:"userInteraction"`
-				Scope                 string  `json:"scope"`
-				ConfidentialityImpact string  `json:"confidentialityImpact"`
-				IntegrityImpact       string  `json:"integrityImpact"`
-				AvailabilityImpact    string  `json:"availabilityImpact"`
-				BaseScore             float64 `json:"baseScore"`
-				BaseSeverity          string  `json:"baseSeverity"`
-			} `json:"cvssData"`
-			ExploitabilityScore float64 `json:"exploitabilityScore"`
-			ImpactScore         float64 `json:"impactScore"`
-		} `json:"cvssMetricV31"`
-		CvssMetricV2 []struct {
-			Source   string `json:"source"`
-			Type     string `json:"type"`
-			CvssData struct {
-				Version               string  `json:"version"`
-				VectorString          string  `json:"vectorString"`
-				AccessVector          string  `json:"accessVector"`
-				AccessComplexity      string  `json:"accessComplexity"`
-				Authentication        string  `json:"authentication"`
-				ConfidentialityImpact string  `json:"confidentialityImpact"`
-				IntegrityImpact       string  `json:"integrityImpact"`
-				AvailabilityImpact    string  `json:"availabilityImpact"`
-				BaseScore             float64 `json:"baseScore"`
-			} `json:"cvssData"`
-			BaseSeverity            string  `json:"baseSeverity"`
-			ExploitabilityScore     float64 `json:"exploitabilityScore"`
-			ImpactScore             float64 `json:"impactScore"`
-			AcInsufInfo             bool    `json:"acInsufInfo"`
-			ObtainAllPrivilege      bool    `json:"obtainAllPrivilege"`
-			ObtainUserPrivilege     bool    `json:"obtainUserPrivilege"`
-			ObtainOtherPrivilege    bool    `json:"obtainOtherPrivilege"`
-			UserInteractionRequired bool    `json:"userInteractionRequired"`
-		} `json:"cvssMetricV2"`
-	} `json:"metrics"`
-	Weaknesses []struct {
-		Source      string `json:"source"`
-		Type        string `json:"type"`
-		Description []struct {
-			Lang  string `json:"lang"`
-			Value string `json:"value"`
-		} `json:"description"`
-	} `json:"weaknesses"`
-	Configurations []struct {
-		Nodes []struct {
-			Operator string `json:"operator"`
-			Negate   bool   `json:"negate"`
-			CpeMatch []struct {
-				Vulnerable          bool   `json:"vulnerable"`
-				Criteria            string `json:"criteria"`
-				VersionEndIncluding string `json:"versionEndIncluding"`
-				MatchCriteriaID     string `json:"matchCriteriaId"`
-			} `json:"cpeMatch"`
-		} `json:"nodes"`
-	} `json:"configurations"`
-	References []struct {
-		URL    string   `json:"url"`
-		Source string   `json:"source"`
-		Tags   []string `json:"tags"`
-	} `json:"references"`
+	ID                    string          `json:"id"`
+	SourceIdentifier      string          `json:"sourceIdentifier"`
+	VulnStatus            string          `json:"vulnStatus"`
+	Published             string          `json:"published"`
+	LastModified          string          `json:"lastModified"`
+	EvaluatorComment      string          `json:"evaluatorComment"`
+	EvaluatorSolution     string          `json:"evaluatorSolution"`
+	EvaluatorImpact       string          `json:"evaluatorImpact"`
+	CisaExploitAdd        string          `json:"cisaExploitAdd"`
+	CisaActionDue         string          `json:"cisaActionDue"`
+	CisaRequiredAction    string          `json:"cisaRequiredAction"`
+	CisaVulnerabilityName string          `json:"cisaVulnerabilityName"`
+	CveTags               []CveTag        `json:"cveTags"`
+	Descriptions          []LangString    `json:"descriptions"`
+	References            []Reference     `json:"references"`
+	Metrics               Metrics         `json:"metrics"`
+	Weaknesses            []Weakness      `json:"weaknesses"`
+	Configurations        []Configuration `json:"configurations"`
+	VendorComments        []VendorComment `json:"vendorComments"`
+}
+
+// a piece of text in a given language
+type LangString struct {
+	Lang  string `json:"lang"`
+	Value string `json:"value"`
+}
+
+// tags a source has put on the CVE, e.g. disputed or unsupported-when-assigned
+type CveTag struct {
+	SourceIdentifier string   `json:"sourceIdentifier"`
+	Tags             []string `json:"tags"`
+}
+
+type Reference struct {
+	URL    string   `json:"url"`
+	Source string   `json:"source"`
+	Tags   []string `json:"tags"`
+}
+
+type Metrics struct {
+	CvssMetricV40 []CvssMetricV40 `json:"cvssMetricV40"`
+	CvssMetricV31 []CvssMetricV3  `json:"cvssMetricV31"`
+	CvssMetricV30 []CvssMetricV3  `json:"cvssMetricV30"`
+	CvssMetricV2  []CvssMetricV2  `json:"cvssMetricV2"`
+}
+
+type Weakness struct {
+	Source      string       `json:"source"`
+	Type        string       `json:"type"`
+	Description []LangString `json:"description"`
+}
+
+// a configuration describes the platforms that are affected, as a tree of nodes combining CPE matches
+type Configuration struct {
+	Operator string `json:"operator"`
+	Negate   bool   `json:"negate"`
+	Nodes    []Node `json:"nodes"`
+}
+
+type Node struct {
+	Operator string     `json:"operator"`
+	Negate   bool       `json:"negate"`
+	CpeMatch []CpeMatch `json:"cpeMatch"`
+}
+
+// a CPE match criteria, along with the range of versions it's affected in. Any of the bounds may be empty
+type CpeMatch struct {
+	Vulnerable            bool   `json:"vulnerable"`
+	Criteria              string `json:"criteria"`
+	MatchCriteriaID       string `json:"matchCriteriaId"`
+	VersionStartExcluding string `json:"versionStartExcluding"`
+	VersionStartIncluding string `json:"versionStartIncluding"`
+	VersionEndExcluding   string `json:"versionEndExcluding"`
+	VersionEndIncluding   string `json:"versionEndIncluding"`
+}
+
+// a statement from a vendor about the CVE
+type VendorComment struct {
+	Organization string `json:"organization"`
+	Comment      string `json:"comment"`
+	LastModified string `json:"lastModified"`
+}
+
+// a CVSS v4.0 score, as published by NVD or a CNA
+type CvssMetricV40 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                           string  `json:"version"`
+		VectorString                      string  `json:"vectorString"`
+		BaseScore                         float64 `json:"baseScore"`
+		BaseSeverity                      string  `json:"baseSeverity"`
+		AttackVector                      string  `json:"attackVector"`
+		AttackComplexity                  string  `json:"attackComplexity"`
+		AttackRequirements                string  `json:"attackRequirements"`
+		PrivilegesRequired                string  `json:"privilegesRequired"`
+		UserInteraction                   string  `json:"userInteraction"`
+		VulnConfidentialityImpact         string  `json:"vulnConfidentialityImpact"`
+		VulnIntegrityImpact               string  `json:"vulnIntegrityImpact"`
+		VulnAvailabilityImpact            string  `json:"vulnAvailabilityImpact"`
+		SubConfidentialityImpact          string  `json:"subConfidentialityImpact"`
+		SubIntegrityImpact                string  `json:"subIntegrityImpact"`
+		SubAvailabilityImpact             string  `json:"subAvailabilityImpact"`
+		ExploitMaturity                   string  `json:"exploitMaturity"`
+		ConfidentialityRequirement        string  `json:"confidentialityRequirement"`
+		IntegrityRequirement              string  `json:"integrityRequirement"`
+		AvailabilityRequirement           string  `json:"availabilityRequirement"`
+		ModifiedAttackVector              string  `json:"modifiedAttackVector"`
+		ModifiedAttackComplexity          string  `json:"modifiedAttackComplexity"`
+		ModifiedAttackRequirements        string  `json:"modifiedAttackRequirements"`
+		ModifiedPrivilegesRequired        string  `json:"modifiedPrivilegesRequired"`
+		ModifiedUserInteraction           string  `json:"modifiedUserInteraction"`
+		ModifiedVulnConfidentialityImpact string  `json:"modifiedVulnConfidentialityImpact"`
+		ModifiedVulnIntegrityImpact       string  `json:"modifiedVulnIntegrityImpact"`
+		ModifiedVulnAvailabilityImpact    string  `json:"modifiedVulnAvailabilityImpact"`
+		ModifiedSubConfidentialityImpact  string  `json:"modifiedSubConfidentialityImpact"`
+		ModifiedSubIntegrityImpact        string  `json:"modifiedSubIntegrityImpact"`
+		ModifiedSubAvailabilityImpact     string  `json:"modifiedSubAvailabilityImpact"`
+		Safety                            string  `json:"Safety"`
+		Automatable                       string  `json:"Automatable"`
+		Recovery                          string  `json:"Recovery"`
+		ValueDensity                      string  `json:"valueDensity"`
+		VulnerabilityResponseEffort       string  `json:"vulnerabilityResponseEffort"`
+		ProviderUrgency                   string  `json:"providerUrgency"`
+	} `json:"cvssData"`
+}
+
+// a CVSS v3.0 or v3.1 score - the two versions share their metrics
+type CvssMetricV3 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                       string  `json:"version"`
+		VectorString                  string  `json:"vectorString"`
+		AttackVector                  string  `json:"attackVector"`
+		AttackComplexity              string  `json:"attackComplexity"`
+		PrivilegesRequired            string  `json:"privilegesRequired"`
+		UserInteraction               string  `json:"userInteraction"`
+		Scope                         string  `json:"scope"`
+		ConfidentialityImpact         string  `json:"confidentialityImpact"`
+		IntegrityImpact               string  `json:"integrityImpact"`
+		AvailabilityImpact            string  `json:"availabilityImpact"`
+		BaseScore                     float64 `json:"baseScore"`
+		BaseSeverity                  string  `json:"baseSeverity"`
+		ExploitCodeMaturity           string  `json:"exploitCodeMaturity"`
+		RemediationLevel              string  `json:"remediationLevel"`
+		ReportConfidence              string  `json:"reportConfidence"`
+		TemporalScore                 float64 `json:"temporalScore"`
+		TemporalSeverity              string  `json:"temporalSeverity"`
+		ConfidentialityRequirement    string  `json:"confidentialityRequirement"`
+		IntegrityRequirement          string  `json:"integrityRequirement"`
+		AvailabilityRequirement       string  `json:"availabilityRequirement"`
+		ModifiedAttackVector          string  `json:"modifiedAttackVector"`
+		ModifiedAttackComplexity      string  `json:"modifiedAttackComplexity"`
+		ModifiedPrivilegesRequired    string  `json:"modifiedPrivilegesRequired"`
+		ModifiedUserInteraction       string  `json:"modifiedUserInteraction"`
+		ModifiedScope                 string  `json:"modifiedScope"`
+		ModifiedConfidentialityImpact string  `json:"modifiedConfidentialityImpact"`
+		ModifiedIntegrityImpact       string  `json:"modifiedIntegrityImpact"`
+		ModifiedAvailabilityImpact    string  `json:"modifiedAvailabilityImpact"`
+		EnvironmentalScore            float64 `json:"environmentalScore"`
+		EnvironmentalSeverity         string  `json:"environmentalSeverity"`
+	} `json:"cvssData"`
+	ExploitabilityScore float64 `json:"exploitabilityScore"`
+	ImpactScore         float64 `json:"impactScore"`
+}
+
+// a CVSS v2.0 score, which carries its severity and NVD's flags outside of the cvssData block
+type CvssMetricV2 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                    string  `json:"version"`
+		VectorString               string  `json:"vectorString"`
+		AccessVector               string  `json:"accessVector"`
+		AccessComplexity           string  `json:"accessComplexity"`
+		Authentication             string  `json:"authentication"`
+		ConfidentialityImpact      string  `json:"confidentialityImpact"`
+		IntegrityImpact            string  `json:"integrityImpact"`
+		AvailabilityImpact         string  `json:"availabilityImpact"`
+		BaseScore                  float64 `json:"baseScore"`
+		Exploitability             string  `json:"exploitability"`
+		RemediationLevel           string  `json:"remediationLevel"`
+		ReportConfidence           string  `json:"reportConfidence"`
+		TemporalScore              float64 `json:"temporalScore"`
+		CollateralDamagePotential  string  `json:"collateralDamagePotential"`
+		TargetDistribution         string  `json:"targetDistribution"`
+		ConfidentialityRequirement string  `json:"confidentialityRequirement"`
+		IntegrityRequirement       string  `json:"integrityRequirement"`
+		AvailabilityRequirement    string  `json:"availabilityRequirement"`
+		EnvironmentalScore         float64 `json:"environmentalScore"`
+	} `json:"cvssData"`
+	BaseSeverity            string  `json:"baseSeverity"`
+	ExploitabilityScore     float64 `json:"exploitabilityScore"`
+	ImpactScore             float64 `json:"impactScore"`
+	AcInsufInfo             bool    `json:"acInsufInfo"`
+	ObtainAllPrivilege      bool    `json:"obtainAllPrivilege"`
+	ObtainUserPrivilege     bool    `json:"obtainUserPrivilege"`
+	ObtainOtherPrivilege    bool    `json:"obtainOtherPrivilege"`
+	UserInteractionRequired bool    `json:"userInteractionRequired"`
+}
+
+// the single score we file a CVE under, so that CVEs can be filtered and sorted on it whichever versions they were scored with
+type PreferredScore struct {
+	Source       string  `bson:"source" json:"source"`
+	Type         string  `bson:"type" json:"type"`
+	Version      string  `bson:"version" json:"version"`
+	VectorString string  `bson:"vectorString" json:"vectorString"`
+	BaseScore    float64 `bson:"baseScore" json:"baseScore"`
+	BaseSeverity string  `bson:"baseSeverity" json:"baseSeverity"`
+}
+
+// PreferredScore picks the CVE's primary score over any secondary (CNA) ones and, within those, the one using the newest
+// CVSS version. It returns nil if the CVE hasn't been scored yet
+func (n *NvdCveData) PreferredScore() *PreferredScore {
+
+	// candidates are listed newest version first
+	candidates := []PreferredScore{}
+	for _, m := range n.Metrics.CvssMetricV40 {
+		candidates = append(candidates, PreferredScore{m.Source, m.Type, m.CvssData.Version, m.CvssData.VectorString, m.CvssData.BaseScore, m.CvssData.BaseSeverity})
+	}
+	for _, m := range append(n.Metrics.CvssMetricV31, n.Metrics.CvssMetricV30...) {
+		candidates = append(candidates, PreferredScore{m.Source, m.Type, m.CvssData.Version, m.CvssData.VectorString, m.CvssData.BaseScore, m.CvssData.BaseSeverity})
+	}
+	for _, m := range n.Metrics.CvssMetricV2 {
+		candidates = append(candidates, PreferredScore{m.Source, m.Type, m.CvssData.Version, m.CvssData.VectorString, m.CvssData.BaseScore, m.BaseSeverity})
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Type == "Primary" {
+			return &candidate
+		}
+	}
+	if len(candidates) > 0 {
+		return &candidates[0]
+	}
+
+	return nil
 }
 
 type Vulnerability struct {
@@ -98,9 +263,458 @@ type Response struct {
 	Vulnerabilities []Vulnerability `json:"vulnerabilities"`
 }
 
+// the text we index a CVE's record for full-text search under, kept in its `search` block
+type SearchText struct {
+	Description string   `bson:"description" json:"description"` // its descriptions in the languages we index
+	References  []string `bson:"references" json:"references"`   // its reference URLs
+	Products    []string `bson:"products" json:"products"`       // the vendor and product of each CPE in its configurations
+}
+
+// SearchText gathers up the text to index the CVE under, keeping only the descriptions in the given language
+func (n *NvdCveData) SearchText(language string) SearchText {
+
+	search := SearchText{References: []string{}, Products: []string{}}
+
+	descriptions := []string{}
+	for _, desc := range n.Descriptions {
+		if desc.Lang == language {
+			descriptions = append(descriptions, desc.Value)
+		}
+	}
+	search.Description = strings.Join(descriptions, "\n")
+
+	for _, ref := range n.References {
+		search.References = append(search.References, ref.URL)
+	}
+
+	// e.g. cpe:2.3:a:fasterxml:jackson-databind:* gives "fasterxml jackson-databind"
+	seen := map[string]bool{}
+	for _, config := range n.Configurations {
+		for _, node := range config.Nodes {
+			for _, match := range node.CpeMatch {
+				parts := strings.Split(match.Criteria, ":")
+				if len(parts) < 5 {
+					continue
+				}
+				product := strings.ReplaceAll(parts[3]+" "+parts[4], "_", " ")
+				if !seen[product] {
+					seen[product] = true
+					search.Products = append(search.Products, product)
+				}
+			}
+		}
+	}
+
+	return search
+}
+
+// CveUpdateMsg is what we publish whenever a source creates or changes a CVE's record, for services that react
+// to new and updated CVEs. It only identifies the CVE - consumers read the record itself from the database
+type CveUpdateMsg struct {
+	Timestamp string `json:"timestamp"`
+	CveID     string `json:"cveId"`
+	Event     string `json:"event"`  // created or updated
+	Source    string `json:"source"` // the source whose update it was, e.g. nvd
+	Revision  int64  `json:"revision"`
+}
+
 // model of the NVD msg coming from Kafka
 type CveMsg struct {
 	Timestamp string     `json:"timestamp"`
 	Source    string     `json:"source"`
 	Cve       NvdCveData `json:"cvedata"`
 }
+
+// structure of an advisory in the OSV schema (https://ossf.github.io/osv-schema/)
+type OsvAdvisory struct {
+	SchemaVersion    string                 `json:"schema_version"`
+	ID               string                 `json:"id"`
+	Modified         string                 `json:"modified"`
+	Published        string                 `json:"published"`
+	Withdrawn        string                 `json:"withdrawn"`
+	Aliases          []string               `json:"aliases"`
+	Related          []string               `json:"related"`
+	Summary          string                 `json:"summary"`
+	Details          string                 `json:"details"`
+	Severity         []OsvSeverity          `json:"severity"`
+	Affected         []OsvAffected          `json:"affected"`
+	References       []OsvReference         `json:"references"`
+	Credits          []OsvCredit            `json:"credits"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+type OsvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type OsvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+		Purl      string `json:"purl"`
+	} `json:"package"`
+	Severity []OsvSeverity `json:"severity"`
+	Ranges   []struct {
+		Type   string `json:"type"`
+		Repo   string `json:"repo"`
+		Events []struct {
+			Introduced   string `json:"introduced,omitempty"`
+			Fixed        string `json:"fixed,omitempty"`
+			LastAffected string `json:"last_affected,omitempty"`
+			Limit        string `json:"limit,omitempty"`
+		} `json:"events"`
+	} `json:"ranges"`
+	Versions          []string               `json:"versions"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+}
+
+type OsvReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type OsvCredit struct {
+	Name    string   `json:"name"`
+	Contact []string `json:"contact"`
+	Type    string   `json:"type"`
+}
+
+// CveIDs returns the CVE IDs this advisory describes - its own ID if it's a CVE, plus any CVE aliases
+func (o OsvAdvisory) CveIDs() []string {
+	var ids []string
+	for _, id := range append([]string{o.ID}, o.Aliases...) {
+		if strings.HasPrefix(id, "CVE-") {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// PackageKeys returns the normalised keys of the packages the advisory lists as affected, which are stored alongside
+// it so cvequerier can look up the advisories for a package by an index rather than by matching names case-insensitively
+func (o OsvAdvisory) PackageKeys() []string {
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, affected := range o.Affected {
+		if affected.Package.Name == "" {
+			continue
+		}
+		key := purl.PackageKey(affected.Package.Ecosystem, affected.Package.Name)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// model of the OSV msg coming from Kafka
+type OsvMsg struct {
+	Timestamp string      `json:"timestamp"`
+	Source    string      `json:"source"`
+	Osv       OsvAdvisory `json:"osvdata"`
+}
+
+// IsGhsa reports whether the advisory is one of GitHub's. OSV's own exports republish GitHub's advisories too, so
+// we go by the ID as well as the source that sent it
+func (o OsvMsg) IsGhsa() bool {
+	return o.Source == "GHSA" || strings.HasPrefix(o.Osv.ID, "GHSA-")
+}
+
+// structure of an entry in the CISA Known Exploited Vulnerabilities catalog
+type KevEntry struct {
+	CveID                      string   `json:"cveID"`
+	VendorProject              string   `json:"vendorProject"`
+	Product                    string   `json:"product"`
+	VulnerabilityName          string   `json:"vulnerabilityName"`
+	DateAdded                  string   `json:"dateAdded"`
+	ShortDescription           string   `json:"shortDescription"`
+	RequiredAction             string   `json:"requiredAction"`
+	DueDate                    string   `json:"dueDate"`
+	KnownRansomwareCampaignUse string   `json:"knownRansomwareCampaignUse"`
+	Notes                      string   `json:"notes"`
+	Cwes                       []string `json:"cwes"`
+}
+
+// model of the KEV msg coming from Kafka
+type KevMsg struct {
+	Timestamp string   `json:"timestamp"`
+	Source    string   `json:"source"`
+	Kev       KevEntry `json:"kevdata"`
+}
+
+// the KEV block we attach to a CVE's record
+type KevData struct {
+	DateAdded                  string `bson:"dateAdded" json:"dateAdded"`
+	DueDate                    string `bson:"dueDate" json:"dueDate"`
+	RequiredAction             string `bson:"requiredAction" json:"requiredAction"`
+	KnownRansomwareCampaignUse string `bson:"knownRansomwareCampaignUse" json:"knownRansomwareCampaignUse"`
+}
+
+func NewKevData(entry KevEntry) KevData {
+	return KevData{
+		DateAdded:                  entry.DateAdded,
+		DueDate:                    entry.DueDate,
+		RequiredAction:             entry.RequiredAction,
+		KnownRansomwareCampaignUse: entry.KnownRansomwareCampaignUse,
+	}
+}
+
+// a single row of FIRST's daily EPSS scores
+type EpssScore struct {
+	Cve          string  `json:"cve"`
+	Epss         float64 `json:"epss"`
+	Percentile   float64 `json:"percentile"`
+	Date         string  `json:"date"`
+	ModelVersion string  `json:"modelVersion"`
+}
+
+// model of the EPSS msg coming from Kafka
+type EpssMsg struct {
+	Timestamp string    `json:"timestamp"`
+	Source    string    `json:"source"`
+	Epss      EpssScore `json:"epssdata"`
+}
+
+// an EPSS score as we store it, both in the history collection and as the latest score on a CVE's record
+type EpssData struct {
+	Cve          string  `bson:"cve" json:"cve"`
+	Score        float64 `bson:"score" json:"score"`
+	Percentile   float64 `bson:"percentile" json:"percentile"`
+	Date         string  `bson:"date" json:"date"`
+	ModelVersion string  `bson:"modelVersion" json:"modelVersion"`
+}
+
+func NewEpssData(score EpssScore) EpssData {
+	return EpssData{
+		Cve:          score.Cve,
+		Score:        score.Epss,
+		Percentile:   score.Percentile,
+		Date:         score.Date,
+		ModelVersion: score.ModelVersion,
+	}
+}
+
+// a weakness or category from MITRE's CWE catalog, with its parents in the Research Concepts view
+type CweEntry struct {
+	ID          string   `bson:"id" json:"id"`
+	Type        string   `bson:"type" json:"type"`
+	Name        string   `bson:"name" json:"name"`
+	Description string   `bson:"description" json:"description"`
+	Abstraction string   `bson:"abstraction" json:"abstraction"`
+	Status      string   `bson:"status" json:"status"`
+	Parents     []string `bson:"parents" json:"parents"`
+}
+
+// model of the CWE msg coming from Kafka
+type CweMsg struct {
+	Timestamp string   `json:"timestamp"`
+	Source    string   `json:"source"`
+	Cwe       CweEntry `json:"cwedata"`
+}
+
+// a product from the NVD CPE dictionary. We store the whole message, so only need the fields we key it by
+type CpeMsg struct {
+	Timestamp string `json:"timestamp"`
+	Source    string `json:"source"`
+	Cpe       struct {
+		CpeName   string `json:"cpeName"`
+		CpeNameID string `json:"cpeNameId"`
+	} `json:"cpedata"`
+}
+
+// a match string from the NVD CPE Match Criteria API, keyed by the ID CVEs' configurations refer to it by
+type CpeMatchMsg struct {
+	Timestamp string `json:"timestamp"`
+	Source    string `json:"source"`
+	Match     struct {
+		MatchCriteriaID string `json:"matchCriteriaId"`
+	} `json:"matchdata"`
+}
+
+// a change event from the NVD CVE Change History API. We store the whole message, so only need the fields we key it by
+type CveChangeMsg struct {
+	Timestamp string `json:"timestamp"`
+	Source    string `json:"source"`
+	Change    struct {
+		CveID       string `json:"cveId"`
+		CveChangeID string `json:"cveChangeId"`
+	} `json:"changedata"`
+}
+
+// a distribution's status for one of its packages in one of its releases, as published by the distro scrapers
+type DistroStatus struct {
+	Cve          string `bson:"cve" json:"cve"`
+	Distro       string `bson:"distro" json:"distro"`
+	Release      string `bson:"release" json:"release"`
+	Package      string `bson:"package" json:"package"`
+	Status       string `bson:"status" json:"status"`
+	FixedVersion string `bson:"fixedVersion" json:"fixedVersion"`
+	Severity     string `bson:"severity" json:"severity"`
+	Notes        string `bson:"notes" json:"notes"`
+}
+
+type DistroMsg struct {
+	Timestamp string       `json:"timestamp"`
+	Source    string       `json:"source"`
+	Status    DistroStatus `json:"distrodata"`
+}
+
+// the records each source holds about a CVE, as kept in its document's `sources` block. NVD and KEV have one
+// record per CVE; OSV and GHSA can have several advisories describing the same CVE, so keep one per advisory
+type CveSources struct {
+	Nvd  *SourceRecord  `bson:"nvd,omitempty" json:"nvd,omitempty"`
+	Osv  []SourceRecord `bson:"osv,omitempty" json:"osv,omitempty"`
+	Ghsa []SourceRecord `bson:"ghsa,omitempty" json:"ghsa,omitempty"`
+	Kev  *SourceRecord  `bson:"kev,omitempty" json:"kev,omitempty"`
+}
+
+// what a single source says about a CVE, normalized down to the fields we consolidate
+type SourceRecord struct {
+	ID          string     `bson:"id" json:"id"` // the source's own ID for the record, e.g. the advisory ID
+	Modified    string     `bson:"modified" json:"modified"`
+	Description string     `bson:"description,omitempty" json:"description,omitempty"`
+	Severity    *Severity  `bson:"severity,omitempty" json:"severity,omitempty"`
+	Affected    []Affected `bson:"affected,omitempty" json:"affected,omitempty"`
+}
+
+// a source's rating of a CVE. Not every source gives all of it - GHSA gives a rating and usually a vector, but no score
+type Severity struct {
+	BaseSeverity string  `bson:"baseSeverity,omitempty" json:"baseSeverity,omitempty"`
+	BaseScore    float64 `bson:"baseScore,omitempty" json:"baseScore,omitempty"`
+	VectorString string  `bson:"vectorString,omitempty" json:"vectorString,omitempty"`
+}
+
+// something a source says is affected: either a package in an ecosystem (OSV, GHSA) or a CPE (NVD), with the
+// versions it's affected in. A CPE without ranges pins the affected version in the CPE itself
+type Affected struct {
+	Ecosystem string          `bson:"ecosystem,omitempty" json:"ecosystem,omitempty"`
+	Package   string          `bson:"package,omitempty" json:"package,omitempty"`
+	Cpe       string          `bson:"cpe,omitempty" json:"cpe,omitempty"`
+	Ranges    []AffectedRange `bson:"ranges,omitempty" json:"ranges,omitempty"`
+}
+
+// a range of affected versions, in OSV's terms. NVD's exclusive lower bounds have no OSV equivalent, so get their own field
+type AffectedRange struct {
+	Introduced          string `bson:"introduced,omitempty" json:"introduced,omitempty"`
+	IntroducedExcluding string `bson:"introducedExcluding,omitempty" json:"introducedExcluding,omitempty"`
+	Fixed               string `bson:"fixed,omitempty" json:"fixed,omitempty"`
+	LastAffected        string `bson:"lastAffected,omitempty" json:"lastAffected,omitempty"`
+}
+
+// the view of a CVE we consolidate from its sources, each field taken from the first source in that field's
+// precedence order that has something to say about it
+type ConsolidatedView struct {
+	Description       string     `bson:"description" json:"description"`
+	DescriptionSource string     `bson:"descriptionSource" json:"descriptionSource"`
+	Severity          *Severity  `bson:"severity" json:"severity"`
+	SeveritySource    string     `bson:"severitySource" json:"severitySource"`
+	Affected          []Affected `bson:"affected" json:"affected"`
+	AffectedSource    string     `bson:"affectedSource" json:"affectedSource"`
+	Sources           []string   `bson:"sources" json:"sources"` // every source with a record for the CVE
+}
+
+func NewNvdSourceRecord(cve NvdCveData) SourceRecord {
+
+	record := SourceRecord{
+		ID:       cve.ID,
+		Modified: cve.LastModified,
+	}
+
+	for _, desc := range cve.Descriptions {
+		if desc.Lang == "en" {
+			record.Description = desc.Value
+			break
+		}
+	}
+
+	if score := cve.PreferredScore(); score != nil {
+		record.Severity = &Severity{BaseSeverity: score.BaseSeverity, BaseScore: score.BaseScore, VectorString: score.VectorString}
+	}
+
+	for _, config := range cve.Configurations {
+		for _, node := range config.Nodes {
+			for _, match := range node.CpeMatch {
+				if !match.Vulnerable {
+					continue
+				}
+				affected := Affected{Cpe: match.Criteria}
+				r := AffectedRange{
+					Introduced:          match.VersionStartIncluding,
+					IntroducedExcluding: match.VersionStartExcluding,
+					Fixed:               match.VersionEndExcluding,
+					LastAffected:        match.VersionEndIncluding,
+				}
+				if r != (AffectedRange{}) {
+					affected.Ranges = []AffectedRange{r}
+				}
+				record.Affected = append(record.Affected, affected)
+			}
+		}
+	}
+
+	return record
+}
+
+func NewOsvSourceRecord(advisory OsvAdvisory) SourceRecord {
+
+	record := SourceRecord{
+		ID:          advisory.ID,
+		Modified:    advisory.Modified,
+		Description: advisory.Details,
+	}
+	if record.Description == "" {
+		record.Description = advisory.Summary
+	}
+
+	// GHSA puts its own rating in database_specific, calling medium MODERATE
+	severity := Severity{}
+	if label, ok := advisory.DatabaseSpecific["severity"].(string); ok {
+		severity.BaseSeverity = strings.ToUpper(label)
+		if severity.BaseSeverity == "MODERATE" {
+			severity.BaseSeverity = "MEDIUM"
+		}
+	}
+	for _, s := range advisory.Severity {
+		if s.Type == "CVSS_V4" || (s.Type == "CVSS_V3" && severity.VectorString == "") {
+			severity.VectorString = s.Score
+		}
+	}
+	if severity != (Severity{}) {
+		record.Severity = &severity
+	}
+
+	for _, a := range advisory.Affected {
+		affected := Affected{Ecosystem: a.Package.Ecosystem, Package: a.Package.Name}
+		for _, r := range a.Ranges {
+			// each introduced event opens a range, closed by the fixed or last_affected event following it
+			current := -1
+			for _, event := range r.Events {
+				switch {
+				case event.Introduced != "":
+					affected.Ranges = append(affected.Ranges, AffectedRange{Introduced: event.Introduced})
+					current = len(affected.Ranges) - 1
+				case current >= 0 && event.Fixed != "":
+					affected.Ranges[current].Fixed = event.Fixed
+					current = -1
+				case current >= 0 && event.LastAffected != "":
+					affected.Ranges[current].LastAffected = event.LastAffected
+					current = -1
+				}
+			}
+		}
+		record.Affected = append(record.Affected, affected)
+	}
+
+	return record
+}
+
+func NewKevSourceRecord(entry KevEntry) SourceRecord {
+	return SourceRecord{
+		ID:          entry.CveID,
+		Modified:    entry.DateAdded,
+		Description: entry.ShortDescription,
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	kafkaUpdateReader *kafka.Reader
+	store             *MongoStore
+	notifier          *Notifier
+	wg                sync.WaitGroup
+	maxWorkers        = 10 // Maximum number of concurrent deliveries
+)
+
+// setup connects to Kafka and MongoDB, and configures how we deliver updates
+func setup() {
+	// Connect to Kafka broker and create a reader for the topic cvewriter publishes updates to
+	kafkaServer := readFromENV("KAFKA_BROKER", "localhost:9092")
+	kafkaUpdateTopic := readFromENV("KAFKA_UPDATE_TOPIC", "cve-updates")
+	kafkaUpdateReader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{kafkaServer}, // TODO handling for multiple brokers
+		GroupID:   "CVE-Notifiers",
+		Topic:     kafkaUpdateTopic,
+		Partition: 0,
+		MaxBytes:  10e6,
+	})
+	fmt.Println("Kafka Broker - ", kafkaServer)
+	fmt.Println("Kafka Topic - ", kafkaUpdateTopic)
+
+	// Connect to MongoDB
+	mongoServer := readFromENV("MONGO_URL", "mongodb://localhost:27017")
+	mongoDatabaseName := readFromENV("MONGO_DB", "melakaDB")
+	mongoCollectionName := readFromENV("MONGO_COLLECTION", "cves")
+	mongoSubscriptionCollectionName := readFromENV("MONGO_SUBSCRIPTION_COLLECTION", "subscriptions")
+	mongoDeliveryCollectionName := readFromENV("MONGO_DELIVERY_COLLECTION", "deliveries")
+
+	credentials := options.Credential{
+		Username: readFromENV("MONGO_ROOT_USERNAME", "dev"),
+		Password: readFromENV("MONGO_ROOT_PASSWORD", "dev"),
+	}
+
+	dbClient, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoServer).SetAuth(credentials))
+	if err != nil {
+		log.Fatalf("Instantiation of db connection failed: %s", err)
+	}
+
+	// Ping db to test the connection
+	if err := dbClient.Ping(context.TODO(), readpref.Primary()); err != nil {
+		log.Fatalf("Pinging db failed: %s", err)
+	}
+
+	store = &MongoStore{
+		cves:          dbClient.Database(mongoDatabaseName).Collection(mongoCollectionName),
+		subscriptions: dbClient.Database(mongoDatabaseName).Collection(mongoSubscriptionCollectionName),
+		deliveries:    dbClient.Database(mongoDatabaseName).Collection(mongoDeliveryCollectionName),
+	}
+
+	// how hard we try to deliver each update, and how many deliveries in a row can fail before we give up on a
+	// subscription altogether
+	maxAttempts := readIntFromENV("NOTIFIER_MAX_ATTEMPTS", 5)
+	backoff := readDurationFromENV("NOTIFIER_BACKOFF", 2*time.Second)
+	maxBackoff := readDurationFromENV("NOTIFIER_MAX_BACKOFF", time.Minute)
+	disableAfter := readIntFromENV("NOTIFIER_DISABLE_AFTER", 5)
+	timeout := readDurationFromENV("NOTIFIER_TIMEOUT", 10*time.Second)
+	fmt.Printf("Delivery - %d attempts, backoff %s up to %s, timeout %s, disable after %d failures\n", maxAttempts, backoff, maxBackoff, timeout, disableAfter)
+
+	notifier = NewNotifier(NewWebhookClient(timeout), store, maxAttempts, backoff, maxBackoff, disableAfter)
+}
+
+func main() {
+	setup()
+	defer kafkaUpdateReader.Close()
+
+	// Create a channel to limit the number of goroutines
+	workerChan := make(chan struct{}, maxWorkers)
+
+	for {
+		m, err := kafkaUpdateReader.ReadMessage(context.Background())
+		if err != nil {
+			continue
+		}
+
+		if err := handleUpdateMsg(m, workerChan); err != nil {
+			fmt.Printf("Failed to handle message: %s\n", err)
+		}
+	}
+
+}
+
+// handleUpdateMsg finds the subscriptions an updated CVE matches, and delivers the update to each once a worker is
+// available
+func handleUpdateMsg(msg kafka.Message, workerChan chan struct{}) error {
+
+	var update CveUpdateMsg
+	if err := json.Unmarshal(msg.Value, &update); err != nil {
+		return err
+	}
+
+	if update.CveID == "" {
+		return error(fmt.Errorf("CVE ID is empty"))
+	}
+
+	subs, err := store.GetEnabledSubscriptions()
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+
+	cve, err := store.GetCve(update.CveID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", update.CveID, err)
+	}
+
+	for _, sub := range subs {
+		if !sub.Filter.matches(cve) {
+			continue
+		}
+
+		// Acquire a worker from the channel
+		workerChan <- struct{}{}
+
+		// Increment the WaitGroup for each delivery
+		wg.Add(1)
+
+		go func(sub Subscription) {
+			defer func() {
+				<-workerChan // Release the worker back to the channel
+				wg.Done()    // Decrement the WaitGroup when the goroutine completes
+			}()
+
+			delivery, err := notifier.Deliver(sub, update, cve)
+			if err != nil {
+				fmt.Printf("Failed to deliver %s to subscription %s: %s\n", update.CveID, sub.ID, err)
+				return
+			}
+			fmt.Printf("Delivery %s of %s to subscription %s %s after %d attempts\n", delivery.ID, update.CveID, sub.ID, delivery.Status, len(delivery.Attempts))
+		}(sub)
+	}
+
+	return nil
+}
+
+func readFromENV(key, defaultVal string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultVal
+}
+
+func readIntFromENV(key string, defaultVal int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Fatalf("Invalid %s: %s", key, value)
+	}
+	return n
+}
+
+func readDurationFromENV(key string, defaultVal time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid %s: %s", key, value)
+	}
+	return d
+}
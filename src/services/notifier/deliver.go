@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"melaka/shared/netguard"
+)
+
+// Store is where the notifier logs its deliveries and keeps count of each subscription's failures
+type Store interface {
+	RecordDelivery(delivery Delivery) error
+	ResetFailures(subscriptionID string) error
+	// RecordFailure counts a failed delivery against a subscription, disabling it with the given reason once it
+	// has failed disableAfter times in a row. It reports whether the subscription was disabled
+	RecordFailure(subscriptionID string, disableAfter int, reason string) (bool, error)
+}
+
+// Notifier delivers CVE updates to subscriptions' webhooks, retrying each delivery with exponential backoff
+type Notifier struct {
+	client       *http.Client
+	store        Store
+	maxAttempts  int
+	backoff      time.Duration // how long to wait before the first retry, doubling for each after it
+	maxBackoff   time.Duration
+	disableAfter int // how many deliveries in a row can fail before a subscription is disabled
+	sleep        func(time.Duration)
+	now          func() time.Time
+}
+
+func NewNotifier(client *http.Client, store Store, maxAttempts int, backoff, maxBackoff time.Duration, disableAfter int) *Notifier {
+	return &Notifier{
+		client:       client,
+		store:        store,
+		maxAttempts:  maxAttempts,
+		backoff:      backoff,
+		maxBackoff:   maxBackoff,
+		disableAfter: disableAfter,
+		sleep:        time.Sleep,
+		now:          time.Now,
+	}
+}
+
+// NewWebhookClient returns the client deliveries are made with. Subscriptions' URLs are checked when they're created,
+// but a name can be pointed somewhere else after that, so it only connects to public addresses, and doesn't follow
+// redirects, which could lead anywhere. It doesn't go through a proxy, as the proxy is the address it'd connect to
+func NewWebhookClient(timeout time.Duration) *http.Client {
+
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Control: netguard.Control}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// signature signs a delivery's body for its subscription, over the timestamp it was sent at and the body, so that
+// receivers can check it came from us and isn't a replay of an old one
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryable reports whether a delivery that got the given status is worth trying again
+func retryable(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// Deliver notifies a subscription of an update to a CVE, trying until the webhook accepts it, it fails in a way
+// retrying won't fix, or we run out of attempts. The delivery is logged either way
+func (n *Notifier) Deliver(sub Subscription, update CveUpdateMsg, cve *CveDoc) (Delivery, error) {
+
+	delivery := Delivery{
+		ID:             newDeliveryID(),
+		SubscriptionID: sub.ID,
+		CveID:          update.CveID,
+		Event:          update.Event,
+		Status:         "failed",
+		CreatedAt:      n.now().UTC().Format(time.RFC3339),
+		Attempts:       []DeliveryAttempt{},
+	}
+
+	body, err := json.Marshal(Payload{
+		ID:             delivery.ID,
+		Event:          update.Event,
+		SubscriptionID: sub.ID,
+		Timestamp:      update.Timestamp,
+		Cve:            NewCveSummary(cve),
+	})
+	if err != nil {
+		return delivery, err
+	}
+
+	wait := n.backoff
+	for attempt := 1; attempt <= n.maxAttempts; attempt++ {
+
+		result := n.attempt(sub, delivery, body)
+		delivery.Attempts = append(delivery.Attempts, result)
+
+		if result.Error == "" && result.StatusCode >= 200 && result.StatusCode < 300 {
+			delivery.Status = "delivered"
+			break
+		}
+		if result.Error == "" && !retryable(result.StatusCode) {
+			break
+		}
+
+		if attempt < n.maxAttempts {
+			n.sleep(wait)
+			if wait *= 2; wait > n.maxBackoff {
+				wait = n.maxBackoff
+			}
+		}
+	}
+
+	if err := n.store.RecordDelivery(delivery); err != nil {
+		return delivery, fmt.Errorf("failed to record delivery %s: %w", delivery.ID, err)
+	}
+
+	if delivery.Status == "delivered" {
+		if sub.ConsecutiveFailures > 0 {
+			return delivery, n.store.ResetFailures(sub.ID)
+		}
+		return delivery, nil
+	}
+
+	reason := fmt.Sprintf("%d deliveries in a row failed, the last with: %s", n.disableAfter, describeAttempt(delivery.Attempts[len(delivery.Attempts)-1]))
+	disabled, err := n.store.RecordFailure(sub.ID, n.disableAfter, reason)
+	if err != nil {
+		return delivery, err
+	}
+	if disabled {
+		fmt.Printf("Disabled subscription %s: %s\n", sub.ID, reason)
+	}
+
+	return delivery, nil
+}
+
+// attempt makes a single attempt at a delivery
+func (n *Notifier) attempt(sub Subscription, delivery Delivery, body []byte) DeliveryAttempt {
+
+	start := n.now()
+	result := DeliveryAttempt{At: start.UTC().Format(time.RFC3339)}
+
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("Failed to build delivery %s to subscription %s: %s\n", delivery.ID, sub.ID, err)
+		result.Error = "invalid url"
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "melaka-notifier")
+	req.Header.Set("X-Melaka-Event", delivery.Event)
+	req.Header.Set("X-Melaka-Delivery", delivery.ID)
+	req.Header.Set("X-Melaka-Timestamp", timestamp)
+	req.Header.Set("X-Melaka-Signature", signature(sub.Secret, timestamp, body))
+
+	// subscribers can read their deliveries' attempts, so what went wrong is only logged, lest they be used to probe
+	// what's listening where
+	resp, err := n.client.Do(req)
+	result.DurationMs = n.now().Sub(start).Milliseconds()
+	if err != nil {
+		fmt.Printf("Failed to make delivery %s to subscription %s: %s\n", delivery.ID, sub.ID, err)
+		result.Error = "request failed"
+		if errors.Is(err, netguard.ErrNotPublic) {
+			result.Error = "address not allowed"
+		}
+		return result
+	}
+	defer resp.Body.Close()
+
+	// read what's left of the response so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result.StatusCode = resp.StatusCode
+	return result
+}
+
+func describeAttempt(attempt DeliveryAttempt) string {
+	if attempt.Error != "" {
+		return attempt.Error
+	}
+	return "HTTP " + strconv.Itoa(attempt.StatusCode)
+}
+
+// newDeliveryID returns a random (version 4) UUID
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
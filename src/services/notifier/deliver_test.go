@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryStore records deliveries and failures in memory
+type memoryStore struct {
+	deliveries []Delivery
+	failures   map[string]int
+	disabled   map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{failures: map[string]int{}, disabled: map[string]string{}}
+}
+
+func (s *memoryStore) RecordDelivery(delivery Delivery) error {
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *memoryStore) ResetFailures(subscriptionID string) error {
+	s.failures[subscriptionID] = 0
+	return nil
+}
+
+func (s *memoryStore) RecordFailure(subscriptionID string, disableAfter int, reason string) (bool, error) {
+	s.failures[subscriptionID]++
+	if s.failures[subscriptionID] >= disableAfter && s.disabled[subscriptionID] == "" {
+		s.disabled[subscriptionID] = reason
+		return true, nil
+	}
+	return false, nil
+}
+
+// newTestNotifier builds a notifier that records how long it would have waited between attempts rather than waiting
+func newTestNotifier(store Store, waits *[]time.Duration) *Notifier {
+	n := NewNotifier(http.DefaultClient, store, 4, time.Second, 3*time.Second, 2)
+	n.sleep = func(d time.Duration) { *waits = append(*waits, d) }
+	return n
+}
+
+var testUpdate = CveUpdateMsg{Timestamp: "2023-07-01T00:00:00Z", CveID: "CVE-2021-44228", Event: "updated", Source: "nvd", Revision: 3}
+
+func TestDeliver_Signs_Payload(t *testing.T) {
+
+	sub := Subscription{ID: "sub-1", Secret: "0123456789abcdef"}
+
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	sub.URL = server.URL
+
+	store, waits := newMemoryStore(), []time.Duration{}
+	delivery, err := newTestNotifier(store, &waits).Deliver(sub, testUpdate, log4jCve())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.Status != "delivered" || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusNoContent {
+		t.Errorf("expected one successful attempt, got %+v", delivery)
+	}
+	if len(store.deliveries) != 1 || store.deliveries[0].ID != delivery.ID {
+		t.Errorf("expected the delivery to be recorded, got %+v", store.deliveries)
+	}
+
+	if header.Get("X-Melaka-Event") != "updated" || header.Get("X-Melaka-Delivery") != delivery.ID {
+		t.Errorf("unexpected headers %v", header)
+	}
+	expected := signature(sub.Secret, header.Get("X-Melaka-Timestamp"), body)
+	if header.Get("X-Melaka-Signature") != expected || !strings.HasPrefix(expected, "sha256=") {
+		t.Errorf("expected signature %s, got %s", expected, header.Get("X-Melaka-Signature"))
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != delivery.ID || payload.SubscriptionID != "sub-1" || payload.Cve.ID != "CVE-2021-44228" || payload.Cve.Severity.BaseScore != 10.0 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestDeliver_Retries_With_Backoff(t *testing.T) {
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls < 4 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sub := Subscription{ID: "sub-1", URL: server.URL, Secret: "0123456789abcdef", ConsecutiveFailures: 1}
+	store, waits := newMemoryStore(), []time.Duration{}
+	store.failures["sub-1"] = 1
+
+	delivery, err := newTestNotifier(store, &waits).Deliver(sub, testUpdate, log4jCve())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery.Status != "delivered" || len(delivery.Attempts) != 4 {
+		t.Errorf("expected delivery on the fourth attempt, got %+v", delivery)
+	}
+	expectedWaits := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if len(waits) != len(expectedWaits) {
+		t.Fatalf("expected waits %v, got %v", expectedWaits, waits)
+	}
+	for i := range waits {
+		if waits[i] != expectedWaits[i] {
+			t.Errorf("expected waits %v, got %v", expectedWaits, waits)
+		}
+	}
+	if store.failures["sub-1"] != 0 {
+		t.Errorf("expected failures to be reset, got %d", store.failures["sub-1"])
+	}
+}
+
+func TestDeliver_Disables_After_Failures(t *testing.T) {
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	sub := Subscription{ID: "sub-1", URL: server.URL, Secret: "0123456789abcdef"}
+	store, waits := newMemoryStore(), []time.Duration{}
+	n := newTestNotifier(store, &waits)
+
+	for i := 0; i < 2; i++ {
+		delivery, err := n.Deliver(sub, testUpdate, log4jCve())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivery.Status != "failed" || len(delivery.Attempts) != 1 {
+			t.Errorf("expected a client error not to be retried, got %+v", delivery)
+		}
+	}
+
+	if calls != 2 || len(waits) != 0 {
+		t.Errorf("expected no retries, got %d calls and waits %v", calls, waits)
+	}
+	if !strings.Contains(store.disabled["sub-1"], "HTTP 410") {
+		t.Errorf("expected the subscription to be disabled for its 410s, got %q", store.disabled["sub-1"])
+	}
+}
+
+func TestWebhookClient_Refuses_Internal_Addresses(t *testing.T) {
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	store, waits := newMemoryStore(), []time.Duration{}
+	n := newTestNotifier(store, &waits)
+	n.client = NewWebhookClient(time.Second)
+
+	// the test server listens on loopback, which a subscription could name to reach something of ours
+	delivery, err := n.Deliver(Subscription{ID: "sub-1", URL: server.URL, Secret: "0123456789abcdef"}, testUpdate, log4jCve())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 || delivery.Status != "failed" {
+		t.Errorf("expected the delivery not to be made, got %d calls and %+v", calls, delivery)
+	}
+	for _, attempt := range delivery.Attempts {
+		if attempt.Error != "address not allowed" {
+			t.Errorf("expected only a generic reason to be recorded, got %q", attempt.Error)
+		}
+	}
+
+	redirect, _ := http.NewRequest(http.MethodPost, "http://169.254.169.254/", nil)
+	if err := n.client.CheckRedirect(redirect, nil); err != http.ErrUseLastResponse {
+		t.Errorf("expected redirects not to be followed, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+
+	"melaka/shared/purl"
+)
+
+// matches reports whether a CVE meets every criterion in a subscription's filter
+func (f SubscriptionFilter) matches(cve *CveDoc) bool {
+
+	if f.Kev && cve.Kev == nil {
+		return false
+	}
+
+	if f.ScoreMin != nil {
+		severity := cve.severity()
+		if severity == nil || severity.BaseScore < *f.ScoreMin {
+			return false
+		}
+	}
+
+	if f.Keyword != "" && !strings.Contains(strings.ToLower(cve.description()), strings.ToLower(f.Keyword)) {
+		return false
+	}
+
+	if f.Cpe != "" && !anyAffected(cve, func(a Affected) bool { return a.Cpe != "" && cpeMatches(f.Cpe, a) }) {
+		return false
+	}
+
+	if f.Purl != "" {
+		p, err := purl.Parse(f.Purl)
+		if err != nil || !anyAffected(cve, func(a Affected) bool { return a.Package != "" && purlMatches(p, a) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// anyAffected reports whether anything any source says is affected by the CVE passes the test
+func anyAffected(cve *CveDoc, test func(Affected) bool) bool {
+	for _, record := range cve.Sources.all() {
+		for _, affected := range record.Affected {
+			if test(affected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the components of a CPE 2.3 name after its cpe:2.3 prefix, from part through to other
+const cpeComponents = 11
+
+// cpeComponents splits a CPE 2.3 name into its components, padding out any it leaves off with ANY
+func splitCpe(cpe string) []string {
+	parts := strings.Split(strings.TrimPrefix(cpe, "cpe:2.3:"), ":")
+	for len(parts) < cpeComponents {
+		parts = append(parts, "*")
+	}
+	return parts
+}
+
+// cpeMatches reports whether the CPE a subscription gives falls under something a source says is affected. Any
+// component left as ANY on either side matches, so a subscription can give as little as a vendor and product. A
+// concrete version has to fall in one of the affected ranges
+func cpeMatches(cpe string, affected Affected) bool {
+
+	want, have := splitCpe(cpe), splitCpe(affected.Cpe)
+	for i := range want {
+		if i == 3 {
+			continue // the version is checked against the ranges below
+		}
+		if want[i] != "*" && have[i] != "*" && !strings.EqualFold(want[i], have[i]) {
+			return false
+		}
+	}
+
+	version := want[3]
+	if version == "*" || version == "-" {
+		return true
+	}
+	if have[3] != "*" && have[3] != "-" {
+		return strings.EqualFold(version, have[3])
+	}
+	return inRanges(version, affected.Ranges)
+}
+
+// purlMatches reports whether the package is the one a source says is affected, and if the purl gives a version,
+// whether it falls in one of the affected ranges
+func purlMatches(p purl.Purl, affected Affected) bool {
+	return p.Is(affected.Ecosystem, affected.Package) && (p.Version == "" || inRanges(p.Version, affected.Ranges))
+}
+
+// inRanges reports whether a version falls in any of the ranges, or there being no ranges, is taken to be affected
+func inRanges(version string, ranges []AffectedRange) bool {
+
+	if len(ranges) == 0 {
+		return true
+	}
+
+	for _, r := range ranges {
+		if r.Introduced != "" && r.Introduced != "0" && purl.CompareVersions(version, r.Introduced) < 0 {
+			continue
+		}
+		if r.IntroducedExcluding != "" && purl.CompareVersions(version, r.IntroducedExcluding) <= 0 {
+			continue
+		}
+		if r.Fixed != "" && purl.CompareVersions(version, r.Fixed) >= 0 {
+			continue
+		}
+		if r.LastAffected != "" && purl.CompareVersions(version, r.LastAffected) > 0 {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func log4jCve() *CveDoc {
+	cve := &CveDoc{
+		PreferredScore: &Severity{BaseSeverity: "CRITICAL", BaseScore: 10.0},
+		Kev:            &KevData{DateAdded: "2021-12-10"},
+		Consolidated:   ConsolidatedView{Description: "Apache Log4j2 JNDI features do not protect against attacker controlled LDAP endpoints"},
+	}
+	cve.CveData.ID = "CVE-2021-44228"
+	cve.Sources.Nvd = &SourceRecord{ID: "CVE-2021-44228", Affected: []Affected{
+		{Cpe: "cpe:2.3:a:apache:log4j:*:*:*:*:*:*:*:*", Ranges: []AffectedRange{{Introduced: "2.0.1", Fixed: "2.3.1"}, {Introduced: "2.4", Fixed: "2.12.2"}}},
+	}}
+	cve.Sources.Ghsa = []SourceRecord{{ID: "GHSA-jfh8-c2jp-5v3q", Affected: []Affected{
+		{Ecosystem: "Maven", Package: "org.apache.logging.log4j:log4j-core", Ranges: []AffectedRange{{Introduced: "2.0-beta9", Fixed: "2.3.1"}, {Introduced: "2.4", Fixed: "2.12.2"}}},
+	}}}
+	return cve
+}
+
+func TestSubscriptionFilterMatches(t *testing.T) {
+
+	high, tooHigh := 9.0, 10.5
+
+	tests := []struct {
+		name     string
+		filter   SubscriptionFilter
+		expected bool
+	}{
+		{"cpe without version", SubscriptionFilter{Cpe: "cpe:2.3:a:apache:log4j"}, true},
+		{"cpe in range", SubscriptionFilter{Cpe: "cpe:2.3:a:apache:log4j:2.11.0:*:*:*:*:*:*:*"}, true},
+		{"cpe fixed", SubscriptionFilter{Cpe: "cpe:2.3:a:apache:log4j:2.12.2"}, false},
+		{"cpe other product", SubscriptionFilter{Cpe: "cpe:2.3:a:apache:tomcat"}, false},
+		{"cpe case insensitive", SubscriptionFilter{Cpe: "cpe:2.3:a:Apache:Log4j"}, true},
+		{"purl without version", SubscriptionFilter{Purl: "pkg:maven/org.apache.logging.log4j/log4j-core"}, true},
+		{"purl in range", SubscriptionFilter{Purl: "pkg:maven/org.apache.logging.log4j/log4j-core@2.0-rc1?type=jar"}, true},
+		{"purl before range", SubscriptionFilter{Purl: "pkg:maven/org.apache.logging.log4j/log4j-core@2.0-beta8"}, false},
+		{"purl fixed", SubscriptionFilter{Purl: "pkg:maven/org.apache.logging.log4j/log4j-core@2.17.0"}, false},
+		{"purl other ecosystem", SubscriptionFilter{Purl: "pkg:npm/log4j-core"}, false},
+		{"keyword", SubscriptionFilter{Keyword: "jndi"}, true},
+		{"missing keyword", SubscriptionFilter{Keyword: "deserialization"}, false},
+		{"score", SubscriptionFilter{ScoreMin: &high}, true},
+		{"score too high", SubscriptionFilter{ScoreMin: &tooHigh}, false},
+		{"kev", SubscriptionFilter{Kev: true}, true},
+		{"every criterion", SubscriptionFilter{Cpe: "cpe:2.3:a:apache:log4j", Keyword: "ldap", ScoreMin: &high, Kev: true}, true},
+		{"one criterion fails", SubscriptionFilter{Cpe: "cpe:2.3:a:apache:log4j", Keyword: "deserialization"}, false},
+	}
+
+	cve := log4jCve()
+	for _, test := range tests {
+		if actual := test.filter.matches(cve); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+
+	cve.Kev = nil
+	if (SubscriptionFilter{Kev: true}).matches(cve) {
+		t.Error("expected a CVE not in KEV not to match a KEV filter")
+	}
+}
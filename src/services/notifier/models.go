@@ -0,0 +1,182 @@
+package main
+
+// the message cvewriter publishes whenever a source creates or changes a CVE's record
+type CveUpdateMsg struct {
+	Timestamp string `json:"timestamp"`
+	CveID     string `json:"cveId"`
+	Event     string `json:"event"`  // created or updated
+	Source    string `json:"source"` // the source whose update it was, e.g. nvd
+	Revision  int64  `json:"revision"`
+}
+
+// what a CVE has to match for a subscription to be notified about it. Every criterion given has to match
+type SubscriptionFilter struct {
+	Cpe      string   `bson:"cpe,omitempty"`
+	Purl     string   `bson:"purl,omitempty"`
+	Keyword  string   `bson:"keyword,omitempty"`
+	ScoreMin *float64 `bson:"scoreMin,omitempty"`
+	Kev      bool     `bson:"kev,omitempty"`
+}
+
+// a webhook registered through cvequerier's /subscriptions
+type Subscription struct {
+	ID                  string             `bson:"id"`
+	URL                 string             `bson:"url"`
+	Secret              string             `bson:"secret"`
+	Filter              SubscriptionFilter `bson:"filter"`
+	Enabled             bool               `bson:"enabled"`
+	ConsecutiveFailures int                `bson:"consecutiveFailures"`
+}
+
+// our log of a delivery to a subscription, with each attempt we made
+type Delivery struct {
+	ID             string            `bson:"id"`
+	SubscriptionID string            `bson:"subscriptionId"`
+	CveID          string            `bson:"cveId"`
+	Event          string            `bson:"event"`
+	Status         string            `bson:"status"` // delivered or failed
+	CreatedAt      string            `bson:"createdAt"`
+	Attempts       []DeliveryAttempt `bson:"attempts"`
+}
+
+type DeliveryAttempt struct {
+	At         string `bson:"at"`
+	StatusCode int    `bson:"statusCode,omitempty"`
+	Error      string `bson:"error,omitempty"`
+	DurationMs int64  `bson:"durationMs"`
+}
+
+// the parts of a CVE's document in the CVE collection that we match subscriptions against and notify them of.
+// cvewriter stores NVD's records under their JSON names
+type CveDoc struct {
+	CveData struct {
+		ID           string       `bson:"id"`
+		Published    string       `bson:"published"`
+		LastModified string       `bson:"lastModified"`
+		Descriptions []LangString `bson:"descriptions"`
+	} `bson:"cvedata"`
+	PreferredScore *Severity        `bson:"preferredScore"`
+	Kev            *KevData         `bson:"kev"`
+	Sources        CveSources       `bson:"sources"`
+	Consolidated   ConsolidatedView `bson:"consolidated"`
+}
+
+type LangString struct {
+	Lang  string `bson:"lang"`
+	Value string `bson:"value"`
+}
+
+type KevData struct {
+	DateAdded      string `bson:"dateAdded" json:"dateAdded"`
+	DueDate        string `bson:"dueDate" json:"dueDate"`
+	RequiredAction string `bson:"requiredAction" json:"requiredAction"`
+}
+
+// the records each source holds about a CVE, as cvewriter keeps them in its document's `sources` block
+type CveSources struct {
+	Nvd  *SourceRecord  `bson:"nvd"`
+	Osv  []SourceRecord `bson:"osv"`
+	Ghsa []SourceRecord `bson:"ghsa"`
+	Kev  *SourceRecord  `bson:"kev"`
+}
+
+// all returns every source's records
+func (s CveSources) all() []SourceRecord {
+	records := append([]SourceRecord{}, s.Osv...)
+	records = append(records, s.Ghsa...)
+	for _, record := range []*SourceRecord{s.Nvd, s.Kev} {
+		if record != nil {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+type SourceRecord struct {
+	ID          string     `bson:"id"`
+	Description string     `bson:"description"`
+	Affected    []Affected `bson:"affected"`
+}
+
+type Severity struct {
+	BaseSeverity string  `bson:"baseSeverity" json:"baseSeverity"`
+	BaseScore    float64 `bson:"baseScore" json:"baseScore"`
+	VectorString string  `bson:"vectorString" json:"vectorString"`
+}
+
+// a package in an ecosystem (OSV, GHSA) or a CPE (NVD) that a source says is affected, in the given versions
+type Affected struct {
+	Ecosystem string          `bson:"ecosystem"`
+	Package   string          `bson:"package"`
+	Cpe       string          `bson:"cpe"`
+	Ranges    []AffectedRange `bson:"ranges"`
+}
+
+type AffectedRange struct {
+	Introduced          string `bson:"introduced"`
+	IntroducedExcluding string `bson:"introducedExcluding"`
+	Fixed               string `bson:"fixed"`
+	LastAffected        string `bson:"lastAffected"`
+}
+
+type ConsolidatedView struct {
+	Description string    `bson:"description"`
+	Severity    *Severity `bson:"severity"`
+}
+
+// the body of a webhook delivery
+type Payload struct {
+	ID             string     `json:"id"` // the delivery's ID, the same for each attempt at it
+	Event          string     `json:"event"`
+	SubscriptionID string     `json:"subscriptionId"`
+	Timestamp      string     `json:"timestamp"`
+	Cve            CveSummary `json:"cve"`
+}
+
+// what we tell subscribers about a CVE. They can fetch the rest from cvequerier
+type CveSummary struct {
+	ID           string    `json:"id"`
+	Published    string    `json:"published"`
+	LastModified string    `json:"lastModified"`
+	Description  string    `json:"description"`
+	Severity     *Severity `json:"severity,omitempty"`
+	Kev          *KevData  `json:"kev,omitempty"`
+	URL          string    `json:"url"`
+}
+
+// description returns the CVE's consolidated description, or NVD's English one if it doesn't have one yet
+func (c *CveDoc) description() string {
+	if c.Consolidated.Description != "" {
+		return c.Consolidated.Description
+	}
+	for _, desc := range c.CveData.Descriptions {
+		if desc.Lang == "en" {
+			return desc.Value
+		}
+	}
+	return ""
+}
+
+// severity returns the CVE's consolidated severity, falling back to its preferred score. GHSA's ratings don't come
+// with a score, so we prefer whichever of the two has one
+func (c *CveDoc) severity() *Severity {
+	if c.Consolidated.Severity != nil && c.Consolidated.Severity.BaseScore > 0 {
+		return c.Consolidated.Severity
+	}
+	if c.PreferredScore != nil {
+		return c.PreferredScore
+	}
+	return c.Consolidated.Severity
+}
+
+func NewCveSummary(cve *CveDoc) CveSummary {
+	return CveSummary{
+		ID:           cve.CveData.ID,
+		Published:    cve.CveData.Published,
+		LastModified: cve.CveData.LastModified,
+		Description:  cve.description(),
+		Severity:     cve.severity(),
+		Kev:          cve.Kev,
+		URL:          "https://nvd.nist.gov/vuln/detail/" + cve.CveData.ID,
+	}
+}
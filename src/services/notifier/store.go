@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps subscriptions and their deliveries in the collections cvequerier serves them from
+type MongoStore struct {
+	cves          *mongo.Collection
+	subscriptions *mongo.Collection
+	deliveries    *mongo.Collection
+}
+
+// GetCve fetches the parts of a CVE's record we match subscriptions against
+func (s *MongoStore) GetCve(cveID string) (*CveDoc, error) {
+	projection := bson.D{
+		{Key: "cvedata.id", Value: 1},
+		{Key: "cvedata.published", Value: 1},
+		{Key: "cvedata.lastModified", Value: 1},
+		{Key: "cvedata.descriptions", Value: 1},
+		{Key: "preferredScore", Value: 1},
+		{Key: "kev", Value: 1},
+		{Key: "sources", Value: 1},
+		{Key: "consolidated", Value: 1},
+	}
+	var cve CveDoc
+	filter := bson.D{{Key: "cvedata.id", Value: cveID}}
+	if err := s.cves.FindOne(context.TODO(), filter, options.FindOne().SetProjection(projection)).Decode(&cve); err != nil {
+		return nil, err
+	}
+	return &cve, nil
+}
+
+func (s *MongoStore) GetEnabledSubscriptions() ([]Subscription, error) {
+	cursor, err := s.subscriptions.Find(context.TODO(), bson.D{{Key: "enabled", Value: true}})
+	if err != nil {
+		return nil, err
+	}
+	subs := []Subscription{}
+	if err := cursor.All(context.TODO(), &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+func (s *MongoStore) RecordDelivery(delivery Delivery) error {
+	_, err := s.deliveries.InsertOne(context.TODO(), delivery)
+	return err
+}
+
+func (s *MongoStore) ResetFailures(subscriptionID string) error {
+	filter := bson.D{{Key: "id", Value: subscriptionID}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "consecutiveFailures", Value: 0}}}}
+	_, err := s.subscriptions.UpdateOne(context.TODO(), filter, update)
+	return err
+}
+
+func (s *MongoStore) RecordFailure(subscriptionID string, disableAfter int, reason string) (bool, error) {
+
+	filter := bson.D{{Key: "id", Value: subscriptionID}}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "consecutiveFailures", Value: 1}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var sub Subscription
+	if err := s.subscriptions.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&sub); err != nil {
+		return false, err
+	}
+	if disableAfter <= 0 || sub.ConsecutiveFailures < disableAfter {
+		return false, nil
+	}
+
+	// only the delivery that crossed the threshold disables it, so its reason and time stick
+	filter = bson.D{{Key: "id", Value: subscriptionID}, {Key: "enabled", Value: true}}
+	update = bson.D{{Key: "$set", Value: bson.D{
+		{Key: "enabled", Value: false},
+		{Key: "disabledAt", Value: time.Now().UTC().Format(time.RFC3339)},
+		{Key: "disabledReason", Value: reason},
+	}}}
+	result, err := s.subscriptions.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
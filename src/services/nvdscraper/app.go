@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -12,7 +13,7 @@ type App struct {
 
 func (a *App) Run() error {
 
-	fmt.Println("Starting NVD Scraper app...")
+	fmt.Println("Starting scraper app...")
 
 	// TODO If the dataset has been initialized, skip this
 	err := a.Api.FetchAll()
@@ -29,18 +30,90 @@ func (a *App) Run() error {
 
 }
 
+// the topic each scraper mode publishes to, unless overridden with KAFKA_TOPIC
+var defaultTopics = map[string]string{
+	"nvd":        "nvd-cves",
+	"osv":        "osv-advisories",
+	"ghsa":       "osv-advisories",
+	"kev":        "kev-entries",
+	"epss":       "epss-scores",
+	"cwe":        "cwe-entries",
+	"cpe":        "cpe-products",
+	"cpematch":   "cpe-matches",
+	"cvehistory": "cve-changes",
+	"debian":     "distro-status",
+	"redhat":     "distro-status",
+	"ubuntu":     "distro-status",
+}
+
+// Canonical's OVAL feeds for the Ubuntu releases still in standard support
+const defaultUbuntuOvalLocations = "https://security-metadata.canonical.com/oval/com.ubuntu.focal.cve.oval.xml.bz2," +
+	"https://security-metadata.canonical.com/oval/com.ubuntu.jammy.cve.oval.xml.bz2," +
+	"https://security-metadata.canonical.com/oval/com.ubuntu.noble.cve.oval.xml.bz2"
+
+// readPollInterval reads how often a scraper re-imports its source, 0 meaning it only imports once
+func readPollInterval(key string, defaultVal string) time.Duration {
+	interval, err := time.ParseDuration(readFromENV(key, defaultVal))
+	if err != nil {
+		log.Fatalf("Invalid %s: %s", key, err)
+	}
+	return interval
+}
+
 func main() {
 
 	time.Sleep(10 * time.Second) // TODO this is a temp hack to wait for kafka to start accepting before we have proper connection/retry handling
 
+	// each deployment of the scraper ingests a single source, publishing to that source's topic
+	mode := readFromENV("SCRAPER_MODE", "nvd")
+	defaultTopic, ok := defaultTopics[mode]
+	if !ok {
+		log.Fatalf("Unknown scraper mode: %s", mode)
+	}
+
 	// create new kafkahandler instance
-	var cveHandler *KafkaHandler = newKafkaHandler(readFromENV("KAFKA_BROKER", "localhost:9092"), readFromENV("KAFKA_TOPIC", "nvd-cves"))
-	defer cveHandler.Writer.Close()
+	var cveHandler *KafkaHandler = newKafkaHandler(readFromENV("KAFKA_BROKER", "localhost:9092"), readFromENV("KAFKA_TOPIC", defaultTopic))
+	defer cveHandler.Close()
 
-	// create new nvdscraper instance and wire in kafkahandler
-	var scraper ApiScraper = NewNvdApiScraper(cveHandler, readFromENV("NVD_API_KEY", ""))
+	// create the scraper for our source and wire in kafkahandler
+	var scraper ApiScraper
+	switch mode {
+	case "nvd":
+		scraper = NewNvdApiScraper(cveHandler, readFromENV("NVD_API_KEY", ""))
+	case "osv":
+		scraper = NewOsvScraper(cveHandler, readFromENV("OSV_DATA_DIR", "/data/osv"))
+	case "ghsa":
+		scraper = NewGhsaScraper(cveHandler, readFromENV("GHSA_REPO_DIR", "/data/advisory-database"), readFromENV("GHSA_STATE_FILE", "/data/state/ghsa.json"))
+	case "kev":
+		scraper = NewKevScraper(cveHandler, readFromENV("KEV_LOCATION", "https://www.cisa.gov/sites/default/files/feeds/known_exploited_vulnerabilities.json"))
+	case "epss":
+		scraper = NewEpssScraper(cveHandler, readFromENV("EPSS_LOCATION", "https://epss.cyentia.com/epss_scores-current.csv.gz"))
+	case "cwe":
+		scraper = NewCweScraper(cveHandler, readFromENV("CWE_LOCATION", "https://cwe.mitre.org/data/xml/cwec_latest.xml.zip"))
+	case "cpe":
+		scraper = NewNvdCpeScraper(cveHandler, readFromENV("NVD_API_KEY", ""))
+	case "cpematch":
+		scraper = NewNvdCpeMatchScraper(cveHandler, readFromENV("NVD_API_KEY", ""))
+	case "cvehistory":
+		historyScraper, err := NewNvdCveHistoryScraper(cveHandler, readFromENV("NVD_API_KEY", ""), readFromENV("CVEHISTORY_SINCE", ""))
+		if err != nil {
+			log.Fatalf("Failed to create CVE history scraper: %s", err)
+		}
+		scraper = historyScraper
+	case "debian":
+		scraper = NewDebianScraper(cveHandler, readFromENV("DEBIAN_TRACKER_LOCATION", "https://security-tracker.debian.org/tracker/data/json"), readPollInterval("DEBIAN_POLL_INTERVAL", "1h"))
+	case "redhat":
+		scraper = NewRedHatScraper(cveHandler, readFromENV("REDHAT_CSAF_LOCATION", "/data/redhat-csaf"))
+	case "ubuntu":
+		scraper = NewUbuntuScraper(
+			cveHandler,
+			strings.Split(readFromENV("UBUNTU_OVAL_LOCATIONS", defaultUbuntuOvalLocations), ","),
+			readFromENV("UBUNTU_TRACKER_DIR", ""),
+			readPollInterval("UBUNTU_POLL_INTERVAL", "6h"),
+		)
+	}
 
-	// create new app instance and wire in nvdscraper
+	// create new app instance and wire in our scraper
 	app := App{
 		Api: scraper,
 	}
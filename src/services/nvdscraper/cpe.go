@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// NvdCpeScraper reads the NVD CPE dictionary, publishing every product in it
+type NvdCpeScraper struct {
+	NvdApiScraper
+}
+
+func (n *NvdCpeScraper) FetchAll() error {
+
+	return n.fetchPages("https://services.nvd.nist.gov/rest/json/cpes/2.0", func(body []byte) (nvdPage, []KafkaMsg, error) {
+
+		var result CpeResponse
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nvdPage{}, nil, err
+		}
+
+		cpeMsgs := make([]KafkaMsg, 0, len(result.Products))
+		for _, product := range result.Products {
+			cpeMsg, err := NewCpeMsg(product.Cpe, result.Timestamp)
+			if err != nil {
+				log.Printf("Error generating CpeMsg instance for data %s: %s", product.Cpe.CpeName, err)
+				continue
+			}
+			cpeMsgs = append(cpeMsgs, cpeMsg)
+		}
+
+		return nvdPage{result.ResultsPerPage, result.TotalResults}, cpeMsgs, nil
+	})
+
+}
+
+func NewNvdCpeScraper(handler CveHandler, key string) *NvdCpeScraper {
+	scraper := &NvdCpeScraper{*NewNvdApiScraper(handler, key)}
+	scraper.batchSize = 10000 // the most the CPE API allows
+	return scraper
+}
+
+// NvdCpeMatchScraper reads the NVD CPE Match Criteria API, publishing the CPE names each match criteria expands to
+type NvdCpeMatchScraper struct {
+	NvdApiScraper
+}
+
+func (n *NvdCpeMatchScraper) FetchAll() error {
+
+	return n.fetchPages("https://services.nvd.nist.gov/rest/json/cpematch/2.0", func(body []byte) (nvdPage, []KafkaMsg, error) {
+
+		var result CpeMatchResponse
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nvdPage{}, nil, err
+		}
+
+		matchMsgs := make([]KafkaMsg, 0, len(result.MatchStrings))
+		for _, matchString := range result.MatchStrings {
+			matchMsg, err := NewCpeMatchMsg(matchString.MatchString, result.Timestamp)
+			if err != nil {
+				log.Printf("Error generating CpeMatchMsg instance for data %s: %s", matchString.MatchString.MatchCriteriaID, err)
+				continue
+			}
+			matchMsgs = append(matchMsgs, matchMsg)
+		}
+
+		return nvdPage{result.ResultsPerPage, result.TotalResults}, matchMsgs, nil
+	})
+
+}
+
+func NewNvdCpeMatchScraper(handler CveHandler, key string) *NvdCpeMatchScraper {
+	scraper := &NvdCpeMatchScraper{*NewNvdApiScraper(handler, key)}
+	scraper.batchSize = 5000 // the most the match criteria API allows
+	return scraper
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// the CWE view whose ChildOf relationships we use as the hierarchy - Research Concepts covers every weakness
+const cweHierarchyView = "1000"
+
+// CweScraper reads MITRE's CWE catalog XML, from either its published URL or a local copy
+// (zipped or not), and publishes each weakness and category in it
+type CweScraper struct {
+	Handler   CveHandler
+	location  string
+	batchSize int
+}
+
+// the parts of the catalog's Weakness and Category elements we keep
+type cweXMLEntry struct {
+	ID              string `xml:"ID,attr"`
+	Name            string `xml:"Name,attr"`
+	Abstraction     string `xml:"Abstraction,attr"`
+	Status          string `xml:"Status,attr"`
+	Description     string `xml:"Description"`
+	Summary         string `xml:"Summary"`
+	RelatedWeakness []struct {
+		Nature string `xml:"Nature,attr"`
+		CweID  string `xml:"CWE_ID,attr"`
+		ViewID string `xml:"View_ID,attr"`
+	} `xml:"Related_Weaknesses>Related_Weakness"`
+}
+
+func (c *CweScraper) FetchAll() error {
+
+	body, err := openLocation(c.location)
+	if err != nil {
+		return fmt.Errorf("failed to open CWE catalog %s: %w", c.location, err)
+	}
+	defer body.Close()
+
+	catalog, err := unzipIfZipped(body)
+	if err != nil {
+		return fmt.Errorf("failed to unzip CWE catalog: %w", err)
+	}
+	defer catalog.Close()
+
+	timestamp := timestampNow()
+	cweMsgs := make([]KafkaMsg, 0, c.batchSize)
+
+	// stream through the catalog rather than unmarshalling the whole document, picking out the elements we want
+	decoder := xml.NewDecoder(catalog)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse CWE catalog: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || (start.Name.Local != "Weakness" && start.Name.Local != "Category") {
+			continue
+		}
+
+		var element cweXMLEntry
+		if err := decoder.DecodeElement(&element, &start); err != nil {
+			return fmt.Errorf("failed to parse CWE catalog: %w", err)
+		}
+
+		cweMsg, err := NewCweMsg(newCweEntry(strings.ToLower(start.Name.Local), element), timestamp)
+		if err != nil {
+			log.Printf("Error generating CweMsg instance for data CWE-%s: %s", element.ID, err)
+			continue
+		}
+		cweMsgs = append(cweMsgs, cweMsg)
+
+		if len(cweMsgs) == c.batchSize {
+			if err := c.Handler.WriteMsgs(cweMsgs); err != nil {
+				return err
+			}
+			cweMsgs = make([]KafkaMsg, 0, c.batchSize)
+		}
+	}
+
+	return c.Handler.WriteMsgs(cweMsgs)
+}
+
+func (c *CweScraper) StartPolling() error {
+
+	// the catalog is only revised a few times a year, so there's nothing to poll for
+
+	return nil
+}
+
+func (c *CweScraper) Close() error {
+	c.Handler.Close()
+	return nil
+}
+
+func NewCweScraper(handler CveHandler, location string) *CweScraper {
+	return &CweScraper{
+		Handler:   handler,
+		location:  location,
+		batchSize: 500,
+	}
+}
+
+func newCweEntry(entryType string, element cweXMLEntry) CweEntry {
+
+	entry := CweEntry{
+		ID:          "CWE-" + element.ID,
+		Type:        entryType,
+		Name:        element.Name,
+		Description: strings.TrimSpace(element.Description),
+		Abstraction: element.Abstraction,
+		Status:      element.Status,
+		Parents:     []string{},
+	}
+
+	// categories have a summary rather than a description
+	if entry.Description == "" {
+		entry.Description = strings.TrimSpace(element.Summary)
+	}
+
+	for _, related := range element.RelatedWeakness {
+		if related.Nature == "ChildOf" && related.ViewID == cweHierarchyView {
+			entry.Parents = append(entry.Parents, "CWE-"+related.CweID)
+		}
+	}
+
+	return entry
+}
+
+// unzipIfZipped sniffs the zip magic number and, if found, returns the archive's first XML file.
+// Zip archives need random access, so we read the download into memory first - it's only a few MB
+func unzipIfZipped(r io.Reader) (io.ReadCloser, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range archive.File {
+		if strings.HasSuffix(strings.ToLower(file.Name), ".xml") {
+			return file.Open()
+		}
+	}
+
+	return nil, fmt.Errorf("no XML file found in archive")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// DebianScraper reads the Debian Security Tracker's JSON export, which lists every CVE affecting each source
+// package along with its status in each Debian release
+type DebianScraper struct {
+	Handler      CveHandler
+	location     string
+	pollInterval time.Duration
+}
+
+// the tracker's view of a CVE in one package
+type debianCve struct {
+	Description string                   `json:"description"`
+	Releases    map[string]debianRelease `json:"releases"`
+}
+
+type debianRelease struct {
+	Status       string `json:"status"` // resolved, open or undetermined
+	FixedVersion string `json:"fixed_version"`
+	Urgency      string `json:"urgency"`
+	NoDsa        string `json:"nodsa"`
+	NoDsaReason  string `json:"nodsa_reason"` // ignored or postponed, when no DSA is planned
+}
+
+func (d *DebianScraper) FetchAll() error {
+
+	body, err := openLocation(d.location)
+	if err != nil {
+		return fmt.Errorf("failed to open Debian security tracker data %s: %w", d.location, err)
+	}
+	defer body.Close()
+
+	publisher := newDistroPublisher(d.Handler, "debian")
+	if err := readDebianTracker(body, publisher.publish); err != nil {
+		return fmt.Errorf("failed to parse Debian security tracker data: %w", err)
+	}
+
+	return publisher.flush()
+}
+
+// readDebianTracker streams through the tracker's export a package at a time, as it's far too large to
+// comfortably hold in memory all at once
+func readDebianTracker(r io.Reader, publish func(DistroStatus) error) error {
+
+	decoder := json.NewDecoder(r)
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		pkg, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("unexpected token %v", token)
+		}
+
+		var cves map[string]debianCve
+		if err := decoder.Decode(&cves); err != nil {
+			return err
+		}
+
+		for id, cve := range cves {
+			// the tracker also lists issues that have yet to be assigned a CVE, under temporary IDs
+			if !strings.HasPrefix(id, "CVE-") {
+				continue
+			}
+			for release, status := range cve.Releases {
+				if err := publish(newDebianStatus(id, pkg, release, status)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func newDebianStatus(id string, pkg string, release string, r debianRelease) DistroStatus {
+
+	status := DistroStatus{
+		Cve:      id,
+		Release:  release,
+		Package:  pkg,
+		Severity: r.Urgency,
+		Notes:    r.NoDsa,
+	}
+
+	switch {
+	// a fixed version of 0 means the release never shipped a vulnerable version
+	case r.Status == "resolved" && r.FixedVersion == "0":
+		status.Status = distroStatusNotAffected
+	case r.Status == "resolved":
+		status.Status = distroStatusFixed
+		status.FixedVersion = r.FixedVersion
+	case r.Status == "undetermined":
+		status.Status = distroStatusUnderInvestigation
+	case r.NoDsaReason == "ignored" || r.Urgency == "unimportant" || r.Urgency == "end-of-life":
+		status.Status = distroStatusWillNotFix
+	case r.NoDsaReason == "postponed":
+		status.Status = distroStatusDeferred
+	default:
+		status.Status = distroStatusAffected
+	}
+
+	return status
+}
+
+// StartPolling re-imports the tracker's export every so often. It's regenerated every few minutes, and cvewriter
+// overwrites each package's status, so re-reading the lot is all it takes
+func (d *DebianScraper) StartPolling() error {
+	return pollDistro("debian", d.pollInterval, d.FetchAll)
+}
+
+func (d *DebianScraper) Close() error {
+	d.Handler.Close()
+	return nil
+}
+
+func NewDebianScraper(handler CveHandler, location string, pollInterval time.Duration) *DebianScraper {
+	return &DebianScraper{
+		Handler:      handler,
+		location:     location,
+		pollInterval: pollInterval,
+	}
+}
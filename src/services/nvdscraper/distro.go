@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// distroPublisher batches up the package statuses a distro tracker scraper produces, sending them to kafka
+// as each batch fills
+type distroPublisher struct {
+	Handler   CveHandler
+	distro    string
+	timestamp string
+	batchSize int
+	pending   []KafkaMsg
+	published int
+}
+
+func newDistroPublisher(handler CveHandler, distro string) *distroPublisher {
+	return &distroPublisher{
+		Handler:   handler,
+		distro:    distro,
+		timestamp: timestampNow(),
+		batchSize: 2000,
+	}
+}
+
+func (d *distroPublisher) publish(status DistroStatus) error {
+
+	status.Distro = d.distro
+
+	msg, err := NewDistroMsg(status, d.timestamp)
+	if err != nil {
+		log.Printf("Error generating DistroMsg instance for data %s/%s: %s", status.Cve, status.Package, err)
+		return nil
+	}
+
+	d.pending = append(d.pending, msg)
+	if len(d.pending) >= d.batchSize {
+		return d.flush()
+	}
+
+	return nil
+}
+
+func (d *distroPublisher) flush() error {
+
+	if len(d.pending) == 0 {
+		return nil
+	}
+
+	if err := d.Handler.WriteMsgs(d.pending); err != nil {
+		return err
+	}
+
+	d.published += len(d.pending)
+	log.Printf("Published %d %s package statuses", d.published, d.distro)
+	d.pending = nil
+
+	return nil
+}
+
+// pollDistro re-imports a distro's statuses every interval until the scraper is stopped, or never if the interval
+// is 0. A failed import is retried at the next interval rather than stopping the scraper
+func pollDistro(distro string, interval time.Duration, fetch func() error) error {
+
+	if interval <= 0 {
+		return nil
+	}
+
+	for {
+		time.Sleep(interval)
+		log.Printf("Re-importing %s package statuses", distro)
+		if err := fetch(); err != nil {
+			log.Printf("Failed to re-import %s package statuses, trying again in %s: %s", distro, interval, err)
+		}
+	}
+}
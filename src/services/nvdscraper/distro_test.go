@@ -0,0 +1,196 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// collect gathers up published statuses, sorted so tests don't depend on map iteration order
+func collect(t *testing.T, read func(publish func(DistroStatus) error) error) []DistroStatus {
+	statuses := []DistroStatus{}
+	if err := read(func(s DistroStatus) error {
+		statuses = append(statuses, s)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Release+statuses[i].Package < statuses[j].Release+statuses[j].Package
+	})
+	return statuses
+}
+
+func assertStatuses(t *testing.T, expected []DistroStatus, actual []DistroStatus) {
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d statuses, got %d: %+v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], actual[i])
+		}
+	}
+}
+
+func TestReadDebianTracker(t *testing.T) {
+
+	data := `{
+		"openssl": {
+			"CVE-2023-0286": {"description": "X.400 type confusion", "releases": {
+				"bookworm": {"status": "resolved", "fixed_version": "3.0.8-1", "urgency": "high"},
+				"bullseye": {"status": "open", "urgency": "low", "nodsa": "Minor issue", "nodsa_reason": "postponed"}
+			}},
+			"TEMP-0000000-ABCDEF": {"releases": {"bookworm": {"status": "open"}}}
+		},
+		"log4j1.2": {
+			"CVE-2021-44228": {"releases": {
+				"bookworm": {"status": "resolved", "fixed_version": "0", "urgency": "not yet assigned"},
+				"buster": {"status": "open", "urgency": "unimportant"}
+			}}
+		}
+	}`
+
+	statuses := collect(t, func(publish func(DistroStatus) error) error {
+		return readDebianTracker(strings.NewReader(data), publish)
+	})
+
+	assertStatuses(t, []DistroStatus{
+		{Cve: "CVE-2021-44228", Release: "bookworm", Package: "log4j1.2", Status: distroStatusNotAffected, Severity: "not yet assigned"},
+		{Cve: "CVE-2023-0286", Release: "bookworm", Package: "openssl", Status: distroStatusFixed, FixedVersion: "3.0.8-1", Severity: "high"},
+		{Cve: "CVE-2023-0286", Release: "bullseye", Package: "openssl", Status: distroStatusDeferred, Severity: "low", Notes: "Minor issue"},
+		{Cve: "CVE-2021-44228", Release: "buster", Package: "log4j1.2", Status: distroStatusWillNotFix, Severity: "unimportant"},
+	}, statuses)
+
+}
+
+func TestReadCsafDocument(t *testing.T) {
+
+	data := `{
+		"document": {"aggregate_severity": {"text": "Important"}},
+		"product_tree": {
+			"branches": [{"category": "vendor", "name": "Red Hat", "branches": [
+				{"category": "product_family", "name": "Red Hat Enterprise Linux", "branches": [
+					{"category": "product_name", "name": "Red Hat Enterprise Linux AppStream (v. 9)",
+					 "product": {"name": "Red Hat Enterprise Linux AppStream (v. 9)", "product_id": "AppStream-9.2.0.Z.MAIN.EUS"}},
+					{"category": "product_name", "name": "Red Hat Enterprise Linux 8",
+					 "product": {"name": "Red Hat Enterprise Linux 8", "product_id": "red_hat_enterprise_linux_8"}}
+				]}
+			]}],
+			"relationships": [
+				{"full_product_name": {"product_id": "AppStream-9.2.0.Z.MAIN.EUS:openssl-1:3.0.7-18.el9_2.src"},
+				 "product_reference": "openssl-1:3.0.7-18.el9_2.src", "relates_to_product_reference": "AppStream-9.2.0.Z.MAIN.EUS"},
+				{"full_product_name": {"product_id": "AppStream-9.2.0.Z.MAIN.EUS:openssl-1:3.0.7-18.el9_2.x86_64"},
+				 "product_reference": "openssl-1:3.0.7-18.el9_2.x86_64", "relates_to_product_reference": "AppStream-9.2.0.Z.MAIN.EUS"},
+				{"full_product_name": {"product_id": "red_hat_enterprise_linux_8:compat-openssl10"},
+				 "product_reference": "compat-openssl10", "relates_to_product_reference": "red_hat_enterprise_linux_8"},
+				{"full_product_name": {"product_id": "red_hat_enterprise_linux_8:java-1.8.0-openjdk"},
+				 "product_reference": "java-1.8.0-openjdk", "relates_to_product_reference": "red_hat_enterprise_linux_8"}
+			]
+		},
+		"vulnerabilities": [{
+			"cve": "CVE-2023-0286",
+			"product_status": {
+				"fixed": ["AppStream-9.2.0.Z.MAIN.EUS:openssl-1:3.0.7-18.el9_2.src", "AppStream-9.2.0.Z.MAIN.EUS:openssl-1:3.0.7-18.el9_2.x86_64"],
+				"known_affected": ["red_hat_enterprise_linux_8:compat-openssl10"],
+				"known_not_affected": ["red_hat_enterprise_linux_8:java-1.8.0-openjdk"]
+			},
+			"remediations": [{"category": "no_fix_planned", "details": "Will not fix", "product_ids": ["red_hat_enterprise_linux_8:compat-openssl10"]}]
+		}]
+	}`
+
+	statuses := collect(t, func(publish func(DistroStatus) error) error {
+		return readCsafDocument(strings.NewReader(data), "test", publish)
+	})
+
+	assertStatuses(t, []DistroStatus{
+		{Cve: "CVE-2023-0286", Release: "Red Hat Enterprise Linux 8", Package: "compat-openssl10", Status: distroStatusWillNotFix, Severity: "Important"},
+		{Cve: "CVE-2023-0286", Release: "Red Hat Enterprise Linux 8", Package: "java-1.8.0-openjdk", Status: distroStatusNotAffected, Severity: "Important"},
+		{Cve: "CVE-2023-0286", Release: "Red Hat Enterprise Linux AppStream (v. 9)", Package: "openssl", Status: distroStatusFixed, FixedVersion: "1:3.0.7-18.el9_2", Severity: "Important"},
+	}, statuses)
+
+}
+
+func TestReadUbuntuCve(t *testing.T) {
+
+	data := `Candidate: CVE-2021-44228
+PublicDate: 2021-12-10
+Description:
+ Apache Log4j2 2.0-beta9 through 2.15.0 JNDI features ...
+ jammy_foo: this continuation line isn't a status
+Priority: high
+
+Patches_apache-log4j2:
+upstream_apache-log4j2: released (2.15.0)
+trusty/esm_apache-log4j2: DNE
+bionic_apache-log4j2: released (2.15.0-0ubuntu0.18.04.1)
+focal_apache-log4j2: ignored (end of standard support)
+jammy_apache-log4j2: not-affected (2.15.0-1)
+devel_apache-log4j2: not-affected (2.15.0-1)
+Priority_apache-log4j2: critical
+
+Patches_log4j:
+bionic_log4j: needs-triage
+`
+
+	statuses := collect(t, func(publish func(DistroStatus) error) error {
+		return readUbuntuCve(strings.NewReader(data), "CVE-2021-44228", publish)
+	})
+
+	assertStatuses(t, []DistroStatus{
+		{Cve: "CVE-2021-44228", Release: "bionic", Package: "apache-log4j2", Status: distroStatusFixed, FixedVersion: "2.15.0-0ubuntu0.18.04.1", Severity: "critical"},
+		{Cve: "CVE-2021-44228", Release: "bionic", Package: "log4j", Status: distroStatusUnderInvestigation, Severity: "high"},
+		{Cve: "CVE-2021-44228", Release: "focal", Package: "apache-log4j2", Status: distroStatusWillNotFix, Severity: "critical", Notes: "end of standard support"},
+		{Cve: "CVE-2021-44228", Release: "jammy", Package: "apache-log4j2", Status: distroStatusNotAffected, Severity: "critical", Notes: "2.15.0-1"},
+	}, statuses)
+
+}
+
+func TestReadUbuntuOval(t *testing.T) {
+
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<oval_definitions xmlns="http://oval.mitre.org/XMLSchema/oval-definitions-5">
+  <definitions>
+    <definition class="inventory" id="oval:com.ubuntu.jammy:def:100" version="1">
+      <metadata><title>Check that Ubuntu 22.04 LTS (jammy) is installed.</title></metadata>
+      <criteria><criterion test_ref="oval:com.ubuntu.jammy:tst:100" comment="The host is part of the unix family." /></criteria>
+    </definition>
+    <definition class="vulnerability" id="oval:com.ubuntu.jammy:def:202144228000000" version="1">
+      <metadata>
+        <title>CVE-2021-44228 on Ubuntu 22.04 LTS (jammy) - high.</title>
+        <reference source="CVE" ref_id="CVE-2021-44228" ref_url="https://ubuntu.com/security/CVE-2021-44228" />
+        <advisory><severity>High</severity></advisory>
+      </metadata>
+      <criteria>
+        <extend_definition definition_ref="oval:com.ubuntu.jammy:def:100" applicability_check="true" />
+        <criteria operator="OR">
+          <criterion test_ref="oval:com.ubuntu.jammy:tst:1" comment="apache-log4j2 package in jammy, is related to the CVE in some way and has been fixed (note: '2.15.0-1')." />
+          <criterion test_ref="oval:com.ubuntu.jammy:tst:2" comment="log4j package in jammy is affected and may need fixing." />
+          <criterion test_ref="oval:com.ubuntu.jammy:tst:3" comment="logback package in jammy is affected, but a decision has been made to defer addressing it (note: '2022-01-01')." />
+          <criterion test_ref="oval:com.ubuntu.jammy:tst:4" comment="liblog4j1.2-java package in jammy is affected and needs fixing." />
+          <criterion test_ref="oval:com.ubuntu.jammy:tst:5" comment="something we can't make sense of" />
+        </criteria>
+      </criteria>
+    </definition>
+  </definitions>
+</oval_definitions>`
+
+	// feeds can be uncompressed files as well as bzip2 compressed URLs
+	path := filepath.Join(t.TempDir(), "com.ubuntu.jammy.cve.oval.xml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := collect(t, func(publish func(DistroStatus) error) error {
+		return readUbuntuOvalLocation(path, publish)
+	})
+
+	assertStatuses(t, []DistroStatus{
+		{Cve: "CVE-2021-44228", Release: "jammy", Package: "apache-log4j2", Status: distroStatusFixed, FixedVersion: "2.15.0-1", Severity: "high"},
+		{Cve: "CVE-2021-44228", Release: "jammy", Package: "liblog4j1.2-java", Status: distroStatusAffected, Severity: "high"},
+		{Cve: "CVE-2021-44228", Release: "jammy", Package: "log4j", Status: distroStatusUnderInvestigation, Severity: "high"},
+		{Cve: "CVE-2021-44228", Release: "jammy", Package: "logback", Status: distroStatusDeferred, Severity: "high", Notes: "2022-01-01"},
+	}, statuses)
+
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// EpssScraper reads FIRST's daily EPSS scores CSV, from either its published URL or a local
+// copy (gzipped or not), and publishes a score update for each CVE in it
+type EpssScraper struct {
+	Handler   CveHandler
+	location  string
+	batchSize int
+}
+
+func (e *EpssScraper) FetchAll() error {
+
+	body, err := openLocation(e.location)
+	if err != nil {
+		return fmt.Errorf("failed to open EPSS scores %s: %w", e.location, err)
+	}
+	defer body.Close()
+
+	r, err := decompressIfGzipped(bufio.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to decompress EPSS scores: %w", err)
+	}
+
+	// the file leads with a comment line describing the scores, e.g. #model_version:v2023.03.01,score_date:2023-07-13T00:00:00+0000
+	modelVersion, scoreDate, err := readEpssHeader(r)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Reading EPSS scores from model %s for %s", modelVersion, scoreDate)
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.ReuseRecord = true
+
+	// skip the column headings
+	if _, err := reader.Read(); err != nil {
+		return fmt.Errorf("failed to read EPSS column headings: %w", err)
+	}
+
+	timestamp := timestampNow()
+	epssMsgs := make([]KafkaMsg, 0, e.batchSize)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read EPSS scores: %w", err)
+		}
+
+		score, err := parseEpssRecord(record, scoreDate, modelVersion)
+		if err != nil {
+			log.Printf("Skipping EPSS row %v: %s", record, err)
+			continue
+		}
+
+		epssMsg, err := NewEpssMsg(score, timestamp)
+		if err != nil {
+			log.Printf("Error generating EpssMsg instance for data %s: %s", score.Cve, err)
+			continue
+		}
+		epssMsgs = append(epssMsgs, epssMsg)
+
+		if len(epssMsgs) == e.batchSize {
+			if err := e.Handler.WriteMsgs(epssMsgs); err != nil {
+				return err
+			}
+			epssMsgs = make([]KafkaMsg, 0, e.batchSize)
+		}
+	}
+
+	return e.Handler.WriteMsgs(epssMsgs)
+}
+
+func (e *EpssScraper) StartPolling() error {
+
+	// TODO scores are republished once a day, so like KEV this is best run as a daily scheduled job
+
+	return nil
+}
+
+func (e *EpssScraper) Close() error {
+	e.Handler.Close()
+	return nil
+}
+
+func NewEpssScraper(handler CveHandler, location string) *EpssScraper {
+	return &EpssScraper{
+		Handler:   handler,
+		location:  location,
+		batchSize: 2000,
+	}
+}
+
+// decompressIfGzipped sniffs the gzip magic number, so we don't rely on the file's name to tell us
+func decompressIfGzipped(r *bufio.Reader) (*bufio.Reader, error) {
+	magic, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return bufio.NewReader(gz), nil
+	}
+	return r, nil
+}
+
+func readEpssHeader(r *bufio.Reader) (string, string, error) {
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read EPSS header: %w", err)
+	}
+
+	header := strings.TrimSpace(line)
+	if !strings.HasPrefix(header, "#") {
+		return "", "", fmt.Errorf("EPSS file has no header comment, found %q", header)
+	}
+
+	var modelVersion, scoreDate string
+	for _, field := range strings.Split(strings.TrimPrefix(header, "#"), ",") {
+		key, value, _ := strings.Cut(field, ":")
+		switch key {
+		case "model_version":
+			modelVersion = value
+		case "score_date":
+			scoreDate = value
+		}
+	}
+
+	// we only care about the day the scores apply to
+	if len(scoreDate) >= 10 {
+		scoreDate = scoreDate[:10]
+	}
+
+	return modelVersion, scoreDate, nil
+}
+
+func parseEpssRecord(record []string, date string, modelVersion string) (EpssScore, error) {
+
+	epss, err := strconv.ParseFloat(record[1], 64)
+	if err != nil {
+		return EpssScore{}, err
+	}
+
+	percentile, err := strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		return EpssScore{}, err
+	}
+
+	return EpssScore{
+		Cve:          record[0],
+		Epss:         epss,
+		Percentile:   percentile,
+		Date:         date,
+		ModelVersion: modelVersion,
+	}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GhsaScraper reads the GitHub Security Advisory database (https://github.com/github/advisory-database)
+// from a local clone. The advisories are already in the OSV schema, so they're published as OSV messages
+// with GHSA as their source, carrying their CVE aliases, ecosystem ranges and GitHub's own severity
+// (database_specific.severity) along with them.
+//
+// Only files modified since the last run are published, so re-running after a `git pull` just sends the
+// advisories the pull touched - git rewrites the files it updates, giving them a fresh mtime
+type GhsaScraper struct {
+	OsvScraper
+	stateFile string
+}
+
+// what we remember between runs
+type ghsaState struct {
+	LastRun time.Time `json:"lastRun"`
+}
+
+func (g *GhsaScraper) FetchAll() error {
+
+	state, err := g.readState()
+	if err != nil {
+		return err
+	}
+	if state.LastRun.IsZero() {
+		log.Printf("No previous GHSA run recorded, reading every advisory in %s", g.dataDir)
+	} else {
+		log.Printf("Reading GHSA advisories modified since %s", state.LastRun.Format(time.RFC3339))
+	}
+
+	// take the start time rather than the end, so that anything pulled in while we walk is picked up next time
+	started := time.Now()
+	timestamp := timestampNow()
+
+	err = filepath.WalkDir(g.dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), "GHSA-") || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().After(state.LastRun) {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return g.readAdvisory(f, path, timestamp)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read GHSA advisories from %s: %w", g.dataDir, err)
+	}
+
+	if err := g.flush(); err != nil {
+		return err
+	}
+
+	// only move on from this run once everything it read has reached kafka, or the next would skip what didn't
+	if err := g.Handler.Flush(); err != nil {
+		return fmt.Errorf("failed to publish GHSA advisories, not recording the run: %w", err)
+	}
+
+	return g.writeState(ghsaState{LastRun: started})
+}
+
+// readState loads the state of the last run, which is empty if there hasn't been one
+func (g *GhsaScraper) readState() (ghsaState, error) {
+
+	var state ghsaState
+
+	data, err := os.ReadFile(g.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read GHSA state from %s: %w", g.stateFile, err)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse GHSA state in %s: %w", g.stateFile, err)
+	}
+
+	return state, nil
+}
+
+func (g *GhsaScraper) writeState(state ghsaState) error {
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(g.stateFile), 0755); err != nil {
+		return fmt.Errorf("failed to write GHSA state to %s: %w", g.stateFile, err)
+	}
+	if err := os.WriteFile(g.stateFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write GHSA state to %s: %w", g.stateFile, err)
+	}
+
+	return nil
+}
+
+func NewGhsaScraper(handler CveHandler, repoDir string, stateFile string) *GhsaScraper {
+	osv := NewOsvScraper(handler, repoDir)
+	osv.source = "GHSA"
+	return &GhsaScraper{
+		OsvScraper: *osv,
+		stateFile:  stateFile,
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingHandler keeps the messages it's given, rather than sending them to kafka
+type recordingHandler struct {
+	msgs     []KafkaMsg
+	flushErr error
+}
+
+func (r *recordingHandler) WriteMsgs(msgs []KafkaMsg) error {
+	r.msgs = append(r.msgs, msgs...)
+	return nil
+}
+
+func (r *recordingHandler) Flush() error {
+	return r.flushErr
+}
+
+func (r *recordingHandler) Close() error {
+	return nil
+}
+
+func writeAdvisory(t *testing.T, dir string, id string, modified time.Time) {
+	path := filepath.Join(dir, "advisories", "github-reviewed", "2021", "12", id, id+".json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := `{
+		"schema_version": "1.4.0",
+		"id": "` + id + `",
+		"aliases": ["CVE-2021-44228"],
+		"affected": [{
+			"package": {"ecosystem": "Maven", "name": "org.apache.logging.log4j:log4j-core"},
+			"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "2.0-beta9"}, {"fixed": "2.3.1"}]}]
+		}],
+		"database_specific": {"severity": "CRITICAL", "github_reviewed": true, "cwe_ids": ["CWE-502"]}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGhsaScraper_Only_Publishes_Changed_Advisories(t *testing.T) {
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state", "ghsa.json")
+	past := time.Now().Add(-time.Hour)
+
+	writeAdvisory(t, dir, "GHSA-jfh8-c2jp-5v3q", past)
+	writeAdvisory(t, dir, "GHSA-7rjr-3q55-vv33", past)
+
+	// the first run has nothing to go on, so publishes everything
+	handler := &recordingHandler{}
+	if err := NewGhsaScraper(handler, dir, stateFile).FetchAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.msgs) != 2 {
+		t.Fatalf("expected 2 advisories on the first run, got %d", len(handler.msgs))
+	}
+
+	msg := handler.msgs[0].(OsvMsg)
+	if msg.Source != "GHSA" {
+		t.Errorf("expected source GHSA, got %s", msg.Source)
+	}
+	if len(msg.Osv.Aliases) != 1 || msg.Osv.Aliases[0] != "CVE-2021-44228" {
+		t.Errorf("expected the CVE alias to be kept, got %v", msg.Osv.Aliases)
+	}
+	if msg.Osv.DatabaseSpecific["severity"] != "CRITICAL" {
+		t.Errorf("expected the GHSA severity to be kept, got %v", msg.Osv.DatabaseSpecific["severity"])
+	}
+
+	// then only what's changed since
+	writeAdvisory(t, dir, "GHSA-7rjr-3q55-vv33", time.Now().Add(time.Minute))
+
+	handler = &recordingHandler{}
+	if err := NewGhsaScraper(handler, dir, stateFile).FetchAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.msgs) != 1 || handler.msgs[0].MsgKey() != "GHSA-7rjr-3q55-vv33" {
+		t.Fatalf("expected only the modified advisory on the second run, got %v", handler.msgs)
+	}
+
+}
+
+func TestGhsaScraper_Keeps_State_When_Publishing_Fails(t *testing.T) {
+
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state", "ghsa.json")
+	writeAdvisory(t, dir, "GHSA-jfh8-c2jp-5v3q", time.Now().Add(-time.Hour))
+
+	// a batch that didn't reach kafka leaves the run unrecorded, so the next one sends it again
+	handler := &recordingHandler{flushErr: errors.New("broker unavailable")}
+	if err := NewGhsaScraper(handler, dir, stateFile).FetchAll(); err == nil {
+		t.Fatal("expected the failed batch to be reported")
+	}
+	if _, err := os.Stat(stateFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected no state to be written, got %v", err)
+	}
+
+	handler = &recordingHandler{}
+	if err := NewGhsaScraper(handler, dir, stateFile).FetchAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.msgs) != 1 {
+		t.Fatalf("expected the advisory to be published again, got %v", handler.msgs)
+	}
+
+}
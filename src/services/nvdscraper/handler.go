@@ -3,33 +3,49 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"sync"
 
 	"github.com/segmentio/kafka-go"
 )
 
+// KafkaMsg is implemented by every message we produce, so that updates for the same
+// record are keyed consistently regardless of which source they came from
+type KafkaMsg interface {
+	MsgKey() string
+}
+
+// WriteMsgs may return before its messages are written, so scrapers that record how far they've got call Flush
+// first, which waits for every batch so far and reports any that failed
 type CveHandler interface {
-	WriteCves(cves []CveMsg) error
+	WriteMsgs(msgs []KafkaMsg) error
+	Flush() error
 	Close() error
 }
 
 type KafkaHandler struct {
-	Writer *kafka.Writer
+	Writer   *kafka.Writer
+	inFlight sync.WaitGroup
+
+	// the batches that failed since the last flush
+	mu     sync.Mutex
+	failed []error
 }
 
-func (k *KafkaHandler) WriteCves(cves []CveMsg) error {
+func (k *KafkaHandler) WriteMsgs(data []KafkaMsg) error {
 
-	l := len(cves)
+	l := len(data)
 	msgs := make([]kafka.Message, l)
 
-	for i, c := range cves {
-		value, err := json.Marshal(c)
+	for i, d := range data {
+		value, err := json.Marshal(d)
 		if err != nil {
-			log.Printf("Failed to serialize CVE data into JSON: %s", err)
+			log.Printf("Failed to serialize message data into JSON: %s", err)
 			return err
 		}
 
-		key := c.Cve.ID
+		key := d.MsgKey()
 		msg := kafka.Message{
 			Key:   []byte(key),
 			Value: []byte(value),
@@ -39,16 +55,21 @@ func (k *KafkaHandler) WriteCves(cves []CveMsg) error {
 
 	}
 
+	k.inFlight.Add(1)
 	go k.enqueueMessages(msgs)
 
 	return nil
 }
 
 func (k *KafkaHandler) enqueueMessages(msgs []kafka.Message) {
+	defer k.inFlight.Done()
 
 	// Write the message to Kafka
 	if err := k.Writer.WriteMessages(context.Background(), msgs...); err != nil {
 		log.Printf("Failed to enqueue messages, error: %s\n", err)
+		k.mu.Lock()
+		k.failed = append(k.failed, err)
+		k.mu.Unlock()
 	} else {
 		for _, msg := range msgs {
 			log.Printf("Enqueued data for %s\n", msg.Key)
@@ -57,8 +78,21 @@ func (k *KafkaHandler) enqueueMessages(msgs []kafka.Message) {
 
 }
 
+// Flush waits for any batches still being enqueued, and returns the errors of those that failed since the last flush
+func (k *KafkaHandler) Flush() error {
+	k.inFlight.Wait()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	err := errors.Join(k.failed...)
+	k.failed = nil
+	return err
+}
+
+// Close flushes, so that one-shot imports don't exit before their final messages reach Kafka, then closes the
+// underlying writer
 func (k *KafkaHandler) Close() error {
-	return nil
+	return errors.Join(k.Flush(), k.Writer.Close())
 }
 
 func newKafkaHandler(kafkaServer string, kafkaTopic string) *KafkaHandler {
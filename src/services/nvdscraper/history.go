@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// the longest period the change history API will return changes for in one query
+const cveHistoryWindow = 120 * 24 * time.Hour
+
+// NvdCveHistoryScraper reads the NVD CVE Change History API, publishing every change event made to CVEs since a
+// given time. The API only accepts date ranges of up to 120 days, so we walk through the period in windows
+type NvdCveHistoryScraper struct {
+	NvdApiScraper
+	since time.Time
+}
+
+func (n *NvdCveHistoryScraper) FetchAll() error {
+
+	// with no start date, the API gives us the complete history in one go
+	if n.since.IsZero() {
+		return n.fetchChanges("https://services.nvd.nist.gov/rest/json/cvehistory/2.0")
+	}
+
+	now := time.Now().UTC()
+	for start := n.since; start.Before(now); start = start.Add(cveHistoryWindow) {
+		end := start.Add(cveHistoryWindow)
+		if end.After(now) {
+			end = now
+		}
+
+		log.Printf("Fetching CVE changes from %s to %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+
+		endpoint := fmt.Sprintf("https://services.nvd.nist.gov/rest/json/cvehistory/2.0?changeStartDate=%s&changeEndDate=%s",
+			url.QueryEscape(formatNvdDate(start)), url.QueryEscape(formatNvdDate(end)))
+		if err := n.fetchChanges(endpoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *NvdCveHistoryScraper) fetchChanges(endpoint string) error {
+
+	return n.fetchPages(endpoint, func(body []byte) (nvdPage, []KafkaMsg, error) {
+
+		var result CveHistoryResponse
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nvdPage{}, nil, err
+		}
+
+		changeMsgs := make([]KafkaMsg, 0, len(result.CveChanges))
+		for _, change := range result.CveChanges {
+			changeMsg, err := NewCveChangeMsg(change.Change, result.Timestamp)
+			if err != nil {
+				log.Printf("Error generating CveChangeMsg instance for data %s: %s", change.Change.CveChangeID, err)
+				continue
+			}
+			changeMsgs = append(changeMsgs, changeMsg)
+		}
+
+		return nvdPage{result.ResultsPerPage, result.TotalResults}, changeMsgs, nil
+	})
+
+}
+
+// NewNvdCveHistoryScraper creates a scraper for the changes made since the given date (in YYYY-MM-DD format), or
+// for the complete history if it's empty
+func NewNvdCveHistoryScraper(handler CveHandler, key string, since string) (*NvdCveHistoryScraper, error) {
+
+	scraper := &NvdCveHistoryScraper{NvdApiScraper: *NewNvdApiScraper(handler, key)}
+	scraper.batchSize = 5000 // the most the change history API allows
+
+	if since != "" {
+		var err error
+		if scraper.since, err = time.Parse("2006-01-02", since); err != nil {
+			return nil, fmt.Errorf("invalid start date for CVE history %s: %w", since, err)
+		}
+	}
+
+	return scraper, nil
+}
+
+// formatNvdDate formats a time the way the NVD APIs expect their date parameters
+func formatNvdDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000-07:00")
+}
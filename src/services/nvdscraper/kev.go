@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// KevScraper reads the CISA Known Exploited Vulnerabilities catalog, from either its published
+// URL or a local copy, and publishes an enrichment message for each CVE in it
+type KevScraper struct {
+	Handler   CveHandler
+	location  string
+	batchSize int
+}
+
+func (k *KevScraper) FetchAll() error {
+
+	body, err := openLocation(k.location)
+	if err != nil {
+		return fmt.Errorf("failed to open KEV catalog %s: %w", k.location, err)
+	}
+	defer body.Close()
+
+	var catalog KevCatalog
+	if err := json.NewDecoder(body).Decode(&catalog); err != nil {
+		return fmt.Errorf("failed to parse KEV catalog: %w", err)
+	}
+
+	log.Printf("Read KEV catalog version %s with %d entries", catalog.CatalogVersion, len(catalog.Vulnerabilities))
+
+	kevMsgs := make([]KafkaMsg, 0, k.batchSize)
+	for _, entry := range catalog.Vulnerabilities {
+		if entry.CveID == "" {
+			continue
+		}
+
+		kevMsg, err := NewKevMsg(entry, catalog.DateReleased)
+		if err != nil {
+			log.Printf("Error generating KevMsg instance for data %s: %s", entry.CveID, err)
+			continue
+		}
+		kevMsgs = append(kevMsgs, kevMsg)
+
+		if len(kevMsgs) == k.batchSize {
+			if err := k.Handler.WriteMsgs(kevMsgs); err != nil {
+				return err
+			}
+			kevMsgs = make([]KafkaMsg, 0, k.batchSize)
+		}
+	}
+
+	return k.Handler.WriteMsgs(kevMsgs)
+}
+
+func (k *KevScraper) StartPolling() error {
+
+	// TODO CISA updates the catalog a few times a week - re-running the import is cheap, as cvewriter
+	// just overwrites each CVE's KEV block, so a scheduled job is likely all we need here
+
+	return nil
+}
+
+func (k *KevScraper) Close() error {
+	k.Handler.Close()
+	return nil
+}
+
+func NewKevScraper(handler CveHandler, location string) *KevScraper {
+	return &KevScraper{
+		Handler:   handler,
+		location:  location,
+		batchSize: 2000,
+	}
+}
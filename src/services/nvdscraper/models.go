@@ -1,86 +1,209 @@
 package main
 
-// structure of the 'Cve' object used by the NVD API to describe individual CVEs
+import "strings"
+
+// structure of the 'Cve' object used by the NVD API to describe individual CVEs, per the NVD 2.0 CVE API schema
 type NvdCveData struct {
-	ID               string `json:"id"`
-	SourceIdentifier string `json:"sourceIdentifier"`
-	Published        string `json:"published"`
-	LastModified     string `json:"lastModified"`
-	VulnStatus       string `json:"vulnStatus"`
-	Descriptions     []struct {
-		Lang  string `json:"lang"`
-		Value string `json:"value"`
-	} `json:"descriptions"`
-	Metrics struct {
-		CvssMetricV31 []struct {
-			Source   string `json:"source"`
-			Type     string `json:"type"`
-			CvssData struct {
-				Version               string  `json:"version"`
-				VectorString          string  `json:"vectorString"`
-				AttackVector          string  `json:"attackVector"`
-				AttackComplexity      string  `json:"attackComplexity"`
-				PrivilegesRequired    string  `json:"privilegesRequired"`
-				UserInteraction       string  `json:"userInteraction"`
-				Scope                 string  `json:"scope"`
-				ConfidentialityImpact string  `json:"confidentialityImpact"`
-				IntegrityImpact       string  `json:"integrityImpact"`
-				AvailabilityImpact    string  `json:"availabilityImpact"`
-				BaseScore             float64 `json:"baseScore"`
-				BaseSeverity          string  `json:"baseSeverity"`
-			} `json:"cvssData"`
-			ExploitabilityScore float64 `json:"exploitabilityScore"`
-			ImpactScore         float64 `json:"impactScore"`
-		} `json:"cvssMetricV31"`
-		CvssMetricV2 []struct {
-			Source   string `json:"source"`
-			Type     string `json:"type"`
-			CvssData struct {
-				Version               string  `json:"version"`
-				VectorString          string  `json:"vectorString"`
-				AccessVector          string  `json:"accessVector"`
-				AccessComplexity      string  `json:"accessComplexity"`
-				Authentication        string  `json:"authentication"`
-				ConfidentialityImpact string  `json:"confidentialityImpact"`
-				IntegrityImpact       string  `json:"integrityImpact"`
-				AvailabilityImpact    string  `json:"availabilityImpact"`
-				BaseScore             float64 `json:"baseScore"`
-			} `json:"cvssData"`
-			BaseSeverity            string  `json:"baseSeverity"`
-			ExploitabilityScore     float64 `json:"exploitabilityScore"`
-			ImpactScore             float64 `json:"impactScore"`
-			AcInsufInfo             bool    `json:"acInsufInfo"`
-			ObtainAllPrivilege      bool    `json:"obtainAllPrivilege"`
-			ObtainUserPrivilege     bool    `json:"obtainUserPrivilege"`
-			ObtainOtherPrivilege    bool    `json:"obtainOtherPrivilege"`
-			UserInteractionRequired bool    `json:"userInteractionRequired"`
-		} `json:"cvssMetricV2"`
-	} `json:"metrics"`
-	Weaknesses []struct {
-		Source      string `json:"source"`
-		Type        string `json:"type"`
-		Description []struct {
-			Lang  string `json:"lang"`
-			Value string `json:"value"`
-		} `json:"description"`
-	} `json:"weaknesses"`
-	Configurations []struct {
-		Nodes []struct {
-			Operator string `json:"operator"`
-			Negate   bool   `json:"negate"`
-			CpeMatch []struct {
-				Vulnerable          bool   `json:"vulnerable"`
-				Criteria            string `json:"criteria"`
-				VersionEndIncluding string `json:"versionEndIncluding"`
-				MatchCriteriaID     string `json:"matchCriteriaId"`
-			} `json:"cpeMatch"`
-		} `json:"nodes"`
-	} `json:"configurations"`
-	References []struct {
-		URL    string   `json:"url"`
-		Source string   `json:"source"`
-		Tags   []string `json:"tags"`
-	} `json:"references"`
+	ID                    string          `json:"id"`
+	SourceIdentifier      string          `json:"sourceIdentifier"`
+	VulnStatus            string          `json:"vulnStatus"`
+	Published             string          `json:"published"`
+	LastModified          string          `json:"lastModified"`
+	EvaluatorComment      string          `json:"evaluatorComment"`
+	EvaluatorSolution     string          `json:"evaluatorSolution"`
+	EvaluatorImpact       string          `json:"evaluatorImpact"`
+	CisaExploitAdd        string          `json:"cisaExploitAdd"`
+	CisaActionDue         string          `json:"cisaActionDue"`
+	CisaRequiredAction    string          `json:"cisaRequiredAction"`
+	CisaVulnerabilityName string          `json:"cisaVulnerabilityName"`
+	CveTags               []CveTag        `json:"cveTags"`
+	Descriptions          []LangString    `json:"descriptions"`
+	References            []Reference     `json:"references"`
+	Metrics               Metrics         `json:"metrics"`
+	Weaknesses            []Weakness      `json:"weaknesses"`
+	Configurations        []Configuration `json:"configurations"`
+	VendorComments        []VendorComment `json:"vendorComments"`
+}
+
+// a piece of text in a given language
+type LangString struct {
+	Lang  string `json:"lang"`
+	Value string `json:"value"`
+}
+
+// tags a source has put on the CVE, e.g. disputed or unsupported-when-assigned
+type CveTag struct {
+	SourceIdentifier string   `json:"sourceIdentifier"`
+	Tags             []string `json:"tags"`
+}
+
+type Reference struct {
+	URL    string   `json:"url"`
+	Source string   `json:"source"`
+	Tags   []string `json:"tags"`
+}
+
+type Metrics struct {
+	CvssMetricV40 []CvssMetricV40 `json:"cvssMetricV40"`
+	CvssMetricV31 []CvssMetricV3  `json:"cvssMetricV31"`
+	CvssMetricV30 []CvssMetricV3  `json:"cvssMetricV30"`
+	CvssMetricV2  []CvssMetricV2  `json:"cvssMetricV2"`
+}
+
+type Weakness struct {
+	Source      string       `json:"source"`
+	Type        string       `json:"type"`
+	Description []LangString `json:"description"`
+}
+
+// a configuration describes the platforms that are affected, as a tree of nodes combining CPE matches
+type Configuration struct {
+	Operator string `json:"operator"`
+	Negate   bool   `json:"negate"`
+	Nodes    []Node `json:"nodes"`
+}
+
+type Node struct {
+	Operator string     `json:"operator"`
+	Negate   bool       `json:"negate"`
+	CpeMatch []CpeMatch `json:"cpeMatch"`
+}
+
+// a CPE match criteria, along with the range of versions it's affected in. Any of the bounds may be empty
+type CpeMatch struct {
+	Vulnerable            bool   `json:"vulnerable"`
+	Criteria              string `json:"criteria"`
+	MatchCriteriaID       string `json:"matchCriteriaId"`
+	VersionStartExcluding string `json:"versionStartExcluding"`
+	VersionStartIncluding string `json:"versionStartIncluding"`
+	VersionEndExcluding   string `json:"versionEndExcluding"`
+	VersionEndIncluding   string `json:"versionEndIncluding"`
+}
+
+// a statement from a vendor about the CVE
+type VendorComment struct {
+	Organization string `json:"organization"`
+	Comment      string `json:"comment"`
+	LastModified string `json:"lastModified"`
+}
+
+// a CVSS v4.0 score, as published by NVD or a CNA
+type CvssMetricV40 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                           string  `json:"version"`
+		VectorString                      string  `json:"vectorString"`
+		BaseScore                         float64 `json:"baseScore"`
+		BaseSeverity                      string  `json:"baseSeverity"`
+		AttackVector                      string  `json:"attackVector"`
+		AttackComplexity                  string  `json:"attackComplexity"`
+		AttackRequirements                string  `json:"attackRequirements"`
+		PrivilegesRequired                string  `json:"privilegesRequired"`
+		UserInteraction                   string  `json:"userInteraction"`
+		VulnConfidentialityImpact         string  `json:"vulnConfidentialityImpact"`
+		VulnIntegrityImpact               string  `json:"vulnIntegrityImpact"`
+		VulnAvailabilityImpact            string  `json:"vulnAvailabilityImpact"`
+		SubConfidentialityImpact          string  `json:"subConfidentialityImpact"`
+		SubIntegrityImpact                string  `json:"subIntegrityImpact"`
+		SubAvailabilityImpact             string  `json:"subAvailabilityImpact"`
+		ExploitMaturity                   string  `json:"exploitMaturity"`
+		ConfidentialityRequirement        string  `json:"confidentialityRequirement"`
+		IntegrityRequirement              string  `json:"integrityRequirement"`
+		AvailabilityRequirement           string  `json:"availabilityRequirement"`
+		ModifiedAttackVector              string  `json:"modifiedAttackVector"`
+		ModifiedAttackComplexity          string  `json:"modifiedAttackComplexity"`
+		ModifiedAttackRequirements        string  `json:"modifiedAttackRequirements"`
+		ModifiedPrivilegesRequired        string  `json:"modifiedPrivilegesRequired"`
+		ModifiedUserInteraction           string  `json:"modifiedUserInteraction"`
+		ModifiedVulnConfidentialityImpact string  `json:"modifiedVulnConfidentialityImpact"`
+		ModifiedVulnIntegrityImpact       string  `json:"modifiedVulnIntegrityImpact"`
+		ModifiedVulnAvailabilityImpact    string  `json:"modifiedVulnAvailabilityImpact"`
+		ModifiedSubConfidentialityImpact  string  `json:"modifiedSubConfidentialityImpact"`
+		ModifiedSubIntegrityImpact        string  `json:"modifiedSubIntegrityImpact"`
+		ModifiedSubAvailabilityImpact     string  `json:"modifiedSubAvailabilityImpact"`
+		Safety                            string  `json:"Safety"`
+		Automatable                       string  `json:"Automatable"`
+		Recovery                          string  `json:"Recovery"`
+		ValueDensity                      string  `json:"valueDensity"`
+		VulnerabilityResponseEffort       string  `json:"vulnerabilityResponseEffort"`
+		ProviderUrgency                   string  `json:"providerUrgency"`
+	} `json:"cvssData"`
+}
+
+// a CVSS v3.0 or v3.1 score - the two versions share their metrics
+type CvssMetricV3 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                       string  `json:"version"`
+		VectorString                  string  `json:"vectorString"`
+		AttackVector                  string  `json:"attackVector"`
+		AttackComplexity              string  `json:"attackComplexity"`
+		PrivilegesRequired            string  `json:"privilegesRequired"`
+		UserInteraction               string  `json:"userInteraction"`
+		Scope                         string  `json:"scope"`
+		ConfidentialityImpact         string  `json:"confidentialityImpact"`
+		IntegrityImpact               string  `json:"integrityImpact"`
+		AvailabilityImpact            string  `json:"availabilityImpact"`
+		BaseScore                     float64 `json:"baseScore"`
+		BaseSeverity                  string  `json:"baseSeverity"`
+		ExploitCodeMaturity           string  `json:"exploitCodeMaturity"`
+		RemediationLevel              string  `json:"remediationLevel"`
+		ReportConfidence              string  `json:"reportConfidence"`
+		TemporalScore                 float64 `json:"temporalScore"`
+		TemporalSeverity              string  `json:"temporalSeverity"`
+		ConfidentialityRequirement    string  `json:"confidentialityRequirement"`
+		IntegrityRequirement          string  `json:"integrityRequirement"`
+		AvailabilityRequirement       string  `json:"availabilityRequirement"`
+		ModifiedAttackVector          string  `json:"modifiedAttackVector"`
+		ModifiedAttackComplexity      string  `json:"modifiedAttackComplexity"`
+		ModifiedPrivilegesRequired    string  `json:"modifiedPrivilegesRequired"`
+		ModifiedUserInteraction       string  `json:"modifiedUserInteraction"`
+		ModifiedScope                 string  `json:"modifiedScope"`
+		ModifiedConfidentialityImpact string  `json:"modifiedConfidentialityImpact"`
+		ModifiedIntegrityImpact       string  `json:"modifiedIntegrityImpact"`
+		ModifiedAvailabilityImpact    string  `json:"modifiedAvailabilityImpact"`
+		EnvironmentalScore            float64 `json:"environmentalScore"`
+		EnvironmentalSeverity         string  `json:"environmentalSeverity"`
+	} `json:"cvssData"`
+	ExploitabilityScore float64 `json:"exploitabilityScore"`
+	ImpactScore         float64 `json:"impactScore"`
+}
+
+// a CVSS v2.0 score, which carries its severity and NVD's flags outside of the cvssData block
+type CvssMetricV2 struct {
+	Source   string `json:"source"`
+	Type     string `json:"type"`
+	CvssData struct {
+		Version                    string  `json:"version"`
+		VectorString               string  `json:"vectorString"`
+		AccessVector               string  `json:"accessVector"`
+		AccessComplexity           string  `json:"accessComplexity"`
+		Authentication             string  `json:"authentication"`
+		ConfidentialityImpact      string  `json:"confidentialityImpact"`
+		IntegrityImpact            string  `json:"integrityImpact"`
+		AvailabilityImpact         string  `json:"availabilityImpact"`
+		BaseScore                  float64 `json:"baseScore"`
+		Exploitability             string  `json:"exploitability"`
+		RemediationLevel           string  `json:"remediationLevel"`
+		ReportConfidence           string  `json:"reportConfidence"`
+		TemporalScore              float64 `json:"temporalScore"`
+		CollateralDamagePotential  string  `json:"collateralDamagePotential"`
+		TargetDistribution         string  `json:"targetDistribution"`
+		ConfidentialityRequirement string  `json:"confidentialityRequirement"`
+		IntegrityRequirement       string  `json:"integrityRequirement"`
+		AvailabilityRequirement    string  `json:"availabilityRequirement"`
+		EnvironmentalScore         float64 `json:"environmentalScore"`
+	} `json:"cvssData"`
+	BaseSeverity            string  `json:"baseSeverity"`
+	ExploitabilityScore     float64 `json:"exploitabilityScore"`
+	ImpactScore             float64 `json:"impactScore"`
+	AcInsufInfo             bool    `json:"acInsufInfo"`
+	ObtainAllPrivilege      bool    `json:"obtainAllPrivilege"`
+	ObtainUserPrivilege     bool    `json:"obtainUserPrivilege"`
+	ObtainOtherPrivilege    bool    `json:"obtainOtherPrivilege"`
+	UserInteractionRequired bool    `json:"userInteractionRequired"`
 }
 
 type Vulnerability struct {
@@ -113,3 +236,377 @@ func NewCveMsg(cve NvdCveData, timestamp string) (CveMsg, error) {
 	}
 	return msg, nil
 }
+
+func (c CveMsg) MsgKey() string {
+	return c.Cve.ID
+}
+
+// structure of an advisory in the OSV schema (https://ossf.github.io/osv-schema/)
+type OsvAdvisory struct {
+	SchemaVersion    string                 `json:"schema_version"`
+	ID               string                 `json:"id"`
+	Modified         string                 `json:"modified"`
+	Published        string                 `json:"published"`
+	Withdrawn        string                 `json:"withdrawn"`
+	Aliases          []string               `json:"aliases"`
+	Related          []string               `json:"related"`
+	Summary          string                 `json:"summary"`
+	Details          string                 `json:"details"`
+	Severity         []OsvSeverity          `json:"severity"`
+	Affected         []OsvAffected          `json:"affected"`
+	References       []OsvReference         `json:"references"`
+	Credits          []OsvCredit            `json:"credits"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+type OsvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type OsvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+		Purl      string `json:"purl"`
+	} `json:"package"`
+	Severity []OsvSeverity `json:"severity"`
+	Ranges   []struct {
+		Type   string `json:"type"`
+		Repo   string `json:"repo"`
+		Events []struct {
+			Introduced   string `json:"introduced,omitempty"`
+			Fixed        string `json:"fixed,omitempty"`
+			LastAffected string `json:"last_affected,omitempty"`
+			Limit        string `json:"limit,omitempty"`
+		} `json:"events"`
+	} `json:"ranges"`
+	Versions          []string               `json:"versions"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+}
+
+type OsvReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type OsvCredit struct {
+	Name    string   `json:"name"`
+	Contact []string `json:"contact"`
+	Type    string   `json:"type"`
+}
+
+// our own model to produce for Kafka for advisories from OSV-format sources
+type OsvMsg struct {
+	Timestamp string      `json:"timestamp"`
+	Source    string      `json:"source"`
+	Osv       OsvAdvisory `json:"osvdata"`
+}
+
+func NewOsvMsg(advisory OsvAdvisory, source string, timestamp string) (OsvMsg, error) {
+	msg := OsvMsg{
+		Timestamp: timestamp,
+		Source:    source,
+		Osv:       advisory,
+	}
+	return msg, nil
+}
+
+func (o OsvMsg) MsgKey() string {
+	return o.Osv.ID
+}
+
+// structure of an entry in the CISA Known Exploited Vulnerabilities catalog
+type KevEntry struct {
+	CveID                      string   `json:"cveID"`
+	VendorProject              string   `json:"vendorProject"`
+	Product                    string   `json:"product"`
+	VulnerabilityName          string   `json:"vulnerabilityName"`
+	DateAdded                  string   `json:"dateAdded"`
+	ShortDescription           string   `json:"shortDescription"`
+	RequiredAction             string   `json:"requiredAction"`
+	DueDate                    string   `json:"dueDate"`
+	KnownRansomwareCampaignUse string   `json:"knownRansomwareCampaignUse"`
+	Notes                      string   `json:"notes"`
+	Cwes                       []string `json:"cwes"`
+}
+
+// the KEV catalog document as published by CISA
+type KevCatalog struct {
+	Title           string     `json:"title"`
+	CatalogVersion  string     `json:"catalogVersion"`
+	DateReleased    string     `json:"dateReleased"`
+	Count           int        `json:"count"`
+	Vulnerabilities []KevEntry `json:"vulnerabilities"`
+}
+
+// our own model to produce for Kafka for KEV enrichment data
+type KevMsg struct {
+	Timestamp string   `json:"timestamp"`
+	Source    string   `json:"source"`
+	Kev       KevEntry `json:"kevdata"`
+}
+
+func NewKevMsg(entry KevEntry, timestamp string) (KevMsg, error) {
+	msg := KevMsg{
+		Timestamp: timestamp,
+		Source:    "KEV",
+		Kev:       entry,
+	}
+	return msg, nil
+}
+
+func (k KevMsg) MsgKey() string {
+	return k.Kev.CveID
+}
+
+// a single row of FIRST's daily EPSS scores, with the model and date from the file's header
+type EpssScore struct {
+	Cve          string  `json:"cve"`
+	Epss         float64 `json:"epss"`
+	Percentile   float64 `json:"percentile"`
+	Date         string  `json:"date"`
+	ModelVersion string  `json:"modelVersion"`
+}
+
+// our own model to produce for Kafka for EPSS score updates
+type EpssMsg struct {
+	Timestamp string    `json:"timestamp"`
+	Source    string    `json:"source"`
+	Epss      EpssScore `json:"epssdata"`
+}
+
+func NewEpssMsg(score EpssScore, timestamp string) (EpssMsg, error) {
+	msg := EpssMsg{
+		Timestamp: timestamp,
+		Source:    "EPSS",
+		Epss:      score,
+	}
+	return msg, nil
+}
+
+func (e EpssMsg) MsgKey() string {
+	return e.Epss.Cve
+}
+
+// a weakness or category from MITRE's CWE catalog, with its parents in the Research Concepts view
+type CweEntry struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Abstraction string   `json:"abstraction"`
+	Status      string   `json:"status"`
+	Parents     []string `json:"parents"`
+}
+
+// our own model to produce for Kafka for CWE catalog entries
+type CweMsg struct {
+	Timestamp string   `json:"timestamp"`
+	Source    string   `json:"source"`
+	Cwe       CweEntry `json:"cwedata"`
+}
+
+func NewCweMsg(entry CweEntry, timestamp string) (CweMsg, error) {
+	msg := CweMsg{
+		Timestamp: timestamp,
+		Source:    "CWE",
+		Cwe:       entry,
+	}
+	return msg, nil
+}
+
+func (c CweMsg) MsgKey() string {
+	return c.Cwe.ID
+}
+
+// structure of a product in the NVD CPE dictionary, as returned by the /cpes/2.0 API
+type CpeProduct struct {
+	CpeName      string `json:"cpeName"`
+	CpeNameID    string `json:"cpeNameId"`
+	Deprecated   bool   `json:"deprecated"`
+	LastModified string `json:"lastModified"`
+	Created      string `json:"created"`
+	Titles       []struct {
+		Title string `json:"title"`
+		Lang  string `json:"lang"`
+	} `json:"titles"`
+	Refs []struct {
+		Ref  string `json:"ref"`
+		Type string `json:"type"`
+	} `json:"refs"`
+	DeprecatedBy []CpeName `json:"deprecatedBy"`
+	Deprecates   []CpeName `json:"deprecates"`
+}
+
+type CpeName struct {
+	CpeName   string `json:"cpeName"`
+	CpeNameID string `json:"cpeNameId"`
+}
+
+type CpeResponse struct {
+	ResultsPerPage int    `json:"resultsPerPage"`
+	StartIndex     int    `json:"startIndex"`
+	TotalResults   int    `json:"totalResults"`
+	Format         string `json:"format"`
+	Version        string `json:"version"`
+	Timestamp      string `json:"timestamp"`
+	Products       []struct {
+		Cpe CpeProduct `json:"cpe"`
+	} `json:"products"`
+}
+
+type CpeMsg struct {
+	Timestamp string     `json:"timestamp"`
+	Source    string     `json:"source"`
+	Cpe       CpeProduct `json:"cpedata"`
+}
+
+func NewCpeMsg(cpe CpeProduct, timestamp string) (CpeMsg, error) {
+	msg := CpeMsg{
+		Timestamp: timestamp,
+		Source:    "NVD",
+		Cpe:       cpe,
+	}
+	return msg, nil
+}
+
+func (c CpeMsg) MsgKey() string {
+	return c.Cpe.CpeNameID
+}
+
+// structure of a match string from the /cpematch/2.0 API: the criteria a CVE's CpeMatch refers to by its
+// MatchCriteriaID, expanded into the concrete CPE names that it matches
+type CpeMatchString struct {
+	MatchCriteriaID       string    `json:"matchCriteriaId"`
+	Criteria              string    `json:"criteria"`
+	VersionStartExcluding string    `json:"versionStartExcluding"`
+	VersionStartIncluding string    `json:"versionStartIncluding"`
+	VersionEndExcluding   string    `json:"versionEndExcluding"`
+	VersionEndIncluding   string    `json:"versionEndIncluding"`
+	LastModified          string    `json:"lastModified"`
+	CpeLastModified       string    `json:"cpeLastModified"`
+	Created               string    `json:"created"`
+	Status                string    `json:"status"`
+	Matches               []CpeName `json:"matches"`
+}
+
+type CpeMatchResponse struct {
+	ResultsPerPage int    `json:"resultsPerPage"`
+	StartIndex     int    `json:"startIndex"`
+	TotalResults   int    `json:"totalResults"`
+	Format         string `json:"format"`
+	Version        string `json:"version"`
+	Timestamp      string `json:"timestamp"`
+	MatchStrings   []struct {
+		MatchString CpeMatchString `json:"matchString"`
+	} `json:"matchStrings"`
+}
+
+type CpeMatchMsg struct {
+	Timestamp string         `json:"timestamp"`
+	Source    string         `json:"source"`
+	Match     CpeMatchString `json:"matchdata"`
+}
+
+func NewCpeMatchMsg(match CpeMatchString, timestamp string) (CpeMatchMsg, error) {
+	msg := CpeMatchMsg{
+		Timestamp: timestamp,
+		Source:    "NVD",
+		Match:     match,
+	}
+	return msg, nil
+}
+
+func (c CpeMatchMsg) MsgKey() string {
+	return c.Match.MatchCriteriaID
+}
+
+// structure of a change event from the /cvehistory/2.0 API - a single edit to a CVE by NVD or a CNA
+type CveChange struct {
+	CveID            string `json:"cveId"`
+	EventName        string `json:"eventName"`
+	CveChangeID      string `json:"cveChangeId"`
+	SourceIdentifier string `json:"sourceIdentifier"`
+	Created          string `json:"created"`
+	Details          []struct {
+		Action   string `json:"action"`
+		Type     string `json:"type"`
+		OldValue string `json:"oldValue"`
+		NewValue string `json:"newValue"`
+	} `json:"details"`
+}
+
+type CveHistoryResponse struct {
+	ResultsPerPage int    `json:"resultsPerPage"`
+	StartIndex     int    `json:"startIndex"`
+	TotalResults   int    `json:"totalResults"`
+	Format         string `json:"format"`
+	Version        string `json:"version"`
+	Timestamp      string `json:"timestamp"`
+	CveChanges     []struct {
+		Change CveChange `json:"change"`
+	} `json:"cveChanges"`
+}
+
+type CveChangeMsg struct {
+	Timestamp string    `json:"timestamp"`
+	Source    string    `json:"source"`
+	Change    CveChange `json:"changedata"`
+}
+
+func NewCveChangeMsg(change CveChange, timestamp string) (CveChangeMsg, error) {
+	msg := CveChangeMsg{
+		Timestamp: timestamp,
+		Source:    "NVD",
+		Change:    change,
+	}
+	return msg, nil
+}
+
+// changes are keyed by their CVE, so that those to the same CVE stay in order
+func (c CveChangeMsg) MsgKey() string {
+	return c.Change.CveID
+}
+
+// a distribution's assessment of a CVE for one of its packages in one of its releases, normalized across
+// the Debian, Red Hat and Ubuntu trackers
+type DistroStatus struct {
+	Cve          string `json:"cve"`
+	Distro       string `json:"distro"`  // debian, redhat or ubuntu
+	Release      string `json:"release"` // e.g. bookworm, Red Hat Enterprise Linux 9, jammy
+	Package      string `json:"package"`
+	Status       string `json:"status"` // one of the distroStatus* constants
+	FixedVersion string `json:"fixedVersion"`
+	Severity     string `json:"severity"` // the distro's own rating, in its own terms
+	Notes        string `json:"notes"`
+}
+
+const (
+	distroStatusFixed              = "fixed"
+	distroStatusAffected           = "affected"
+	distroStatusNotAffected        = "not-affected"
+	distroStatusWillNotFix         = "will-not-fix"
+	distroStatusDeferred           = "deferred"
+	distroStatusUnderInvestigation = "under-investigation"
+)
+
+type DistroMsg struct {
+	Timestamp string       `json:"timestamp"`
+	Source    string       `json:"source"`
+	Status    DistroStatus `json:"distrodata"`
+}
+
+func NewDistroMsg(status DistroStatus, timestamp string) (DistroMsg, error) {
+	msg := DistroMsg{
+		Timestamp: timestamp,
+		Source:    strings.ToUpper(status.Distro),
+		Status:    status,
+	}
+	return msg, nil
+}
+
+func (d DistroMsg) MsgKey() string {
+	return d.Status.Cve
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The fixtures in testdata are NVD 2.0 API responses, trimmed down to a few records that between them use
+// every part of the CVE schema. If NVD adds or renames a field, decoding them strictly should tell us.
+func TestResponse_Conforms_To_Nvd_Schema(t *testing.T) {
+
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "cves_*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no NVD response fixtures found")
+	}
+
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every field in the response must be one we model...
+		var resp Response
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&resp); err != nil {
+			t.Errorf("%s: response doesn't match our model: %s", fixture, err)
+			continue
+		}
+
+		// ...and survive being passed on to Kafka with its value intact
+		var original, roundTripped interface{}
+		if err := json.Unmarshal(data, &original); err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(encoded, &roundTripped); err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range missingFrom(roundTripped, original, "$") {
+			t.Errorf("%s: %s", fixture, problem)
+		}
+	}
+
+}
+
+func TestResponse_Keeps_Cpe_Version_Ranges(t *testing.T) {
+
+	data, err := os.ReadFile(filepath.Join("testdata", "cves_log4shell.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	match := resp.Vulnerabilities[0].Cve.Configurations[0].Nodes[0].CpeMatch[0]
+	if match.VersionStartIncluding != "2.0.1" || match.VersionEndExcluding != "2.3.1" {
+		t.Errorf("expected version range [2.0.1, 2.3.1), got %+v", match)
+	}
+
+	cve := resp.Vulnerabilities[0].Cve
+	if cve.CisaExploitAdd != "2021-12-10" || cve.CisaActionDue != "2021-12-24" {
+		t.Errorf("expected CISA KEV dates, got %q and %q", cve.CisaExploitAdd, cve.CisaActionDue)
+	}
+
+}
+
+// missingFrom lists the values in expected that aren't present, or differ, in actual. Anything actual has
+// in addition (e.g. the zero values of fields the fixture doesn't use) is fine
+func missingFrom(actual, expected interface{}, path string) []string {
+
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return []string{path + " is not an object"}
+		}
+		problems := []string{}
+		for key, value := range e {
+			problems = append(problems, missingFrom(a[key], value, path+"."+key)...)
+		}
+		return problems
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(e) {
+			return []string{path + " has lost elements"}
+		}
+		problems := []string{}
+		for i := range e {
+			problems = append(problems, missingFrom(a[i], e[i], fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	default:
+		if !reflect.DeepEqual(actual, expected) {
+			return []string{path + " was not preserved"}
+		}
+		return nil
+	}
+
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OsvScraper reads OSV advisories from a local directory holding the OSV bulk exports
+// (e.g. Go/all.zip, PyPI/all.zip, npm/all.zip) and/or loose OSV JSON files
+type OsvScraper struct {
+	Handler   CveHandler
+	source    string
+	dataDir   string
+	batchSize int
+	pending   []KafkaMsg
+}
+
+func (o *OsvScraper) FetchAll() error {
+
+	timestamp := timestampNow()
+
+	err := filepath.WalkDir(o.dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".zip":
+			return o.readZip(path, timestamp)
+		case ".json":
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return o.readAdvisory(f, path, timestamp)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read OSV data from %s: %w", o.dataDir, err)
+	}
+
+	return o.flush()
+}
+
+func (o *OsvScraper) StartPolling() error {
+
+	// TODO the bulk exports are refreshed upstream continuously - once something keeps the data directory
+	// in sync we could re-read it periodically, only publishing advisories whose 'modified' date has moved on
+
+	return nil
+}
+
+func (o *OsvScraper) Close() error {
+	o.Handler.Close()
+	return nil
+}
+
+func NewOsvScraper(handler CveHandler, dataDir string) *OsvScraper {
+	return &OsvScraper{
+		Handler:   handler,
+		source:    "OSV",
+		dataDir:   dataDir,
+		batchSize: 2000,
+	}
+}
+
+func (o *OsvScraper) readZip(path string, timestamp string) error {
+
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	log.Printf("Reading OSV advisories from %s", path)
+
+	for _, file := range archive.File {
+		if !strings.HasSuffix(strings.ToLower(file.Name), ".json") {
+			continue
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = o.readAdvisory(f, path+"/"+file.Name, timestamp)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readAdvisory deserializes a single OSV JSON document and queues it for publishing. Malformed
+// documents are logged and skipped rather than aborting the whole import
+func (o *OsvScraper) readAdvisory(r io.Reader, name string, timestamp string) error {
+
+	var advisory OsvAdvisory
+	if err := json.NewDecoder(r).Decode(&advisory); err != nil {
+		log.Printf("Failed to parse OSV advisory %s: %s", name, err)
+		return nil
+	}
+
+	if advisory.ID == "" {
+		log.Printf("Skipping OSV advisory %s with no ID", name)
+		return nil
+	}
+
+	msg, err := NewOsvMsg(advisory, o.source, timestamp)
+	if err != nil {
+		log.Printf("Error generating OsvMsg instance for data %s: %s", advisory.ID, err)
+		return nil
+	}
+
+	o.pending = append(o.pending, msg)
+	if len(o.pending) >= o.batchSize {
+		return o.flush()
+	}
+
+	return nil
+}
+
+func (o *OsvScraper) flush() error {
+
+	if len(o.pending) == 0 {
+		return nil
+	}
+
+	if err := o.Handler.WriteMsgs(o.pending); err != nil {
+		return err
+	}
+
+	log.Printf("Batch of %d %s advisories complete", len(o.pending), o.source)
+	o.pending = nil
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RedHatScraper reads Red Hat's CSAF VEX documents - one per CVE, describing its status in every affected
+// Red Hat product. It takes either a directory holding the extracted bulk archive or a single document's location
+type RedHatScraper struct {
+	Handler  CveHandler
+	location string
+}
+
+// the parts of a CSAF VEX document we use, per https://docs.oasis-open.org/csaf/csaf/v2.0/csaf-v2.0.html
+type csafDocument struct {
+	Document struct {
+		AggregateSeverity struct {
+			Text string `json:"text"`
+		} `json:"aggregate_severity"`
+	} `json:"document"`
+	ProductTree struct {
+		Branches      []csafBranch `json:"branches"`
+		Relationships []struct {
+			FullProductName struct {
+				ProductID string `json:"product_id"`
+			} `json:"full_product_name"`
+			ProductReference          string `json:"product_reference"`
+			RelatesToProductReference string `json:"relates_to_product_reference"`
+		} `json:"relationships"`
+	} `json:"product_tree"`
+	Vulnerabilities []struct {
+		Cve           string `json:"cve"`
+		ProductStatus struct {
+			Fixed              []string `json:"fixed"`
+			KnownAffected      []string `json:"known_affected"`
+			KnownNotAffected   []string `json:"known_not_affected"`
+			UnderInvestigation []string `json:"under_investigation"`
+		} `json:"product_status"`
+		Remediations []struct {
+			Category   string   `json:"category"`
+			Details    string   `json:"details"`
+			ProductIDs []string `json:"product_ids"`
+		} `json:"remediations"`
+	} `json:"vulnerabilities"`
+}
+
+type csafBranch struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Product  *struct {
+		Name      string `json:"name"`
+		ProductID string `json:"product_id"`
+	} `json:"product"`
+	Branches []csafBranch `json:"branches"`
+}
+
+func (r *RedHatScraper) FetchAll() error {
+
+	publisher := newDistroPublisher(r.Handler, "redhat")
+
+	info, err := os.Stat(r.location)
+	if err != nil || !info.IsDir() {
+		// a single document, possibly at a URL
+		body, err := openLocation(r.location)
+		if err != nil {
+			return fmt.Errorf("failed to open Red Hat CSAF document %s: %w", r.location, err)
+		}
+		defer body.Close()
+
+		if err := readCsafDocument(body, r.location, publisher.publish); err != nil {
+			return err
+		}
+		return publisher.flush()
+	}
+
+	err = filepath.WalkDir(r.location, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(path), ".json") {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return readCsafDocument(f, path, publisher.publish)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read Red Hat CSAF documents from %s: %w", r.location, err)
+	}
+
+	return publisher.flush()
+}
+
+// readCsafDocument publishes the status of each package in each product the document covers. Malformed
+// documents are logged and skipped rather than aborting the whole import
+func readCsafDocument(r io.Reader, name string, publish func(DistroStatus) error) error {
+
+	var doc csafDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		log.Printf("Failed to parse CSAF document %s: %s", name, err)
+		return nil
+	}
+
+	// product IDs in the statuses are usually composites of a product and one of its components, which
+	// the relationships break down for us
+	productNames := map[string]string{}
+	collectCsafProductNames(doc.ProductTree.Branches, productNames)
+
+	type composite struct{ product, component string }
+	composites := map[string]composite{}
+	for _, rel := range doc.ProductTree.Relationships {
+		composites[rel.FullProductName.ProductID] = composite{rel.RelatesToProductReference, rel.ProductReference}
+	}
+
+	for _, vuln := range doc.Vulnerabilities {
+		if vuln.Cve == "" {
+			continue
+		}
+
+		// remediations refine the status of the products they list
+		remediation := map[string]string{}
+		for _, rem := range vuln.Remediations {
+			for _, id := range rem.ProductIDs {
+				switch {
+				case rem.Category == "no_fix_planned":
+					remediation[id] = distroStatusWillNotFix
+				case rem.Category == "none_available" && strings.Contains(strings.ToLower(rem.Details), "deferred"):
+					remediation[id] = distroStatusDeferred
+				}
+			}
+		}
+
+		seen := map[string]bool{}
+		emit := func(ids []string, status string) error {
+			for _, id := range ids {
+				c, ok := composites[id]
+				if !ok {
+					c = composite{component: id}
+				}
+
+				pkg, version := parseNevra(c.component)
+				release := productNames[c.product]
+				if release == "" {
+					release = c.product
+				}
+
+				// packages are listed once per architecture, but we only need them once
+				key := release + "/" + pkg
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				s := DistroStatus{
+					Cve:      vuln.Cve,
+					Release:  release,
+					Package:  pkg,
+					Status:   status,
+					Severity: doc.Document.AggregateSeverity.Text,
+				}
+				if status == distroStatusFixed {
+					s.FixedVersion = version
+				}
+				if refined, ok := remediation[id]; ok && status == distroStatusAffected {
+					s.Status = refined
+				}
+
+				if err := publish(s); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		for _, group := range []struct {
+			ids    []string
+			status string
+		}{
+			{vuln.ProductStatus.Fixed, distroStatusFixed},
+			{vuln.ProductStatus.KnownAffected, distroStatusAffected},
+			{vuln.ProductStatus.KnownNotAffected, distroStatusNotAffected},
+			{vuln.ProductStatus.UnderInvestigation, distroStatusUnderInvestigation},
+		} {
+			if err := emit(group.ids, group.status); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func collectCsafProductNames(branches []csafBranch, names map[string]string) {
+	for _, branch := range branches {
+		if branch.Product != nil {
+			names[branch.Product.ProductID] = branch.Product.Name
+		}
+		collectCsafProductNames(branch.Branches, names)
+	}
+}
+
+// the architectures RPM file names can end with
+var rpmArches = map[string]bool{
+	"src": true, "noarch": true, "x86_64": true, "aarch64": true, "ppc64le": true, "s390x": true, "i686": true,
+}
+
+// parseNevra splits an RPM's name-[epoch:]version-release.arch into its name and [epoch:]version-release. Components
+// that are only named, as affected ones are, come back with no version
+func parseNevra(component string) (string, string) {
+
+	// only a versioned RPM has an architecture, and checking for it saves us from mistaking names with
+	// numbers in for versions, like java-1.8.0-openjdk
+	i := strings.LastIndex(component, ".")
+	if i < 0 || !rpmArches[component[i+1:]] {
+		return component, ""
+	}
+
+	parts := strings.Split(component[:i], "-")
+	if len(parts) < 3 {
+		return component, ""
+	}
+
+	name := strings.Join(parts[:len(parts)-2], "-")
+	return name, parts[len(parts)-2] + "-" + parts[len(parts)-1]
+}
+
+func (r *RedHatScraper) StartPolling() error {
+
+	// TODO Red Hat publishes a changes.csv alongside the documents listing when each was last updated, which
+	// we could poll to only re-read the documents that have changed
+
+	return nil
+}
+
+func (r *RedHatScraper) Close() error {
+	r.Handler.Close()
+	return nil
+}
+
+func NewRedHatScraper(handler CveHandler, location string) *RedHatScraper {
+	return &RedHatScraper{
+		Handler:  handler,
+		location: location,
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -25,49 +26,76 @@ type NvdApiScraper struct {
 
 func (n *NvdApiScraper) FetchAll() error {
 
+	return n.fetchPages("https://services.nvd.nist.gov/rest/json/cves/2.0", func(body []byte) (nvdPage, []KafkaMsg, error) {
+
+		// Pull the response body out and deserialize it into a Response obj
+		var result Response
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nvdPage{}, nil, err
+		}
+
+		cveMsgs := make([]KafkaMsg, 0, len(result.Vulnerabilities))
+		for _, vulnerability := range result.Vulnerabilities {
+			cve := vulnerability.Cve
+			cveMsg, err := NewCveMsg(cve, result.Timestamp)
+			if err != nil {
+				log.Printf("Error generating CveMsg instance for data %s: %s", cve.ID, err)
+				continue
+			}
+			cveMsgs = append(cveMsgs, cveMsg)
+		}
+
+		return nvdPage{result.ResultsPerPage, result.TotalResults}, cveMsgs, nil
+	})
+
+}
+
+// the paging details shared by all of the NVD APIs' responses
+type nvdPage struct {
+	ResultsPerPage int
+	TotalResults   int
+}
+
+// fetchPages walks through every page of one of the NVD APIs, parsing each into messages and sending them to kafka
+func (n *NvdApiScraper) fetchPages(endpoint string, parse func(body []byte) (nvdPage, []KafkaMsg, error)) error {
+
+	// the endpoint may come with query parameters of its own
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+
 	startIndex := 0
 	totalResults := 0
 
 	for startIndex <= totalResults {
-		resp, err := n.sendHTTPGetRequest(fmt.Sprintf("https://services.nvd.nist.gov/rest/json/cves/2.0?startIndex=%d&resultsPerPage=%d", startIndex, n.batchSize), n.maxHTTPRetries)
+		resp, err := n.sendHTTPGetRequest(fmt.Sprintf("%s%sstartIndex=%d&resultsPerPage=%d", endpoint, separator, startIndex, n.batchSize), n.maxHTTPRetries)
 		if err != nil {
 			log.Fatalf("HTTP request failed: %s", err)
 		}
-		defer resp.Body.Close()
 
 		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
 			log.Fatalf("Failed to read body from HTTP response: %s", err)
 		}
 
-		// Pull the response body out and deserialize it into a Response obj
-		var result Response
-		if err := json.Unmarshal(body, &result); err != nil {
+		page, msgs, err := parse(body)
+		if err != nil {
 			log.Fatalf("Failed to parse response body into JSON: %s", err)
 		}
 
-		// Send each of the CVE data elements to kafka
-		cveMsgs := make([]CveMsg, len(result.Vulnerabilities))
-		for i, vulnerability := range result.Vulnerabilities {
-			cve := vulnerability.Cve
-			cveMsg, err := NewCveMsg(cve, result.Timestamp)
-			if err != nil {
-				log.Printf("Error generating CveMsg instance for data %s: %s", cve.ID, err)
-				continue
-			}
-			cveMsgs[i] = cveMsg
-		}
-
-		err = n.Handler.WriteCves(cveMsgs)
+		// Send each of the data elements to kafka
+		err = n.Handler.WriteMsgs(msgs)
 		if err != nil {
-			fmt.Printf("Error writing CVE data: %s\n", err)
+			fmt.Printf("Error writing data from %s: %s\n", endpoint, err)
 			continue
 		}
 
 		log.Printf("Batch with startIndex %d complete", startIndex)
 
-		totalResults = result.TotalResults
-		startIndex += result.ResultsPerPage
+		totalResults = page.TotalResults
+		startIndex += page.ResultsPerPage
 
 		time.Sleep(10 * time.Second) // wait to avoid hitting NVD API limits
 	}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// UbuntuScraper reads Ubuntu's CVE statuses from the OVAL feeds Canonical publishes for each release
+// (https://ubuntu.com/security/oval), fetched from their URLs or read from files. Alternatively it reads a checkout
+// of the Ubuntu CVE Tracker (https://git.launchpad.net/ubuntu-cve-tracker), whose active and retired directories hold
+// a file per CVE listing its status in each Ubuntu release
+type UbuntuScraper struct {
+	Handler       CveHandler
+	ovalLocations []string
+	dataDir       string // a tracker checkout, read in place of the OVAL feeds if set
+	pollInterval  time.Duration
+}
+
+func (u *UbuntuScraper) FetchAll() error {
+
+	publisher := newDistroPublisher(u.Handler, "ubuntu")
+
+	if u.dataDir != "" {
+		if err := u.readTracker(publisher.publish); err != nil {
+			return err
+		}
+		return publisher.flush()
+	}
+
+	for _, location := range u.ovalLocations {
+		if err := readUbuntuOvalLocation(location, publisher.publish); err != nil {
+			return err
+		}
+	}
+
+	return publisher.flush()
+}
+
+// readUbuntuOvalLocation reads one release's OVAL feed, which Canonical publishes bzip2 compressed
+func readUbuntuOvalLocation(location string, publish func(DistroStatus) error) error {
+
+	body, err := openLocation(location)
+	if err != nil {
+		return fmt.Errorf("failed to open Ubuntu OVAL feed %s: %w", location, err)
+	}
+	defer body.Close()
+
+	r, err := decompressIfBzipped(bufio.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to read Ubuntu OVAL feed %s: %w", location, err)
+	}
+	if err := readUbuntuOval(r, publish); err != nil {
+		return fmt.Errorf("failed to parse Ubuntu OVAL feed %s: %w", location, err)
+	}
+
+	return nil
+}
+
+// decompressIfBzipped sniffs the bzip2 magic number, so that uncompressed feeds can be read too
+func decompressIfBzipped(r *bufio.Reader) (io.Reader, error) {
+	magic, err := r.Peek(3)
+	if err != nil {
+		return nil, err
+	}
+	if string(magic) == "BZh" {
+		return bzip2.NewReader(r), nil
+	}
+	return r, nil
+}
+
+// the parts of an OVAL vulnerability definition we use. Ubuntu's CVE feeds have a definition per CVE, with a
+// criterion per package whose comment spells out the package's status in the feed's release
+type ovalDefinition struct {
+	Class    string `xml:"class,attr"`
+	Metadata struct {
+		References []struct {
+			Source string `xml:"source,attr"`
+			RefID  string `xml:"ref_id,attr"`
+		} `xml:"reference"`
+		Advisory struct {
+			Severity string `xml:"severity"`
+		} `xml:"advisory"`
+	} `xml:"metadata"`
+	Criteria ovalCriteria `xml:"criteria"`
+}
+
+type ovalCriteria struct {
+	Criteria  []ovalCriteria `xml:"criteria"`
+	Criterion []struct {
+		Comment string `xml:"comment,attr"`
+	} `xml:"criterion"`
+}
+
+// comments gathers up the comments of every criterion, however deeply nested
+func (c ovalCriteria) comments() []string {
+	comments := []string{}
+	for _, criterion := range c.Criterion {
+		comments = append(comments, criterion.Comment)
+	}
+	for _, nested := range c.Criteria {
+		comments = append(comments, nested.comments()...)
+	}
+	return comments
+}
+
+// readUbuntuOval streams through a feed a definition at a time, as each release's feed is hundreds of megabytes
+func readUbuntuOval(r io.Reader, publish func(DistroStatus) error) error {
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "definition" {
+			continue
+		}
+
+		var def ovalDefinition
+		if err := decoder.DecodeElement(&def, &start); err != nil {
+			return err
+		}
+		if def.Class != "vulnerability" {
+			continue
+		}
+
+		id := ""
+		for _, ref := range def.Metadata.References {
+			if ref.Source == "CVE" {
+				id = ref.RefID
+			}
+		}
+		if !strings.HasPrefix(id, "CVE-") {
+			continue
+		}
+
+		for _, comment := range def.Criteria.comments() {
+			status, ok := newUbuntuOvalStatus(comment)
+			if !ok {
+				continue
+			}
+			status.Cve = id
+			status.Severity = strings.ToLower(def.Metadata.Advisory.Severity)
+			if err := publish(status); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// e.g. "openssl package in jammy, is related to the CVE in some way and has been fixed (note: '3.0.2-0ubuntu1.10')."
+var ubuntuOvalComment = regexp.MustCompile(`^(\S+) package in (\S+?),? (.+?)(?: \(note: '([^']*)'\))?\.?$`)
+
+// newUbuntuOvalStatus maps a criterion's comment to a package's status. The feeds don't have the tracker's
+// statuses as such, only the comments written from them, so we go on the wording each status gets
+func newUbuntuOvalStatus(comment string) (DistroStatus, bool) {
+
+	m := ubuntuOvalComment.FindStringSubmatch(comment)
+	if m == nil {
+		return DistroStatus{}, false
+	}
+
+	status := DistroStatus{Package: m[1], Release: m[2], Notes: m[4]}
+	description := m[3]
+
+	switch {
+	case strings.Contains(description, "has been fixed"):
+		status.Status = distroStatusFixed
+		status.FixedVersion = m[4]
+		status.Notes = ""
+	case strings.Contains(description, "not affected"):
+		status.Status = distroStatusNotAffected
+	case strings.Contains(description, "defer"):
+		status.Status = distroStatusDeferred
+	case strings.Contains(description, "ignore"):
+		status.Status = distroStatusWillNotFix
+	case strings.Contains(description, "may need fixing"):
+		status.Status = distroStatusUnderInvestigation
+	case strings.Contains(description, "is affected"):
+		status.Status = distroStatusAffected
+	default:
+		return DistroStatus{}, false
+	}
+
+	return status, true
+}
+
+// readTracker reads every CVE's file in a tracker checkout
+func (u *UbuntuScraper) readTracker(publish func(DistroStatus) error) error {
+
+	err := filepath.WalkDir(u.dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), "CVE-") {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return readUbuntuCve(f, d.Name(), publish)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read the Ubuntu CVE tracker from %s: %w", u.dataDir, err)
+	}
+
+	return nil
+}
+
+// readUbuntuCve parses a tracker file. Alongside its header fields, it has a line per package per release
+// of the form 'jammy_openssl: released (3.0.2-0ubuntu1.10)'
+func readUbuntuCve(r io.Reader, name string, publish func(DistroStatus) error) error {
+
+	id := name
+	priority := ""
+	packagePriorities := map[string]string{}
+	statuses := []DistroStatus{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // descriptions and notes can make for long lines
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// continuation lines of multi-line fields, and comments
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case key == "Candidate":
+			id = value
+		case key == "Priority":
+			priority = value
+		case strings.HasPrefix(key, "Priority_"):
+			packagePriorities[strings.TrimPrefix(key, "Priority_")] = value
+		case strings.HasPrefix(key, "Patches_"), strings.HasPrefix(key, "Tags_"):
+		case strings.Contains(key, "_"):
+			release, pkg, _ := strings.Cut(key, "_")
+			if status, ok := newUbuntuStatus(release, pkg, value); ok {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		status.Cve = id
+		status.Severity = priority
+		if p, ok := packagePriorities[status.Package]; ok {
+			status.Severity = p
+		}
+		if err := publish(status); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newUbuntuStatus maps a tracker status, e.g. 'released (3.0.2-0ubuntu1.10)', to ours. Upstream and the
+// development release aren't releases anyone runs, and packages that don't exist in a release are skipped
+func newUbuntuStatus(release string, pkg string, value string) (DistroStatus, bool) {
+
+	if release == "upstream" || release == "devel" {
+		return DistroStatus{}, false
+	}
+
+	state, detail, _ := strings.Cut(value, " ")
+	detail = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(detail), "("), ")")
+
+	status := DistroStatus{
+		Release: release,
+		Package: pkg,
+		Notes:   detail,
+	}
+
+	switch state {
+	case "released":
+		status.Status = distroStatusFixed
+		status.FixedVersion = detail
+		status.Notes = ""
+	case "not-affected":
+		status.Status = distroStatusNotAffected
+	case "needed", "pending", "active":
+		status.Status = distroStatusAffected
+	case "needs-triage":
+		status.Status = distroStatusUnderInvestigation
+	case "deferred":
+		status.Status = distroStatusDeferred
+	case "ignored":
+		status.Status = distroStatusWillNotFix
+	default: // DNE
+		return DistroStatus{}, false
+	}
+
+	return status, true
+}
+
+// StartPolling re-imports the feeds every so often, or re-reads the tracker checkout for whatever keeps it up to date
+// to have pulled in
+func (u *UbuntuScraper) StartPolling() error {
+	return pollDistro("ubuntu", u.pollInterval, u.FetchAll)
+}
+
+func (u *UbuntuScraper) Close() error {
+	u.Handler.Close()
+	return nil
+}
+
+func NewUbuntuScraper(handler CveHandler, ovalLocations []string, dataDir string, pollInterval time.Duration) *UbuntuScraper {
+	return &UbuntuScraper{
+		Handler:       handler,
+		ovalLocations: ovalLocations,
+		dataDir:       dataDir,
+		pollInterval:  pollInterval,
+	}
+}
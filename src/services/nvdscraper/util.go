@@ -1,7 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 // readFromENV retrieves the value of the environment variable specified by the key.
@@ -12,3 +17,28 @@ func readFromENV(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+// timestampNow returns the current UTC time in the same format the NVD API uses for its
+// response timestamps, for sources that don't provide one of their own.
+func timestampNow() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.000")
+}
+
+// openLocation opens a data file for reading, from either a local path or an http(s) URL,
+// so that file-based sources can be pointed at the published feed or a downloaded copy.
+func openLocation(location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.Open(location)
+	}
+
+	resp, err := http.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response from %s, response code %d", location, resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,3 @@
+module melaka/shared
+
+go 1.18
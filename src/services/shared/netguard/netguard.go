@@ -0,0 +1,92 @@
+// Package netguard keeps requests we make to addresses users give us, like webhook URLs, away from our own network
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ErrNotPublic is returned for an address that isn't on the public internet
+var ErrNotPublic = errors.New("address is not public")
+
+// ranges that aren't for the public internet, beyond those net.IP can tell us about itself
+var reserved = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),      // "this" network
+	mustParseCIDR("100.64.0.0/10"),  // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),   // IETF protocol assignments
+	mustParseCIDR("198.18.0.0/15"),  // benchmarking
+	mustParseCIDR("240.0.0.0/4"),    // reserved, and the broadcast address
+	mustParseCIDR("64:ff9b::/96"),   // NAT64, which can reach IPv4 addresses behind it
+	mustParseCIDR("64:ff9b:1::/48"), // local-use NAT64
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// Public reports whether an address is on the public internet, rather than being loopback, private, link-local,
+// unspecified, multicast or otherwise reserved
+func Public(ip net.IP) bool {
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range reserved {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckHost returns an error unless the host, an IP address or a name looked up with lookup, has addresses and they're
+// all public
+func CheckHost(ctx context.Context, host string, lookup func(ctx context.Context, host string) ([]net.IPAddr, error)) error {
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !Public(ip) {
+			return ErrNotPublic
+		}
+		return nil
+	}
+
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", host, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("%s has no addresses", host)
+	}
+	for _, addr := range addrs {
+		if !Public(addr.IP) {
+			return ErrNotPublic
+		}
+	}
+
+	return nil
+}
+
+// Control can be given as a net.Dialer's Control, to refuse to connect to an address that isn't public. It's
+// called with the address a name was resolved to, so a name that resolved to a public address when it was checked
+// can't be pointed somewhere else by the time we connect
+func Control(network, address string, _ syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !Public(ip) {
+		return ErrNotPublic
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPublic(t *testing.T) {
+
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"255.255.255.255", false},
+		{"::1", false},
+		{"::", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, test := range tests {
+		if actual := Public(net.ParseIP(test.ip)); actual != test.expected {
+			t.Errorf("Public(%s): expected %v, got %v", test.ip, test.expected, actual)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+
+	lookup := func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "hooks.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		case "rebind.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.0.0.1")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	for host, expected := range map[string]error{
+		"hooks.example.com":  nil,
+		"93.184.216.34":      nil,
+		"rebind.example.com": ErrNotPublic,
+		"127.0.0.1":          ErrNotPublic,
+		"::1":                ErrNotPublic,
+	} {
+		if err := CheckHost(context.Background(), host, lookup); !errors.Is(err, expected) {
+			t.Errorf("CheckHost(%s): expected %v, got %v", host, expected, err)
+		}
+	}
+
+	if err := CheckHost(context.Background(), "missing.example.com", lookup); err == nil {
+		t.Error("expected a host that can't be looked up to be rejected")
+	}
+}
+
+func TestControl(t *testing.T) {
+
+	if err := Control("tcp4", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("expected a public address to be allowed, got %s", err)
+	}
+	for _, address := range []string{"127.0.0.1:8080", "[::1]:443", "169.254.169.254:80"} {
+		if err := Control("tcp", address, nil); !errors.Is(err, ErrNotPublic) {
+			t.Errorf("expected %s to be refused, got %v", address, err)
+		}
+	}
+}
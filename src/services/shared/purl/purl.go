@@ -0,0 +1,173 @@
+// Package purl parses package URLs and orders the versions they give, for the services that match packages against
+// the ones OSV and GHSA advisories list as affected
+package purl
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// a package URL, e.g. as assets list the packages they're built from or subscriptions the ones they're interested in
+type Purl struct {
+	Ecosystem string // the OSV ecosystem for the purl's type
+	Name      string // the package's name as OSV gives it in that ecosystem
+	Version   string
+}
+
+// the OSV ecosystems for purl types, and how each names its packages given a purl's namespace and name
+var ecosystems = map[string]struct {
+	ecosystem string
+	name      func(namespace, name string) string
+}{
+	"maven":    {"Maven", func(ns, n string) string { return ns + ":" + n }},
+	"npm":      {"npm", joinPath},
+	"pypi":     {"PyPI", func(ns, n string) string { return strings.ReplaceAll(strings.ToLower(n), "_", "-") }},
+	"golang":   {"Go", joinPath},
+	"cargo":    {"crates.io", joinPath},
+	"gem":      {"RubyGems", joinPath},
+	"nuget":    {"NuGet", joinPath},
+	"composer": {"Packagist", joinPath},
+	"hex":      {"Hex", joinPath},
+	"pub":      {"Pub", joinPath},
+}
+
+func joinPath(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// Parse parses a package URL, e.g. pkg:maven/org.apache.logging.log4j/log4j-core@2.14.1. Its qualifiers and subpath
+// make no difference to what's affected, so are dropped
+func Parse(v string) (Purl, error) {
+
+	rest := strings.TrimPrefix(v, "pkg:")
+	if rest == v {
+		return Purl{}, fmt.Errorf("not a package URL: %s", v)
+	}
+	if i := strings.IndexAny(rest, "?#"); i >= 0 {
+		rest = rest[:i]
+	}
+
+	var p Purl
+	if i := strings.LastIndex(rest, "@"); i > strings.LastIndex(rest, "/") {
+		rest, p.Version = rest[:i], rest[i+1:]
+	}
+
+	segments := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(segments) < 2 {
+		return Purl{}, fmt.Errorf("package URL has no name: %s", v)
+	}
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return Purl{}, fmt.Errorf("invalid package URL %s: %s", v, err)
+		}
+		segments[i] = decoded
+	}
+	if decoded, err := url.PathUnescape(p.Version); err == nil {
+		p.Version = decoded
+	}
+
+	purlType := strings.ToLower(segments[0])
+	namespace, name := strings.Join(segments[1:len(segments)-1], "/"), segments[len(segments)-1]
+
+	if known, ok := ecosystems[purlType]; ok {
+		p.Ecosystem, p.Name = known.ecosystem, known.name(namespace, name)
+	} else {
+		p.Ecosystem, p.Name = purlType, joinPath(namespace, name)
+	}
+
+	return p, nil
+}
+
+// Key is the package's key, as PackageKey gives it
+func (p Purl) Key() string {
+	return PackageKey(p.Ecosystem, p.Name)
+}
+
+// Is reports whether an advisory's ecosystem and package name are the purl's package
+func (p Purl) Is(ecosystem, name string) bool {
+	return PackageKey(ecosystem, name) == p.Key()
+}
+
+// PackageKey normalises an ecosystem and package name into a key that's the same however an advisory or purl
+// capitalises them. OSV qualifies some ecosystems with a release, e.g. Debian:12, which a purl doesn't give, so the
+// release is left off
+func PackageKey(ecosystem, name string) string {
+	ecosystem = strings.SplitN(ecosystem, ":", 2)[0]
+	return strings.ToLower(ecosystem) + "/" + strings.ToLower(name)
+}
+
+// CompareVersions orders two versions, returning -1, 0 or 1. Ecosystems each have their own rules, so this is only
+// an approximation of them all: versions are compared a run of digits or letters at a time, numerically where both
+// are numbers, and a version with a pre-release tag like -beta1 comes before the release itself
+func CompareVersions(a, b string) int {
+
+	ta, tb := versionTokens(a), versionTokens(b)
+	for i := 0; i < len(ta) || i < len(tb); i++ {
+		if i == len(ta) {
+			return -releaseOrder(tb[i])
+		}
+		if i == len(tb) {
+			return releaseOrder(ta[i])
+		}
+
+		na, errA := strconv.Atoi(ta[i])
+		nb, errB := strconv.Atoi(tb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			return 1 // a release number comes after a pre-release tag
+		case errB == nil:
+			return -1
+		default:
+			if c := strings.Compare(ta[i], tb[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return 0
+}
+
+// releaseOrder says how a version compares to one it extends with the given token: 2.0.1 comes after 2.0, but
+// 2.0-beta1 before it
+func releaseOrder(next string) int {
+	if _, err := strconv.Atoi(next); err == nil {
+		return 1
+	}
+	return -1
+}
+
+// versionTokens splits a version into its runs of digits and of letters, lowercased
+func versionTokens(v string) []string {
+	tokens := []string{}
+	current := []rune{}
+	digits := false
+	for _, r := range strings.ToLower(v) {
+		isDigit, isLetter := unicode.IsDigit(r), unicode.IsLetter(r)
+		if len(current) > 0 && (!(isDigit || isLetter) || isDigit != digits) {
+			tokens = append(tokens, string(current))
+			current = current[:0]
+		}
+		if isDigit || isLetter {
+			current = append(current, r)
+			digits = isDigit
+		}
+	}
+	if len(current) > 0 {
+		tokens = append(tokens, string(current))
+	}
+	return tokens
+}
@@ -0,0 +1,80 @@
+package purl
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		purl     string
+		expected Purl
+	}{
+		{"pkg:maven/org.apache.logging.log4j/log4j-core@2.14.1?type=jar", Purl{"Maven", "org.apache.logging.log4j:log4j-core", "2.14.1"}},
+		{"pkg:npm/%40babel/core@7.0.0", Purl{"npm", "@babel/core", "7.0.0"}},
+		{"pkg:npm/lodash", Purl{"npm", "lodash", ""}},
+		{"pkg:pypi/Django_Rest@3.0#sub/path", Purl{"PyPI", "django-rest", "3.0"}},
+		{"pkg:golang/github.com/gin-gonic/gin@v1.9.0#sub", Purl{"Go", "github.com/gin-gonic/gin", "v1.9.0"}},
+		{"pkg:cargo/hyper@0.14.0", Purl{"crates.io", "hyper", "0.14.0"}},
+		{"pkg:deb/debian/openssl@3.0.11", Purl{"deb", "debian/openssl", "3.0.11"}},
+	}
+
+	for _, test := range tests {
+		actual, err := Parse(test.purl)
+		if err != nil {
+			t.Errorf("%s: %s", test.purl, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.purl, test.expected, actual)
+		}
+	}
+
+	for _, invalid := range []string{"maven/log4j", "pkg:npm", "pkg:npm/%zz"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestPackageKey(t *testing.T) {
+
+	p := Purl{Ecosystem: "PyPI", Name: "django-rest"}
+	for _, advisory := range [][2]string{{"PyPI", "django-rest"}, {"pypi", "Django-Rest"}, {"PyPI:3", "django-rest"}} {
+		if !p.Is(advisory[0], advisory[1]) {
+			t.Errorf("expected %s %s to be %+v", advisory[0], advisory[1], p)
+		}
+	}
+	if p.Is("npm", "django-rest") {
+		t.Error("expected a package in another ecosystem not to match")
+	}
+	if key := PackageKey("Debian:12", "OpenSSL"); key != "debian/openssl" {
+		t.Errorf("expected debian/openssl, got %s", key)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.10", "1.2.9", 1},
+		{"1.2", "1.2.1", -1},
+		{"2.0-beta9", "2.0", -1},
+		{"2.0-beta9", "2.0-rc1", -1},
+		{"2.0-rc1", "2.0.1", -1},
+		{"v1.9.0", "v1.10.0", -1},
+		{"1.0.0", "1.0", 1},
+	}
+
+	for _, test := range tests {
+		if actual := CompareVersions(test.a, test.b); actual != test.expected {
+			t.Errorf("CompareVersions(%s, %s): expected %d, got %d", test.a, test.b, test.expected, actual)
+		}
+		if actual := CompareVersions(test.b, test.a); actual != -test.expected {
+			t.Errorf("CompareVersions(%s, %s): expected %d, got %d", test.b, test.a, -test.expected, actual)
+		}
+	}
+}